@@ -1,14 +1,23 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/iraven/iraven-admin/pkg/billing"
 	"github.com/iraven/iraven-admin/pkg/config"
 	"github.com/iraven/iraven-admin/pkg/database"
 	"github.com/iraven/iraven-admin/pkg/handlers"
+	"github.com/iraven/iraven-admin/pkg/jobs"
 	"github.com/iraven/iraven-admin/pkg/middleware"
+	"github.com/iraven/iraven-admin/pkg/notify"
+	"github.com/iraven/iraven-admin/pkg/search"
+	"github.com/iraven/iraven-admin/pkg/storage"
+	"github.com/iraven/iraven-admin/pkg/verification"
 	"github.com/labstack/echo/v4"
 	echoMiddleware "github.com/labstack/echo/v4/middleware"
 )
@@ -29,6 +38,39 @@ func main() {
 
 	log.Println("Database connection established")
 
+	if err := db.Migrate(context.Background()); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	textSearchConfigs, err := db.TextSearchConfigs(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to list text search configurations: %v", err)
+	}
+	if missing := search.SetInstalled(textSearchConfigs); len(missing) > 0 {
+		log.Printf("Text search configurations %s are missing; those languages are indexed with %s",
+			strings.Join(missing, ", "), search.DefaultDictionary)
+	}
+
+	store, err := storage.New(&cfg.Storage)
+	if err != nil {
+		log.Fatalf("Failed to initialize file storage: %v", err)
+	}
+
+	providers, err := notify.New(&cfg.Notify)
+	if err != nil {
+		log.Fatalf("Failed to initialize notification providers: %v", err)
+	}
+
+	// Without a payment provider the admin still runs, with refunds and
+	// cancellations disabled
+	paymentProvider, err := billing.New(&cfg.Payments)
+	if err != nil {
+		log.Printf("Payments: refunds and cancellations are disabled: %v", err)
+	}
+	if cfg.Payments.Driver == "stub" {
+		log.Printf("Payments use the stub driver; refunds and cancellations are kept in memory and do not reach Stripe")
+	}
+
 	// Initialize Echo
 	e := echo.New()
 	e.Debug = cfg.Server.Debug
@@ -51,13 +93,27 @@ func main() {
 	// Static files
 	e.Static("/static", "static")
 
+	// Objects in local storage are public at /media, which
+	// storage.public_url should point to
+	if local, ok := store.(*storage.Local); ok {
+		e.Static("/media", local.Dir())
+	}
+
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(db)
 	dashboardHandler := handlers.NewDashboardHandler(db)
-	userHandler := handlers.NewUserHandler(db)
+	quotas := handlers.QuotaDefaults{User: cfg.Storage.UserQuota, Application: cfg.Storage.ApplicationQuota}
+	userHandler := handlers.NewUserHandler(db, store, quotas, cfg.Admin.ErasureGraceDays)
 	roleHandler := handlers.NewRoleHandler(db)
-	applicationHandler := handlers.NewApplicationHandler(db)
-	contentHandler := handlers.NewContentHandler(db)
+	applicationHandler := handlers.NewApplicationHandler(db, verification.NewVerifier())
+	contentHandler := handlers.NewContentHandler(db, cfg.Admin.DefaultLanguage)
+	contentTypeHandler := handlers.NewContentTypeHandler(db)
+	fileHandler := handlers.NewFileHandler(db, store, cfg.Storage.MaxUploadSize, quotas)
+	languageHandler := handlers.NewLanguageHandler(db)
+	countryHandler := handlers.NewCountryHandler(db)
+	translationHandler := handlers.NewTranslationHandler(db, cfg.Admin.DefaultLanguage)
+	notificationHandler := handlers.NewNotificationHandler(db, providers, cfg.Admin.DefaultLanguage, cfg.Notify.Push.StaleDays)
+	paymentHandler := handlers.NewPaymentHandler(db, paymentProvider, cfg.Payments.Stripe.WebhookSecret)
 	systemHandler := handlers.NewSystemHandler(db)
 	supabaseHandler := handlers.NewSupabaseHandler(db)
 
@@ -65,6 +121,7 @@ func main() {
 	e.GET("/login", authHandler.ShowLogin)
 	e.POST("/login", authHandler.Login)
 	e.GET("/logout", authHandler.Logout)
+	e.POST("/webhooks/stripe", paymentHandler.StripeWebhook)
 
 	// Protected routes
 	protected := e.Group("")
@@ -82,6 +139,16 @@ func main() {
 	protected.GET("/users/:id/edit", userHandler.Edit)
 	protected.POST("/users/:id", userHandler.Update)
 	protected.POST("/users/:id/delete", userHandler.Delete)
+	protected.GET("/users/:id/profile", userHandler.EditProfile)
+	protected.POST("/users/:id/profile", userHandler.UpdateProfile)
+	protected.POST("/users/:id/profile/photo", fileHandler.UploadProfilePhoto)
+	protected.POST("/users/:id/profile/photo/delete", userHandler.RemoveProfilePhoto)
+	protected.GET("/users/:id/privacy", userHandler.Privacy)
+	protected.GET("/users/:id/export", userHandler.Export)
+	protected.POST("/users/:id/erasure", userHandler.RequestErasure)
+	protected.POST("/users/:id/erasure/cancel", userHandler.CancelErasure)
+	protected.POST("/users/:id/devices/:device/test", notificationHandler.TestPush)
+	protected.POST("/users/:id/devices/:device/revoke", notificationHandler.RevokeDevice)
 
 	// Roles
 	protected.GET("/roles", roleHandler.List)
@@ -100,15 +167,124 @@ func main() {
 	protected.GET("/applications/:id/edit", applicationHandler.Edit)
 	protected.POST("/applications/:id", applicationHandler.Update)
 	protected.POST("/applications/:id/delete", applicationHandler.Delete)
+	protected.POST("/applications/:id/roles", applicationHandler.AttachRole)
+	protected.POST("/applications/:id/roles/:roleId/delete", applicationHandler.DetachRole)
+	protected.POST("/applications/:id/verification", applicationHandler.StartVerification)
+	protected.POST("/applications/:id/verification/check", applicationHandler.CheckVerification)
 
 	// Content
 	protected.GET("/content", contentHandler.List)
 	protected.GET("/content/new", contentHandler.New)
+	protected.GET("/content/review", contentHandler.ReviewQueue)
+	protected.GET("/content/slug-check", contentHandler.SlugCheck)
+	protected.POST("/content/search/rebuild", contentHandler.RebuildSearchIndex)
+	protected.GET("/content/redirects", contentHandler.Redirects)
+	protected.POST("/content/redirects/flatten", contentHandler.FlattenRedirects)
+	protected.POST("/content/redirects/:id/delete", contentHandler.DeleteRedirect)
 	protected.POST("/content", contentHandler.Create)
 	protected.GET("/content/:id", contentHandler.Show)
 	protected.GET("/content/:id/edit", contentHandler.Edit)
 	protected.POST("/content/:id", contentHandler.Update)
 	protected.POST("/content/:id/delete", contentHandler.Delete)
+	protected.GET("/content/:id/revisions", contentHandler.Revisions)
+	protected.GET("/content/:id/revisions/diff", contentHandler.DiffRevisions)
+	protected.POST("/content/:id/revisions/:revision/restore", contentHandler.RestoreRevision)
+	protected.POST("/content/:id/transition", contentHandler.Transition)
+	protected.GET("/content/:id/preview", contentHandler.Preview)
+	protected.GET("/content/:id/variants/:code", contentHandler.EditVariant)
+	protected.POST("/content/:id/variants/:code", contentHandler.SaveVariant)
+	protected.POST("/content/:id/variants/:code/delete", contentHandler.DeleteVariant)
+
+	// Content Types
+	protected.GET("/content-types", contentTypeHandler.List)
+	protected.GET("/content-types/new", contentTypeHandler.New)
+	protected.POST("/content-types", contentTypeHandler.Create)
+	protected.GET("/content-types/:id", contentTypeHandler.Show)
+	protected.GET("/content-types/:id/edit", contentTypeHandler.Edit)
+	protected.POST("/content-types/:id", contentTypeHandler.Update)
+	protected.POST("/content-types/:id/delete", contentTypeHandler.Delete)
+
+	// Files
+	protected.GET("/files", fileHandler.List)
+	protected.POST("/files", fileHandler.Upload)
+	protected.GET("/files/orphans", fileHandler.Orphans)
+	protected.POST("/files/orphans/purge", fileHandler.PurgeOrphans)
+	protected.GET("/files/quotas", fileHandler.Quotas)
+	protected.POST("/files/quotas", fileHandler.SetQuota)
+	protected.GET("/files/:id", fileHandler.Show)
+	protected.GET("/files/:id/download", fileHandler.Download)
+	protected.GET("/files/:id/preview", fileHandler.Preview)
+	protected.GET("/files/:id/thumbnail", fileHandler.Thumbnail)
+	protected.POST("/files/:id/process", fileHandler.Reprocess)
+	protected.POST("/files/:id/delete", fileHandler.Delete)
+
+	// Languages
+	protected.GET("/languages", languageHandler.List)
+	protected.GET("/languages/new", languageHandler.New)
+	protected.POST("/languages", languageHandler.Create)
+	protected.POST("/languages/seed", languageHandler.Seed)
+	protected.GET("/languages/:id/edit", languageHandler.Edit)
+	protected.POST("/languages/:id", languageHandler.Update)
+	protected.POST("/languages/:id/delete", languageHandler.Delete)
+
+	// Countries
+	protected.GET("/countries", countryHandler.List)
+	protected.GET("/countries/new", countryHandler.New)
+	protected.GET("/countries/matrix", countryHandler.Matrix)
+	protected.POST("/countries/matrix", countryHandler.SaveMatrix)
+	protected.POST("/countries", countryHandler.Create)
+	protected.GET("/countries/:id", countryHandler.Show)
+	protected.GET("/countries/:id/edit", countryHandler.Edit)
+	protected.POST("/countries/:id", countryHandler.Update)
+	protected.POST("/countries/:id/delete", countryHandler.Delete)
+
+	// Translations
+	protected.GET("/translations", translationHandler.Index)
+	protected.POST("/translations/values", translationHandler.SaveValues)
+	protected.POST("/translations/keys", translationHandler.CreateKey)
+	protected.GET("/translations/keys/:id", translationHandler.EditKey)
+	protected.POST("/translations/keys/:id", translationHandler.UpdateKey)
+	protected.POST("/translations/keys/:id/delete", translationHandler.DeleteKey)
+	protected.POST("/translations/import", translationHandler.Import)
+	protected.GET("/translations/export", translationHandler.Export)
+	protected.GET("/translations/bundles", translationHandler.Bundles)
+	protected.POST("/translations/bundles/publish", translationHandler.PublishBundles)
+	protected.GET("/translations/bundles/:code", translationHandler.Bundle)
+
+	// Notifications
+	protected.GET("/notifications", notificationHandler.List)
+	protected.GET("/notifications/new", notificationHandler.New)
+	protected.POST("/notifications", notificationHandler.Create)
+	protected.GET("/notifications/analytics", notificationHandler.Analytics)
+	protected.GET("/notifications/analytics/failures", notificationHandler.Failures)
+	protected.GET("/notifications/devices", notificationHandler.Devices)
+	protected.GET("/notifications/segments", notificationHandler.Segments)
+	protected.GET("/notifications/segments/new", notificationHandler.NewSegment)
+	protected.POST("/notifications/segments", notificationHandler.CreateSegment)
+	protected.GET("/notifications/segments/:id/edit", notificationHandler.EditSegment)
+	protected.POST("/notifications/segments/:id", notificationHandler.UpdateSegment)
+	protected.POST("/notifications/segments/:id/delete", notificationHandler.DeleteSegment)
+	protected.GET("/notifications/templates", notificationHandler.Templates)
+	protected.GET("/notifications/templates/new", notificationHandler.NewTemplate)
+	protected.POST("/notifications/templates", notificationHandler.CreateTemplate)
+	protected.GET("/notifications/templates/:id", notificationHandler.EditTemplate)
+	protected.POST("/notifications/templates/:id", notificationHandler.UpdateTemplate)
+	protected.POST("/notifications/templates/:id/delete", notificationHandler.DeleteTemplate)
+	protected.GET("/notifications/templates/:id/preview", notificationHandler.PreviewTemplate)
+	protected.POST("/notifications/templates/:id/versions/:version/restore", notificationHandler.RestoreTemplateVersion)
+	protected.GET("/notifications/:id", notificationHandler.Show)
+
+	// Payments
+	protected.GET("/payments", paymentHandler.List)
+	protected.GET("/payments/reports", paymentHandler.Reports)
+	protected.GET("/payments/reports/export", paymentHandler.ExportReport)
+	protected.POST("/payments/reports/refresh", paymentHandler.RefreshReports)
+	protected.GET("/payments/reconciliations", paymentHandler.Reconciliations)
+	protected.POST("/payments/reconciliations", paymentHandler.UploadReconciliation)
+	protected.GET("/payments/reconciliations/:id", paymentHandler.Reconciliation)
+	protected.GET("/payments/:id", paymentHandler.Show)
+	protected.POST("/payments/:id/refund", paymentHandler.Refund)
+	protected.POST("/payments/:id/cancel", paymentHandler.Cancel)
 
 	// System
 	protected.GET("/system", systemHandler.Dashboard)
@@ -122,6 +298,17 @@ func main() {
 	protected.GET("/supabase/:table", supabaseHandler.BrowseTable)
 	protected.GET("/supabase/:table/:id", supabaseHandler.ViewRow)
 
+	// Background jobs
+	runner := jobs.NewRunner()
+	runner.Add("publish-scheduled-content", time.Minute, contentHandler.PublishScheduled)
+	runner.Add("report-orphaned-files", 24*time.Hour, fileHandler.ReportOrphans)
+	runner.Add("deliver-notifications", 30*time.Second, notificationHandler.DeliverQueued)
+	runner.Add("prune-notification-devices", 24*time.Hour, notificationHandler.PruneDevices)
+	runner.Add("reconcile-payments", time.Minute, paymentHandler.ReconcileQueued)
+	runner.Add("refresh-payment-summaries", 15*time.Minute, paymentHandler.RefreshSummaries)
+	runner.Add("erase-users", time.Hour, userHandler.EraseDue)
+	runner.Start(context.Background())
+
 	// Start server
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
 	log.Printf("Starting admin dashboard on %s", addr)
@@ -0,0 +1,59 @@
+// Package billing performs actions on payments at the payment provider.
+// Amounts are in minor units of the payment's currency, as stored in
+// iraven.payments.
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/iraven/iraven-admin/pkg/config"
+)
+
+// ErrNoPaymentID means the payment was never created at the provider, so
+// there is nothing to act on there.
+var ErrNoPaymentID = errors.New("payment has no provider payment ID")
+
+// RefundRequest asks for Amount of a payment to be returned. The provider
+// performs a request with the same IdempotencyKey at most once.
+type RefundRequest struct {
+	PaymentID      string
+	Amount         int64
+	Currency       string
+	Reason         string
+	IdempotencyKey string
+}
+
+// Refund describes a refund the provider accepted.
+type Refund struct {
+	ID     string
+	Status string
+}
+
+// Provider is implemented by every payment provider.
+type Provider interface {
+	Name() string
+	Refund(ctx context.Context, req RefundRequest) (Refund, error)
+	// Cancel stops a payment that has not completed yet.
+	Cancel(ctx context.Context, paymentID, idempotencyKey string) error
+}
+
+// New creates the provider selected in cfg. The driver must be set: the
+// "stub" driver keeps payments in memory and accepts refunds and
+// cancellations Stripe may refuse, so it is only used when asked for.
+func New(cfg *config.PaymentsConfig) (Provider, error) {
+	switch cfg.Driver {
+	case "":
+		return nil, errors.New("no payments driver configured; use stripe, or stub for development")
+	case "stub":
+		return NewStub(), nil
+	case "stripe":
+		if cfg.Stripe.SecretKey == "" {
+			return nil, errors.New("stripe: no secret key configured")
+		}
+		return NewStripe(cfg.Stripe.SecretKey), nil
+	default:
+		return nil, fmt.Errorf("unknown payments driver %q", cfg.Driver)
+	}
+}
@@ -0,0 +1,294 @@
+package billing
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/iraven/iraven-admin/pkg/money"
+)
+
+// Reconciliation issue kinds.
+const (
+	IssueMissingInAdmin  = "missing_in_admin"
+	IssueMissingInStripe = "missing_in_stripe"
+	IssueDuplicate       = "duplicate"
+	IssueStatusMismatch  = "status_mismatch"
+	IssueAmountMismatch  = "amount_mismatch"
+)
+
+var IssueKinds = []string{IssueMissingInAdmin, IssueMissingInStripe, IssueDuplicate, IssueStatusMismatch, IssueAmountMismatch}
+
+// BalanceRow is a transaction of a Stripe balance export. Amount is in
+// minor units; refunds are negative.
+type BalanceRow struct {
+	ID              string
+	Type            string
+	SourceID        string
+	PaymentIntentID string
+	Amount          int64
+	Currency        string
+	Created         time.Time
+}
+
+// balanceColumns maps the fields of a row to the column names of the
+// dashboard's balance export and of the itemized balance report.
+var balanceColumns = map[string][]string{
+	"id":             {"balance_transaction_id", "id"},
+	"type":           {"reporting_category", "type"},
+	"source":         {"source_id", "source"},
+	"payment_intent": {"payment_intent_id", "payment_intent"},
+	"amount":         {"gross", "amount"},
+	"currency":       {"currency"},
+	"created":        {"created_utc", "created (utc)", "created"},
+}
+
+var balanceTimeLayouts = []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04:05Z", "2006-01-02"}
+
+// ParseBalanceCSV reads a Stripe balance export, either the dashboard's
+// "Balance" export or an itemized balance change report. Amounts in it are
+// in major units.
+func ParseBalanceCSV(r io.Reader) ([]BalanceRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	index := map[string]int{}
+	for field, names := range balanceColumns {
+		for i, column := range header {
+			column = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+			if containsString(names, column) {
+				index[field] = i
+				break
+			}
+		}
+	}
+	for _, field := range []string{"id", "type", "source", "amount", "currency", "created"} {
+		if _, ok := index[field]; !ok {
+			return nil, fmt.Errorf("no %s column; expected one of %s", field, strings.Join(balanceColumns[field], ", "))
+		}
+	}
+
+	var rows []BalanceRow
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		get := func(field string) string {
+			if i, ok := index[field]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		row := BalanceRow{
+			ID:              get("id"),
+			Type:            strings.ToLower(get("type")),
+			SourceID:        get("source"),
+			PaymentIntentID: get("payment_intent"),
+			Currency:        strings.ToUpper(get("currency")),
+		}
+		if row.Amount, err = money.Parse(get("amount"), row.Currency); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		created := get("created")
+		for _, layout := range balanceTimeLayouts {
+			if row.Created, err = time.Parse(layout, created); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q", line, created)
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("no transactions")
+	}
+	return rows, nil
+}
+
+// Period returns the dates of the first and last transaction.
+func Period(rows []BalanceRow) (from, to time.Time) {
+	for i, row := range rows {
+		if i == 0 || row.Created.Before(from) {
+			from = row.Created
+		}
+		if i == 0 || row.Created.After(to) {
+			to = row.Created
+		}
+	}
+	return from, to
+}
+
+// Issue is a difference between the balance export and iraven.payments.
+// PaymentID is zero when the payment is not ours.
+type Issue struct {
+	Kind      string
+	StripeID  string
+	PaymentID int64
+	Expected  string
+	Actual    string
+}
+
+// stripePayment totals the balance transactions of one payment.
+type stripePayment struct {
+	key      string
+	keys     []string
+	charges  int
+	amount   int64
+	refunded int64
+	currency string
+}
+
+// status is the payment status Stripe's transactions imply.
+func (p *stripePayment) status() string {
+	switch {
+	case p.refunded >= p.amount:
+		return "refunded"
+	case p.refunded > 0:
+		return "partially_refunded"
+	}
+	return "succeeded"
+}
+
+// Reconcile compares the charges and refunds of a balance export with our
+// payments. payments must hold every payment created in the period of the
+// export and every payment the export refers to; those created between
+// from and to that Stripe has no charge for are reported missing. It
+// returns the number of payments found on both sides, and the issues.
+func Reconcile(rows []BalanceRow, payments []models.Payment, from, to time.Time) (int, []Issue) {
+	var issues []Issue
+
+	local := map[string]models.Payment{}
+	for _, p := range payments {
+		if p.StripePaymentID == "" {
+			continue
+		}
+		if first, ok := local[p.StripePaymentID]; ok {
+			issues = append(issues, Issue{Kind: IssueDuplicate, StripeID: p.StripePaymentID, PaymentID: p.ID,
+				Expected: "one payment", Actual: fmt.Sprintf("payments #%d and #%d", first.ID, p.ID)})
+			continue
+		}
+		local[p.StripePaymentID] = p
+	}
+
+	// Charges are keyed by payment intent when the export has it, and by
+	// charge otherwise; refunds are matched to them by either
+	var order []*stripePayment
+	byKey := map[string]*stripePayment{}
+	for _, row := range rows {
+		if row.Type != "charge" && row.Type != "payment" {
+			continue
+		}
+		keys := nonEmpty(row.PaymentIntentID, row.SourceID)
+		p := byKey[keys[0]]
+		if p == nil {
+			p = &stripePayment{key: keys[0], currency: row.Currency}
+			order = append(order, p)
+		}
+		for _, k := range keys {
+			byKey[k] = p
+			p.keys = appendUnique(p.keys, k)
+		}
+		p.charges++
+		p.amount += row.Amount
+	}
+	for _, row := range rows {
+		if row.Type != "refund" && row.Type != "payment_refund" {
+			continue
+		}
+		for _, k := range nonEmpty(row.PaymentIntentID, row.SourceID) {
+			if p := byKey[k]; p != nil {
+				p.refunded -= row.Amount
+				break
+			}
+		}
+	}
+
+	matched := 0
+	seen := map[string]bool{}
+	for _, sp := range order {
+		if sp.charges > 1 {
+			issues = append(issues, Issue{Kind: IssueDuplicate, StripeID: sp.key,
+				Expected: "one charge", Actual: fmt.Sprintf("%d charges", sp.charges)})
+		}
+
+		var p models.Payment
+		found := false
+		for _, k := range sp.keys {
+			if p, found = local[k]; found {
+				seen[k] = true
+				break
+			}
+		}
+		if !found {
+			issues = append(issues, Issue{Kind: IssueMissingInAdmin, StripeID: sp.key,
+				Expected: money.Format(sp.amount, sp.currency) + " " + sp.status()})
+			continue
+		}
+		matched++
+
+		if p.Amount != sp.amount || !strings.EqualFold(p.Currency, sp.currency) {
+			issues = append(issues, Issue{Kind: IssueAmountMismatch, StripeID: sp.key, PaymentID: p.ID,
+				Expected: money.Format(sp.amount, sp.currency), Actual: money.Format(p.Amount, p.Currency)})
+		}
+		if p.Status != sp.status() {
+			issues = append(issues, Issue{Kind: IssueStatusMismatch, StripeID: sp.key, PaymentID: p.ID,
+				Expected: sp.status(), Actual: p.Status})
+		}
+	}
+
+	for _, p := range payments {
+		if seen[p.StripePaymentID] || p.CreatedAt.Before(from) || !p.CreatedAt.Before(to) {
+			continue
+		}
+		switch p.Status {
+		case "succeeded", "partially_refunded", "refunded":
+			issues = append(issues, Issue{Kind: IssueMissingInStripe, StripeID: p.StripePaymentID, PaymentID: p.ID,
+				Actual: money.Format(p.Amount, p.Currency) + " " + p.Status})
+		}
+	}
+
+	return matched, issues
+}
+
+func nonEmpty(values ...string) []string {
+	var out []string
+	for _, v := range values {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	if len(out) == 0 {
+		out = []string{""}
+	}
+	return out
+}
+
+func appendUnique(values []string, v string) []string {
+	if containsString(values, v) {
+		return values
+	}
+	return append(values, v)
+}
+
+func containsString(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package billing
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iraven/iraven-admin/pkg/models"
+)
+
+func TestParseBalanceCSV(t *testing.T) {
+	want := []BalanceRow{
+		{ID: "txn_1", Type: "charge", SourceID: "ch_1", PaymentIntentID: "pi_1", Amount: 5000, Currency: "EUR",
+			Created: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)},
+		{ID: "txn_2", Type: "refund", SourceID: "re_1", PaymentIntentID: "pi_1", Amount: -1250, Currency: "EUR",
+			Created: time.Date(2024, 3, 2, 11, 30, 0, 0, time.UTC)},
+		{ID: "txn_3", Type: "charge", SourceID: "ch_2", Amount: 1000, Currency: "JPY",
+			Created: time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)},
+	}
+
+	tests := []struct {
+		name string
+		csv  string
+	}{
+		{"dashboard export", "\ufeffid,Type,Source,Amount,Fee,Net,Currency,Created (UTC)\n" +
+			"txn_1,charge,ch_1,50.00,1.00,49.00,eur,2024-03-01 10:00\n" +
+			"txn_2,refund,re_1,-12.50,0.00,-12.50,eur,2024-03-02 11:30\n" +
+			"txn_3,charge,ch_2,1000,30,970,jpy,2024-03-03\n"},
+		{"itemized report", "balance_transaction_id,created_utc,currency,gross,fee,net,reporting_category,source_id,payment_intent_id\n" +
+			"txn_1,2024-03-01 10:00:00,eur,50.00,1.00,49.00,charge,ch_1,pi_1\n" +
+			"txn_2,2024-03-02 11:30:00,eur,-12.50,0.00,-12.50,refund,re_1,pi_1\n" +
+			"txn_3,2024-03-03 00:00:00,jpy,1000,30,970,charge,ch_2,\n"},
+	}
+	for _, tt := range tests {
+		rows, err := ParseBalanceCSV(strings.NewReader(tt.csv))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		// The dashboard export has no payment intent column
+		if tt.name == "dashboard export" {
+			for i := range rows {
+				rows[i].PaymentIntentID = want[i].PaymentIntentID
+			}
+		}
+		if !reflect.DeepEqual(rows, want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.name, rows, want)
+		}
+	}
+
+	invalid := map[string]string{
+		"no amount column":  "id,type,source,currency,created\ntxn_1,charge,ch_1,eur,2024-03-01\n",
+		"too many decimals": "id,type,source,amount,currency,created\ntxn_1,charge,ch_1,10.5,jpy,2024-03-01\n",
+		"bad date":          "id,type,source,amount,currency,created\ntxn_1,charge,ch_1,10.50,eur,01/03/2024\n",
+		"no transactions":   "id,type,source,amount,currency,created\n",
+		"empty":             "",
+	}
+	for name, csv := range invalid {
+		if _, err := ParseBalanceCSV(strings.NewReader(csv)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 12, 0, 0, 0, time.UTC) }
+	rows := []BalanceRow{
+		// Refunded in part, the refund found by payment intent
+		{Type: "charge", SourceID: "ch_1", PaymentIntentID: "pi_1", Amount: 5000, Currency: "EUR", Created: day(1)},
+		{Type: "refund", SourceID: "re_1", PaymentIntentID: "pi_1", Amount: -2000, Currency: "EUR", Created: day(2)},
+		// Refunded in full, the refund found by charge
+		{Type: "payment", SourceID: "ch_2", PaymentIntentID: "pi_2", Amount: 1000, Currency: "EUR", Created: day(1)},
+		{Type: "payment_refund", SourceID: "ch_2", Amount: -1000, Currency: "EUR", Created: day(3)},
+		// A charge without a payment intent, ours under its charge ID
+		{Type: "charge", SourceID: "ch_3", Amount: 700, Currency: "USD", Created: day(2)},
+		// Charged twice
+		{Type: "charge", SourceID: "ch_4a", PaymentIntentID: "pi_4", Amount: 300, Currency: "EUR", Created: day(2)},
+		{Type: "charge", SourceID: "ch_4b", PaymentIntentID: "pi_4", Amount: 300, Currency: "EUR", Created: day(2)},
+		// Not ours
+		{Type: "charge", SourceID: "ch_5", PaymentIntentID: "pi_5", Amount: 900, Currency: "EUR", Created: day(3)},
+		// Amount differs
+		{Type: "charge", SourceID: "ch_6", PaymentIntentID: "pi_6", Amount: 1200, Currency: "EUR", Created: day(3)},
+		// Payouts and fees are not payments
+		{Type: "payout", SourceID: "po_1", Amount: -4000, Currency: "EUR", Created: day(4)},
+	}
+	payments := []models.Payment{
+		{ID: 1, StripePaymentID: "pi_1", Amount: 5000, Currency: "eur", Status: "partially_refunded", CreatedAt: day(1)},
+		{ID: 2, StripePaymentID: "pi_2", Amount: 1000, Currency: "eur", Status: "succeeded", CreatedAt: day(1)},
+		{ID: 3, StripePaymentID: "ch_3", Amount: 700, Currency: "usd", Status: "succeeded", CreatedAt: day(2)},
+		{ID: 4, StripePaymentID: "pi_4", Amount: 300, Currency: "eur", Status: "succeeded", CreatedAt: day(2)},
+		{ID: 6, StripePaymentID: "pi_6", Amount: 1000, Currency: "eur", Status: "succeeded", CreatedAt: day(3)},
+		// Recorded twice
+		{ID: 7, StripePaymentID: "pi_1", Amount: 5000, Currency: "eur", Status: "partially_refunded", CreatedAt: day(1)},
+		// Stripe has no charge for it
+		{ID: 8, StripePaymentID: "pi_8", Amount: 400, Currency: "eur", Status: "succeeded", CreatedAt: day(2)},
+		// Outside the window, or never charged
+		{ID: 9, StripePaymentID: "pi_9", Amount: 400, Currency: "eur", Status: "succeeded", CreatedAt: day(1).Add(-time.Hour)},
+		{ID: 10, StripePaymentID: "pi_10", Amount: 400, Currency: "eur", Status: "succeeded", CreatedAt: day(4)},
+		{ID: 11, StripePaymentID: "pi_11", Amount: 400, Currency: "eur", Status: "failed", CreatedAt: day(2)},
+		{ID: 12, Amount: 400, Currency: "eur", Status: "pending", CreatedAt: day(2)},
+	}
+
+	matched, issues := Reconcile(rows, payments, day(1), day(4))
+	if matched != 5 {
+		t.Errorf("matched = %d, want 5", matched)
+	}
+
+	type issue struct {
+		kind      string
+		stripeID  string
+		paymentID int64
+	}
+	var got []issue
+	for _, i := range issues {
+		got = append(got, issue{i.Kind, i.StripeID, i.PaymentID})
+	}
+	want := []issue{
+		{IssueDuplicate, "pi_1", 7},
+		{IssueStatusMismatch, "pi_2", 2},
+		{IssueDuplicate, "pi_4", 0},
+		// Both charges count towards the amount
+		{IssueAmountMismatch, "pi_4", 4},
+		{IssueMissingInAdmin, "pi_5", 0},
+		{IssueAmountMismatch, "pi_6", 6},
+		{IssueMissingInStripe, "pi_8", 8},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("issues:\n got %v\nwant %v", got, want)
+	}
+	for _, i := range issues {
+		if i.Kind == IssueStatusMismatch && (i.Expected != "refunded" || i.Actual != "succeeded") {
+			t.Errorf("status mismatch = %+v", i)
+		}
+		if i.Kind == IssueAmountMismatch && i.StripeID == "pi_6" && (i.Expected != "12.00 EUR" || i.Actual != "10.00 EUR") {
+			t.Errorf("amount mismatch = %+v", i)
+		}
+	}
+}
+
+func TestPeriod(t *testing.T) {
+	first := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	last := time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)
+	from, to := Period([]BalanceRow{{Created: first.AddDate(0, 0, 10)}, {Created: last}, {Created: first}})
+	if !from.Equal(first) || !to.Equal(last) {
+		t.Errorf("Period = %v, %v; want %v, %v", from, to, first, last)
+	}
+}
@@ -0,0 +1,169 @@
+package billing
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// stripeStandIn is an in-memory server covering the API calls the Stripe
+// provider makes, for testing the client without a Stripe account:
+//
+//	standIn := newstripeStandIn()
+//	standIn.AddPayment("pi_1", 5000, "eur", "succeeded")
+//	srv := httptest.NewServer(standIn)
+//	provider := &Stripe{BaseURL: srv.URL, SecretKey: "sk_test", Client: srv.Client()}
+//
+// Payments not added beforehand are taken to be succeeded payments of
+// unknown amount, so any refund of them is accepted. Requests must carry a
+// secret key, but it is not checked.
+type stripeStandIn struct {
+	mu       sync.Mutex
+	payments map[string]*standInPayment
+	replies  map[string][]byte
+	refunds  int
+}
+
+type standInPayment struct {
+	amount   int64
+	currency string
+	status   string
+	refunded int64
+}
+
+func newStripeStandIn() *stripeStandIn {
+	return &stripeStandIn{payments: map[string]*standInPayment{}, replies: map[string][]byte{}}
+}
+
+// AddPayment registers a payment intent or charge with its amount and
+// Stripe status.
+func (s *stripeStandIn) AddPayment(id string, amount int64, currency, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.payments[id] = &standInPayment{amount: amount, currency: currency, status: status}
+}
+
+// Refunded returns the amount refunded from a payment so far.
+func (s *stripeStandIn) Refunded(id string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p := s.payments[id]; p != nil {
+		return p.refunded
+	}
+	return 0
+}
+
+func (s *stripeStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if key, _, ok := r.BasicAuth(); !ok || !strings.HasPrefix(key, "sk_") {
+		standInStripeError(w, http.StatusUnauthorized, "Invalid API Key provided")
+		return
+	}
+	if r.Method != http.MethodPost {
+		standInStripeError(w, http.StatusMethodNotAllowed, "Unsupported method")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		standInStripeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := r.Header.Get("Idempotency-Key")
+	if reply, ok := s.replies[key]; ok && key != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(reply)
+		return
+	}
+
+	var reply interface{}
+	var status int
+	var message string
+	switch {
+	case r.URL.Path == "/v1/refunds":
+		reply, status, message = s.refund(r)
+	case strings.HasPrefix(r.URL.Path, "/v1/payment_intents/") && strings.HasSuffix(r.URL.Path, "/cancel"):
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/payment_intents/"), "/cancel")
+		reply, status, message = s.cancel(id)
+	default:
+		status, message = http.StatusNotFound, "Unrecognized request URL"
+	}
+	if message != "" {
+		standInStripeError(w, status, message)
+		return
+	}
+
+	data, _ := json.Marshal(reply)
+	if key != "" {
+		s.replies[key] = data
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (s *stripeStandIn) refund(r *http.Request) (interface{}, int, string) {
+	id := r.PostForm.Get("payment_intent")
+	if id == "" {
+		id = r.PostForm.Get("charge")
+	}
+	if id == "" {
+		return nil, http.StatusBadRequest, "One of charge or payment_intent is required"
+	}
+	amount, err := strconv.ParseInt(r.PostForm.Get("amount"), 10, 64)
+	if err != nil || amount <= 0 {
+		return nil, http.StatusBadRequest, "Invalid positive integer: amount"
+	}
+
+	p := s.payments[id]
+	if p == nil {
+		p = &standInPayment{amount: -1, status: "succeeded"}
+		s.payments[id] = p
+	}
+	if p.status != "succeeded" {
+		return nil, http.StatusBadRequest, fmt.Sprintf("Payment %s has status %s and cannot be refunded", id, p.status)
+	}
+	if p.amount >= 0 && p.refunded+amount > p.amount {
+		return nil, http.StatusBadRequest, fmt.Sprintf("Refund amount (%d) is greater than unrefunded amount on payment (%d)", amount, p.amount-p.refunded)
+	}
+	p.refunded += amount
+
+	s.refunds++
+	return map[string]interface{}{
+		"id":       fmt.Sprintf("re_standin_%d", s.refunds),
+		"object":   "refund",
+		"amount":   amount,
+		"currency": p.currency,
+		"status":   "succeeded",
+	}, http.StatusOK, ""
+}
+
+func (s *stripeStandIn) cancel(id string) (interface{}, int, string) {
+	p := s.payments[id]
+	if p == nil {
+		p = &standInPayment{amount: -1, status: "requires_payment_method"}
+		s.payments[id] = p
+	}
+	switch p.status {
+	case "succeeded", "canceled":
+		return nil, http.StatusBadRequest, fmt.Sprintf("You cannot cancel this PaymentIntent because it has a status of %s", p.status)
+	}
+	p.status = "canceled"
+
+	return map[string]interface{}{
+		"id":     id,
+		"object": "payment_intent",
+		"status": p.status,
+	}, http.StatusOK, ""
+}
+
+func standInStripeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"type": "invalid_request_error", "message": message},
+	})
+}
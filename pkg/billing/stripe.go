@@ -0,0 +1,106 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stripe acts on payments through the Stripe API. Payment IDs are payment
+// intents (pi_...) or, for older payments, charges (ch_...).
+type Stripe struct {
+	BaseURL   string
+	SecretKey string
+	Client    *http.Client
+}
+
+func NewStripe(secretKey string) *Stripe {
+	return &Stripe{
+		BaseURL:   "https://api.stripe.com",
+		SecretKey: secretKey,
+		Client:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *Stripe) Name() string {
+	return "stripe"
+}
+
+func (s *Stripe) Refund(ctx context.Context, req RefundRequest) (Refund, error) {
+	if req.PaymentID == "" {
+		return Refund{}, ErrNoPaymentID
+	}
+
+	form := url.Values{
+		"amount":           {strconv.FormatInt(req.Amount, 10)},
+		"metadata[reason]": {req.Reason},
+	}
+	if strings.HasPrefix(req.PaymentID, "ch_") {
+		form.Set("charge", req.PaymentID)
+	} else {
+		form.Set("payment_intent", req.PaymentID)
+	}
+
+	var refund struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	if err := s.post(ctx, "/v1/refunds", form, req.IdempotencyKey, &refund); err != nil {
+		return Refund{}, err
+	}
+	return Refund{ID: refund.ID, Status: refund.Status}, nil
+}
+
+func (s *Stripe) Cancel(ctx context.Context, paymentID, idempotencyKey string) error {
+	if paymentID == "" {
+		return ErrNoPaymentID
+	}
+	if !strings.HasPrefix(paymentID, "pi_") {
+		return fmt.Errorf("stripe: only payment intents can be canceled, not %s", paymentID)
+	}
+	return s.post(ctx, "/v1/payment_intents/"+url.PathEscape(paymentID)+"/cancel", url.Values{}, idempotencyKey, nil)
+}
+
+// post sends a form to the API and decodes the reply into v when it is
+// not nil.
+func (s *Stripe) post(ctx context.Context, path string, form url.Values, idempotencyKey string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.BaseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.SecretKey, "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var reply struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.Unmarshal(body, &reply)
+		return fmt.Errorf("stripe: %s: %s", resp.Status, reply.Error.Message)
+	}
+	if v != nil {
+		return json.Unmarshal(body, v)
+	}
+	return nil
+}
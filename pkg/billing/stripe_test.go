@@ -0,0 +1,128 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/iraven/iraven-admin/pkg/config"
+)
+
+// newTestStripe returns a Stripe client served by a fresh stand-in.
+func newTestStripe(t *testing.T) (*Stripe, *stripeStandIn) {
+	t.Helper()
+	standIn := newStripeStandIn()
+	srv := httptest.NewServer(standIn)
+	t.Cleanup(srv.Close)
+	return &Stripe{BaseURL: srv.URL, SecretKey: "sk_test", Client: srv.Client()}, standIn
+}
+
+func TestNewRequiresDriver(t *testing.T) {
+	if _, err := New(&config.PaymentsConfig{}); err == nil {
+		t.Error("empty driver: expected an error")
+	}
+	if _, err := New(&config.PaymentsConfig{Driver: "stripe"}); err == nil {
+		t.Error("stripe without a secret key: expected an error")
+	}
+	if _, err := New(&config.PaymentsConfig{Driver: "paypal"}); err == nil {
+		t.Error("unknown driver: expected an error")
+	}
+	if p, err := New(&config.PaymentsConfig{Driver: "stub"}); err != nil || p.Name() != "stub" {
+		t.Errorf("stub driver: got %v, %v", p, err)
+	}
+}
+
+func TestRefund(t *testing.T) {
+	stripe, standIn := newTestStripe(t)
+	standIn.AddPayment("pi_1", 5000, "eur", "succeeded")
+	ctx := context.Background()
+
+	refund, err := stripe.Refund(ctx, RefundRequest{PaymentID: "pi_1", Amount: 2000, Currency: "eur", Reason: "damaged", IdempotencyKey: "refund-1"})
+	if err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	if refund.ID == "" || refund.Status != "succeeded" {
+		t.Errorf("Refund = %+v", refund)
+	}
+
+	// A retry with the same key is not refunded twice
+	again, err := stripe.Refund(ctx, RefundRequest{PaymentID: "pi_1", Amount: 2000, Currency: "eur", IdempotencyKey: "refund-1"})
+	if err != nil {
+		t.Fatalf("Refund retry: %v", err)
+	}
+	if again.ID != refund.ID {
+		t.Errorf("retry got refund %s, want %s", again.ID, refund.ID)
+	}
+	if got := standIn.Refunded("pi_1"); got != 2000 {
+		t.Errorf("Refunded = %d, want 2000", got)
+	}
+
+	_, err = stripe.Refund(ctx, RefundRequest{PaymentID: "pi_1", Amount: 3001, Currency: "eur", IdempotencyKey: "refund-2"})
+	if err == nil || !strings.Contains(err.Error(), "greater than unrefunded amount") {
+		t.Errorf("over-refund: got %v", err)
+	}
+
+	if _, err := stripe.Refund(ctx, RefundRequest{Amount: 100}); !errors.Is(err, ErrNoPaymentID) {
+		t.Errorf("no payment ID: got %v, want ErrNoPaymentID", err)
+	}
+}
+
+func TestRefundCharge(t *testing.T) {
+	stripe, standIn := newTestStripe(t)
+	standIn.AddPayment("ch_1", 1000, "usd", "succeeded")
+
+	if _, err := stripe.Refund(context.Background(), RefundRequest{PaymentID: "ch_1", Amount: 1000, Currency: "usd"}); err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	if got := standIn.Refunded("ch_1"); got != 1000 {
+		t.Errorf("Refunded = %d, want 1000", got)
+	}
+}
+
+func TestRefundUnsettledPayment(t *testing.T) {
+	stripe, standIn := newTestStripe(t)
+	standIn.AddPayment("pi_1", 5000, "eur", "processing")
+
+	_, err := stripe.Refund(context.Background(), RefundRequest{PaymentID: "pi_1", Amount: 100, Currency: "eur"})
+	if err == nil || !strings.Contains(err.Error(), "cannot be refunded") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	stripe, standIn := newTestStripe(t)
+	standIn.AddPayment("pi_open", 5000, "eur", "requires_payment_method")
+	standIn.AddPayment("pi_done", 5000, "eur", "succeeded")
+	ctx := context.Background()
+
+	if err := stripe.Cancel(ctx, "pi_open", "cancel-1"); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	// The retry gets the stored reply rather than "already canceled"
+	if err := stripe.Cancel(ctx, "pi_open", "cancel-1"); err != nil {
+		t.Errorf("Cancel retry: %v", err)
+	}
+	if err := stripe.Cancel(ctx, "pi_open", "cancel-2"); err == nil {
+		t.Error("second cancel: expected an error")
+	}
+	if err := stripe.Cancel(ctx, "pi_done", ""); err == nil || !strings.Contains(err.Error(), "status of succeeded") {
+		t.Errorf("cancel succeeded payment: got %v", err)
+	}
+	if err := stripe.Cancel(ctx, "ch_1", ""); err == nil {
+		t.Error("cancel charge: expected an error")
+	}
+	if err := stripe.Cancel(ctx, "", ""); !errors.Is(err, ErrNoPaymentID) {
+		t.Errorf("no payment ID: got %v, want ErrNoPaymentID", err)
+	}
+}
+
+func TestStandInRejectsMissingKey(t *testing.T) {
+	stripe, _ := newTestStripe(t)
+	stripe.SecretKey = ""
+
+	if _, err := stripe.Refund(context.Background(), RefundRequest{PaymentID: "pi_1", Amount: 100}); err == nil {
+		t.Error("expected an error without a secret key")
+	}
+}
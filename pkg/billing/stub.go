@@ -0,0 +1,123 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Stub is an in-memory Provider for local development without a Stripe
+// account. It applies Stripe's rules to the payments it knows: only
+// succeeded payments are refunded, never for more than is left, and only
+// payment intents that have not completed are canceled. Payments not
+// added beforehand are taken to be succeeded payments of unknown amount,
+// so any refund of them is accepted. A request repeating an idempotency
+// key gets the first result.
+type Stub struct {
+	mu       sync.Mutex
+	payments map[string]*stubPayment
+	refunds  map[string]Refund
+	canceled map[string]bool
+	count    int
+}
+
+type stubPayment struct {
+	// amount is negative when not known
+	amount   int64
+	status   string
+	refunded int64
+}
+
+func NewStub() *Stub {
+	return &Stub{payments: map[string]*stubPayment{}, refunds: map[string]Refund{}, canceled: map[string]bool{}}
+}
+
+func (s *Stub) Name() string {
+	return "stub"
+}
+
+// AddPayment registers a payment intent or charge with its amount and
+// Stripe status.
+func (s *Stub) AddPayment(id string, amount int64, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.payments[id] = &stubPayment{amount: amount, status: status}
+}
+
+// Refunded returns the amount refunded from a payment so far.
+func (s *Stub) Refunded(id string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p := s.payments[id]; p != nil {
+		return p.refunded
+	}
+	return 0
+}
+
+func (s *Stub) Refund(ctx context.Context, req RefundRequest) (Refund, error) {
+	if req.PaymentID == "" {
+		return Refund{}, ErrNoPaymentID
+	}
+	if req.Amount <= 0 {
+		return Refund{}, fmt.Errorf("stub: invalid refund amount %d", req.Amount)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if refund, ok := s.refunds[req.IdempotencyKey]; ok && req.IdempotencyKey != "" {
+		return refund, nil
+	}
+
+	p := s.payments[req.PaymentID]
+	if p == nil {
+		p = &stubPayment{amount: -1, status: "succeeded"}
+		s.payments[req.PaymentID] = p
+	}
+	if p.status != "succeeded" {
+		return Refund{}, fmt.Errorf("stub: payment %s has status %s and cannot be refunded", req.PaymentID, p.status)
+	}
+	if p.amount >= 0 && p.refunded+req.Amount > p.amount {
+		return Refund{}, fmt.Errorf("stub: refund amount (%d) is greater than unrefunded amount on payment (%d)",
+			req.Amount, p.amount-p.refunded)
+	}
+	p.refunded += req.Amount
+
+	s.count++
+	refund := Refund{ID: fmt.Sprintf("re_stub_%d", s.count), Status: "succeeded"}
+	if req.IdempotencyKey != "" {
+		s.refunds[req.IdempotencyKey] = refund
+	}
+	return refund, nil
+}
+
+func (s *Stub) Cancel(ctx context.Context, paymentID, idempotencyKey string) error {
+	if paymentID == "" {
+		return ErrNoPaymentID
+	}
+	if !strings.HasPrefix(paymentID, "pi_") {
+		return fmt.Errorf("stub: only payment intents can be canceled, not %s", paymentID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.canceled[idempotencyKey] && idempotencyKey != "" {
+		return nil
+	}
+
+	p := s.payments[paymentID]
+	if p == nil {
+		p = &stubPayment{amount: -1, status: "requires_payment_method"}
+		s.payments[paymentID] = p
+	}
+	switch p.status {
+	case "succeeded", "canceled":
+		return fmt.Errorf("stub: payment %s has status %s and cannot be canceled", paymentID, p.status)
+	}
+	p.status = "canceled"
+
+	if idempotencyKey != "" {
+		s.canceled[idempotencyKey] = true
+	}
+	return nil
+}
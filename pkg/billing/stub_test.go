@@ -0,0 +1,71 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestStubRefund(t *testing.T) {
+	s := NewStub()
+	s.AddPayment("pi_1", 5000, "succeeded")
+	s.AddPayment("pi_2", 5000, "processing")
+	ctx := context.Background()
+
+	refund, err := s.Refund(ctx, RefundRequest{PaymentID: "pi_1", Amount: 2000, IdempotencyKey: "refund-1"})
+	if err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	if refund.ID == "" || refund.Status != "succeeded" {
+		t.Errorf("Refund = %+v", refund)
+	}
+	again, err := s.Refund(ctx, RefundRequest{PaymentID: "pi_1", Amount: 2000, IdempotencyKey: "refund-1"})
+	if err != nil || again != refund {
+		t.Errorf("retry = %+v, %v; want %+v", again, err, refund)
+	}
+	if got := s.Refunded("pi_1"); got != 2000 {
+		t.Errorf("Refunded = %d, want 2000", got)
+	}
+
+	if _, err := s.Refund(ctx, RefundRequest{PaymentID: "pi_1", Amount: 3001, IdempotencyKey: "refund-2"}); err == nil {
+		t.Error("over-refund: expected an error")
+	}
+	if _, err := s.Refund(ctx, RefundRequest{PaymentID: "pi_1", Amount: 3000, IdempotencyKey: "refund-3"}); err != nil {
+		t.Errorf("refunding the rest: %v", err)
+	}
+	if _, err := s.Refund(ctx, RefundRequest{PaymentID: "pi_2", Amount: 100}); err == nil {
+		t.Error("refund of a processing payment: expected an error")
+	}
+	if _, err := s.Refund(ctx, RefundRequest{PaymentID: "ch_unknown", Amount: 100}); err != nil {
+		t.Errorf("refund of an unknown payment: %v", err)
+	}
+	if _, err := s.Refund(ctx, RefundRequest{Amount: 100}); !errors.Is(err, ErrNoPaymentID) {
+		t.Errorf("no payment ID: got %v, want ErrNoPaymentID", err)
+	}
+}
+
+func TestStubCancel(t *testing.T) {
+	s := NewStub()
+	s.AddPayment("pi_open", 5000, "requires_payment_method")
+	s.AddPayment("pi_done", 5000, "succeeded")
+	ctx := context.Background()
+
+	if err := s.Cancel(ctx, "pi_open", "cancel-1"); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	if err := s.Cancel(ctx, "pi_open", "cancel-1"); err != nil {
+		t.Errorf("Cancel retry: %v", err)
+	}
+	if err := s.Cancel(ctx, "pi_open", "cancel-2"); err == nil {
+		t.Error("second cancel: expected an error")
+	}
+	if err := s.Cancel(ctx, "pi_done", ""); err == nil {
+		t.Error("cancel of a succeeded payment: expected an error")
+	}
+	if err := s.Cancel(ctx, "ch_1", ""); err == nil {
+		t.Error("cancel of a charge: expected an error")
+	}
+	if err := s.Cancel(ctx, "", ""); !errors.Is(err, ErrNoPaymentID) {
+		t.Errorf("no payment ID: got %v, want ErrNoPaymentID", err)
+	}
+}
@@ -0,0 +1,72 @@
+package billing
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseWebhook(t *testing.T) {
+	const secret = "whsec_test"
+	now := time.Unix(1700000000, 0)
+	payload := []byte(`{"id":"evt_1","type":"charge.refunded","created":1700000000,"data":{"object":{"id":"ch_1","payment_intent":"pi_1","refunded":false,"amount_refunded":200}}}`)
+
+	event, err := ParseWebhook(payload, SignWebhook(payload, secret, now), secret, now)
+	if err != nil {
+		t.Fatalf("ParseWebhook: %v", err)
+	}
+	if event.ID != "evt_1" || event.Type != "charge.refunded" {
+		t.Errorf("event = %+v", event)
+	}
+	update, ok := event.PaymentUpdate()
+	if !ok || update.Status != "partially_refunded" {
+		t.Fatalf("PaymentUpdate = %+v, %v", update, ok)
+	}
+	if len(update.PaymentIDs) != 2 || update.PaymentIDs[0] != "ch_1" || update.PaymentIDs[1] != "pi_1" {
+		t.Errorf("PaymentIDs = %v", update.PaymentIDs)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		secret string
+	}{
+		{"wrong secret", SignWebhook(payload, "whsec_other", now), secret},
+		{"no secret", SignWebhook(payload, secret, now), ""},
+		{"stale", SignWebhook(payload, secret, now.Add(-WebhookTolerance-time.Second)), secret},
+		{"malformed", "v1=abc", secret},
+		{"tampered", SignWebhook([]byte(`{"id":"evt_2"}`), secret, now), secret},
+	}
+	for _, tt := range tests {
+		if _, err := ParseWebhook(payload, tt.header, tt.secret, now); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: got %v, want ErrInvalidSignature", tt.name, err)
+		}
+	}
+}
+
+func TestPaymentUpdate(t *testing.T) {
+	tests := []struct {
+		event  string
+		want   string
+		report bool
+	}{
+		{`{"type":"payment_intent.succeeded","data":{"object":{"id":"pi_1"}}}`, "succeeded", true},
+		{`{"type":"payment_intent.payment_failed","data":{"object":{"id":"pi_1"}}}`, "failed", true},
+		{`{"type":"payment_intent.canceled","data":{"object":{"id":"pi_1"}}}`, "canceled", true},
+		{`{"type":"charge.refunded","data":{"object":{"id":"ch_1","refunded":true,"amount_refunded":500}}}`, "refunded", true},
+		{`{"type":"charge.refunded","data":{"object":{"id":"ch_1","amount_refunded":0}}}`, "", false},
+		{`{"type":"customer.created","data":{"object":{"id":"cus_1"}}}`, "", false},
+	}
+	for _, tt := range tests {
+		payload := []byte(tt.event)
+		now := time.Now()
+		event, err := ParseWebhook(payload, SignWebhook(payload, "whsec_test", now), "whsec_test", now)
+		if err != nil {
+			t.Fatalf("ParseWebhook(%s): %v", tt.event, err)
+		}
+		update, ok := event.PaymentUpdate()
+		if ok != tt.report || update.Status != tt.want {
+			t.Errorf("%s: PaymentUpdate = %q, %v; want %q, %v", tt.event, update.Status, ok, tt.want, tt.report)
+		}
+	}
+}
@@ -13,6 +13,9 @@ type Config struct {
 	API      APIConfig      `yaml:"api"`
 	Auth     AuthConfig     `yaml:"auth"`
 	Admin    AdminConfig    `yaml:"admin"`
+	Storage  StorageConfig  `yaml:"storage"`
+	Notify   NotifyConfig   `yaml:"notifications"`
+	Payments PaymentsConfig `yaml:"payments"`
 }
 
 type ServerConfig struct {
@@ -43,8 +46,82 @@ type AuthConfig struct {
 }
 
 type AdminConfig struct {
-	DefaultPageSize int `yaml:"default_page_size"`
-	MaxPageSize     int `yaml:"max_page_size"`
+	DefaultPageSize int    `yaml:"default_page_size"`
+	MaxPageSize     int    `yaml:"max_page_size"`
+	DefaultLanguage string `yaml:"default_language"`
+	// Requested user erasures can be canceled for this many days before
+	// they are carried out
+	ErasureGraceDays int `yaml:"erasure_grace_days"`
+}
+
+type StorageConfig struct {
+	Driver        string `yaml:"driver"`
+	Bucket        string `yaml:"bucket"`
+	LocalPath     string `yaml:"local_path"`
+	PublicURL     string `yaml:"public_url"`
+	MaxUploadSize int64  `yaml:"max_upload_size"`
+	// Default quotas in bytes; zero means unlimited
+	UserQuota        int64    `yaml:"user_quota"`
+	ApplicationQuota int64    `yaml:"application_quota"`
+	S3               S3Config `yaml:"s3"`
+}
+
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	PathStyle bool   `yaml:"path_style"`
+}
+
+// NotifyConfig selects the provider of each delivery channel. Every
+// channel needs a driver; the "stub" driver logs messages instead of
+// sending them, and their deliveries are skipped.
+type NotifyConfig struct {
+	Email EmailConfig `yaml:"email"`
+	Push  PushConfig  `yaml:"push"`
+	SMS   SMSConfig   `yaml:"sms"`
+}
+
+type EmailConfig struct {
+	Driver   string `yaml:"driver"` // stub or smtp
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+}
+
+type PushConfig struct {
+	Driver string `yaml:"driver"` // stub or webhook
+	URL    string `yaml:"url"`
+	Token  string `yaml:"token"`
+	// Devices the app has not registered or refreshed for this many days
+	// are pruned; zero, or the stub driver, keeps them
+	StaleDays int `yaml:"stale_days"`
+}
+
+type SMSConfig struct {
+	Driver     string `yaml:"driver"` // stub or twilio
+	AccountSID string `yaml:"account_sid"`
+	AuthToken  string `yaml:"auth_token"`
+	From       string `yaml:"from"`
+}
+
+// PaymentsConfig selects the payment provider refunds and cancellations go
+// through. Without a valid driver the admin runs with refunds and
+// cancellations disabled; the "stub" driver fakes Stripe in memory and is
+// meant for development only.
+type PaymentsConfig struct {
+	Driver string       `yaml:"driver"` // stripe or stub
+	Stripe StripeConfig `yaml:"stripe"`
+}
+
+type StripeConfig struct {
+	SecretKey string `yaml:"secret_key"`
+	// Signing secret of the webhook endpoint (whsec_...); webhooks are
+	// rejected without one
+	WebhookSecret string `yaml:"webhook_secret"`
 }
 
 func Load(configPath string) (*Config, error) {
@@ -99,6 +176,42 @@ func (c *Config) overrideFromEnv() {
 	if debug := os.Getenv("DEBUG"); debug != "" {
 		c.Server.Debug = debug == "true"
 	}
+	if defaultLanguage := os.Getenv("DEFAULT_LANGUAGE"); defaultLanguage != "" {
+		c.Admin.DefaultLanguage = defaultLanguage
+	}
+	if driver := os.Getenv("STORAGE_DRIVER"); driver != "" {
+		c.Storage.Driver = driver
+	}
+	if bucket := os.Getenv("STORAGE_BUCKET"); bucket != "" {
+		c.Storage.Bucket = bucket
+	}
+	if endpoint := os.Getenv("S3_ENDPOINT"); endpoint != "" {
+		c.Storage.S3.Endpoint = endpoint
+	}
+	if accessKey := os.Getenv("S3_ACCESS_KEY"); accessKey != "" {
+		c.Storage.S3.AccessKey = accessKey
+	}
+	if secretKey := os.Getenv("S3_SECRET_KEY"); secretKey != "" {
+		c.Storage.S3.SecretKey = secretKey
+	}
+	if password := os.Getenv("SMTP_PASSWORD"); password != "" {
+		c.Notify.Email.Password = password
+	}
+	if token := os.Getenv("PUSH_TOKEN"); token != "" {
+		c.Notify.Push.Token = token
+	}
+	if authToken := os.Getenv("TWILIO_AUTH_TOKEN"); authToken != "" {
+		c.Notify.SMS.AuthToken = authToken
+	}
+	if driver := os.Getenv("PAYMENTS_DRIVER"); driver != "" {
+		c.Payments.Driver = driver
+	}
+	if secretKey := os.Getenv("STRIPE_SECRET_KEY"); secretKey != "" {
+		c.Payments.Stripe.SecretKey = secretKey
+	}
+	if webhookSecret := os.Getenv("STRIPE_WEBHOOK_SECRET"); webhookSecret != "" {
+		c.Payments.Stripe.WebhookSecret = webhookSecret
+	}
 }
 
 func (c *DatabaseConfig) DSN() string {
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/iraven/iraven-admin/pkg/config"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -49,3 +50,12 @@ func (db *Database) Close() {
 func (db *Database) Ping(ctx context.Context) error {
 	return db.Pool.Ping(ctx)
 }
+
+// TextSearchConfigs lists the text search configurations the server has.
+func (db *Database) TextSearchConfigs(ctx context.Context) ([]string, error) {
+	rows, err := db.Pool.Query(ctx, "SELECT cfgname FROM pg_catalog.pg_ts_config")
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
@@ -0,0 +1,67 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migrate applies the admin-owned schema changes in migrations/ that have not
+// been recorded in iraven.admin_migrations yet. Files run in name order, each
+// inside its own transaction.
+func (db *Database) Migrate(ctx context.Context) error {
+	_, err := db.Pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS iraven.admin_migrations (
+			version TEXT PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`)
+	if err != nil {
+		return fmt.Errorf("unable to create migrations table: %w", err)
+	}
+
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var applied bool
+		if err := db.Pool.QueryRow(ctx,
+			"SELECT EXISTS (SELECT 1 FROM iraven.admin_migrations WHERE version = $1)", name).
+			Scan(&applied); err != nil {
+			return fmt.Errorf("unable to check migration %s: %w", name, err)
+		}
+		if applied {
+			continue
+		}
+
+		script, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		tx, err := db.Pool.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, string(script)); err != nil {
+			tx.Rollback(ctx)
+			return fmt.Errorf("unable to apply migration %s: %w", name, err)
+		}
+		if _, err := tx.Exec(ctx, "INSERT INTO iraven.admin_migrations (version) VALUES ($1)", name); err != nil {
+			tx.Rollback(ctx)
+			return fmt.Errorf("unable to record migration %s: %w", name, err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS iraven.application_domain_verifications (
+    application_id BIGINT PRIMARY KEY REFERENCES iraven.applications(id) ON DELETE CASCADE,
+    domain TEXT NOT NULL,
+    method TEXT NOT NULL,
+    token TEXT NOT NULL,
+    verified BOOLEAN NOT NULL DEFAULT false,
+    verified_at TIMESTAMPTZ,
+    last_checked_at TIMESTAMPTZ,
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
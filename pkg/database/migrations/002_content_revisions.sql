@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS iraven.content_revisions (
+    id BIGSERIAL PRIMARY KEY,
+    content_id BIGINT NOT NULL REFERENCES iraven.content(id) ON DELETE CASCADE,
+    revision INT NOT NULL,
+    slug TEXT NOT NULL,
+    title TEXT NOT NULL,
+    data TEXT,
+    author_id BIGINT REFERENCES iraven.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (content_id, revision)
+);
+
+-- Revisions are an audit history: once written they must not change. Only
+-- author_id may be cleared, so that deleting a user does not fail.
+CREATE OR REPLACE FUNCTION iraven.content_revisions_immutable() RETURNS trigger AS $$
+BEGIN
+    IF NEW.content_id <> OLD.content_id OR NEW.revision <> OLD.revision
+        OR NEW.slug <> OLD.slug OR NEW.title <> OLD.title
+        OR NEW.data IS DISTINCT FROM OLD.data OR NEW.created_at <> OLD.created_at
+        OR NEW.author_id IS NOT NULL AND NEW.author_id IS DISTINCT FROM OLD.author_id THEN
+        RAISE EXCEPTION 'content revisions are immutable';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS content_revisions_immutable ON iraven.content_revisions;
+CREATE TRIGGER content_revisions_immutable
+    BEFORE UPDATE ON iraven.content_revisions
+    FOR EACH ROW EXECUTE FUNCTION iraven.content_revisions_immutable();
+
+-- Seed a first revision for content that predates revision tracking.
+INSERT INTO iraven.content_revisions (content_id, revision, slug, title, data, author_id, created_at)
+SELECT id, 1, slug, title, data::text, created_by, updated_at
+FROM iraven.content
+ON CONFLICT (content_id, revision) DO NOTHING;
@@ -0,0 +1,17 @@
+ALTER TABLE iraven.content
+    ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'published',
+    ADD COLUMN IF NOT EXISTS published_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS scheduled_at TIMESTAMPTZ;
+
+-- Everything that existed before the workflow was live.
+UPDATE iraven.content SET published_at = created_at WHERE status = 'published' AND published_at IS NULL;
+
+-- New content starts as a draft.
+ALTER TABLE iraven.content ALTER COLUMN status SET DEFAULT 'draft';
+
+ALTER TABLE iraven.content DROP CONSTRAINT IF EXISTS content_status_check;
+ALTER TABLE iraven.content ADD CONSTRAINT content_status_check
+    CHECK (status IN ('draft', 'in_review', 'published', 'archived'));
+
+CREATE INDEX IF NOT EXISTS content_status_idx ON iraven.content (status);
+CREATE INDEX IF NOT EXISTS content_scheduled_at_idx ON iraven.content (scheduled_at) WHERE scheduled_at IS NOT NULL;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS iraven.content_types (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    key TEXT NOT NULL UNIQUE,
+    description TEXT,
+    schema TEXT NOT NULL DEFAULT '{"type": "object"}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE iraven.content
+    ADD COLUMN IF NOT EXISTS content_type_id BIGINT REFERENCES iraven.content_types(id) ON DELETE RESTRICT;
+
+CREATE INDEX IF NOT EXISTS content_content_type_id_idx ON iraven.content (content_type_id);
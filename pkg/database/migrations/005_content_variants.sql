@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS iraven.content_variants (
+    id BIGSERIAL PRIMARY KEY,
+    content_id BIGINT NOT NULL REFERENCES iraven.content(id) ON DELETE CASCADE,
+    language_id BIGINT NOT NULL REFERENCES iraven.languages(id) ON DELETE CASCADE,
+    title TEXT NOT NULL,
+    data TEXT,
+    updated_by BIGINT REFERENCES iraven.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (content_id, language_id)
+);
+
+CREATE INDEX IF NOT EXISTS content_variants_language_id_idx ON iraven.content_variants (language_id);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS iraven.content_slug_redirects (
+    id BIGSERIAL PRIMARY KEY,
+    from_slug TEXT NOT NULL UNIQUE,
+    to_slug TEXT NOT NULL,
+    content_id BIGINT NOT NULL REFERENCES iraven.content(id) ON DELETE CASCADE,
+    created_by BIGINT REFERENCES iraven.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS content_slug_redirects_content_id_idx ON iraven.content_slug_redirects (content_id);
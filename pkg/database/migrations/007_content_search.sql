@@ -0,0 +1,20 @@
+-- One search document per content entry and language: the source entry
+-- (language_id NULL, indexed with the site default language) plus one row
+-- for each translated variant. Rows are written by the admin whenever
+-- content changes and can be rebuilt from scratch.
+CREATE TABLE IF NOT EXISTS iraven.content_search (
+    id BIGSERIAL PRIMARY KEY,
+    content_id BIGINT NOT NULL REFERENCES iraven.content(id) ON DELETE CASCADE,
+    language_id BIGINT REFERENCES iraven.languages(id) ON DELETE CASCADE,
+    config REGCONFIG NOT NULL,
+    title TEXT NOT NULL,
+    body TEXT NOT NULL,
+    document TSVECTOR NOT NULL,
+    indexed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS content_search_content_language_idx
+    ON iraven.content_search (content_id, COALESCE(language_id, 0));
+
+CREATE INDEX IF NOT EXISTS content_search_document_idx
+    ON iraven.content_search USING GIN (document);
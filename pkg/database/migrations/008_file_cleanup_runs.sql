@@ -0,0 +1,14 @@
+-- History of orphaned file scans: scheduled and on-demand dry runs, and
+-- purges confirmed by an admin.
+CREATE TABLE IF NOT EXISTS iraven.file_cleanup_runs (
+    id BIGSERIAL PRIMARY KEY,
+    dry_run BOOLEAN NOT NULL,
+    unattached_files INTEGER NOT NULL DEFAULT 0,
+    dangling_attachments INTEGER NOT NULL DEFAULT 0,
+    untracked_objects INTEGER NOT NULL DEFAULT 0,
+    reclaimable_bytes BIGINT NOT NULL DEFAULT 0,
+    run_by BIGINT REFERENCES iraven.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS file_cleanup_runs_created_at_idx ON iraven.file_cleanup_runs (created_at DESC);
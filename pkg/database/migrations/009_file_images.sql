@@ -0,0 +1,22 @@
+-- Dimensions of uploaded images, and the resized variants rendered from
+-- them. Variant objects live in the same bucket as their file.
+CREATE TABLE IF NOT EXISTS iraven.file_images (
+    file_id BIGINT PRIMARY KEY REFERENCES iraven.files(id) ON DELETE CASCADE,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    processed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS iraven.file_variants (
+    id BIGSERIAL PRIMARY KEY,
+    file_id BIGINT NOT NULL REFERENCES iraven.files(id) ON DELETE CASCADE,
+    name VARCHAR(50) NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    mime_type VARCHAR(100) NOT NULL,
+    size BIGINT NOT NULL,
+    path TEXT NOT NULL,
+    url TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (file_id, name)
+);
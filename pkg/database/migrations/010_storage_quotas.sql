@@ -0,0 +1,13 @@
+-- Storage quotas granted to specific users and applications, overriding
+-- the configured defaults.
+CREATE TABLE IF NOT EXISTS iraven.storage_quotas (
+    id BIGSERIAL PRIMARY KEY,
+    subject_type VARCHAR(20) NOT NULL CHECK (subject_type IN ('user', 'application')),
+    subject_id BIGINT NOT NULL,
+    quota_bytes BIGINT NOT NULL CHECK (quota_bytes >= 0),
+    reason TEXT,
+    updated_by BIGINT REFERENCES iraven.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (subject_type, subject_id)
+);
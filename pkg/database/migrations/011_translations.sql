@@ -0,0 +1,36 @@
+-- UI string translations for the apps. Keys are grouped into namespaces and
+-- have at most one value per language.
+CREATE TABLE IF NOT EXISTS iraven.translation_keys (
+    id BIGSERIAL PRIMARY KEY,
+    namespace VARCHAR(100) NOT NULL DEFAULT 'common',
+    key VARCHAR(255) NOT NULL,
+    description TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (namespace, key)
+);
+
+CREATE TABLE IF NOT EXISTS iraven.translation_values (
+    key_id BIGINT NOT NULL REFERENCES iraven.translation_keys(id) ON DELETE CASCADE,
+    language_id BIGINT NOT NULL REFERENCES iraven.languages(id) ON DELETE CASCADE,
+    value TEXT NOT NULL,
+    updated_by BIGINT REFERENCES iraven.users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (key_id, language_id)
+);
+
+CREATE INDEX IF NOT EXISTS translation_values_language_idx ON iraven.translation_values (language_id);
+
+-- Published snapshots of a language's translations, read by the API. Data
+-- maps namespace to key to value; the highest version is current.
+CREATE TABLE IF NOT EXISTS iraven.translation_bundles (
+    id BIGSERIAL PRIMARY KEY,
+    language_id BIGINT NOT NULL REFERENCES iraven.languages(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    checksum CHAR(64) NOT NULL,
+    data JSONB NOT NULL,
+    key_count INTEGER NOT NULL,
+    published_by BIGINT REFERENCES iraven.users(id) ON DELETE SET NULL,
+    published_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (language_id, version)
+);
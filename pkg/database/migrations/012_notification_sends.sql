@@ -0,0 +1,49 @@
+-- Saved audiences for notifications. Filter is a JSON object of criteria
+-- that users must all match.
+CREATE TABLE IF NOT EXISTS iraven.notification_segments (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    description TEXT,
+    filter JSONB NOT NULL DEFAULT '{}',
+    created_by BIGINT REFERENCES iraven.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- A notification composed in the admin and sent to a target audience.
+CREATE TABLE IF NOT EXISTS iraven.notification_sends (
+    id BIGSERIAL PRIMARY KEY,
+    title VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL,
+    data JSONB,
+    target_type VARCHAR(20) NOT NULL CHECK (target_type IN ('user', 'role', 'application', 'segment')),
+    target_id BIGINT NOT NULL,
+    channels TEXT[] NOT NULL,
+    recipients INTEGER NOT NULL DEFAULT 0,
+    created_by BIGINT REFERENCES iraven.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- One row per recipient and channel. Queued rows are picked up by the
+-- delivery job; skipped rows record why a channel was not used, such as
+-- the user having opted out of it.
+CREATE TABLE IF NOT EXISTS iraven.notification_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    send_id BIGINT NOT NULL REFERENCES iraven.notification_sends(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES iraven.users(id) ON DELETE CASCADE,
+    channel VARCHAR(20) NOT NULL CHECK (channel IN ('in_app', 'email', 'push', 'sms')),
+    status VARCHAR(20) NOT NULL CHECK (status IN ('queued', 'sending', 'sent', 'failed', 'skipped')),
+    notification_id BIGINT,
+    provider VARCHAR(50),
+    provider_message_id TEXT,
+    error TEXT,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS notification_deliveries_send_idx ON iraven.notification_deliveries (send_id, channel, status);
+CREATE INDEX IF NOT EXISTS notification_deliveries_queue_idx ON iraven.notification_deliveries (next_attempt_at)
+    WHERE status IN ('queued', 'sending');
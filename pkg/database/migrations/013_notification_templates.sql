@@ -0,0 +1,41 @@
+-- Reusable notifications, referenced by key from the composer and from
+-- automated triggers. Every saved change creates a new version; version
+-- points at the current one.
+CREATE TABLE IF NOT EXISTS iraven.notification_templates (
+    id BIGSERIAL PRIMARY KEY,
+    key VARCHAR(100) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    version INTEGER NOT NULL DEFAULT 1,
+    created_by BIGINT REFERENCES iraven.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS iraven.notification_template_versions (
+    id BIGSERIAL PRIMARY KEY,
+    template_id BIGINT NOT NULL REFERENCES iraven.notification_templates(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    author_id BIGINT REFERENCES iraven.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (template_id, version)
+);
+
+-- The title and body of a version in one language.
+CREATE TABLE IF NOT EXISTS iraven.notification_template_variants (
+    version_id BIGINT NOT NULL REFERENCES iraven.notification_template_versions(id) ON DELETE CASCADE,
+    language_id BIGINT NOT NULL REFERENCES iraven.languages(id) ON DELETE CASCADE,
+    title TEXT NOT NULL,
+    body TEXT NOT NULL,
+    PRIMARY KEY (version_id, language_id)
+);
+
+ALTER TABLE iraven.notification_sends
+    ADD COLUMN IF NOT EXISTS template_id BIGINT REFERENCES iraven.notification_templates(id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS template_version INTEGER;
+
+-- Title and body as rendered for the recipient. Deliveries queued before
+-- templates existed use the send's.
+ALTER TABLE iraven.notification_deliveries
+    ADD COLUMN IF NOT EXISTS title TEXT,
+    ADD COLUMN IF NOT EXISTS body TEXT;
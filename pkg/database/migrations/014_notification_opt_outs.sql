@@ -0,0 +1,11 @@
+-- Recipients who opted out of a channel get their own status, so that
+-- analytics can tell them apart from those with no address on it.
+ALTER TABLE iraven.notification_deliveries DROP CONSTRAINT IF EXISTS notification_deliveries_status_check;
+ALTER TABLE iraven.notification_deliveries ADD CONSTRAINT notification_deliveries_status_check
+    CHECK (status IN ('queued', 'sending', 'sent', 'failed', 'skipped', 'opted_out'));
+
+UPDATE iraven.notification_deliveries SET status = 'opted_out', error = NULL
+WHERE status = 'skipped' AND error = 'Opted out';
+
+CREATE INDEX IF NOT EXISTS notification_deliveries_failed_idx ON iraven.notification_deliveries (updated_at)
+    WHERE status = 'failed';
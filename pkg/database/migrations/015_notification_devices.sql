@@ -0,0 +1,21 @@
+-- Push diagnostics on the API's device tokens. invalid_at is set when the
+-- push service reports a token as no longer registered; such tokens are
+-- not pushed to again and are pruned.
+ALTER TABLE iraven.notification_devices
+    ADD COLUMN IF NOT EXISTS last_success_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS last_error TEXT,
+    ADD COLUMN IF NOT EXISTS last_error_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS invalid_at TIMESTAMPTZ;
+
+-- Devices revoked by an admin or pruned by the cleanup job.
+CREATE TABLE IF NOT EXISTS iraven.notification_device_removals (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES iraven.users(id) ON DELETE CASCADE,
+    device_token TEXT NOT NULL,
+    device_type VARCHAR(50),
+    reason VARCHAR(20) NOT NULL CHECK (reason IN ('revoked', 'invalid', 'stale')),
+    removed_by BIGINT REFERENCES iraven.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS notification_device_removals_user_idx ON iraven.notification_device_removals (user_id, created_at);
@@ -0,0 +1,19 @@
+-- Refunds and cancellations admins perform on payments, with the reason
+-- given. Failed attempts are kept too, with the provider's error. Rows are
+-- an audit trail and are never updated.
+CREATE TABLE IF NOT EXISTS iraven.payment_actions (
+    id BIGSERIAL PRIMARY KEY,
+    payment_id BIGINT NOT NULL REFERENCES iraven.payments(id) ON DELETE CASCADE,
+    action VARCHAR(20) NOT NULL CHECK (action IN ('refund', 'cancel')),
+    amount BIGINT NOT NULL DEFAULT 0,
+    currency VARCHAR(10) NOT NULL,
+    reason TEXT NOT NULL CHECK (btrim(reason) <> ''),
+    status VARCHAR(20) NOT NULL CHECK (status IN ('succeeded', 'failed')),
+    provider VARCHAR(50) NOT NULL,
+    provider_ref VARCHAR(255),
+    error TEXT,
+    performed_by BIGINT REFERENCES iraven.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS payment_actions_payment_idx ON iraven.payment_actions (payment_id, created_at);
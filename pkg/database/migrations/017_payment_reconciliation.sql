@@ -0,0 +1,45 @@
+-- Stripe webhook events received, so that redelivered events are applied
+-- once and events arriving out of order do not undo newer ones.
+CREATE TABLE IF NOT EXISTS iraven.stripe_events (
+    id VARCHAR(255) PRIMARY KEY,
+    type VARCHAR(100) NOT NULL,
+    payment_id BIGINT REFERENCES iraven.payments(id) ON DELETE SET NULL,
+    status VARCHAR(20),
+    outcome VARCHAR(20) NOT NULL CHECK (outcome IN ('applied', 'unchanged', 'stale', 'unmatched', 'ignored')),
+    event_created TIMESTAMPTZ NOT NULL,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS stripe_events_payment_idx ON iraven.stripe_events (payment_id, event_created);
+
+-- Reconciliations of iraven.payments against uploaded Stripe balance
+-- exports. Uploads are queued and compared by a background job.
+CREATE TABLE IF NOT EXISTS iraven.payment_reconciliations (
+    id BIGSERIAL PRIMARY KEY,
+    file_name VARCHAR(255) NOT NULL,
+    csv TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'running', 'done', 'failed')),
+    error TEXT,
+    transactions INTEGER NOT NULL DEFAULT 0,
+    period_from TIMESTAMPTZ,
+    period_to TIMESTAMPTZ,
+    matched INTEGER NOT NULL DEFAULT 0,
+    issues INTEGER NOT NULL DEFAULT 0,
+    created_by BIGINT REFERENCES iraven.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS payment_reconciliations_queued_idx ON iraven.payment_reconciliations (id) WHERE status = 'queued';
+
+CREATE TABLE IF NOT EXISTS iraven.payment_reconciliation_issues (
+    id BIGSERIAL PRIMARY KEY,
+    reconciliation_id BIGINT NOT NULL REFERENCES iraven.payment_reconciliations(id) ON DELETE CASCADE,
+    kind VARCHAR(30) NOT NULL CHECK (kind IN ('missing_in_admin', 'missing_in_stripe', 'duplicate', 'status_mismatch', 'amount_mismatch')),
+    stripe_id VARCHAR(255),
+    payment_id BIGINT REFERENCES iraven.payments(id) ON DELETE SET NULL,
+    expected TEXT,
+    actual TEXT
+);
+
+CREATE INDEX IF NOT EXISTS payment_reconciliation_issues_run_idx ON iraven.payment_reconciliation_issues (reconciliation_id, kind);
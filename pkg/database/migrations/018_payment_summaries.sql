@@ -0,0 +1,58 @@
+-- Revenue summaries for the payment reports, refreshed by a background
+-- job. Refunds count against the day of the payment they return. A refund
+-- is known from the admin's audit trail, or in full from a refunded status
+-- set by a webhook.
+CREATE MATERIALIZED VIEW IF NOT EXISTS iraven.payment_daily_summaries AS
+WITH refunds AS (
+    SELECT payment_id, SUM(amount) AS amount
+    FROM iraven.payment_actions
+    WHERE action = 'refund' AND status = 'succeeded'
+    GROUP BY payment_id
+), payments AS (
+    SELECT p.created_at, upper(p.currency) AS currency, p.status, p.amount,
+        p.status IN ('succeeded', 'partially_refunded', 'refunded') AS paid,
+        CASE
+            WHEN p.status = 'refunded' THEN p.amount
+            WHEN p.status IN ('succeeded', 'partially_refunded') THEN LEAST(p.amount, COALESCE(r.amount, 0))
+            ELSE 0
+        END AS refunded
+    FROM iraven.payments p
+    LEFT JOIN refunds r ON r.payment_id = p.id
+)
+SELECT date_trunc('day', created_at)::date AS day,
+    currency,
+    COUNT(*) AS payments,
+    COUNT(*) FILTER (WHERE paid) AS paid,
+    COUNT(*) FILTER (WHERE paid AND refunded > 0) AS refunded_payments,
+    COUNT(*) FILTER (WHERE status = 'failed') AS failed,
+    COALESCE(SUM(amount) FILTER (WHERE paid), 0)::bigint AS gross,
+    COALESCE(SUM(refunded), 0)::bigint AS refunded,
+    NOW() AS refreshed_at
+FROM payments
+GROUP BY 1, 2;
+
+-- Unique, so that the view can be refreshed concurrently with reads
+CREATE UNIQUE INDEX IF NOT EXISTS payment_daily_summaries_day_idx ON iraven.payment_daily_summaries (day, currency);
+
+-- Paid revenue per user and month, for the top users and per-application
+-- reports.
+CREATE MATERIALIZED VIEW IF NOT EXISTS iraven.payment_monthly_user_summaries AS
+WITH refunds AS (
+    SELECT payment_id, SUM(amount) AS amount
+    FROM iraven.payment_actions
+    WHERE action = 'refund' AND status = 'succeeded'
+    GROUP BY payment_id
+)
+SELECT date_trunc('month', p.created_at)::date AS month,
+    p.user_id,
+    upper(p.currency) AS currency,
+    COUNT(*) AS payments,
+    SUM(p.amount)::bigint AS gross,
+    SUM(CASE WHEN p.status = 'refunded' THEN p.amount ELSE LEAST(p.amount, COALESCE(r.amount, 0)) END)::bigint AS refunded
+FROM iraven.payments p
+LEFT JOIN refunds r ON r.payment_id = p.id
+WHERE p.status IN ('succeeded', 'partially_refunded', 'refunded')
+GROUP BY 1, 2, 3;
+
+CREATE UNIQUE INDEX IF NOT EXISTS payment_monthly_user_summaries_idx ON iraven.payment_monthly_user_summaries (month, user_id, currency);
+CREATE INDEX IF NOT EXISTS payment_monthly_user_summaries_currency_idx ON iraven.payment_monthly_user_summaries (currency, month);
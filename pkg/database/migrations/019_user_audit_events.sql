@@ -0,0 +1,41 @@
+-- Changes admins make to a user account: creation, edits, role grants and
+-- removals, profile and quota changes. user_id is not a foreign key so the
+-- trail outlives the user. Rows are never updated.
+CREATE TABLE IF NOT EXISTS iraven.user_audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    details TEXT,
+    performed_by BIGINT REFERENCES iraven.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS user_audit_events_user_idx ON iraven.user_audit_events (user_id, created_at);
+
+-- Seed the role grants that predate the trail.
+INSERT INTO iraven.user_audit_events (user_id, action, details, created_at)
+SELECT ur.user_id, 'role_added', r.name, ur.created_at
+FROM iraven.user_roles ur
+JOIN iraven.roles r ON r.id = ur.role_id;
+
+-- When a notification was read. The apps only flip read, so a trigger
+-- stamps the time.
+ALTER TABLE iraven.notifications ADD COLUMN IF NOT EXISTS read_at TIMESTAMPTZ;
+
+CREATE OR REPLACE FUNCTION iraven.notifications_read_at() RETURNS trigger AS $$
+BEGIN
+    IF NEW.read AND NOT OLD.read THEN
+        NEW.read_at := NOW();
+    ELSIF NOT NEW.read THEN
+        NEW.read_at := NULL;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS notifications_read_at ON iraven.notifications;
+CREATE TRIGGER notifications_read_at
+    BEFORE UPDATE OF read ON iraven.notifications
+    FOR EACH ROW EXECUTE FUNCTION iraven.notifications_read_at();
+
+CREATE INDEX IF NOT EXISTS notifications_user_created_idx ON iraven.notifications (user_id, created_at);
@@ -0,0 +1,22 @@
+-- Requests to erase a user's personal data. A request stays pending through
+-- a grace period, during which it can be canceled, and is then carried out
+-- by a background job; error holds the last failed attempt. user_id is not
+-- a foreign key so the request outlives what it erased. summary is JSON of
+-- the rows deleted, anonymized and kept per table.
+CREATE TABLE IF NOT EXISTS iraven.user_erasures (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    reason TEXT NOT NULL CHECK (btrim(reason) <> ''),
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'canceled', 'completed')),
+    scheduled_for TIMESTAMPTZ NOT NULL,
+    requested_by BIGINT REFERENCES iraven.users(id) ON DELETE SET NULL,
+    canceled_by BIGINT REFERENCES iraven.users(id) ON DELETE SET NULL,
+    summary TEXT,
+    error TEXT,
+    completed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS user_erasures_pending_idx ON iraven.user_erasures (user_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS user_erasures_due_idx ON iraven.user_erasures (scheduled_for) WHERE status = 'pending';
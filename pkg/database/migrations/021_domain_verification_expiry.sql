@@ -0,0 +1,7 @@
+-- Tokens can only verify a domain until expires_at. Pending verifications
+-- started before expiry existed get a fresh window.
+ALTER TABLE iraven.application_domain_verifications ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+
+UPDATE iraven.application_domain_verifications
+SET expires_at = NOW() + INTERVAL '7 days'
+WHERE expires_at IS NULL AND NOT verified;
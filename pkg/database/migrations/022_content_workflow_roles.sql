@@ -0,0 +1,9 @@
+-- The workflow roles. Admins are not granted them: whoever edits or
+-- reviews content is given the role explicitly.
+INSERT INTO iraven.roles (name, description)
+SELECT v.name, v.description
+FROM (VALUES
+    ('content_editor', 'Writes content and submits it for review'),
+    ('content_reviewer', 'Reviews, publishes and archives content')
+) AS v(name, description)
+WHERE NOT EXISTS (SELECT 1 FROM iraven.roles r WHERE r.name = v.name);
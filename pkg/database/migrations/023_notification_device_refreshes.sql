@@ -0,0 +1,20 @@
+-- When an app last registered or refreshed a device token. The API writes
+-- the registration columns whenever an app does either, and the trigger
+-- stamps the time; the admin only updates the push diagnostics, which
+-- leave it alone. Devices not refreshed for the configured number of days
+-- are pruned as stale. Existing devices start a fresh window, as when they
+-- were last refreshed is not known.
+ALTER TABLE iraven.notification_devices
+    ADD COLUMN IF NOT EXISTS refreshed_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE OR REPLACE FUNCTION iraven.notification_devices_refreshed_at() RETURNS trigger AS $$
+BEGIN
+    NEW.refreshed_at := NOW();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS notification_devices_refreshed_at ON iraven.notification_devices;
+CREATE TRIGGER notification_devices_refreshed_at
+    BEFORE INSERT OR UPDATE OF user_id, device_token, device_type ON iraven.notification_devices
+    FOR EACH ROW EXECUTE FUNCTION iraven.notification_devices_refreshed_at();
@@ -5,34 +5,58 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/iraven/iraven-admin/pkg/database"
 	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/iraven/iraven-admin/pkg/verification"
 	"github.com/labstack/echo/v4"
 )
 
 type ApplicationHandler struct {
-	db *database.Database
+	db       *database.Database
+	verifier *verification.Verifier
 }
 
-func NewApplicationHandler(db *database.Database) *ApplicationHandler {
-	return &ApplicationHandler{db: db}
+func NewApplicationHandler(db *database.Database, verifier *verification.Verifier) *ApplicationHandler {
+	return &ApplicationHandler{db: db, verifier: verifier}
 }
 
 func (h *ApplicationHandler) List(c echo.Context) error {
 	rows, err := h.db.Pool.Query(context.Background(),
-		"SELECT id, name, description, domain, created_at, updated_at FROM iraven.applications ORDER BY name")
+		`SELECT a.id, a.name, a.description, a.domain, a.created_at, a.updated_at,
+			v.method, v.verified, v.verified_at, v.last_checked_at,
+			NOT v.verified AND v.expires_at <= NOW()
+		FROM iraven.applications a
+		LEFT JOIN iraven.application_domain_verifications v
+			ON v.application_id = a.id AND v.domain = a.domain
+		ORDER BY a.name`)
 	if err != nil {
 		return err
 	}
 	defer rows.Close()
 
-	var apps []models.Application
+	var apps []models.ApplicationWithVerification
 	for rows.Next() {
-		var app models.Application
-		if err := rows.Scan(&app.ID, &app.Name, &app.Description, &app.Domain, &app.CreatedAt, &app.UpdatedAt); err != nil {
+		var app models.ApplicationWithVerification
+		var method *string
+		var verified, expired *bool
+		var verifiedAt, lastCheckedAt *time.Time
+		if err := rows.Scan(&app.ID, &app.Name, &app.Description, &app.Domain, &app.CreatedAt, &app.UpdatedAt,
+			&method, &verified, &verifiedAt, &lastCheckedAt, &expired); err != nil {
 			continue
 		}
+		if method != nil {
+			app.Verification = &models.DomainVerification{
+				ApplicationID: app.ID,
+				Domain:        app.Domain,
+				Method:        *method,
+				Verified:      *verified,
+				VerifiedAt:    verifiedAt,
+				LastCheckedAt: lastCheckedAt,
+				Expired:       expired != nil && *expired,
+			}
+		}
 		apps = append(apps, app)
 	}
 
@@ -75,10 +99,65 @@ func (h *ApplicationHandler) Show(c echo.Context) error {
 		clients = append(clients, client)
 	}
 
+	// Get roles scoped to this application
+	roleRows, err := h.db.Pool.Query(context.Background(),
+		`SELECT r.id, r.name, r.description, ar.created_at
+		FROM iraven.roles r
+		INNER JOIN iraven.application_roles ar ON r.id = ar.role_id
+		WHERE ar.application_id = $1 ORDER BY r.name`, id)
+	if err != nil {
+		return err
+	}
+	defer roleRows.Close()
+
+	var roles []models.Role
+	attached := make(map[int64]bool)
+	for roleRows.Next() {
+		var r models.Role
+		if err := roleRows.Scan(&r.ID, &r.Name, &r.Description, &r.CreatedAt); err != nil {
+			continue
+		}
+		roles = append(roles, r)
+		attached[r.ID] = true
+	}
+
+	// Get roles that can still be attached
+	allRows, err := h.db.Pool.Query(context.Background(), "SELECT id, name FROM iraven.roles ORDER BY name")
+	if err != nil {
+		return err
+	}
+	defer allRows.Close()
+
+	var availableRoles []models.Role
+	for allRows.Next() {
+		var r models.Role
+		if err := allRows.Scan(&r.ID, &r.Name); err != nil {
+			continue
+		}
+		if !attached[r.ID] {
+			availableRoles = append(availableRoles, r)
+		}
+	}
+
+	users, err := h.effectiveUsers(id)
+	if err != nil {
+		return err
+	}
+
 	data := map[string]interface{}{
-		"Title":       "Application Details",
-		"Application": app,
-		"Clients":     clients,
+		"Title":          "Application Details",
+		"Application":    app,
+		"Clients":        clients,
+		"Roles":          roles,
+		"AvailableRoles": availableRoles,
+		"Users":          users,
+	}
+
+	if v, err := h.getVerification(id); err == nil && v.Domain == app.Domain {
+		data["Verification"] = v
+		data["RecordName"] = verification.RecordName(v.Domain)
+		data["RecordValue"] = verification.RecordValue(v.Token)
+		data["WellKnownURL"] = verification.WellKnownURL(v.Domain)
 	}
 
 	return c.Render(http.StatusOK, "applications/show", data)
@@ -150,6 +229,11 @@ func (h *ApplicationHandler) Update(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Failed to update application: "+err.Error())
 	}
 
+	// A verification only proves ownership of the domain it was issued for
+	h.db.Pool.Exec(context.Background(),
+		"DELETE FROM iraven.application_domain_verifications WHERE application_id = $1 AND domain <> $2",
+		id, domain)
+
 	return c.Redirect(http.StatusFound, fmt.Sprintf("/applications/%d", id))
 }
 
@@ -166,6 +250,9 @@ func (h *ApplicationHandler) Delete(c echo.Context) error {
 			fmt.Sprintf("Cannot delete application: %d clients depend on it", count))
 	}
 
+	// Detach scoped roles first
+	h.db.Pool.Exec(context.Background(), "DELETE FROM iraven.application_roles WHERE application_id = $1", id)
+
 	_, err := h.db.Pool.Exec(context.Background(), "DELETE FROM iraven.applications WHERE id = $1", id)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Failed to delete application: "+err.Error())
@@ -173,3 +260,177 @@ func (h *ApplicationHandler) Delete(c echo.Context) error {
 
 	return c.Redirect(http.StatusFound, "/applications")
 }
+
+func (h *ApplicationHandler) AttachRole(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	roleID, _ := strconv.ParseInt(c.FormValue("role_id"), 10, 64)
+
+	if roleID == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Role is required")
+	}
+
+	_, err := h.db.Pool.Exec(context.Background(),
+		`INSERT INTO iraven.application_roles (application_id, role_id) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`,
+		id, roleID)
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to attach role: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/applications/%d", id))
+}
+
+func (h *ApplicationHandler) DetachRole(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	roleID, _ := strconv.ParseInt(c.Param("roleId"), 10, 64)
+
+	_, err := h.db.Pool.Exec(context.Background(),
+		"DELETE FROM iraven.application_roles WHERE application_id = $1 AND role_id = $2",
+		id, roleID)
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to detach role: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/applications/%d", id))
+}
+
+func (h *ApplicationHandler) StartVerification(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	method := c.FormValue("method")
+
+	if !verification.ValidMethod(method) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid verification method")
+	}
+
+	var domain string
+	err := h.db.Pool.QueryRow(context.Background(),
+		"SELECT domain FROM iraven.applications WHERE id = $1", id).Scan(&domain)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Application not found")
+	}
+
+	token, err := verification.NewToken()
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.Pool.Exec(context.Background(),
+		`INSERT INTO iraven.application_domain_verifications (application_id, domain, method, token, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (application_id) DO UPDATE SET
+			domain = EXCLUDED.domain, method = EXCLUDED.method, token = EXCLUDED.token,
+			verified = false, verified_at = NULL, last_checked_at = NULL, last_error = NULL,
+			expires_at = EXCLUDED.expires_at, updated_at = NOW()`,
+		id, domain, method, token, time.Now().Add(verification.TokenTTL))
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to start verification: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/applications/%d", id))
+}
+
+func (h *ApplicationHandler) CheckVerification(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	v, err := h.getVerification(id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "No verification in progress")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	// Expiry only limits how long a token can first verify the domain
+	var expiresAt time.Time
+	if !v.Verified && v.ExpiresAt != nil {
+		expiresAt = *v.ExpiresAt
+	}
+
+	var lastError *string
+	if err := h.verifier.Check(ctx, v.Method, v.Domain, v.Token, expiresAt); err != nil {
+		msg := err.Error()
+		lastError = &msg
+	}
+
+	_, err = h.db.Pool.Exec(context.Background(),
+		`UPDATE iraven.application_domain_verifications SET
+			verified = $1,
+			verified_at = CASE WHEN $1 THEN COALESCE(verified_at, NOW()) ELSE NULL END,
+			last_checked_at = NOW(), last_error = $2, updated_at = NOW()
+		WHERE application_id = $3`,
+		lastError == nil, lastError, id)
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to record verification: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/applications/%d", id))
+}
+
+func (h *ApplicationHandler) getVerification(appID int64) (*models.DomainVerification, error) {
+	var v models.DomainVerification
+	err := h.db.Pool.QueryRow(context.Background(),
+		`SELECT application_id, domain, method, token, verified, verified_at, last_checked_at, last_error,
+			expires_at, NOT verified AND expires_at IS NOT NULL AND expires_at <= NOW(), created_at, updated_at
+		FROM iraven.application_domain_verifications WHERE application_id = $1`, appID).
+		Scan(&v.ApplicationID, &v.Domain, &v.Method, &v.Token, &v.Verified, &v.VerifiedAt, &v.LastCheckedAt,
+			&v.LastError, &v.ExpiresAt, &v.Expired, &v.CreatedAt, &v.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// effectiveUsers returns every user that reaches the application through one
+// of its scoped roles, with the granting roles attached.
+func (h *ApplicationHandler) effectiveUsers(appID int64) ([]models.UserWithRoles, error) {
+	rows, err := h.db.Pool.Query(context.Background(),
+		`SELECT u.id, u.email, u.name, r.id, r.name
+		FROM iraven.users u
+		INNER JOIN iraven.user_roles ur ON u.id = ur.user_id
+		INNER JOIN iraven.application_roles ar ON ur.role_id = ar.role_id
+		INNER JOIN iraven.roles r ON r.id = ar.role_id
+		WHERE ar.application_id = $1
+		ORDER BY u.name, u.id, r.name`, appID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.UserWithRoles
+	for rows.Next() {
+		var u models.User
+		var r models.Role
+		if err := rows.Scan(&u.ID, &u.Email, &u.Name, &r.ID, &r.Name); err != nil {
+			continue
+		}
+		if n := len(users); n > 0 && users[n-1].ID == u.ID {
+			users[n-1].Roles = append(users[n-1].Roles, r)
+			continue
+		}
+		users = append(users, models.UserWithRoles{User: u, Roles: []models.Role{r}})
+	}
+
+	return users, nil
+}
+
+func listApplications(db *database.Database) ([]models.Application, error) {
+	rows, err := db.Pool.Query(context.Background(), "SELECT id, name, domain FROM iraven.applications ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var applications []models.Application
+	for rows.Next() {
+		var a models.Application
+		if err := rows.Scan(&a.ID, &a.Name, &a.Domain); err != nil {
+			continue
+		}
+		applications = append(applications, a)
+	}
+	return applications, nil
+}
@@ -2,22 +2,28 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/iraven/iraven-admin/pkg/database"
+	"github.com/iraven/iraven-admin/pkg/i18n"
+	"github.com/iraven/iraven-admin/pkg/jsonschema"
 	"github.com/iraven/iraven-admin/pkg/middleware"
 	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/iraven/iraven-admin/pkg/workflow"
 	"github.com/labstack/echo/v4"
 )
 
 type ContentHandler struct {
-	db *database.Database
+	db              *database.Database
+	defaultLanguage string
 }
 
-func NewContentHandler(db *database.Database) *ContentHandler {
-	return &ContentHandler{db: db}
+func NewContentHandler(db *database.Database, defaultLanguage string) *ContentHandler {
+	return &ContentHandler{db: db, defaultLanguage: defaultLanguage}
 }
 
 func (h *ContentHandler) List(c echo.Context) error {
@@ -28,10 +34,29 @@ func (h *ContentHandler) List(c echo.Context) error {
 	pageSize := 20
 	offset := (page - 1) * pageSize
 
+	status := c.QueryParam("status")
+	if !workflow.ValidStatus(status) {
+		status = ""
+	}
+
+	if query := strings.TrimSpace(c.QueryParam("q")); query != "" {
+		return h.search(c, query, status, page)
+	}
+
+	missing := i18n.NormalizeCode(c.QueryParam("missing"))
+
+	// Optional filters: by status, and to entries lacking a variant for a locale
+	where := `WHERE ($1 = '' OR c.status = $1)
+		AND ($2 = '' OR NOT EXISTS (
+			SELECT 1 FROM iraven.content_variants v
+			INNER JOIN iraven.languages l ON l.id = v.language_id
+			WHERE v.content_id = c.id AND ` + normalizedCodeSQL("l.code") + ` = $2))`
+
 	rows, err := h.db.Pool.Query(context.Background(),
-		`SELECT id, slug, title, created_by, created_at, updated_at
-		FROM iraven.content ORDER BY updated_at DESC LIMIT $1 OFFSET $2`,
-		pageSize, offset)
+		`SELECT c.id, c.slug, c.title, c.created_by, c.status, c.published_at, c.scheduled_at, c.created_at, c.updated_at
+		FROM iraven.content c `+where+`
+		ORDER BY c.updated_at DESC LIMIT $3 OFFSET $4`,
+		status, missing, pageSize, offset)
 	if err != nil {
 		return err
 	}
@@ -41,6 +66,7 @@ func (h *ContentHandler) List(c echo.Context) error {
 	for rows.Next() {
 		var content models.Content
 		if err := rows.Scan(&content.ID, &content.Slug, &content.Title, &content.CreatedBy,
+			&content.Status, &content.PublishedAt, &content.ScheduledAt,
 			&content.CreatedAt, &content.UpdatedAt); err != nil {
 			continue
 		}
@@ -48,11 +74,21 @@ func (h *ContentHandler) List(c echo.Context) error {
 	}
 
 	var totalContent int64
-	h.db.Pool.QueryRow(context.Background(), "SELECT COUNT(*) FROM iraven.content").Scan(&totalContent)
+	h.db.Pool.QueryRow(context.Background(),
+		"SELECT COUNT(*) FROM iraven.content c "+where, status, missing).Scan(&totalContent)
+
+	languages, err := listLanguages(h.db)
+	if err != nil {
+		return err
+	}
 
 	data := map[string]interface{}{
 		"Title":      "Content",
 		"Contents":   contents,
+		"Statuses":   workflow.Statuses,
+		"Status":     status,
+		"Languages":  languages,
+		"Missing":    missing,
 		"Page":       page,
 		"TotalPages": (totalContent + int64(pageSize) - 1) / int64(pageSize),
 	}
@@ -65,49 +101,119 @@ func (h *ContentHandler) Show(c echo.Context) error {
 
 	var content models.Content
 	err := h.db.Pool.QueryRow(context.Background(),
-		"SELECT id, slug, title, data, created_by, created_at, updated_at FROM iraven.content WHERE id = $1", id).
-		Scan(&content.ID, &content.Slug, &content.Title, &content.Data, &content.CreatedBy, &content.CreatedAt, &content.UpdatedAt)
+		`SELECT id, slug, title, data, content_type_id, created_by, status, published_at, scheduled_at,
+			created_at, updated_at
+		FROM iraven.content WHERE id = $1`, id).
+		Scan(&content.ID, &content.Slug, &content.Title, &content.Data, &content.TypeID, &content.CreatedBy,
+			&content.Status, &content.PublishedAt, &content.ScheduledAt, &content.CreatedAt, &content.UpdatedAt)
 
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "Content not found")
 	}
 
+	roles := middleware.CurrentUserRoles(c)
 	data := map[string]interface{}{
-		"Title":   "Content Details",
-		"Content": content,
+		"Title":        "Content Details",
+		"Content":      content,
+		"Transitions":  workflow.Available(content.Status, roles),
+		"WorkflowRole": workflow.HasRole(roles),
+	}
+
+	if content.TypeID != nil {
+		if ct, err := getContentType(h.db, *content.TypeID); err == nil {
+			data["Type"] = ct
+		}
+	}
+
+	translations, err := h.translationStatus(content)
+	if err != nil {
+		return err
+	}
+	data["Translations"] = translations
+	data["DefaultLanguage"] = h.defaultLanguage
+
+	if data["Files"], err = attachedFiles(h.db, "content", id); err != nil {
+		return err
+	}
+	if content.Data != nil {
+		if data["ReferencedFiles"], err = filesByURL(h.db, jsonStrings(*content.Data)); err != nil {
+			return err
+		}
 	}
 
 	return c.Render(http.StatusOK, "content/show", data)
 }
 
 func (h *ContentHandler) New(c echo.Context) error {
-	data := map[string]interface{}{
-		"Title": "New Content",
+	var content models.Content
+	if typeID, _ := strconv.ParseInt(c.QueryParam("type"), 10, 64); typeID != 0 {
+		content.TypeID = &typeID
 	}
-	return c.Render(http.StatusOK, "content/new", data)
+
+	return h.renderForm(c, http.StatusOK, "content/new", "New Content", content, nil)
 }
 
 func (h *ContentHandler) Create(c echo.Context) error {
 	slug := c.FormValue("slug")
 	title := c.FormValue("title")
-	data := c.FormValue("data")
 
-	if slug == "" || title == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "Slug and title are required")
+	if title == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Title is required")
+	}
+
+	content := models.Content{Slug: slug, Title: title, TypeID: formTypeID(c)}
+	data, errs, err := h.resolveData(c, content.TypeID, "")
+	if err != nil {
+		return err
+	}
+	content.Data = data
+	if len(errs) > 0 {
+		return h.renderForm(c, http.StatusUnprocessableEntity, "content/new", "New Content", content, errs)
 	}
 
 	session, _ := middleware.GetSession(c)
 	userID := session.Values[middleware.UserIDKey].(int64)
 
+	ctx := context.Background()
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if slug, err = claimSlug(ctx, tx, slug, title, 0); err != nil {
+		return err
+	}
+
 	var contentID int64
-	err := h.db.Pool.QueryRow(context.Background(),
-		"INSERT INTO iraven.content (slug, title, data, created_by) VALUES ($1, $2, $3, $4) RETURNING id",
-		slug, title, data, userID).Scan(&contentID)
+	err = tx.QueryRow(ctx,
+		`INSERT INTO iraven.content (slug, title, data, content_type_id, created_by)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		slug, title, data, content.TypeID, userID).Scan(&contentID)
 
+	if isUniqueViolation(err) {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Slug \"%s\" is already in use", slug))
+	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Failed to create content: "+err.Error())
 	}
 
+	if err := recordSlugChange(ctx, tx, contentID, "", slug, userID); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to update redirects: "+err.Error())
+	}
+
+	if err := writeRevision(ctx, tx, contentID, userID); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to record revision: "+err.Error())
+	}
+
+	if err := h.indexContent(ctx, tx, contentID); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to index content: "+err.Error())
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
 	return c.Redirect(http.StatusFound, fmt.Sprintf("/content/%d", contentID))
 }
 
@@ -116,42 +222,189 @@ func (h *ContentHandler) Edit(c echo.Context) error {
 
 	var content models.Content
 	err := h.db.Pool.QueryRow(context.Background(),
-		"SELECT id, slug, title, data FROM iraven.content WHERE id = $1", id).
-		Scan(&content.ID, &content.Slug, &content.Title, &content.Data)
+		"SELECT id, slug, title, data, content_type_id FROM iraven.content WHERE id = $1", id).
+		Scan(&content.ID, &content.Slug, &content.Title, &content.Data, &content.TypeID)
 
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "Content not found")
 	}
 
-	data := map[string]interface{}{
-		"Title":   "Edit Content",
-		"Content": content,
+	// Allow switching the type before saving; the form re-renders for it
+	if typeParam := c.QueryParam("type"); typeParam != "" {
+		content.TypeID = nil
+		if typeID, _ := strconv.ParseInt(typeParam, 10, 64); typeID != 0 {
+			content.TypeID = &typeID
+		}
 	}
 
-	return c.Render(http.StatusOK, "content/edit", data)
+	return h.renderForm(c, http.StatusOK, "content/edit", "Edit Content", content, nil)
 }
 
 func (h *ContentHandler) Update(c echo.Context) error {
 	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
 	slug := c.FormValue("slug")
 	title := c.FormValue("title")
-	data := c.FormValue("data")
 
-	if slug == "" || title == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "Slug and title are required")
+	if title == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Title is required")
+	}
+
+	var oldSlug string
+	var current *string
+	if err := h.db.Pool.QueryRow(context.Background(),
+		"SELECT slug, data FROM iraven.content WHERE id = $1", id).Scan(&oldSlug, &current); err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Content not found")
+	}
+	base := ""
+	if current != nil {
+		base = *current
 	}
 
-	_, err := h.db.Pool.Exec(context.Background(),
-		"UPDATE iraven.content SET slug = $1, title = $2, data = $3, updated_at = NOW() WHERE id = $4",
-		slug, title, data, id)
+	content := models.Content{ID: id, Slug: slug, Title: title, TypeID: formTypeID(c)}
+	data, errs, err := h.resolveData(c, content.TypeID, base)
+	if err != nil {
+		return err
+	}
+	content.Data = data
+	if len(errs) > 0 {
+		return h.renderForm(c, http.StatusUnprocessableEntity, "content/edit", "Edit Content", content, errs)
+	}
 
+	ctx := context.Background()
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if slug, err = claimSlug(ctx, tx, slug, title, id); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE iraven.content SET slug = $1, title = $2, data = $3, content_type_id = $4, updated_at = NOW()
+		WHERE id = $5`,
+		slug, title, data, content.TypeID, id)
+
+	if isUniqueViolation(err) {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Slug \"%s\" is already in use", slug))
+	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Failed to update content: "+err.Error())
 	}
 
+	if err := recordSlugChange(ctx, tx, id, oldSlug, slug, middleware.CurrentUserID(c)); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to update redirects: "+err.Error())
+	}
+
+	if err := writeRevision(ctx, tx, id, middleware.CurrentUserID(c)); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to record revision: "+err.Error())
+	}
+
+	if err := h.indexContent(ctx, tx, id); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to index content: "+err.Error())
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
 	return c.Redirect(http.StatusFound, fmt.Sprintf("/content/%d", id))
 }
 
+// resolveData reads the submitted content data, either from the raw JSON
+// textarea or from the fields generated for the content type's schema, and
+// validates it. base is the currently stored data, kept for properties the
+// schema does not describe.
+func (h *ContentHandler) resolveData(c echo.Context, typeID *int64, base string) (*string, []jsonschema.FieldError, error) {
+	var schema *jsonschema.Schema
+	if typeID != nil {
+		ct, err := getContentType(h.db, *typeID)
+		if err != nil {
+			return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "Unknown content type")
+		}
+		if schema, err = jsonschema.Parse(ct.Schema); err != nil {
+			return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "Content type has an invalid schema: "+err.Error())
+		}
+	}
+
+	var data string
+	var errs []jsonschema.FieldError
+	if schema != nil && c.FormValue("data_mode") == "fields" {
+		form, err := c.FormParams()
+		if err != nil {
+			return nil, nil, err
+		}
+		data, errs = schema.FromForm(form, base)
+	} else {
+		data = strings.TrimSpace(c.FormValue("data"))
+	}
+
+	if data == "" && schema == nil {
+		return nil, nil, nil
+	}
+	if len(errs) == 0 {
+		if schema != nil {
+			if data == "" {
+				data = "{}"
+			}
+			errs = schema.ValidateJSON(data)
+		} else if !json.Valid([]byte(data)) {
+			var v interface{}
+			err := json.Unmarshal([]byte(data), &v)
+			errs = []jsonschema.FieldError{{Message: "not valid JSON: " + err.Error()}}
+		}
+	}
+
+	return &data, errs, nil
+}
+
+func (h *ContentHandler) renderForm(c echo.Context, status int, name, title string, content models.Content,
+	errs []jsonschema.FieldError) error {
+	types, err := listContentTypes(h.db)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{
+		"Title":   title,
+		"Content": content,
+		"Types":   types,
+		"Errors":  errs,
+		"Mode":    "json",
+		"TypeID":  int64(0),
+	}
+
+	if content.TypeID != nil {
+		data["TypeID"] = *content.TypeID
+		ct, err := getContentType(h.db, *content.TypeID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Unknown content type")
+		}
+		data["Type"] = ct
+
+		schema, err := jsonschema.Parse(ct.Schema)
+		if err == nil && len(schema.PropertyOrder) > 0 && c.QueryParam("mode") != "json" {
+			doc := ""
+			if content.Data != nil {
+				doc = *content.Data
+			}
+			data["Fields"] = schema.Fields(doc, errs)
+			data["Mode"] = "fields"
+		}
+	}
+
+	return c.Render(status, name, data)
+}
+
+func formTypeID(c echo.Context) *int64 {
+	typeID, _ := strconv.ParseInt(c.FormValue("content_type_id"), 10, 64)
+	if typeID == 0 {
+		return nil
+	}
+	return &typeID
+}
+
 func (h *ContentHandler) Delete(c echo.Context) error {
 	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
 
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/iraven/iraven-admin/pkg/jsondiff"
+	"github.com/iraven/iraven-admin/pkg/middleware"
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// writeRevision snapshots the current state of a content row as its next
+// revision. It must run in the same transaction as the write it records.
+func writeRevision(ctx context.Context, tx pgx.Tx, contentID, authorID int64) error {
+	var author *int64
+	if authorID != 0 {
+		author = &authorID
+	}
+
+	// Serialize revision numbering per content row
+	if _, err := tx.Exec(ctx, "SELECT id FROM iraven.content WHERE id = $1 FOR UPDATE", contentID); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(ctx,
+		`INSERT INTO iraven.content_revisions (content_id, revision, slug, title, data, author_id)
+		SELECT c.id,
+			COALESCE((SELECT MAX(revision) FROM iraven.content_revisions WHERE content_id = c.id), 0) + 1,
+			c.slug, c.title, c.data::text, $2
+		FROM iraven.content c WHERE c.id = $1`,
+		contentID, author)
+	return err
+}
+
+func (h *ContentHandler) Revisions(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	var content models.Content
+	err := h.db.Pool.QueryRow(context.Background(),
+		"SELECT id, slug, title FROM iraven.content WHERE id = $1", id).
+		Scan(&content.ID, &content.Slug, &content.Title)
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Content not found")
+	}
+
+	rows, err := h.db.Pool.Query(context.Background(),
+		`SELECT r.id, r.revision, r.slug, r.title, r.author_id, u.name, r.created_at
+		FROM iraven.content_revisions r
+		LEFT JOIN iraven.users u ON u.id = r.author_id
+		WHERE r.content_id = $1 ORDER BY r.revision DESC`, id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var revisions []models.ContentRevision
+	for rows.Next() {
+		var r models.ContentRevision
+		if err := rows.Scan(&r.ID, &r.Revision, &r.Slug, &r.Title, &r.AuthorID, &r.AuthorName, &r.CreatedAt); err != nil {
+			continue
+		}
+		revisions = append(revisions, r)
+	}
+
+	data := map[string]interface{}{
+		"Title":     "Content Revisions",
+		"Content":   content,
+		"Revisions": revisions,
+	}
+
+	return c.Render(http.StatusOK, "content/revisions", data)
+}
+
+func (h *ContentHandler) DiffRevisions(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	from, _ := strconv.Atoi(c.QueryParam("from"))
+	to, _ := strconv.Atoi(c.QueryParam("to"))
+
+	left, err := h.getRevision(id, from)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Revision %d not found", from))
+	}
+	right, err := h.getRevision(id, to)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Revision %d not found", to))
+	}
+
+	var leftData, rightData string
+	if left.Data != nil {
+		leftData = *left.Data
+	}
+	if right.Data != nil {
+		rightData = *right.Data
+	}
+
+	data := map[string]interface{}{
+		"Title":     "Compare Revisions",
+		"ContentID": id,
+		"From":      left,
+		"To":        right,
+		"Rows":      jsondiff.SideBySide(leftData, rightData),
+	}
+
+	return c.Render(http.StatusOK, "content/diff", data)
+}
+
+func (h *ContentHandler) RestoreRevision(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	revision, _ := strconv.Atoi(c.Param("revision"))
+
+	r, err := h.getRevision(id, revision)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Revision not found")
+	}
+
+	ctx := context.Background()
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var oldSlug string
+	if err := tx.QueryRow(ctx, "SELECT slug FROM iraven.content WHERE id = $1 FOR UPDATE", id).Scan(&oldSlug); err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Content not found")
+	}
+
+	newSlug, err := claimSlug(ctx, tx, r.Slug, r.Title, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		"UPDATE iraven.content SET slug = $1, title = $2, data = $3, updated_at = NOW() WHERE id = $4",
+		newSlug, r.Title, r.Data, id)
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to restore revision: "+err.Error())
+	}
+
+	if err := recordSlugChange(ctx, tx, id, oldSlug, newSlug, middleware.CurrentUserID(c)); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to update redirects: "+err.Error())
+	}
+
+	if err := writeRevision(ctx, tx, id, middleware.CurrentUserID(c)); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to record revision: "+err.Error())
+	}
+
+	if err := h.indexContent(ctx, tx, id); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to index content: "+err.Error())
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/content/%d/revisions", id))
+}
+
+func (h *ContentHandler) getRevision(contentID int64, revision int) (*models.ContentRevision, error) {
+	var r models.ContentRevision
+	err := h.db.Pool.QueryRow(context.Background(),
+		`SELECT r.id, r.content_id, r.revision, r.slug, r.title, r.data, r.author_id, u.name, r.created_at
+		FROM iraven.content_revisions r
+		LEFT JOIN iraven.users u ON u.id = r.author_id
+		WHERE r.content_id = $1 AND r.revision = $2`, contentID, revision).
+		Scan(&r.ID, &r.ContentID, &r.Revision, &r.Slug, &r.Title, &r.Data, &r.AuthorID, &r.AuthorName, &r.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/iraven/iraven-admin/pkg/i18n"
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/iraven/iraven-admin/pkg/search"
+	"github.com/iraven/iraven-admin/pkg/workflow"
+	"github.com/labstack/echo/v4"
+)
+
+// SearchResult is a content entry matched by a full-text query, with the
+// best-ranked language it matched in and highlighted fragments.
+type SearchResult struct {
+	models.Content
+	Language  string
+	Rank      float32
+	TitleHTML template.HTML
+	Snippet   template.HTML
+}
+
+// SearchIndexStatus compares the search index with the content table.
+type SearchIndexStatus struct {
+	Content   int64
+	Indexed   int64
+	Documents int64
+	Stale     int64
+}
+
+// searchMatches selects, for every content entry whose search documents
+// match the query ($1), its best-ranked document. Each document is queried
+// with its own text search configuration; $2 optionally limits the match to
+// one language, compared by normalized code, and $3 is the code of the
+// language source entries are in.
+var searchMatches = `WITH queries AS (
+		SELECT config, websearch_to_tsquery(config, $1) AS query
+		FROM (SELECT DISTINCT config FROM iraven.content_search) configs
+	),
+	matches AS (
+		SELECT DISTINCT ON (s.content_id) s.content_id, s.config, s.title, s.body, q.query,
+			` + normalizedCodeSQL("COALESCE(l.code, $3)") + ` AS language_code,
+			ts_rank_cd(s.document, q.query, 32) AS rank
+		FROM iraven.content_search s
+		INNER JOIN queries q ON q.config = s.config
+		LEFT JOIN iraven.languages l ON l.id = s.language_id
+		WHERE s.document @@ q.query AND ($2 = '' OR ` + normalizedCodeSQL("COALESCE(l.code, $3)") + ` = $2)
+		ORDER BY s.content_id, rank DESC
+	)`
+
+// search renders the content list for a full-text query.
+func (h *ContentHandler) search(c echo.Context, query, status string, page int) error {
+	pageSize := 20
+	offset := (page - 1) * pageSize
+	language := i18n.NormalizeCode(c.QueryParam("lang"))
+	sourceLanguage := i18n.NormalizeCode(h.defaultLanguage)
+
+	rows, err := h.db.Pool.Query(context.Background(),
+		searchMatches+`
+		SELECT c.id, c.slug, c.title, c.status, c.updated_at, m.language_code, m.rank,
+			ts_headline(m.config, m.title, m.query, $4), ts_headline(m.config, m.body, m.query, $5)
+		FROM matches m
+		INNER JOIN iraven.content c ON c.id = m.content_id
+		WHERE ($6 = '' OR c.status = $6)
+		ORDER BY m.rank DESC, c.updated_at DESC
+		LIMIT $7 OFFSET $8`,
+		query, language, sourceLanguage, search.HeadlineOptions(true), search.HeadlineOptions(false),
+		status, pageSize, offset)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Search failed: "+err.Error())
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		var title, snippet string
+		if err := rows.Scan(&r.ID, &r.Slug, &r.Title, &r.Status, &r.UpdatedAt, &r.Language, &r.Rank,
+			&title, &snippet); err != nil {
+			continue
+		}
+		r.TitleHTML = search.Highlight(title)
+		r.Snippet = search.Highlight(snippet)
+		results = append(results, r)
+	}
+
+	var total int64
+	h.db.Pool.QueryRow(context.Background(),
+		searchMatches+`
+		SELECT COUNT(*) FROM matches m
+		INNER JOIN iraven.content c ON c.id = m.content_id
+		WHERE ($4 = '' OR c.status = $4)`,
+		query, language, sourceLanguage, status).Scan(&total)
+
+	languages, err := listLanguages(h.db)
+	if err != nil {
+		return err
+	}
+
+	index, err := h.searchIndexStatus()
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{
+		"Title":      "Search Content",
+		"Query":      query,
+		"Results":    results,
+		"Total":      total,
+		"Statuses":   workflow.Statuses,
+		"Status":     status,
+		"Languages":  languages,
+		"Language":   language,
+		"Index":      index,
+		"Page":       page,
+		"TotalPages": (total + int64(pageSize) - 1) / int64(pageSize),
+	}
+
+	return c.Render(http.StatusOK, "content/search", data)
+}
+
+// RebuildSearchIndex reindexes every content entry and its translations,
+// picking up content written outside the admin.
+func (h *ContentHandler) RebuildSearchIndex(c echo.Context) error {
+	ctx := context.Background()
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, "SELECT id FROM iraven.content ORDER BY id")
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	if _, err := tx.Exec(ctx, "DELETE FROM iraven.content_search"); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to rebuild search index: "+err.Error())
+	}
+	for _, id := range ids {
+		if err := h.indexContent(ctx, tx, id); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to rebuild search index: "+err.Error())
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	target := "/content"
+	if q := c.FormValue("q"); q != "" {
+		target += "?q=" + url.QueryEscape(q)
+	}
+	return c.Redirect(http.StatusFound, target)
+}
+
+// indexContent replaces the search documents of a content entry: one for
+// the source entry, in the site default language, and one per translation.
+func (h *ContentHandler) indexContent(ctx context.Context, q querier, contentID int64) error {
+	if _, err := q.Exec(ctx, "DELETE FROM iraven.content_search WHERE content_id = $1", contentID); err != nil {
+		return err
+	}
+
+	var title string
+	var data *string
+	err := q.QueryRow(ctx, "SELECT title, data FROM iraven.content WHERE id = $1", contentID).Scan(&title, &data)
+	if err != nil {
+		// Deleted content has nothing left to index
+		return nil
+	}
+	if err := insertSearchDocument(ctx, q, contentID, nil, search.DictionaryForCode(h.defaultLanguage), title, data); err != nil {
+		return err
+	}
+
+	rows, err := q.Query(ctx,
+		`SELECT l.id, l.name, l.code, v.title, v.data
+		FROM iraven.content_variants v
+		INNER JOIN iraven.languages l ON l.id = v.language_id
+		WHERE v.content_id = $1`, contentID)
+	if err != nil {
+		return err
+	}
+
+	type variant struct {
+		language models.Language
+		title    string
+		data     *string
+	}
+	var variants []variant
+	for rows.Next() {
+		var v variant
+		if err := rows.Scan(&v.language.ID, &v.language.Name, &v.language.Code, &v.title, &v.data); err != nil {
+			continue
+		}
+		variants = append(variants, v)
+	}
+	rows.Close()
+
+	for _, v := range variants {
+		if v.data == nil {
+			// Untranslated data falls back to the source entry's
+			v.data = data
+		}
+		languageID := v.language.ID
+		if err := insertSearchDocument(ctx, q, contentID, &languageID, search.Dictionary(v.language), v.title, v.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertSearchDocument(ctx context.Context, q querier, contentID int64, languageID *int64,
+	config, title string, data *string) error {
+	body := ""
+	if data != nil {
+		body = search.Text(*data)
+	}
+
+	// Titles weigh more than body text in the ranking
+	_, err := q.Exec(ctx,
+		`INSERT INTO iraven.content_search (content_id, language_id, config, title, body, document)
+		VALUES ($1, $2, $3::text::regconfig, $4, $5,
+			setweight(to_tsvector($3::text::regconfig, $4), 'A') ||
+			setweight(to_tsvector($3::text::regconfig, $5), 'B'))`,
+		contentID, languageID, config, title, body)
+	return err
+}
+
+func (h *ContentHandler) searchIndexStatus() (SearchIndexStatus, error) {
+	var s SearchIndexStatus
+	err := h.db.Pool.QueryRow(context.Background(),
+		`SELECT
+			(SELECT COUNT(*) FROM iraven.content),
+			(SELECT COUNT(DISTINCT content_id) FROM iraven.content_search),
+			(SELECT COUNT(*) FROM iraven.content_search),
+			(SELECT COUNT(*) FROM iraven.content c
+				LEFT JOIN iraven.content_search s ON s.content_id = c.id AND s.language_id IS NULL
+				WHERE s.id IS NULL OR s.indexed_at < c.updated_at)`).
+		Scan(&s.Content, &s.Indexed, &s.Documents, &s.Stale)
+	return s, err
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestSearchMatchesLanguage(t *testing.T) {
+	tx := testTx(t, "007_content_search.sql")
+	ctx := context.Background()
+
+	exec(t, tx, "INSERT INTO iraven.content (id, title) VALUES (1, 'Lamp'), (2, 'Luminária')")
+	exec(t, tx, "INSERT INTO iraven.languages (id, code) VALUES (1, 'pt-BR')")
+	exec(t, tx, `INSERT INTO iraven.content_search (content_id, language_id, config, title, body, document) VALUES
+		(1, NULL, 'simple', 'Lamp', 'lamp', to_tsvector('simple', 'lamp')),
+		(2, 1, 'simple', 'Luminária', 'lamp', to_tsvector('simple', 'lamp'))`)
+
+	tests := map[string][]string{
+		"":   {"1:en", "2:pt"},
+		"pt": {"2:pt"},
+		"en": {"1:en"},
+	}
+	for language, want := range tests {
+		got, err := collectStrings(tx.Query(ctx,
+			searchMatches+` SELECT content_id || ':' || language_code FROM matches ORDER BY content_id`,
+			"lamp", language, "en"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("language %q: matches = %v, want %v", language, got, want)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/iraven/iraven-admin/pkg/slug"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	RedirectOK     = "ok"
+	RedirectChain  = "chain"
+	RedirectLoop   = "loop"
+	RedirectBroken = "broken"
+)
+
+type RedirectReport struct {
+	models.SlugRedirect
+	ContentTitle string
+	Path         []string
+	Status       string
+}
+
+// SlugCheck backs the live validation on the content form. It normalizes
+// the requested slug (or derives one from the title) and reports whether it
+// is free.
+func (h *ContentHandler) SlugCheck(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.QueryParam("id"), 10, 64)
+	requested := c.QueryParam("slug")
+
+	ctx := context.Background()
+	result := map[string]interface{}{}
+
+	if requested == "" {
+		suggestion, err := uniqueSlug(ctx, h.db.Pool, slugBase(c.QueryParam("title")), id)
+		if err != nil {
+			return err
+		}
+		result["slug"] = suggestion
+		result["available"] = true
+		return c.JSON(http.StatusOK, result)
+	}
+
+	normalized := slug.Make(requested)
+	result["slug"] = normalized
+	result["valid"] = normalized != "" && normalized == requested
+
+	owner, err := slugOwner(ctx, h.db.Pool, normalized, id)
+	if err != nil {
+		return err
+	}
+	result["available"] = owner == nil && normalized != ""
+	if owner != nil {
+		result["message"] = fmt.Sprintf("Already used by \"%s\" (#%d)", owner.Title, owner.ID)
+		suggestion, err := uniqueSlug(ctx, h.db.Pool, normalized, id)
+		if err != nil {
+			return err
+		}
+		result["suggestion"] = suggestion
+	}
+
+	var redirectTo string
+	if err := h.db.Pool.QueryRow(ctx,
+		"SELECT to_slug FROM iraven.content_slug_redirects WHERE from_slug = $1 AND content_id <> $2",
+		normalized, id).Scan(&redirectTo); err == nil {
+		result["redirect"] = fmt.Sprintf("Currently redirects to %s; saving will replace that redirect", redirectTo)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+func (h *ContentHandler) Redirects(c echo.Context) error {
+	ctx := context.Background()
+
+	rows, err := h.db.Pool.Query(ctx,
+		`SELECT r.id, r.from_slug, r.to_slug, r.content_id, r.created_by, r.created_at, c.title
+		FROM iraven.content_slug_redirects r
+		INNER JOIN iraven.content c ON c.id = r.content_id
+		ORDER BY r.from_slug`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var reports []RedirectReport
+	for rows.Next() {
+		var r RedirectReport
+		if err := rows.Scan(&r.ID, &r.FromSlug, &r.ToSlug, &r.ContentID, &r.CreatedBy, &r.CreatedAt,
+			&r.ContentTitle); err != nil {
+			continue
+		}
+		reports = append(reports, r)
+	}
+
+	live, err := liveSlugs(ctx, h.db.Pool)
+	if err != nil {
+		return err
+	}
+	analyzeRedirects(reports, live)
+
+	counts := map[string]int{}
+	for _, r := range reports {
+		counts[r.Status]++
+	}
+
+	filter := c.QueryParam("status")
+	if filter != "" {
+		var filtered []RedirectReport
+		for _, r := range reports {
+			if r.Status == filter {
+				filtered = append(filtered, r)
+			}
+		}
+		reports = filtered
+	}
+
+	data := map[string]interface{}{
+		"Title":     "Slug Redirects",
+		"Redirects": reports,
+		"Counts":    counts,
+		"Filter":    filter,
+	}
+
+	return c.Render(http.StatusOK, "content/redirects", data)
+}
+
+func (h *ContentHandler) DeleteRedirect(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	_, err := h.db.Pool.Exec(context.Background(), "DELETE FROM iraven.content_slug_redirects WHERE id = $1", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to delete redirect: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, "/content/redirects")
+}
+
+// FlattenRedirects points every redirect straight at its content's current
+// slug, collapsing chains and breaking loops.
+func (h *ContentHandler) FlattenRedirects(c echo.Context) error {
+	ctx := context.Background()
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// A redirect from a slug that is live again would shadow that content
+	_, err = tx.Exec(ctx,
+		`DELETE FROM iraven.content_slug_redirects r
+		USING iraven.content c WHERE c.slug = r.from_slug`)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to flatten redirects: "+err.Error())
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE iraven.content_slug_redirects r SET to_slug = c.slug
+		FROM iraven.content c WHERE c.id = r.content_id AND r.to_slug <> c.slug`)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to flatten redirects: "+err.Error())
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusFound, "/content/redirects")
+}
+
+// analyzeRedirects follows each redirect through the others until it reaches
+// a live slug, and classifies it as direct, a chain, a loop, or broken.
+func analyzeRedirects(reports []RedirectReport, live map[string]bool) {
+	next := make(map[string]string, len(reports))
+	for _, r := range reports {
+		next[r.FromSlug] = r.ToSlug
+	}
+
+	for i := range reports {
+		r := &reports[i]
+		r.Path = []string{r.FromSlug}
+		seen := map[string]bool{r.FromSlug: true}
+		current := r.ToSlug
+
+		for {
+			r.Path = append(r.Path, current)
+			if live[current] {
+				r.Status = RedirectOK
+				if len(r.Path) > 2 {
+					r.Status = RedirectChain
+				}
+				break
+			}
+			if seen[current] {
+				r.Status = RedirectLoop
+				break
+			}
+			seen[current] = true
+			to, ok := next[current]
+			if !ok {
+				r.Status = RedirectBroken
+				break
+			}
+			current = to
+		}
+	}
+}
+
+// querier is satisfied by both the pool and a transaction.
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// claimSlug resolves the slug to store for a content write. An empty slug is
+// generated from the title and made unique; a given slug is normalized and
+// must not belong to other content.
+func claimSlug(ctx context.Context, q querier, requested, title string, contentID int64) (string, error) {
+	if requested == "" {
+		return uniqueSlug(ctx, q, slugBase(title), contentID)
+	}
+
+	s := slug.Make(requested)
+	if s == "" {
+		return "", echo.NewHTTPError(http.StatusBadRequest, "Slug must contain letters or digits")
+	}
+
+	owner, err := slugOwner(ctx, q, s, contentID)
+	if err != nil {
+		return "", err
+	}
+	if owner != nil {
+		return "", echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("Slug \"%s\" is already used by \"%s\" (#%d)", s, owner.Title, owner.ID))
+	}
+	return s, nil
+}
+
+// recordSlugChange keeps old links working after a slug change and drops
+// any redirect that would shadow the newly live slug.
+func recordSlugChange(ctx context.Context, q querier, contentID int64, oldSlug, newSlug string, userID int64) error {
+	if _, err := q.Exec(ctx, "DELETE FROM iraven.content_slug_redirects WHERE from_slug = $1", newSlug); err != nil {
+		return err
+	}
+	if oldSlug == "" || oldSlug == newSlug {
+		return nil
+	}
+
+	var createdBy *int64
+	if userID != 0 {
+		createdBy = &userID
+	}
+
+	_, err := q.Exec(ctx,
+		`INSERT INTO iraven.content_slug_redirects (from_slug, to_slug, content_id, created_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (from_slug) DO UPDATE SET
+			to_slug = EXCLUDED.to_slug, content_id = EXCLUDED.content_id,
+			created_by = EXCLUDED.created_by, created_at = NOW()`,
+		oldSlug, newSlug, contentID, createdBy)
+	return err
+}
+
+func slugOwner(ctx context.Context, q querier, s string, excludeID int64) (*models.Content, error) {
+	var owner models.Content
+	err := q.QueryRow(ctx, "SELECT id, title FROM iraven.content WHERE slug = $1 AND id <> $2", s, excludeID).
+		Scan(&owner.ID, &owner.Title)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &owner, nil
+}
+
+func uniqueSlug(ctx context.Context, q querier, base string, excludeID int64) (string, error) {
+	return slug.Unique(base, func(candidate string) (bool, error) {
+		owner, err := slugOwner(ctx, q, candidate, excludeID)
+		return owner != nil, err
+	})
+}
+
+func slugBase(title string) string {
+	if s := slug.Make(title); s != "" {
+		return s
+	}
+	return "content"
+}
+
+func liveSlugs(ctx context.Context, q querier) (map[string]bool, error) {
+	rows, err := q.Query(ctx, "SELECT slug FROM iraven.content")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	live := map[string]bool{}
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			continue
+		}
+		live[s] = true
+	}
+	return live, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
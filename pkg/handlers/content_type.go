@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/iraven/iraven-admin/pkg/database"
+	"github.com/iraven/iraven-admin/pkg/jsonschema"
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/labstack/echo/v4"
+)
+
+var contentTypeKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+type ContentTypeHandler struct {
+	db *database.Database
+}
+
+func NewContentTypeHandler(db *database.Database) *ContentTypeHandler {
+	return &ContentTypeHandler{db: db}
+}
+
+func (h *ContentTypeHandler) List(c echo.Context) error {
+	rows, err := h.db.Pool.Query(context.Background(),
+		`SELECT t.id, t.name, t.key, t.description, t.created_at, t.updated_at,
+			(SELECT COUNT(*) FROM iraven.content c WHERE c.content_type_id = t.id)
+		FROM iraven.content_types t ORDER BY t.name`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type typeRow struct {
+		models.ContentType
+		ContentCount int64
+	}
+
+	var types []typeRow
+	for rows.Next() {
+		var t typeRow
+		if err := rows.Scan(&t.ID, &t.Name, &t.Key, &t.Description, &t.CreatedAt, &t.UpdatedAt, &t.ContentCount); err != nil {
+			continue
+		}
+		types = append(types, t)
+	}
+
+	data := map[string]interface{}{
+		"Title": "Content Types",
+		"Types": types,
+	}
+
+	return c.Render(http.StatusOK, "content_types/list", data)
+}
+
+func (h *ContentTypeHandler) Show(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	t, err := getContentType(h.db, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Content type not found")
+	}
+
+	schema, _ := jsonschema.Parse(t.Schema)
+
+	var contentCount int64
+	h.db.Pool.QueryRow(context.Background(),
+		"SELECT COUNT(*) FROM iraven.content WHERE content_type_id = $1", id).Scan(&contentCount)
+
+	data := map[string]interface{}{
+		"Title":        "Content Type Details",
+		"Type":         t,
+		"Schema":       schema,
+		"ContentCount": contentCount,
+	}
+
+	return c.Render(http.StatusOK, "content_types/show", data)
+}
+
+func (h *ContentTypeHandler) New(c echo.Context) error {
+	data := map[string]interface{}{
+		"Title": "New Content Type",
+		"Type": models.ContentType{
+			Schema: "{\n  \"type\": \"object\",\n  \"properties\": {},\n  \"required\": []\n}",
+		},
+	}
+	return c.Render(http.StatusOK, "content_types/new", data)
+}
+
+func (h *ContentTypeHandler) Create(c echo.Context) error {
+	name := c.FormValue("name")
+	key := c.FormValue("key")
+	description := c.FormValue("description")
+	schema := c.FormValue("schema")
+
+	if err := validateContentType(name, key, schema); err != nil {
+		return err
+	}
+
+	var typeID int64
+	err := h.db.Pool.QueryRow(context.Background(),
+		"INSERT INTO iraven.content_types (name, key, description, schema) VALUES ($1, $2, $3, $4) RETURNING id",
+		name, key, description, schema).Scan(&typeID)
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to create content type: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/content-types/%d", typeID))
+}
+
+func (h *ContentTypeHandler) Edit(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	t, err := getContentType(h.db, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Content type not found")
+	}
+
+	data := map[string]interface{}{
+		"Title": "Edit Content Type",
+		"Type":  t,
+	}
+
+	return c.Render(http.StatusOK, "content_types/edit", data)
+}
+
+func (h *ContentTypeHandler) Update(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	name := c.FormValue("name")
+	key := c.FormValue("key")
+	description := c.FormValue("description")
+	schema := c.FormValue("schema")
+
+	if err := validateContentType(name, key, schema); err != nil {
+		return err
+	}
+
+	_, err := h.db.Pool.Exec(context.Background(),
+		`UPDATE iraven.content_types SET name = $1, key = $2, description = $3, schema = $4, updated_at = NOW()
+		WHERE id = $5`,
+		name, key, description, schema, id)
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to update content type: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/content-types/%d", id))
+}
+
+func (h *ContentTypeHandler) Delete(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	// Check if content uses this type
+	var count int
+	h.db.Pool.QueryRow(context.Background(),
+		"SELECT COUNT(*) FROM iraven.content WHERE content_type_id = $1", id).Scan(&count)
+
+	if count > 0 {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("Cannot delete content type: %d content entries use it", count))
+	}
+
+	_, err := h.db.Pool.Exec(context.Background(), "DELETE FROM iraven.content_types WHERE id = $1", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to delete content type: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, "/content-types")
+}
+
+func validateContentType(name, key, schema string) error {
+	if name == "" || key == "" || schema == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Name, key and schema are required")
+	}
+	if !contentTypeKeyPattern.MatchString(key) {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			"Key may only contain lowercase letters, digits, dashes and underscores")
+	}
+	if _, err := jsonschema.Parse(schema); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid schema: "+err.Error())
+	}
+	return nil
+}
+
+func getContentType(db *database.Database, id int64) (*models.ContentType, error) {
+	var t models.ContentType
+	err := db.Pool.QueryRow(context.Background(),
+		"SELECT id, name, key, description, schema, created_at, updated_at FROM iraven.content_types WHERE id = $1", id).
+		Scan(&t.ID, &t.Name, &t.Key, &t.Description, &t.Schema, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func listContentTypes(db *database.Database) ([]models.ContentType, error) {
+	rows, err := db.Pool.Query(context.Background(), "SELECT id, name, key FROM iraven.content_types ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var types []models.ContentType
+	for rows.Next() {
+		var t models.ContentType
+		if err := rows.Scan(&t.ID, &t.Name, &t.Key); err != nil {
+			continue
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iraven/iraven-admin/pkg/i18n"
+	"github.com/iraven/iraven-admin/pkg/jsonschema"
+	"github.com/iraven/iraven-admin/pkg/middleware"
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/labstack/echo/v4"
+)
+
+type TranslationStatus struct {
+	Language   models.Language
+	Variant    *models.ContentVariant
+	Translated int
+	Total      int
+	Percent    int
+	Outdated   bool
+}
+
+// translationStatus reports, for every language, whether the content has a
+// variant, how complete it is and whether the source changed since.
+func (h *ContentHandler) translationStatus(content models.Content) ([]TranslationStatus, error) {
+	rows, err := h.db.Pool.Query(context.Background(),
+		`SELECT l.id, l.name, l.code, v.id, v.title, v.data, v.updated_at
+		FROM iraven.languages l
+		LEFT JOIN iraven.content_variants v ON v.language_id = l.id AND v.content_id = $1
+		ORDER BY l.name`, content.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	source := ""
+	if content.Data != nil {
+		source = *content.Data
+	}
+
+	var statuses []TranslationStatus
+	for rows.Next() {
+		var st TranslationStatus
+		var variantID *int64
+		var title *string
+		var updatedAt *time.Time
+		var v models.ContentVariant
+		if err := rows.Scan(&st.Language.ID, &st.Language.Name, &st.Language.Code,
+			&variantID, &title, &v.Data, &updatedAt); err != nil {
+			continue
+		}
+
+		_, st.Total = i18n.Completeness(source, content.Title, "")
+		if variantID != nil {
+			v.ID = *variantID
+			v.Title = *title
+			v.UpdatedAt = *updatedAt
+			v.LanguageID = st.Language.ID
+			v.LanguageCode = st.Language.Code
+			st.Variant = &v
+
+			data := ""
+			if v.Data != nil {
+				data = *v.Data
+			}
+			st.Translated, st.Total = i18n.Completeness(source, v.Title, data)
+			st.Outdated = v.UpdatedAt.Before(content.UpdatedAt)
+		}
+		st.Percent = st.Translated * 100 / st.Total
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
+
+func (h *ContentHandler) EditVariant(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	content, err := h.getContentForVariant(id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Content not found")
+	}
+	language, err := getLanguageByCode(h.db, c.Param("code"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Language not found")
+	}
+
+	variant := models.ContentVariant{ContentID: id, LanguageID: language.ID, LanguageCode: language.Code}
+	err = h.db.Pool.QueryRow(context.Background(),
+		`SELECT id, title, data, updated_by, created_at, updated_at
+		FROM iraven.content_variants WHERE content_id = $1 AND language_id = $2`, id, language.ID).
+		Scan(&variant.ID, &variant.Title, &variant.Data, &variant.UpdatedBy, &variant.CreatedAt, &variant.UpdatedAt)
+	if err != nil {
+		// Start a new translation from the source text
+		variant.Title = content.Title
+		variant.Data = content.Data
+	}
+
+	return h.renderVariantForm(c, http.StatusOK, content, language, variant, nil)
+}
+
+func (h *ContentHandler) SaveVariant(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	title := c.FormValue("title")
+
+	content, err := h.getContentForVariant(id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Content not found")
+	}
+	language, err := getLanguageByCode(h.db, c.Param("code"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Language not found")
+	}
+
+	if title == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Title is required")
+	}
+
+	var current *string
+	h.db.Pool.QueryRow(context.Background(),
+		"SELECT data FROM iraven.content_variants WHERE content_id = $1 AND language_id = $2", id, language.ID).
+		Scan(&current)
+	if current == nil {
+		current = content.Data
+	}
+	base := ""
+	if current != nil {
+		base = *current
+	}
+
+	data, errs, err := h.resolveData(c, content.TypeID, base)
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		variant := models.ContentVariant{ContentID: id, LanguageID: language.ID, LanguageCode: language.Code,
+			Title: title, Data: data}
+		return h.renderVariantForm(c, http.StatusUnprocessableEntity, content, language, variant, errs)
+	}
+
+	var updatedBy *int64
+	if userID := middleware.CurrentUserID(c); userID != 0 {
+		updatedBy = &userID
+	}
+
+	_, err = h.db.Pool.Exec(context.Background(),
+		`INSERT INTO iraven.content_variants (content_id, language_id, title, data, updated_by)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (content_id, language_id) DO UPDATE SET
+			title = EXCLUDED.title, data = EXCLUDED.data, updated_by = EXCLUDED.updated_by, updated_at = NOW()`,
+		id, language.ID, title, data, updatedBy)
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to save translation: "+err.Error())
+	}
+
+	if err := h.indexContent(context.Background(), h.db.Pool, id); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to index content: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/content/%d", id))
+}
+
+func (h *ContentHandler) DeleteVariant(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	language, err := getLanguageByCode(h.db, c.Param("code"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Language not found")
+	}
+
+	_, err = h.db.Pool.Exec(context.Background(),
+		"DELETE FROM iraven.content_variants WHERE content_id = $1 AND language_id = $2", id, language.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to delete translation: "+err.Error())
+	}
+
+	if err := h.indexContent(context.Background(), h.db.Pool, id); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to index content: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/content/%d", id))
+}
+
+// Preview shows which variant a reader with the given language and country
+// would receive.
+func (h *ContentHandler) Preview(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	lang := c.QueryParam("lang")
+	country := strings.ToUpper(strings.TrimSpace(c.QueryParam("country")))
+
+	content, err := h.getContentForVariant(id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Content not found")
+	}
+
+	var countryDefault string
+	if country != "" {
+		h.db.Pool.QueryRow(context.Background(),
+			`SELECT l.code FROM iraven.countries c
+			INNER JOIN iraven.languages l ON l.id = c.default_language_id
+			WHERE upper(c.code) = $1`, country).Scan(&countryDefault)
+	}
+
+	chain := i18n.FallbackChain(lang, countryDefault, h.defaultLanguage)
+	resolved, err := resolveVariant(context.Background(), h.db.Pool, id, chain)
+	if err != nil {
+		return err
+	}
+
+	languages, err := listLanguages(h.db)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{
+		"Title":          "Preview Content",
+		"Content":        content,
+		"Languages":      languages,
+		"Lang":           i18n.NormalizeCode(lang),
+		"Country":        country,
+		"CountryDefault": countryDefault,
+		"Chain":          chain,
+		"Variant":        resolved,
+	}
+
+	return c.Render(http.StatusOK, "content/preview", data)
+}
+
+// resolveVariant returns the first variant along the fallback chain, or nil
+// when the source content should be served. Stored codes are normalized
+// as the chain is, so a "pt-BR" variant serves "pt"; should two languages
+// normalize alike, the one without a region wins, then the first by code.
+func resolveVariant(ctx context.Context, q querier, contentID int64, chain []string) (*models.ContentVariant, error) {
+	code := normalizedCodeSQL("l.code")
+	rows, err := q.Query(ctx,
+		`SELECT v.id, v.language_id, `+code+`, v.title, v.data, v.updated_at
+		FROM iraven.content_variants v
+		INNER JOIN iraven.languages l ON l.id = v.language_id
+		WHERE v.content_id = $1 AND `+code+` = ANY($2)
+		ORDER BY lower(l.code) <> `+code+`, l.code`, contentID, chain)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byCode := map[string]*models.ContentVariant{}
+	for rows.Next() {
+		var v models.ContentVariant
+		if err := rows.Scan(&v.ID, &v.LanguageID, &v.LanguageCode, &v.Title, &v.Data, &v.UpdatedAt); err != nil {
+			continue
+		}
+		v.ContentID = contentID
+		if _, ok := byCode[v.LanguageCode]; !ok {
+			byCode[v.LanguageCode] = &v
+		}
+	}
+
+	for _, code := range chain {
+		if v, ok := byCode[code]; ok {
+			return v, nil
+		}
+	}
+	return nil, nil
+}
+
+// normalizedCodeSQL is i18n.NormalizeCode in SQL, applied to a column of
+// language codes.
+func normalizedCodeSQL(column string) string {
+	return "split_part(replace(lower(" + column + "), '_', '-'), '-', 1)"
+}
+
+func (h *ContentHandler) renderVariantForm(c echo.Context, status int, content *models.Content,
+	language *models.Language, variant models.ContentVariant, errs []jsonschema.FieldError) error {
+	data := map[string]interface{}{
+		"Title":    "Translate Content",
+		"Content":  models.Content{ID: content.ID, Title: variant.Title, Data: variant.Data},
+		"Source":   content,
+		"Language": language,
+		"Variant":  variant,
+		"Errors":   errs,
+		"Mode":     "json",
+		"TypeID":   int64(0),
+	}
+
+	if content.TypeID != nil {
+		data["TypeID"] = *content.TypeID
+		if ct, err := getContentType(h.db, *content.TypeID); err == nil {
+			data["Type"] = ct
+			schema, err := jsonschema.Parse(ct.Schema)
+			if err == nil && len(schema.PropertyOrder) > 0 && c.QueryParam("mode") != "json" {
+				doc := ""
+				if variant.Data != nil {
+					doc = *variant.Data
+				}
+				data["Fields"] = schema.Fields(doc, errs)
+				data["Mode"] = "fields"
+			}
+		}
+	}
+
+	return c.Render(status, "content/variant", data)
+}
+
+func (h *ContentHandler) getContentForVariant(id int64) (*models.Content, error) {
+	var content models.Content
+	err := h.db.Pool.QueryRow(context.Background(),
+		"SELECT id, slug, title, data, content_type_id, updated_at FROM iraven.content WHERE id = $1", id).
+		Scan(&content.ID, &content.Slug, &content.Title, &content.Data, &content.TypeID, &content.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &content, nil
+}
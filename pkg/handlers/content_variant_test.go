@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/iraven/iraven-admin/pkg/i18n"
+)
+
+func TestNormalizedCodeSQL(t *testing.T) {
+	tx := testTx(t)
+	for _, code := range []string{"de", "pt-BR", "pt_br", "EN-gb", "zh-Hant-TW", ""} {
+		var got string
+		if err := tx.QueryRow(context.Background(), "SELECT "+normalizedCodeSQL("$1::text"), code).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if want := i18n.NormalizeCode(code); got != want {
+			t.Errorf("%q: SQL gives %q, NormalizeCode %q", code, got, want)
+		}
+	}
+}
+
+func TestResolveVariant(t *testing.T) {
+	tx := testTx(t, "005_content_variants.sql")
+	ctx := context.Background()
+
+	exec(t, tx, "INSERT INTO iraven.content (id, title) VALUES (1, 'Lamp'), (2, 'Desk')")
+	exec(t, tx, `INSERT INTO iraven.languages (id, code) VALUES
+		(1, 'pt-BR'), (2, 'pt-PT'), (3, 'pt'), (4, 'de_AT'), (5, 'FR')`)
+	exec(t, tx, `INSERT INTO iraven.content_variants (content_id, language_id, title) VALUES
+		(1, 1, 'Luminária'), (1, 2, 'Candeeiro'), (1, 4, 'Lampe'), (1, 5, 'Lampe (fr)'),
+		(2, 1, 'Mesa'), (2, 2, 'Secretária'), (2, 3, 'Escrivaninha')`)
+
+	tests := []struct {
+		contentID int64
+		chain     []string
+		want      string
+	}{
+		// Stored codes with a region match their language
+		{1, i18n.FallbackChain("de-DE", "", "en"), "Lampe"},
+		{1, i18n.FallbackChain("fr", "", "en"), "Lampe (fr)"},
+		// Of two regions, the first by code
+		{1, i18n.FallbackChain("pt-PT", "", "en"), "Luminária"},
+		// The language without a region wins
+		{2, i18n.FallbackChain("pt-BR", "", "en"), "Escrivaninha"},
+		// Down the chain
+		{1, i18n.FallbackChain("it", "de", "en"), "Lampe"},
+		{2, i18n.FallbackChain("it", "", "en"), ""},
+	}
+	for _, tt := range tests {
+		v, err := resolveVariant(ctx, tx, tt.contentID, tt.chain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if v != nil {
+			got = v.Title
+		}
+		if got != tt.want {
+			t.Errorf("content %d, chain %v: got %q, want %q", tt.contentID, tt.chain, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/iraven/iraven-admin/pkg/middleware"
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/iraven/iraven-admin/pkg/workflow"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/labstack/echo/v4"
+)
+
+func (h *ContentHandler) Transition(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	t, ok := workflow.Find(c.FormValue("action"))
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "Unknown action")
+	}
+	if !t.Permitted(middleware.CurrentUserRoles(c)) {
+		return echo.NewHTTPError(http.StatusForbidden, "You are not allowed to "+t.Label)
+	}
+
+	var publishAt *time.Time
+	if value := c.FormValue("publish_at"); value != "" && t.To == workflow.StatusPublished {
+		at, err := time.ParseInLocation("2006-01-02T15:04", value, time.Local)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid publish time")
+		}
+		if at.After(time.Now()) {
+			publishAt = &at
+		}
+	}
+
+	var tag pgconn.CommandTag
+	var err error
+	if publishAt != nil {
+		// Approved for a future date: stays in review until the scheduler publishes it
+		tag, err = h.db.Pool.Exec(context.Background(),
+			`UPDATE iraven.content SET scheduled_at = $1, updated_at = NOW()
+			WHERE id = $2 AND status = ANY($3)`,
+			publishAt, id, t.From)
+	} else {
+		tag, err = h.db.Pool.Exec(context.Background(),
+			`UPDATE iraven.content SET
+				status = $1,
+				published_at = CASE
+					WHEN $1 = 'published' THEN NOW()
+					WHEN $1 = 'draft' THEN NULL
+					ELSE published_at END,
+				scheduled_at = NULL,
+				updated_at = NOW()
+			WHERE id = $2 AND status = ANY($3)`,
+			t.To, id, t.From)
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to change status: "+err.Error())
+	}
+	if tag.RowsAffected() == 0 {
+		return echo.NewHTTPError(http.StatusConflict, "Content is not in a state that allows: "+t.Label)
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/content/%d", id))
+}
+
+func (h *ContentHandler) ReviewQueue(c echo.Context) error {
+	rows, err := h.db.Pool.Query(context.Background(),
+		`SELECT c.id, c.slug, c.title, c.created_by, c.status, c.scheduled_at, c.created_at, c.updated_at
+		FROM iraven.content c
+		WHERE c.status = $1
+		ORDER BY c.scheduled_at NULLS FIRST, c.updated_at`, workflow.StatusInReview)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var contents []models.Content
+	for rows.Next() {
+		var content models.Content
+		if err := rows.Scan(&content.ID, &content.Slug, &content.Title, &content.CreatedBy, &content.Status,
+			&content.ScheduledAt, &content.CreatedAt, &content.UpdatedAt); err != nil {
+			continue
+		}
+		contents = append(contents, content)
+	}
+
+	roles := middleware.CurrentUserRoles(c)
+	publish, _ := workflow.Find("publish")
+	reject, _ := workflow.Find("reject")
+
+	data := map[string]interface{}{
+		"Title":      "Review Queue",
+		"Contents":   contents,
+		"CanPublish": publish.Permitted(roles),
+		"CanReject":  reject.Permitted(roles),
+	}
+
+	return c.Render(http.StatusOK, "content/review", data)
+}
+
+// PublishScheduled publishes approved content whose scheduled time has
+// passed. It runs as a background job.
+func (h *ContentHandler) PublishScheduled(ctx context.Context) error {
+	_, err := h.db.Pool.Exec(ctx,
+		`UPDATE iraven.content SET
+			status = $1, published_at = scheduled_at, scheduled_at = NULL, updated_at = NOW()
+		WHERE status = $2 AND scheduled_at <= NOW()`,
+		workflow.StatusPublished, workflow.StatusInReview)
+	return err
+}
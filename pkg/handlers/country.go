@@ -0,0 +1,515 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/iraven/iraven-admin/pkg/database"
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/labstack/echo/v4"
+)
+
+// countryCode matches ISO 3166-1 alpha-2 codes.
+var countryCode = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// matrixPageSize is how many countries the language matrix edits at once.
+const matrixPageSize = 50
+
+type CountryHandler struct {
+	db *database.Database
+}
+
+func NewCountryHandler(db *database.Database) *CountryHandler {
+	return &CountryHandler{db: db}
+}
+
+// CountryWithLanguages is a country with the languages spoken there and the
+// name of its default language.
+type CountryWithLanguages struct {
+	models.Country
+	DefaultLanguage *string
+	Languages       []models.Language
+	Profiles        int
+}
+
+// Speaks reports whether languageID is one of the country's languages.
+func (c CountryWithLanguages) Speaks(languageID int64) bool {
+	for _, l := range c.Languages {
+		if l.ID == languageID {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDefault reports whether languageID is the country's default language.
+func (c CountryWithLanguages) IsDefault(languageID int64) bool {
+	return c.DefaultLanguageID != nil && *c.DefaultLanguageID == languageID
+}
+
+func (h *CountryHandler) List(c echo.Context) error {
+	search := strings.TrimSpace(c.QueryParam("q"))
+
+	countries, err := h.countries(search, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{
+		"Title":     "Countries",
+		"Countries": countries,
+		"Search":    search,
+		"Seeded":    seedResultFromQuery(c),
+	}
+
+	return c.Render(http.StatusOK, "countries/list", data)
+}
+
+func (h *CountryHandler) Show(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	country, err := h.getCountry(id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Country not found")
+	}
+
+	data := map[string]interface{}{
+		"Title":   "Country Details",
+		"Country": country,
+	}
+
+	return c.Render(http.StatusOK, "countries/show", data)
+}
+
+func (h *CountryHandler) New(c echo.Context) error {
+	languages, err := listLanguages(h.db)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{
+		"Title":     "New Country",
+		"Languages": languages,
+	}
+	return c.Render(http.StatusOK, "countries/new", data)
+}
+
+func (h *CountryHandler) Create(c echo.Context) error {
+	name, code, defaultLanguageID, languageIDs, err := h.countryForm(c, 0)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var countryID int64
+	err = tx.QueryRow(ctx,
+		"INSERT INTO iraven.countries (name, code, default_language_id) VALUES ($1, $2, $3) RETURNING id",
+		name, code, defaultLanguageID).Scan(&countryID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to create country: "+err.Error())
+	}
+	if err := setCountryLanguages(ctx, tx, countryID, languageIDs); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to save country languages: "+err.Error())
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/countries/%d", countryID))
+}
+
+func (h *CountryHandler) Edit(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	country, err := h.getCountry(id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Country not found")
+	}
+
+	languages, err := listLanguages(h.db)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{
+		"Title":     "Edit Country",
+		"Country":   country,
+		"Languages": languages,
+	}
+
+	return c.Render(http.StatusOK, "countries/edit", data)
+}
+
+func (h *CountryHandler) Update(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	name, code, defaultLanguageID, languageIDs, err := h.countryForm(c, id)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		"UPDATE iraven.countries SET name = $1, code = $2, default_language_id = $3, updated_at = NOW() WHERE id = $4",
+		name, code, defaultLanguageID, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to update country: "+err.Error())
+	}
+	if err := setCountryLanguages(ctx, tx, id, languageIDs); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to save country languages: "+err.Error())
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/countries/%d", id))
+}
+
+func (h *CountryHandler) Delete(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	// Check if country is in use
+	var count int
+	h.db.Pool.QueryRow(context.Background(),
+		"SELECT COUNT(*) FROM iraven.profiles WHERE country_id = $1", id).Scan(&count)
+
+	if count > 0 {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("Cannot delete country: %d profiles are in this country", count))
+	}
+
+	// Detach languages first
+	h.db.Pool.Exec(context.Background(), "DELETE FROM iraven.country_languages WHERE country_id = $1", id)
+
+	_, err := h.db.Pool.Exec(context.Background(), "DELETE FROM iraven.countries WHERE id = $1", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to delete country: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, "/countries")
+}
+
+// Matrix edits which languages are spoken in which countries, and each
+// country's default, a page of countries at a time. The columns are the
+// languages already linked to one of the countries on the page plus any
+// added with the lang parameter.
+func (h *CountryHandler) Matrix(c echo.Context) error {
+	search := strings.TrimSpace(c.QueryParam("q"))
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	var total int
+	h.db.Pool.QueryRow(context.Background(),
+		"SELECT COUNT(*) FROM iraven.countries WHERE $1 = '' OR name ILIKE '%' || $1 || '%' OR code ILIKE $1",
+		search).Scan(&total)
+
+	countries, err := h.countries(search, matrixPageSize, (page-1)*matrixPageSize)
+	if err != nil {
+		return err
+	}
+
+	languages, err := listLanguages(h.db)
+	if err != nil {
+		return err
+	}
+
+	shown := map[int64]bool{}
+	for _, country := range countries {
+		for _, l := range country.Languages {
+			shown[l.ID] = true
+		}
+		if country.DefaultLanguageID != nil {
+			shown[*country.DefaultLanguageID] = true
+		}
+	}
+	var added []string
+	for _, value := range c.QueryParams()["lang"] {
+		if id, err := strconv.ParseInt(value, 10, 64); err == nil && !shown[id] {
+			shown[id] = true
+			added = append(added, value)
+		}
+	}
+
+	var columns, others []models.Language
+	for _, l := range languages {
+		if shown[l.ID] {
+			columns = append(columns, l)
+		} else {
+			others = append(others, l)
+		}
+	}
+
+	data := map[string]interface{}{
+		"Title":      "Country Languages",
+		"Countries":  countries,
+		"Columns":    columns,
+		"Others":     others,
+		"Added":      added,
+		"Search":     search,
+		"Page":       page,
+		"TotalPages": (total + matrixPageSize - 1) / matrixPageSize,
+	}
+
+	return c.Render(http.StatusOK, "countries/matrix", data)
+}
+
+// SaveMatrix applies the matrix form: for every country and language it
+// showed, the link exists exactly when its box was ticked. Choosing a
+// default language also links it.
+func (h *CountryHandler) SaveMatrix(c echo.Context) error {
+	form, err := c.FormParams()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid form")
+	}
+
+	countryIDs := parseIDs(form["country_id"])
+	languageIDs := parseIDs(form["language_id"])
+	linked := map[string]bool{}
+	for _, link := range form["link"] {
+		linked[link] = true
+	}
+
+	ctx := context.Background()
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, countryID := range countryIDs {
+		var defaultLanguageID *int64
+		if id, err := strconv.ParseInt(form.Get(fmt.Sprintf("default_%d", countryID)), 10, 64); err == nil {
+			defaultLanguageID = &id
+			linked[fmt.Sprintf("%d:%d", countryID, id)] = true
+		}
+
+		for _, languageID := range languageIDs {
+			if linked[fmt.Sprintf("%d:%d", countryID, languageID)] {
+				_, err = tx.Exec(ctx,
+					`INSERT INTO iraven.country_languages (country_id, language_id)
+					SELECT $1, $2 WHERE NOT EXISTS (
+						SELECT 1 FROM iraven.country_languages WHERE country_id = $1 AND language_id = $2)`,
+					countryID, languageID)
+			} else {
+				_, err = tx.Exec(ctx,
+					"DELETE FROM iraven.country_languages WHERE country_id = $1 AND language_id = $2",
+					countryID, languageID)
+			}
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "Failed to save country languages: "+err.Error())
+			}
+		}
+
+		_, err = tx.Exec(ctx,
+			`UPDATE iraven.countries SET default_language_id = $1, updated_at = NOW()
+			WHERE id = $2 AND default_language_id IS DISTINCT FROM $1`,
+			defaultLanguageID, countryID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to save default language: "+err.Error())
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	if search := form.Get("q"); search != "" {
+		query.Set("q", search)
+	}
+	if page := form.Get("page"); page != "" {
+		query.Set("page", page)
+	}
+	for _, id := range form["lang"] {
+		query.Add("lang", id)
+	}
+	return c.Redirect(http.StatusFound, "/countries/matrix?"+query.Encode())
+}
+
+// countryForm reads and validates a country. The default language, when
+// chosen, is always among the returned languages.
+func (h *CountryHandler) countryForm(c echo.Context, id int64) (string, string, *int64, []int64, error) {
+	name := strings.TrimSpace(c.FormValue("name"))
+	code := strings.ToUpper(strings.TrimSpace(c.FormValue("code")))
+
+	if name == "" || code == "" {
+		return "", "", nil, nil, echo.NewHTTPError(http.StatusBadRequest, "Name and code are required")
+	}
+	if !countryCode.MatchString(code) {
+		return "", "", nil, nil, echo.NewHTTPError(http.StatusBadRequest,
+			"Code must be a two-letter ISO 3166-1 country code such as \"DE\"")
+	}
+
+	var taken bool
+	h.db.Pool.QueryRow(context.Background(),
+		"SELECT EXISTS (SELECT 1 FROM iraven.countries WHERE upper(code) = $1 AND id <> $2)", code, id).
+		Scan(&taken)
+	if taken {
+		return "", "", nil, nil, echo.NewHTTPError(http.StatusBadRequest, "Another country already has code "+code)
+	}
+
+	var languageIDs []int64
+	if err := c.Request().ParseForm(); err == nil {
+		languageIDs = parseIDs(c.Request().Form["language_ids"])
+	}
+
+	var defaultLanguageID *int64
+	if languageID, err := strconv.ParseInt(c.FormValue("default_language_id"), 10, 64); err == nil {
+		defaultLanguageID = &languageID
+		found := false
+		for _, id := range languageIDs {
+			found = found || id == languageID
+		}
+		if !found {
+			languageIDs = append(languageIDs, languageID)
+		}
+	}
+
+	return name, code, defaultLanguageID, languageIDs, nil
+}
+
+// countries lists countries matching search by name or exact code, with
+// their languages. A zero limit lists them all.
+func (h *CountryHandler) countries(search string, limit, offset int) ([]CountryWithLanguages, error) {
+	query := `SELECT c.id, c.name, c.code, c.default_language_id, c.created_at, c.updated_at, l.name
+		FROM iraven.countries c
+		LEFT JOIN iraven.languages l ON l.id = c.default_language_id
+		WHERE $1 = '' OR c.name ILIKE '%' || $1 || '%' OR c.code ILIKE $1
+		ORDER BY c.name`
+	args := []interface{}{search}
+	if limit > 0 {
+		query += " LIMIT $2 OFFSET $3"
+		args = append(args, limit, offset)
+	}
+
+	rows, err := h.db.Pool.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var countries []CountryWithLanguages
+	index := map[int64]int{}
+	for rows.Next() {
+		var country CountryWithLanguages
+		if err := rows.Scan(&country.ID, &country.Name, &country.Code, &country.DefaultLanguageID,
+			&country.CreatedAt, &country.UpdatedAt, &country.DefaultLanguage); err != nil {
+			continue
+		}
+		index[country.ID] = len(countries)
+		countries = append(countries, country)
+	}
+	rows.Close()
+
+	if len(countries) == 0 {
+		return countries, nil
+	}
+
+	ids := make([]int64, 0, len(countries))
+	for _, country := range countries {
+		ids = append(ids, country.ID)
+	}
+
+	rows, err = h.db.Pool.Query(context.Background(),
+		`SELECT cl.country_id, l.id, l.name, l.code FROM iraven.country_languages cl
+		INNER JOIN iraven.languages l ON l.id = cl.language_id
+		WHERE cl.country_id = ANY($1) ORDER BY l.name`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var countryID int64
+		var l models.Language
+		if err := rows.Scan(&countryID, &l.ID, &l.Name, &l.Code); err != nil {
+			continue
+		}
+		i := index[countryID]
+		countries[i].Languages = append(countries[i].Languages, l)
+	}
+
+	return countries, nil
+}
+
+func (h *CountryHandler) getCountry(id int64) (*CountryWithLanguages, error) {
+	var country CountryWithLanguages
+	err := h.db.Pool.QueryRow(context.Background(),
+		`SELECT c.id, c.name, c.code, c.default_language_id, c.created_at, c.updated_at, l.name,
+			(SELECT COUNT(*) FROM iraven.profiles p WHERE p.country_id = c.id)
+		FROM iraven.countries c
+		LEFT JOIN iraven.languages l ON l.id = c.default_language_id
+		WHERE c.id = $1`, id).
+		Scan(&country.ID, &country.Name, &country.Code, &country.DefaultLanguageID,
+			&country.CreatedAt, &country.UpdatedAt, &country.DefaultLanguage, &country.Profiles)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := h.db.Pool.Query(context.Background(),
+		`SELECT l.id, l.name, l.code FROM iraven.languages l
+		INNER JOIN iraven.country_languages cl ON cl.language_id = l.id
+		WHERE cl.country_id = $1 ORDER BY l.name`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l models.Language
+		if err := rows.Scan(&l.ID, &l.Name, &l.Code); err != nil {
+			continue
+		}
+		country.Languages = append(country.Languages, l)
+	}
+
+	return &country, nil
+}
+
+// setCountryLanguages replaces the languages spoken in a country.
+func setCountryLanguages(ctx context.Context, q querier, countryID int64, languageIDs []int64) error {
+	if _, err := q.Exec(ctx, "DELETE FROM iraven.country_languages WHERE country_id = $1", countryID); err != nil {
+		return err
+	}
+	for _, languageID := range languageIDs {
+		if _, err := q.Exec(ctx,
+			"INSERT INTO iraven.country_languages (country_id, language_id) VALUES ($1, $2)",
+			countryID, languageID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseIDs parses form values as IDs, skipping invalid and repeated ones.
+func parseIDs(values []string) []int64 {
+	var ids []int64
+	seen := map[int64]bool{}
+	for _, value := range values {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
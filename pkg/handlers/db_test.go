@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// apiSchema creates the API-owned tables the tested queries use, with the
+// columns they read and write.
+const apiSchema = `
+	DROP SCHEMA IF EXISTS iraven CASCADE;
+	CREATE SCHEMA iraven;
+
+	CREATE TABLE iraven.users (
+		id BIGSERIAL PRIMARY KEY,
+		email VARCHAR(255) NOT NULL UNIQUE,
+		name VARCHAR(255) NOT NULL DEFAULT '',
+		password VARCHAR(255) NOT NULL DEFAULT '',
+		last_login TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+
+	CREATE TABLE iraven.languages (
+		id BIGSERIAL PRIMARY KEY,
+		code VARCHAR(20) NOT NULL UNIQUE,
+		name VARCHAR(100) NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE iraven.profiles (
+		user_id BIGINT PRIMARY KEY REFERENCES iraven.users(id) ON DELETE CASCADE,
+		extra_data JSONB,
+		photo TEXT
+	);
+
+	CREATE TABLE iraven.notification_preferences (
+		user_id BIGINT PRIMARY KEY REFERENCES iraven.users(id) ON DELETE CASCADE,
+		email_notifications BOOLEAN NOT NULL DEFAULT TRUE,
+		push_notifications BOOLEAN NOT NULL DEFAULT TRUE,
+		sms_notifications BOOLEAN NOT NULL DEFAULT TRUE
+	);
+
+	CREATE TABLE iraven.notification_devices (
+		id BIGSERIAL PRIMARY KEY,
+		user_id BIGINT NOT NULL REFERENCES iraven.users(id) ON DELETE CASCADE,
+		device_token TEXT NOT NULL UNIQUE,
+		device_type VARCHAR(50),
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+
+	CREATE TABLE iraven.content (
+		id BIGSERIAL PRIMARY KEY,
+		slug VARCHAR(255),
+		title VARCHAR(255) NOT NULL,
+		data TEXT,
+		status VARCHAR(20) NOT NULL DEFAULT 'draft',
+		created_by BIGINT REFERENCES iraven.users(id) ON DELETE SET NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+
+	CREATE TABLE iraven.applications (
+		id BIGSERIAL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL
+	);
+
+	CREATE TABLE iraven.roles (
+		id BIGSERIAL PRIMARY KEY,
+		name VARCHAR(100) NOT NULL UNIQUE
+	);
+
+	CREATE TABLE iraven.user_roles (
+		user_id BIGINT NOT NULL REFERENCES iraven.users(id) ON DELETE CASCADE,
+		role_id BIGINT NOT NULL REFERENCES iraven.roles(id) ON DELETE CASCADE,
+		PRIMARY KEY (user_id, role_id)
+	);
+
+	CREATE TABLE iraven.application_roles (
+		application_id BIGINT NOT NULL REFERENCES iraven.applications(id) ON DELETE CASCADE,
+		role_id BIGINT NOT NULL REFERENCES iraven.roles(id) ON DELETE CASCADE,
+		PRIMARY KEY (application_id, role_id)
+	);
+
+	CREATE TABLE iraven.files (
+		id BIGSERIAL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL DEFAULT '',
+		original_name VARCHAR(255) NOT NULL DEFAULT '',
+		mime_type VARCHAR(100) NOT NULL DEFAULT '',
+		size BIGINT NOT NULL,
+		path TEXT NOT NULL DEFAULT '',
+		bucket VARCHAR(255) NOT NULL DEFAULT '',
+		url TEXT NOT NULL DEFAULT '',
+		uploaded_by BIGINT REFERENCES iraven.users(id) ON DELETE SET NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+
+	CREATE TABLE iraven.notifications (
+		id BIGSERIAL PRIMARY KEY,
+		user_id BIGINT NOT NULL REFERENCES iraven.users(id) ON DELETE CASCADE,
+		title VARCHAR(255) NOT NULL,
+		body TEXT NOT NULL,
+		data JSONB,
+		read BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`
+
+// testTx begins a transaction on the scratch database named by
+// IRAVEN_TEST_DATABASE_URL and recreates the iraven schema inside it: the
+// API tables of apiSchema, then the named admin migrations. The
+// transaction is rolled back when the test ends, so the database is left
+// as it was. Without the variable the test is skipped.
+func testTx(t *testing.T, migrations ...string) pgx.Tx {
+	t.Helper()
+	url := os.Getenv("IRAVEN_TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("IRAVEN_TEST_DATABASE_URL is not set")
+	}
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, url)
+	if err != nil {
+		t.Fatalf("connecting to the test database: %v", err)
+	}
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		conn.Close(ctx)
+		t.Fatalf("beginning a transaction: %v", err)
+	}
+	t.Cleanup(func() {
+		tx.Rollback(ctx)
+		conn.Close(ctx)
+	})
+
+	if _, err := tx.Exec(ctx, apiSchema); err != nil {
+		t.Fatalf("creating the API schema: %v", err)
+	}
+	for _, name := range migrations {
+		script, err := os.ReadFile(filepath.Join("..", "database", "migrations", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.Exec(ctx, string(script)); err != nil {
+			t.Fatalf("applying %s: %v", name, err)
+		}
+	}
+	return tx
+}
+
+// exec runs a fixture statement, failing the test on error.
+func exec(t *testing.T, q querier, sql string, args ...any) {
+	t.Helper()
+	if _, err := q.Exec(context.Background(), sql, args...); err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+}
+
+// collectStrings reads a single text column from a query.
+func collectStrings(rows pgx.Rows, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iraven/iraven-admin/pkg/database"
+	"github.com/iraven/iraven-admin/pkg/imaging"
+	"github.com/iraven/iraven-admin/pkg/middleware"
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/iraven/iraven-admin/pkg/storage"
+	"github.com/labstack/echo/v4"
+)
+
+type FileHandler struct {
+	db            *database.Database
+	store         storage.Storage
+	maxUploadSize int64
+	quotas        QuotaDefaults
+}
+
+func NewFileHandler(db *database.Database, store storage.Storage, maxUploadSize int64, quotas QuotaDefaults) *FileHandler {
+	return &FileHandler{db: db, store: store, maxUploadSize: maxUploadSize, quotas: quotas}
+}
+
+// fileKinds are the type filters offered on the file list.
+var fileKinds = []string{"image", "pdf", "other"}
+
+func (h *FileHandler) List(c echo.Context) error {
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize := 20
+	offset := (page - 1) * pageSize
+
+	query := strings.TrimSpace(c.QueryParam("q"))
+	kind := c.QueryParam("kind")
+
+	where := `WHERE ($1 = '' OR f.original_name ILIKE '%' || $1 || '%' OR f.name ILIKE '%' || $1 || '%')
+		AND ($2 = ''
+			OR ($2 = 'image' AND f.mime_type LIKE 'image/%')
+			OR ($2 = 'pdf' AND f.mime_type = 'application/pdf')
+			OR ($2 = 'other' AND f.mime_type NOT LIKE 'image/%' AND f.mime_type <> 'application/pdf'))`
+
+	rows, err := h.db.Pool.Query(context.Background(),
+		`SELECT f.id, f.name, f.original_name, f.mime_type, f.size, f.path, f.bucket, f.url, f.uploaded_by,
+			f.created_at, f.updated_at, COALESCE(u.email, ''),
+			(SELECT COUNT(*) FROM iraven.file_attachments a WHERE a.file_id = f.id)
+		FROM iraven.files f
+		LEFT JOIN iraven.users u ON u.id = f.uploaded_by `+where+`
+		ORDER BY f.created_at DESC LIMIT $3 OFFSET $4`,
+		query, kind, pageSize, offset)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var files []models.FileWithUploader
+	for rows.Next() {
+		var f models.FileWithUploader
+		if err := rows.Scan(&f.ID, &f.Name, &f.OriginalName, &f.MimeType, &f.Size, &f.Path, &f.Bucket, &f.URL,
+			&f.UploadedBy, &f.CreatedAt, &f.UpdatedAt, &f.UploaderEmail, &f.Attachments); err != nil {
+			continue
+		}
+		f.Thumbnail = thumbnailable(f.MimeType)
+		files = append(files, f)
+	}
+
+	var totalFiles, totalSize int64
+	h.db.Pool.QueryRow(context.Background(),
+		"SELECT COUNT(*), COALESCE(SUM(f.size), 0) FROM iraven.files f "+where, query, kind).
+		Scan(&totalFiles, &totalSize)
+
+	data := map[string]interface{}{
+		"Title":         "Files",
+		"Files":         files,
+		"Query":         query,
+		"Kind":          kind,
+		"Kinds":         fileKinds,
+		"TotalFiles":    totalFiles,
+		"TotalSize":     totalSize,
+		"MaxUploadSize": h.maxUploadSize,
+		"Page":          page,
+		"TotalPages":    (totalFiles + int64(pageSize) - 1) / int64(pageSize),
+	}
+
+	return c.Render(http.StatusOK, "files/list", data)
+}
+
+func (h *FileHandler) Show(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	f, err := h.getFile(id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "File not found")
+	}
+
+	rows, err := h.db.Pool.Query(context.Background(),
+		`SELECT id, file_id, entity_type, entity_id, created_at
+		FROM iraven.file_attachments WHERE file_id = $1 ORDER BY created_at DESC`, id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var attachments []models.FileAttachment
+	for rows.Next() {
+		var a models.FileAttachment
+		if err := rows.Scan(&a.ID, &a.FileID, &a.EntityType, &a.EntityID, &a.CreatedAt); err != nil {
+			continue
+		}
+		attachments = append(attachments, a)
+	}
+
+	image, variants, err := h.getImage(id)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{
+		"Title":       "File Details",
+		"File":        f,
+		"Attachments": attachments,
+		"Previewable": storage.Previewable(f.MimeType),
+		"IsImage":     thumbnailable(f.MimeType),
+		"Processable": imaging.CanStrip(f.MimeType) || imaging.CanDecode(f.MimeType),
+		"Image":       image,
+		"Variants":    variants,
+	}
+
+	return c.Render(http.StatusOK, "files/show", data)
+}
+
+// localPath reports whether a return_to value is a path on this site.
+// Browsers read a backslash as a slash, so "/\evil.example" leaves the
+// site just as "//evil.example" does.
+func localPath(raw string) bool {
+	if !strings.HasPrefix(raw, "/") || strings.HasPrefix(raw, "//") || strings.HasPrefix(raw, "/\\") {
+		return false
+	}
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme == "" && u.Host == "" && u.User == nil
+}
+
+// Upload stores every file of a multipart upload and records it. When an
+// entity is given, the files are attached to it and the admin is sent back
+// to return_to.
+func (h *FileHandler) Upload(c echo.Context) error {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid upload: "+err.Error())
+	}
+	headers := form.File["files"]
+	if len(headers) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Choose at least one file to upload")
+	}
+
+	entityType := c.FormValue("entity_type")
+	entityID, _ := strconv.ParseInt(c.FormValue("entity_id"), 10, 64)
+	if entityType != "" {
+		if _, ok := attachmentTables[entityType]; !ok || entityID == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid attachment target")
+		}
+	}
+
+	var total int64
+	for _, fh := range headers {
+		if h.maxUploadSize > 0 && fh.Size > h.maxUploadSize {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("%s is larger than the %d byte upload limit", fh.Filename, h.maxUploadSize))
+		}
+		total += fh.Size
+	}
+	// Uploads are checked against the quota again as they are stored,
+	// when their processed size is known; this refuses the plainly too
+	// large before any work is done
+	if err := checkQuota(c.Request().Context(), h.db.Pool, h.quotas, middleware.CurrentUserID(c), total); err != nil {
+		return err
+	}
+
+	var lastID int64
+	for _, fh := range headers {
+		f, err := h.saveUpload(c, fh)
+		if err != nil {
+			return uploadError(fh, err)
+		}
+		lastID = f.ID
+
+		if entityType != "" {
+			_, err := h.db.Pool.Exec(context.Background(),
+				"INSERT INTO iraven.file_attachments (file_id, entity_type, entity_id) VALUES ($1, $2, $3)",
+				f.ID, entityType, entityID)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "Failed to attach "+fh.Filename+": "+err.Error())
+			}
+		}
+	}
+
+	if len(headers) == 1 {
+		return h.redirectBack(c, fmt.Sprintf("/files/%d", lastID))
+	}
+	return h.redirectBack(c, "/files")
+}
+
+// Download streams a file as an attachment under its original name.
+func (h *FileHandler) Download(c echo.Context) error {
+	return h.serve(c, "attachment")
+}
+
+// Preview streams an image or PDF for display in the browser.
+func (h *FileHandler) Preview(c echo.Context) error {
+	return h.serve(c, "inline")
+}
+
+func (h *FileHandler) serve(c echo.Context, disposition string) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	f, err := h.getFile(id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "File not found")
+	}
+
+	contentType := f.MimeType
+	if disposition == "inline" && !storage.Previewable(f.MimeType) {
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType, "This file type cannot be previewed")
+	}
+	if disposition == "attachment" {
+		// Downloads are never rendered, whatever type was recorded
+		contentType = "application/octet-stream"
+	}
+
+	r, err := h.store.Get(c.Request().Context(), f.Path)
+	if err == storage.ErrNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, "File is missing from storage")
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentDisposition, mime.FormatMediaType(disposition, map[string]string{"filename": f.OriginalName}))
+	header.Set(echo.HeaderXContentTypeOptions, "nosniff")
+	header.Set(echo.HeaderContentLength, strconv.FormatInt(f.Size, 10))
+	return c.Stream(http.StatusOK, contentType, r)
+}
+
+func (h *FileHandler) Delete(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	f, err := h.getFile(id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "File not found")
+	}
+
+	ctx := context.Background()
+	variants, err := h.variantPaths(ctx, []int64{id})
+	if err != nil {
+		return err
+	}
+	paths := append(variants[id], f.Path)
+
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM iraven.file_attachments WHERE file_id = $1", id); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to delete file: "+err.Error())
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM iraven.files WHERE id = $1", id); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to delete file: "+err.Error())
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	// The record is gone either way; an object left behind shows up as
+	// orphaned storage rather than failing the delete
+	h.deleteObjects(ctx, paths)
+
+	return c.Redirect(http.StatusFound, "/files")
+}
+
+// saveUpload puts one uploaded file into storage and records it, removing
+// the stored objects again if the record cannot be written. Images are
+// stripped of metadata before anything is stored, and their variants are
+// rendered alongside. The file and its variants are checked against the
+// uploader's quotas in the transaction that records them.
+func (h *FileHandler) saveUpload(c echo.Context, fh *multipart.FileHeader) (*models.File, error) {
+	src, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	head := make([]byte, storage.SniffLen)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	f := models.File{
+		OriginalName: filepath.Base(strings.ReplaceAll(fh.Filename, "\\", "/")),
+		MimeType:     storage.DetectMIME(head, fh.Filename),
+		Size:         fh.Size,
+		Bucket:       h.store.Bucket(),
+		UploadedBy:   middleware.CurrentUserID(c),
+	}
+	if f.Path, err = storage.NewKey(f.OriginalName, time.Now()); err != nil {
+		return nil, err
+	}
+	f.Name = filepath.Base(f.Path)
+	f.URL = h.store.URL(f.Path)
+
+	var body io.Reader = io.MultiReader(bytes.NewReader(head), src)
+	var processed *imaging.Result
+	if imaging.CanStrip(f.MimeType) || imaging.CanDecode(f.MimeType) {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		if processed, err = imaging.Process(data, f.MimeType); err != nil {
+			return nil, err
+		}
+		f.Size = int64(len(processed.Data))
+		body = bytes.NewReader(processed.Data)
+	}
+
+	ctx := c.Request().Context()
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkQuota(ctx, tx, h.quotas, f.UploadedBy, storedBytes(f.Size, processed)); err != nil {
+		return nil, err
+	}
+
+	if err := h.store.Put(ctx, f.Path, body, f.Size, f.MimeType); err != nil {
+		return nil, err
+	}
+	stored := []string{f.Path}
+
+	err = tx.QueryRow(ctx,
+		`INSERT INTO iraven.files (name, original_name, mime_type, size, path, bucket, url, uploaded_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		f.Name, f.OriginalName, f.MimeType, f.Size, f.Path, f.Bucket, f.URL, f.UploadedBy).Scan(&f.ID)
+	if err == nil && processed != nil {
+		var paths []string
+		paths, err = h.storeImage(ctx, tx, f.ID, f.Path, processed)
+		stored = append(stored, paths...)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		h.deleteObjects(context.Background(), stored)
+		return nil, err
+	}
+	return &f, nil
+}
+
+// storedBytes is what an upload of size bytes takes in storage once its
+// variants are added.
+func storedBytes(size int64, processed *imaging.Result) int64 {
+	if processed != nil && processed.Width > 0 {
+		for _, v := range processed.Variants {
+			size += int64(len(v.Data))
+		}
+	}
+	return size
+}
+
+// uploadError reports a file that could not be saved, passing on the
+// status of a refusal such as an exceeded quota.
+func uploadError(fh *multipart.FileHeader, err error) error {
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		return err
+	}
+	return echo.NewHTTPError(http.StatusBadRequest, "Failed to upload "+fh.Filename+": "+err.Error())
+}
+
+func (h *FileHandler) getFile(id int64) (*models.FileWithUploader, error) {
+	var f models.FileWithUploader
+	err := h.db.Pool.QueryRow(context.Background(),
+		`SELECT f.id, f.name, f.original_name, f.mime_type, f.size, f.path, f.bucket, f.url, f.uploaded_by,
+			f.created_at, f.updated_at, COALESCE(u.email, '')
+		FROM iraven.files f
+		LEFT JOIN iraven.users u ON u.id = f.uploaded_by
+		WHERE f.id = $1`, id).
+		Scan(&f.ID, &f.Name, &f.OriginalName, &f.MimeType, &f.Size, &f.Path, &f.Bucket, &f.URL,
+			&f.UploadedBy, &f.CreatedAt, &f.UpdatedAt, &f.UploaderEmail)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
@@ -0,0 +1,378 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/iraven/iraven-admin/pkg/middleware"
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/iraven/iraven-admin/pkg/storage"
+	"github.com/labstack/echo/v4"
+)
+
+// attachmentTables maps the entity types used in file attachments to the
+// tables holding those entities. Attachments of other types cannot be
+// checked and are never purged.
+var attachmentTables = map[string]string{
+	"user":         "users",
+	"users":        "users",
+	"role":         "roles",
+	"roles":        "roles",
+	"application":  "applications",
+	"applications": "applications",
+	"content":      "content",
+	"content_type": "content_types",
+}
+
+// orphanGracePeriod protects recent uploads, which may not be attached to
+// anything, or recorded at all, yet.
+const orphanGracePeriod = 24 * time.Hour
+
+// OrphanReport lists what a cleanup would remove.
+type OrphanReport struct {
+	// UnattachedFiles are recorded files nothing refers to.
+	UnattachedFiles []models.FileWithUploader
+	// DanglingAttachments point at entities that no longer exist.
+	DanglingAttachments []models.FileAttachment
+	// UncheckedTypes counts attachments per entity type that is not known.
+	UncheckedTypes map[string]int
+	// UntrackedObjects are stored objects without a file record.
+	UntrackedObjects []storage.Object
+	ReclaimableBytes int64
+}
+
+func (h *FileHandler) Orphans(c echo.Context) error {
+	report, err := h.orphanReport(c.Request().Context())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to scan for orphaned files: "+err.Error())
+	}
+
+	runs, err := h.cleanupRuns()
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{
+		"Title":       "Orphaned Files",
+		"Report":      report,
+		"Runs":        runs,
+		"GracePeriod": orphanGracePeriod.String(),
+	}
+
+	return c.Render(http.StatusOK, "files/orphans", data)
+}
+
+// PurgeOrphans removes the selected kinds of orphans. It rescans first, so
+// only what is orphaned at the time of the purge is removed, and requires
+// the admin to type "purge" to confirm.
+func (h *FileHandler) PurgeOrphans(c echo.Context) error {
+	if c.FormValue("confirm") != "purge" {
+		return echo.NewHTTPError(http.StatusBadRequest, `Type "purge" to confirm`)
+	}
+	form, err := c.FormParams()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid form")
+	}
+	selected := map[string]bool{}
+	for _, kind := range form["purge"] {
+		selected[kind] = true
+	}
+	if len(selected) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Choose what to purge")
+	}
+
+	ctx := c.Request().Context()
+	run := models.FileCleanupRun{DryRun: false}
+	if userID := middleware.CurrentUserID(c); userID != 0 {
+		run.RunBy = &userID
+	}
+
+	// Attachments go first: files they held on to may become unattached
+	if selected["attachments"] {
+		dangling, _, err := h.danglingAttachments(ctx)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to purge attachments: "+err.Error())
+		}
+		ids := make([]int64, len(dangling))
+		for i, a := range dangling {
+			ids[i] = a.ID
+		}
+		tag, err := h.db.Pool.Exec(ctx, "DELETE FROM iraven.file_attachments WHERE id = ANY($1)", ids)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to purge attachments: "+err.Error())
+		}
+		run.DanglingAttachments = int(tag.RowsAffected())
+	}
+
+	if selected["files"] {
+		files, err := h.unattachedFiles(ctx)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to purge files: "+err.Error())
+		}
+		ids := make([]int64, len(files))
+		for i, f := range files {
+			ids[i] = f.ID
+		}
+
+		variants, err := h.variantPaths(ctx, ids)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to purge files: "+err.Error())
+		}
+
+		// Recheck at delete time in case a file was attached meanwhile
+		rows, err := h.db.Pool.Query(ctx,
+			`DELETE FROM iraven.files f WHERE f.id = ANY($1)
+				AND NOT EXISTS (SELECT 1 FROM iraven.file_attachments a WHERE a.file_id = f.id)
+			RETURNING f.id, f.path, f.size`, ids)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to purge files: "+err.Error())
+		}
+		type deleted struct {
+			id   int64
+			path string
+			size int64
+		}
+		var removed []deleted
+		for rows.Next() {
+			var d deleted
+			if err := rows.Scan(&d.id, &d.path, &d.size); err != nil {
+				continue
+			}
+			removed = append(removed, d)
+		}
+		rows.Close()
+
+		for _, d := range removed {
+			h.deleteObjects(ctx, variants[d.id])
+			if err := h.store.Delete(ctx, d.path); err != nil {
+				log.Printf("files: failed to delete object %s: %v", d.path, err)
+				continue
+			}
+			run.ReclaimableBytes += d.size
+		}
+		run.UnattachedFiles = len(removed)
+	}
+
+	if selected["objects"] {
+		objects, err := h.untrackedObjects(ctx)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to purge storage objects: "+err.Error())
+		}
+		for _, o := range objects {
+			if err := h.store.Delete(ctx, o.Key); err != nil {
+				log.Printf("files: failed to delete object %s: %v", o.Key, err)
+				continue
+			}
+			run.UntrackedObjects++
+			run.ReclaimableBytes += o.Size
+		}
+	}
+
+	if err := h.recordCleanupRun(ctx, run); err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusFound, "/files/orphans")
+}
+
+// ReportOrphans records a dry run of the cleanup. It runs as a background
+// job so the history shows how orphans accumulate.
+func (h *FileHandler) ReportOrphans(ctx context.Context) error {
+	report, err := h.orphanReport(ctx)
+	if err != nil {
+		return err
+	}
+
+	run := models.FileCleanupRun{
+		DryRun:              true,
+		UnattachedFiles:     len(report.UnattachedFiles),
+		DanglingAttachments: len(report.DanglingAttachments),
+		UntrackedObjects:    len(report.UntrackedObjects),
+		ReclaimableBytes:    report.ReclaimableBytes,
+	}
+	log.Printf("files: %d unattached files, %d dangling attachments, %d untracked objects; %d bytes reclaimable",
+		run.UnattachedFiles, run.DanglingAttachments, run.UntrackedObjects, run.ReclaimableBytes)
+
+	return h.recordCleanupRun(ctx, run)
+}
+
+func (h *FileHandler) orphanReport(ctx context.Context) (*OrphanReport, error) {
+	var report OrphanReport
+	var err error
+
+	if report.UnattachedFiles, err = h.unattachedFiles(ctx); err != nil {
+		return nil, err
+	}
+	if report.DanglingAttachments, report.UncheckedTypes, err = h.danglingAttachments(ctx); err != nil {
+		return nil, err
+	}
+	if report.UntrackedObjects, err = h.untrackedObjects(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, f := range report.UnattachedFiles {
+		report.ReclaimableBytes += f.Size
+	}
+	for _, o := range report.UntrackedObjects {
+		report.ReclaimableBytes += o.Size
+	}
+	return &report, nil
+}
+
+// unattachedFiles finds files older than the grace period that are neither
+// attached to anything nor used as a user's picture or profile photo.
+func (h *FileHandler) unattachedFiles(ctx context.Context) ([]models.FileWithUploader, error) {
+	rows, err := h.db.Pool.Query(ctx,
+		`SELECT f.id, f.name, f.original_name, f.mime_type, f.size, f.path, f.bucket, f.url, f.uploaded_by,
+			f.created_at, f.updated_at, COALESCE(u.email, '')
+		FROM iraven.files f
+		LEFT JOIN iraven.users u ON u.id = f.uploaded_by
+		WHERE f.created_at < $1
+			AND NOT EXISTS (SELECT 1 FROM iraven.file_attachments a WHERE a.file_id = f.id)
+			AND NOT EXISTS (SELECT 1 FROM iraven.users p WHERE p.picture = f.url)
+			AND NOT EXISTS (SELECT 1 FROM iraven.profiles p WHERE p.photo = f.url)
+		ORDER BY f.size DESC`, time.Now().Add(-orphanGracePeriod))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []models.FileWithUploader
+	for rows.Next() {
+		var f models.FileWithUploader
+		if err := rows.Scan(&f.ID, &f.Name, &f.OriginalName, &f.MimeType, &f.Size, &f.Path, &f.Bucket, &f.URL,
+			&f.UploadedBy, &f.CreatedAt, &f.UpdatedAt, &f.UploaderEmail); err != nil {
+			continue
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// danglingAttachments finds attachments whose entity no longer exists, and
+// counts attachments of entity types it cannot check.
+func (h *FileHandler) danglingAttachments(ctx context.Context) ([]models.FileAttachment, map[string]int, error) {
+	typesByTable := map[string][]string{}
+	var known []string
+	for entityType, table := range attachmentTables {
+		typesByTable[table] = append(typesByTable[table], entityType)
+		known = append(known, entityType)
+	}
+	tables := make([]string, 0, len(typesByTable))
+	for table := range typesByTable {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	var dangling []models.FileAttachment
+	for _, table := range tables {
+		// Table names come from attachmentTables, never from input
+		rows, err := h.db.Pool.Query(ctx,
+			`SELECT a.id, a.file_id, a.entity_type, a.entity_id, a.created_at
+			FROM iraven.file_attachments a
+			WHERE a.entity_type = ANY($1)
+				AND NOT EXISTS (SELECT 1 FROM iraven.`+table+` e WHERE e.id = a.entity_id)
+			ORDER BY a.created_at`, typesByTable[table])
+		if err != nil {
+			return nil, nil, err
+		}
+		for rows.Next() {
+			var a models.FileAttachment
+			if err := rows.Scan(&a.ID, &a.FileID, &a.EntityType, &a.EntityID, &a.CreatedAt); err != nil {
+				continue
+			}
+			dangling = append(dangling, a)
+		}
+		rows.Close()
+	}
+
+	rows, err := h.db.Pool.Query(ctx,
+		`SELECT entity_type, COUNT(*) FROM iraven.file_attachments
+		WHERE NOT (entity_type = ANY($1)) GROUP BY entity_type`, known)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	unchecked := map[string]int{}
+	for rows.Next() {
+		var entityType string
+		var count int
+		if err := rows.Scan(&entityType, &count); err != nil {
+			continue
+		}
+		unchecked[entityType] = count
+	}
+	return dangling, unchecked, nil
+}
+
+// untrackedObjects finds objects in the bucket, older than the grace
+// period, that no file or image variant record points at.
+func (h *FileHandler) untrackedObjects(ctx context.Context) ([]storage.Object, error) {
+	rows, err := h.db.Pool.Query(ctx,
+		`SELECT path FROM iraven.files WHERE bucket = $1
+		UNION ALL
+		SELECT v.path FROM iraven.file_variants v
+		INNER JOIN iraven.files f ON f.id = v.file_id
+		WHERE f.bucket = $1`, h.store.Bucket())
+	if err != nil {
+		return nil, err
+	}
+	tracked := map[string]bool{}
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			continue
+		}
+		tracked[path] = true
+	}
+	rows.Close()
+
+	objects, err := h.store.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-orphanGracePeriod)
+	var untracked []storage.Object
+	for _, o := range objects {
+		if !tracked[o.Key] && o.ModifiedAt.Before(cutoff) {
+			untracked = append(untracked, o)
+		}
+	}
+	return untracked, nil
+}
+
+func (h *FileHandler) recordCleanupRun(ctx context.Context, run models.FileCleanupRun) error {
+	_, err := h.db.Pool.Exec(ctx,
+		`INSERT INTO iraven.file_cleanup_runs
+			(dry_run, unattached_files, dangling_attachments, untracked_objects, reclaimable_bytes, run_by)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		run.DryRun, run.UnattachedFiles, run.DanglingAttachments, run.UntrackedObjects, run.ReclaimableBytes, run.RunBy)
+	return err
+}
+
+func (h *FileHandler) cleanupRuns() ([]models.FileCleanupRun, error) {
+	rows, err := h.db.Pool.Query(context.Background(),
+		`SELECT id, dry_run, unattached_files, dangling_attachments, untracked_objects, reclaimable_bytes,
+			run_by, created_at
+		FROM iraven.file_cleanup_runs ORDER BY created_at DESC LIMIT 20`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []models.FileCleanupRun
+	for rows.Next() {
+		var r models.FileCleanupRun
+		if err := rows.Scan(&r.ID, &r.DryRun, &r.UnattachedFiles, &r.DanglingAttachments, &r.UntrackedObjects,
+			&r.ReclaimableBytes, &r.RunBy, &r.CreatedAt); err != nil {
+			continue
+		}
+		runs = append(runs, r)
+	}
+	return runs, nil
+}
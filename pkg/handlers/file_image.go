@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/iraven/iraven-admin/pkg/database"
+	"github.com/iraven/iraven-admin/pkg/imaging"
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/iraven/iraven-admin/pkg/storage"
+	"github.com/labstack/echo/v4"
+)
+
+// Thumbnail serves a rendered variant of an image, the "thumb" variant
+// unless another is named. Images without variants are served as they are.
+func (h *FileHandler) Thumbnail(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	name := c.QueryParam("variant")
+	if name == "" {
+		name = "thumb"
+	}
+
+	var v models.FileVariant
+	err := h.db.Pool.QueryRow(context.Background(),
+		"SELECT path, mime_type, size FROM iraven.file_variants WHERE file_id = $1 AND name = $2", id, name).
+		Scan(&v.Path, &v.MimeType, &v.Size)
+	if err != nil {
+		return h.Preview(c)
+	}
+
+	r, err := h.store.Get(c.Request().Context(), v.Path)
+	if err != nil {
+		return h.Preview(c)
+	}
+	defer r.Close()
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderXContentTypeOptions, "nosniff")
+	header.Set(echo.HeaderContentLength, strconv.FormatInt(v.Size, 10))
+	header.Set(echo.HeaderCacheControl, "private, max-age=86400")
+	return c.Stream(http.StatusOK, v.MimeType, r)
+}
+
+// Reprocess runs a stored image through the pipeline again: for uploads
+// made before images were processed, or after the variant sizes change.
+func (h *FileHandler) Reprocess(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	f, err := h.getFile(id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "File not found")
+	}
+	if !imaging.CanStrip(f.MimeType) && !imaging.CanDecode(f.MimeType) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Only images can be processed")
+	}
+
+	ctx := c.Request().Context()
+	r, err := h.store.Get(ctx, f.Path)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to read file: "+err.Error())
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to read file: "+err.Error())
+	}
+
+	processed, err := imaging.Process(data, f.MimeType)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to process image: "+err.Error())
+	}
+	if !bytes.Equal(processed.Data, data) {
+		if err := h.store.Put(ctx, f.Path, bytes.NewReader(processed.Data), int64(len(processed.Data)), f.MimeType); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to store file: "+err.Error())
+		}
+	}
+
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, "UPDATE iraven.files SET size = $1, updated_at = NOW() WHERE id = $2",
+		len(processed.Data), id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to update file: "+err.Error())
+	}
+	if _, err := h.storeImage(ctx, tx, id, f.Path, processed); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to store variants: "+err.Error())
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusFound, "/files/"+strconv.FormatInt(id, 10))
+}
+
+// storeImage puts the variants of a processed image into storage and
+// records them with the image's dimensions, replacing earlier ones. It
+// returns the keys of the stored variants.
+func (h *FileHandler) storeImage(ctx context.Context, q querier, fileID int64, key string, processed *imaging.Result) ([]string, error) {
+	if processed.Width == 0 {
+		return nil, nil
+	}
+
+	_, err := q.Exec(ctx,
+		`INSERT INTO iraven.file_images (file_id, width, height) VALUES ($1, $2, $3)
+		ON CONFLICT (file_id) DO UPDATE SET
+			width = EXCLUDED.width, height = EXCLUDED.height, processed_at = NOW()`,
+		fileID, processed.Width, processed.Height)
+	if err != nil {
+		return nil, err
+	}
+
+	var stored, names []string
+	for _, v := range processed.Variants {
+		variantKey := variantKey(key, v.Name, v.MimeType)
+		if err := h.store.Put(ctx, variantKey, bytes.NewReader(v.Data), int64(len(v.Data)), v.MimeType); err != nil {
+			return stored, err
+		}
+		stored = append(stored, variantKey)
+		names = append(names, v.Name)
+
+		_, err := q.Exec(ctx,
+			`INSERT INTO iraven.file_variants (file_id, name, width, height, mime_type, size, path, url)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT (file_id, name) DO UPDATE SET
+				width = EXCLUDED.width, height = EXCLUDED.height, mime_type = EXCLUDED.mime_type,
+				size = EXCLUDED.size, path = EXCLUDED.path, url = EXCLUDED.url, created_at = NOW()`,
+			fileID, v.Name, v.Width, v.Height, v.MimeType, len(v.Data), variantKey, h.store.URL(variantKey))
+		if err != nil {
+			return stored, err
+		}
+	}
+
+	// Variants no longer rendered, e.g. after the sizes changed
+	rows, err := q.Query(ctx,
+		"DELETE FROM iraven.file_variants WHERE file_id = $1 AND NOT (name = ANY($2)) RETURNING path",
+		fileID, names)
+	if err != nil {
+		return stored, err
+	}
+	var stale []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			continue
+		}
+		stale = append(stale, p)
+	}
+	rows.Close()
+	h.deleteObjects(ctx, stale)
+
+	return stored, nil
+}
+
+// variantKey places a variant next to its original:
+// uploads/2024/05/abc.jpg becomes uploads/2024/05/abc_thumb.jpg.
+func variantKey(key, name, mimeType string) string {
+	ext := ".png"
+	if mimeType == "image/jpeg" {
+		ext = ".jpg"
+	}
+	return strings.TrimSuffix(key, path.Ext(key)) + "_" + name + ext
+}
+
+// variantPaths returns the storage keys of the variants of the given files.
+func (h *FileHandler) variantPaths(ctx context.Context, fileIDs []int64) (map[int64][]string, error) {
+	rows, err := h.db.Pool.Query(ctx,
+		"SELECT file_id, path FROM iraven.file_variants WHERE file_id = ANY($1)", fileIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := map[int64][]string{}
+	for rows.Next() {
+		var id int64
+		var p string
+		if err := rows.Scan(&id, &p); err != nil {
+			continue
+		}
+		paths[id] = append(paths[id], p)
+	}
+	return paths, nil
+}
+
+// deleteObjects removes objects from storage, logging failures: the
+// orphan report picks up anything left behind.
+func (h *FileHandler) deleteObjects(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := h.store.Delete(ctx, key); err != nil {
+			log.Printf("files: failed to delete object %s: %v", key, err)
+		}
+	}
+}
+
+func (h *FileHandler) getImage(fileID int64) (*models.FileImage, []models.FileVariant, error) {
+	var img models.FileImage
+	err := h.db.Pool.QueryRow(context.Background(),
+		"SELECT file_id, width, height, processed_at FROM iraven.file_images WHERE file_id = $1", fileID).
+		Scan(&img.FileID, &img.Width, &img.Height, &img.ProcessedAt)
+	if err != nil {
+		return nil, nil, nil
+	}
+
+	rows, err := h.db.Pool.Query(context.Background(),
+		`SELECT id, file_id, name, width, height, mime_type, size, path, url, created_at
+		FROM iraven.file_variants WHERE file_id = $1 ORDER BY width`, fileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var variants []models.FileVariant
+	for rows.Next() {
+		var v models.FileVariant
+		if err := rows.Scan(&v.ID, &v.FileID, &v.Name, &v.Width, &v.Height, &v.MimeType, &v.Size, &v.Path,
+			&v.URL, &v.CreatedAt); err != nil {
+			continue
+		}
+		variants = append(variants, v)
+	}
+	return &img, variants, nil
+}
+
+// thumbnailable reports whether a file can be shown as a thumbnail.
+func thumbnailable(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "image/") && storage.Previewable(mimeType)
+}
+
+// attachedFiles lists the files attached to an entity.
+func attachedFiles(db *database.Database, entityType string, entityID int64) ([]models.FileWithUploader, error) {
+	return queryFiles(db,
+		`WHERE f.id IN (SELECT a.file_id FROM iraven.file_attachments a WHERE a.entity_type = $1 AND a.entity_id = $2)
+		ORDER BY f.created_at DESC`, entityType, entityID)
+}
+
+// filesByURL finds the recorded files among a list of URLs, e.g. the
+// string values of content data or a profile picture.
+func filesByURL(db *database.Database, urls []string) ([]models.FileWithUploader, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	return queryFiles(db, "WHERE f.url = ANY($1) ORDER BY f.created_at DESC", urls)
+}
+
+func queryFiles(db *database.Database, where string, args ...interface{}) ([]models.FileWithUploader, error) {
+	rows, err := db.Pool.Query(context.Background(),
+		`SELECT f.id, f.name, f.original_name, f.mime_type, f.size, f.path, f.bucket, f.url, f.uploaded_by,
+			f.created_at, f.updated_at, COALESCE(u.email, '')
+		FROM iraven.files f
+		LEFT JOIN iraven.users u ON u.id = f.uploaded_by `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []models.FileWithUploader
+	for rows.Next() {
+		var f models.FileWithUploader
+		if err := rows.Scan(&f.ID, &f.Name, &f.OriginalName, &f.MimeType, &f.Size, &f.Path, &f.Bucket, &f.URL,
+			&f.UploadedBy, &f.CreatedAt, &f.UpdatedAt, &f.UploaderEmail); err != nil {
+			continue
+		}
+		f.Thumbnail = thumbnailable(f.MimeType)
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// jsonStrings collects every string value in a JSON document.
+func jsonStrings(data string) []string {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		return nil
+	}
+	var values []string
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case string:
+			values = append(values, v)
+		case []interface{}:
+			for _, item := range v {
+				walk(item)
+			}
+		case map[string]interface{}:
+			for _, item := range v {
+				walk(item)
+			}
+		}
+	}
+	walk(doc)
+	return values
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/iraven/iraven-admin/pkg/middleware"
+	"github.com/labstack/echo/v4"
+)
+
+// QuotaDefaults are the storage quotas, in bytes, of users and
+// applications without one of their own. Zero means unlimited.
+type QuotaDefaults struct {
+	User        int64
+	Application int64
+}
+
+// StorageUsage compares what a user, or an application's users together,
+// have uploaded with their quota.
+type StorageUsage struct {
+	SubjectType string
+	SubjectID   int64
+	Name        string
+	Files       int64
+	Used        int64
+	// Quota is zero when unlimited.
+	Quota int64
+	// Custom is set when the quota was granted specifically rather than
+	// being the default.
+	Custom bool
+	Reason *string
+}
+
+func (u StorageUsage) Percent() int {
+	if u.Quota == 0 {
+		return 0
+	}
+	return int(math.Min(100, math.Round(float64(u.Used)*100/float64(u.Quota))))
+}
+
+func (u StorageUsage) Exceeded() bool {
+	return u.Quota > 0 && u.Used >= u.Quota
+}
+
+// QuotaMB is the quota in megabytes, for editing.
+func (u StorageUsage) QuotaMB() int64 {
+	return u.Quota / (1 << 20)
+}
+
+// applicationUsers selects the users holding a role scoped to application $1.
+const applicationUsers = `SELECT ur.user_id FROM iraven.user_roles ur
+	INNER JOIN iraven.application_roles ar ON ar.role_id = ur.role_id
+	WHERE ar.application_id = $1`
+
+// storedSize is what file f takes in storage: the file and its variants.
+const storedSize = `(f.size + COALESCE((SELECT SUM(v.size) FROM iraven.file_variants v WHERE v.file_id = f.id), 0))`
+
+func userStorageUsage(ctx context.Context, q querier, defaults QuotaDefaults, userID int64) (StorageUsage, error) {
+	usage := StorageUsage{SubjectType: "user", SubjectID: userID, Quota: defaults.User}
+	err := q.QueryRow(ctx,
+		`SELECT u.email, COUNT(f.id), COALESCE(SUM(`+storedSize+`), 0)
+		FROM iraven.users u
+		LEFT JOIN iraven.files f ON f.uploaded_by = u.id
+		WHERE u.id = $1 GROUP BY u.email`, userID).Scan(&usage.Name, &usage.Files, &usage.Used)
+	if err != nil {
+		return usage, err
+	}
+	return usage, applyQuotaOverride(ctx, q, &usage)
+}
+
+func applicationStorageUsage(ctx context.Context, q querier, defaults QuotaDefaults, appID int64) (StorageUsage, error) {
+	usage := StorageUsage{SubjectType: "application", SubjectID: appID, Quota: defaults.Application}
+	err := q.QueryRow(ctx,
+		`SELECT a.name,
+			(SELECT COUNT(*) FROM iraven.files f WHERE f.uploaded_by IN (`+applicationUsers+`)),
+			(SELECT COALESCE(SUM(`+storedSize+`), 0) FROM iraven.files f WHERE f.uploaded_by IN (`+applicationUsers+`))
+		FROM iraven.applications a WHERE a.id = $1`, appID).Scan(&usage.Name, &usage.Files, &usage.Used)
+	if err != nil {
+		return usage, err
+	}
+	return usage, applyQuotaOverride(ctx, q, &usage)
+}
+
+func applyQuotaOverride(ctx context.Context, q querier, usage *StorageUsage) error {
+	var quota int64
+	var reason *string
+	err := q.QueryRow(ctx,
+		"SELECT quota_bytes, reason FROM iraven.storage_quotas WHERE subject_type = $1 AND subject_id = $2",
+		usage.SubjectType, usage.SubjectID).Scan(&quota, &reason)
+	if err != nil {
+		// No override: the default applies
+		return nil
+	}
+	usage.Quota, usage.Custom, usage.Reason = quota, true, reason
+	return nil
+}
+
+// lockQuota takes a transaction-level advisory lock on a user's or
+// application's quota.
+func lockQuota(ctx context.Context, q querier, subjectType string, subjectID int64) error {
+	_, err := q.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtextextended($1, 0))",
+		fmt.Sprintf("iraven.storage_quotas:%s:%d", subjectType, subjectID))
+	return err
+}
+
+// checkQuota refuses an upload that would take a user, or any application
+// the user belongs to, over quota; size counts the file and its variants.
+// It locks the quotas it checks, user first and applications in order, so
+// that run in the transaction recording the upload it makes concurrent
+// uploads against the same quota wait for it rather than both fitting.
+func checkQuota(ctx context.Context, q querier, defaults QuotaDefaults, userID, size int64) error {
+	if err := lockQuota(ctx, q, "user", userID); err != nil {
+		return err
+	}
+	usages := []StorageUsage{}
+	usage, err := userStorageUsage(ctx, q, defaults, userID)
+	if err != nil {
+		return err
+	}
+	usages = append(usages, usage)
+
+	rows, err := q.Query(ctx,
+		`SELECT DISTINCT ar.application_id FROM iraven.user_roles ur
+		INNER JOIN iraven.application_roles ar ON ar.role_id = ur.role_id
+		WHERE ur.user_id = $1 ORDER BY ar.application_id`, userID)
+	if err != nil {
+		return err
+	}
+	var appIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			continue
+		}
+		appIDs = append(appIDs, id)
+	}
+	rows.Close()
+
+	for _, appID := range appIDs {
+		if err := lockQuota(ctx, q, "application", appID); err != nil {
+			return err
+		}
+		usage, err := applicationStorageUsage(ctx, q, defaults, appID)
+		if err != nil {
+			return err
+		}
+		usages = append(usages, usage)
+	}
+
+	for _, u := range usages {
+		if u.Quota > 0 && u.Used+size > u.Quota {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("Storage quota exceeded: %s %s has used %d of %d bytes and the upload needs %d more",
+					u.SubjectType, u.Name, u.Used, u.Quota, size))
+		}
+	}
+	return nil
+}
+
+// Quotas reports the users and applications using the most storage.
+func (h *FileHandler) Quotas(c echo.Context) error {
+	users, err := h.topConsumers(`
+		SELECT u.id, u.email, COUNT(f.id), COALESCE(SUM(`+storedSize+`), 0), q.quota_bytes, q.reason
+		FROM iraven.files f
+		INNER JOIN iraven.users u ON u.id = f.uploaded_by
+		LEFT JOIN iraven.storage_quotas q ON q.subject_type = 'user' AND q.subject_id = u.id
+		GROUP BY u.id, u.email, q.quota_bytes, q.reason
+		ORDER BY 4 DESC LIMIT 20`, "user", h.quotas.User)
+	if err != nil {
+		return err
+	}
+
+	applications, err := h.topConsumers(`
+		WITH application_files AS (
+			SELECT DISTINCT ar.application_id, f.id, `+storedSize+` AS size
+			FROM iraven.files f
+			INNER JOIN iraven.user_roles ur ON ur.user_id = f.uploaded_by
+			INNER JOIN iraven.application_roles ar ON ar.role_id = ur.role_id
+		)
+		SELECT a.id, a.name, COUNT(af.id), COALESCE(SUM(af.size), 0), q.quota_bytes, q.reason
+		FROM application_files af
+		INNER JOIN iraven.applications a ON a.id = af.application_id
+		LEFT JOIN iraven.storage_quotas q ON q.subject_type = 'application' AND q.subject_id = a.id
+		GROUP BY a.id, a.name, q.quota_bytes, q.reason
+		ORDER BY 4 DESC LIMIT 20`, "application", h.quotas.Application)
+	if err != nil {
+		return err
+	}
+
+	var totalFiles, totalBytes int64
+	h.db.Pool.QueryRow(context.Background(),
+		"SELECT COUNT(*), COALESCE(SUM("+storedSize+"), 0) FROM iraven.files f").Scan(&totalFiles, &totalBytes)
+
+	data := map[string]interface{}{
+		"Title":        "Storage Usage",
+		"Users":        users,
+		"Applications": applications,
+		"Defaults":     h.quotas,
+		"TotalFiles":   totalFiles,
+		"TotalBytes":   totalBytes,
+	}
+
+	return c.Render(http.StatusOK, "files/quotas", data)
+}
+
+func (h *FileHandler) topConsumers(query, subjectType string, defaultQuota int64) ([]StorageUsage, error) {
+	rows, err := h.db.Pool.Query(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usages []StorageUsage
+	for rows.Next() {
+		u := StorageUsage{SubjectType: subjectType, Quota: defaultQuota}
+		var quota *int64
+		if err := rows.Scan(&u.SubjectID, &u.Name, &u.Files, &u.Used, &quota, &u.Reason); err != nil {
+			continue
+		}
+		if quota != nil {
+			u.Quota, u.Custom = *quota, true
+		}
+		usages = append(usages, u)
+	}
+	return usages, nil
+}
+
+// SetQuota grants a user or application its own quota, in megabytes. An
+// empty quota returns it to the default.
+func (h *FileHandler) SetQuota(c echo.Context) error {
+	subjectType := c.FormValue("subject_type")
+	subjectID, _ := strconv.ParseInt(c.FormValue("subject_id"), 10, 64)
+	if (subjectType != "user" && subjectType != "application") || subjectID == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid quota subject")
+	}
+
+	value := strings.TrimSpace(c.FormValue("quota_mb"))
+	if value == "" {
+		_, err := h.db.Pool.Exec(context.Background(),
+			"DELETE FROM iraven.storage_quotas WHERE subject_type = $1 AND subject_id = $2", subjectType, subjectID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to reset quota: "+err.Error())
+		}
+		if subjectType == "user" {
+			recordUserEvent(h.db.Pool, subjectID, "quota_reset", "", currentUserIDPtr(c))
+		}
+		return h.redirectBack(c, "/files/quotas")
+	}
+
+	megabytes, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || megabytes < 0 || megabytes > math.MaxInt64>>20 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Quota must be a whole number of megabytes")
+	}
+
+	var reason *string
+	if r := strings.TrimSpace(c.FormValue("reason")); r != "" {
+		reason = &r
+	}
+	var updatedBy *int64
+	if userID := middleware.CurrentUserID(c); userID != 0 {
+		updatedBy = &userID
+	}
+
+	_, err = h.db.Pool.Exec(context.Background(),
+		`INSERT INTO iraven.storage_quotas (subject_type, subject_id, quota_bytes, reason, updated_by)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (subject_type, subject_id) DO UPDATE SET
+			quota_bytes = EXCLUDED.quota_bytes, reason = EXCLUDED.reason,
+			updated_by = EXCLUDED.updated_by, updated_at = NOW()`,
+		subjectType, subjectID, megabytes<<20, reason, updatedBy)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to save quota: "+err.Error())
+	}
+	if subjectType == "user" {
+		details := fmt.Sprintf("%d MB", megabytes)
+		if reason != nil {
+			details += ": " + *reason
+		}
+		recordUserEvent(h.db.Pool, subjectID, "quota_set", details, updatedBy)
+	}
+
+	return h.redirectBack(c, "/files/quotas")
+}
+
+// redirectBack returns to the local path in return_to, or to fallback.
+func (h *FileHandler) redirectBack(c echo.Context, fallback string) error {
+	if returnTo := c.FormValue("return_to"); localPath(returnTo) {
+		return c.Redirect(http.StatusFound, returnTo)
+	}
+	return c.Redirect(http.StatusFound, fallback)
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestCheckQuota(t *testing.T) {
+	tx := testTx(t, "009_file_images.sql", "010_storage_quotas.sql")
+	ctx := context.Background()
+
+	exec(t, tx, "INSERT INTO iraven.users (id, email) VALUES (1, 'a@example.com'), (2, 'b@example.com')")
+	exec(t, tx, "INSERT INTO iraven.applications (id, name) VALUES (1, 'Shop')")
+	exec(t, tx, "INSERT INTO iraven.roles (id, name) VALUES (1, 'shop-staff')")
+	exec(t, tx, "INSERT INTO iraven.application_roles (application_id, role_id) VALUES (1, 1)")
+	exec(t, tx, "INSERT INTO iraven.user_roles (user_id, role_id) VALUES (1, 1), (2, 1)")
+	// User 1 stores 600 bytes: a 400 byte image and its 200 bytes of
+	// variants. User 2 stores 300.
+	exec(t, tx, "INSERT INTO iraven.files (id, size, uploaded_by) VALUES (1, 400, 1), (2, 300, 2)")
+	exec(t, tx, `INSERT INTO iraven.file_variants (file_id, name, width, height, mime_type, size, path, url) VALUES
+		(1, 'thumb', 160, 160, 'image/jpeg', 50, 'a.thumb.jpg', ''),
+		(1, 'small', 480, 320, 'image/jpeg', 150, 'a.small.jpg', '')`)
+
+	usage, err := userStorageUsage(ctx, tx, QuotaDefaults{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Files != 1 || usage.Used != 600 {
+		t.Errorf("user usage = %d files, %d bytes; want 1 file, 600 bytes", usage.Files, usage.Used)
+	}
+	usage, err = applicationStorageUsage(ctx, tx, QuotaDefaults{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Files != 2 || usage.Used != 900 {
+		t.Errorf("application usage = %d files, %d bytes; want 2 files, 900 bytes", usage.Files, usage.Used)
+	}
+
+	exec(t, tx, `INSERT INTO iraven.storage_quotas (subject_type, subject_id, quota_bytes) VALUES ('user', 1, 1000)`)
+	tests := []struct {
+		name     string
+		defaults QuotaDefaults
+		userID   int64
+		size     int64
+		refused  bool
+	}{
+		{"unlimited", QuotaDefaults{}, 2, 1 << 30, false},
+		{"fits the user's own quota", QuotaDefaults{User: 100}, 1, 400, false},
+		{"over the user's own quota", QuotaDefaults{User: 100}, 1, 401, true},
+		{"over the default quota", QuotaDefaults{User: 500}, 2, 201, true},
+		{"fits the application quota", QuotaDefaults{Application: 1000}, 2, 100, false},
+		{"over the application quota", QuotaDefaults{Application: 1000}, 2, 101, true},
+	}
+	for _, tt := range tests {
+		err := checkQuota(ctx, tx, tt.defaults, tt.userID, tt.size)
+		var httpErr *echo.HTTPError
+		refused := errors.As(err, &httpErr) && httpErr.Code == http.StatusRequestEntityTooLarge
+		if refused != tt.refused || (err != nil && !refused) {
+			t.Errorf("%s: got %v, want refused = %v", tt.name, err, tt.refused)
+		}
+	}
+}
+
+func TestRedirectBack(t *testing.T) {
+	tests := map[string]string{
+		"/users/3":          "/users/3",
+		"":                  "/files/quotas",
+		"//evil.example":    "/files/quotas",
+		`/\evil.example`:    "/files/quotas",
+		"https://evil.test": "/files/quotas",
+	}
+	for returnTo, want := range tests {
+		form := url.Values{"return_to": {returnTo}}
+		req := httptest.NewRequest(http.MethodPost, "/files/quotas", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+
+		if err := (&FileHandler{}).redirectBack(c, "/files/quotas"); err != nil {
+			t.Fatal(err)
+		}
+		if got := rec.Header().Get(echo.HeaderLocation); got != want {
+			t.Errorf("return_to %q: redirected to %q, want %q", returnTo, got, want)
+		}
+	}
+}
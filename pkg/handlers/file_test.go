@@ -0,0 +1,26 @@
+package handlers
+
+import "testing"
+
+func TestLocalPath(t *testing.T) {
+	tests := map[string]bool{
+		"/files":                    true,
+		"/content/12?tab=files#top": true,
+		"/users/3/files":            true,
+		"/%5Cevil.example":          true,
+		"":                          false,
+		"files":                     false,
+		"//evil.example":            false,
+		`/\evil.example`:            false,
+		`/\/evil.example`:           false,
+		"/\t/evil.example":          false,
+		"/\n/evil.example":          false,
+		"https://evil.example/":     false,
+		"javascript:alert(1)":       false,
+	}
+	for raw, want := range tests {
+		if got := localPath(raw); got != want {
+			t.Errorf("localPath(%q) = %v, want %v", raw, got, want)
+		}
+	}
+}
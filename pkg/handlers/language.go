@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/iraven/iraven-admin/pkg/database"
+	"github.com/iraven/iraven-admin/pkg/i18n"
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/labstack/echo/v4"
+)
+
+// languageCode matches canonical ISO 639 codes with optional subtags, such
+// as "en", "fil" or "pt-BR".
+var languageCode = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+type LanguageHandler struct {
+	db *database.Database
+}
+
+func NewLanguageHandler(db *database.Database) *LanguageHandler {
+	return &LanguageHandler{db: db}
+}
+
+// LanguageWithUsage is a language with how many countries, profiles and
+// content variants refer to it.
+type LanguageWithUsage struct {
+	models.Language
+	Countries int
+	Profiles  int
+	Variants  int
+}
+
+func (h *LanguageHandler) List(c echo.Context) error {
+	rows, err := h.db.Pool.Query(context.Background(),
+		`SELECT l.id, l.name, l.code, l.created_at, l.updated_at,
+			(SELECT COUNT(*) FROM iraven.country_languages cl WHERE cl.language_id = l.id),
+			(SELECT COUNT(*) FROM iraven.profiles p WHERE p.language_id = l.id),
+			(SELECT COUNT(*) FROM iraven.content_variants v WHERE v.language_id = l.id)
+		FROM iraven.languages l ORDER BY l.name`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var languages []LanguageWithUsage
+	for rows.Next() {
+		var l LanguageWithUsage
+		if err := rows.Scan(&l.ID, &l.Name, &l.Code, &l.CreatedAt, &l.UpdatedAt,
+			&l.Countries, &l.Profiles, &l.Variants); err != nil {
+			continue
+		}
+		languages = append(languages, l)
+	}
+
+	data := map[string]interface{}{
+		"Title":     "Languages",
+		"Languages": languages,
+		"Seeded":    seedResultFromQuery(c),
+	}
+
+	return c.Render(http.StatusOK, "languages/list", data)
+}
+
+func (h *LanguageHandler) New(c echo.Context) error {
+	data := map[string]interface{}{
+		"Title": "New Language",
+	}
+	return c.Render(http.StatusOK, "languages/new", data)
+}
+
+func (h *LanguageHandler) Create(c echo.Context) error {
+	name, code, err := h.languageForm(c, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.Pool.Exec(context.Background(),
+		"INSERT INTO iraven.languages (name, code) VALUES ($1, $2)", name, code)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to create language: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, "/languages")
+}
+
+func (h *LanguageHandler) Edit(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	var l models.Language
+	err := h.db.Pool.QueryRow(context.Background(),
+		"SELECT id, name, code, created_at, updated_at FROM iraven.languages WHERE id = $1", id).
+		Scan(&l.ID, &l.Name, &l.Code, &l.CreatedAt, &l.UpdatedAt)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Language not found")
+	}
+
+	// Countries speaking this language
+	rows, err := h.db.Pool.Query(context.Background(),
+		`SELECT c.id, c.name, c.code, c.default_language_id FROM iraven.countries c
+		INNER JOIN iraven.country_languages cl ON cl.country_id = c.id
+		WHERE cl.language_id = $1 ORDER BY c.name`, id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var countries []CountryWithLanguages
+	for rows.Next() {
+		var country CountryWithLanguages
+		if err := rows.Scan(&country.ID, &country.Name, &country.Code, &country.DefaultLanguageID); err != nil {
+			continue
+		}
+		countries = append(countries, country)
+	}
+
+	data := map[string]interface{}{
+		"Title":     "Edit Language",
+		"Language":  l,
+		"Countries": countries,
+	}
+
+	return c.Render(http.StatusOK, "languages/edit", data)
+}
+
+func (h *LanguageHandler) Update(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	name, code, err := h.languageForm(c, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.Pool.Exec(context.Background(),
+		"UPDATE iraven.languages SET name = $1, code = $2, updated_at = NOW() WHERE id = $3",
+		name, code, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to update language: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, "/languages")
+}
+
+func (h *LanguageHandler) Delete(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	// Check if language is in use
+	var profiles, variants, defaults int
+	h.db.Pool.QueryRow(context.Background(),
+		`SELECT (SELECT COUNT(*) FROM iraven.profiles WHERE language_id = $1),
+			(SELECT COUNT(*) FROM iraven.content_variants WHERE language_id = $1),
+			(SELECT COUNT(*) FROM iraven.countries WHERE default_language_id = $1)`, id).
+		Scan(&profiles, &variants, &defaults)
+
+	if profiles > 0 || variants > 0 || defaults > 0 {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("Cannot delete language: used by %d profiles, %d content variants and as the default of %d countries",
+				profiles, variants, defaults))
+	}
+
+	// Detach from countries first
+	h.db.Pool.Exec(context.Background(), "DELETE FROM iraven.country_languages WHERE language_id = $1", id)
+
+	_, err := h.db.Pool.Exec(context.Background(), "DELETE FROM iraven.languages WHERE id = $1", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to delete language: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, "/languages")
+}
+
+// languageForm reads and validates the name and code of a language. Codes
+// are stored in canonical form and must be unique, ignoring case.
+func (h *LanguageHandler) languageForm(c echo.Context, id int64) (string, string, error) {
+	name := strings.TrimSpace(c.FormValue("name"))
+	code := i18n.CanonicalCode(c.FormValue("code"))
+
+	if name == "" || code == "" {
+		return "", "", echo.NewHTTPError(http.StatusBadRequest, "Name and code are required")
+	}
+	if !languageCode.MatchString(code) {
+		return "", "", echo.NewHTTPError(http.StatusBadRequest,
+			"Code must be an ISO 639 language code such as \"en\" or \"pt-BR\"")
+	}
+
+	var taken bool
+	h.db.Pool.QueryRow(context.Background(),
+		"SELECT EXISTS (SELECT 1 FROM iraven.languages WHERE lower(code) = lower($1) AND id <> $2)", code, id).
+		Scan(&taken)
+	if taken {
+		return "", "", echo.NewHTTPError(http.StatusBadRequest, "Another language already has code "+code)
+	}
+
+	return name, code, nil
+}
+
+func listLanguages(db *database.Database) ([]models.Language, error) {
+	rows, err := db.Pool.Query(context.Background(), "SELECT id, name, code FROM iraven.languages ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var languages []models.Language
+	for rows.Next() {
+		var l models.Language
+		if err := rows.Scan(&l.ID, &l.Name, &l.Code); err != nil {
+			continue
+		}
+		languages = append(languages, l)
+	}
+	return languages, nil
+}
+
+func getLanguageByCode(db *database.Database, code string) (*models.Language, error) {
+	var l models.Language
+	err := db.Pool.QueryRow(context.Background(),
+		"SELECT id, name, code FROM iraven.languages WHERE lower(code) = lower($1)", code).
+		Scan(&l.ID, &l.Name, &l.Code)
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
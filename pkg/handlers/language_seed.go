@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/iraven/iraven-admin/pkg/i18n"
+	"github.com/labstack/echo/v4"
+)
+
+// SeedResult counts what an ISO import added.
+type SeedResult struct {
+	Languages int
+	Countries int
+	Links     int
+	Defaults  int
+}
+
+// seedResultFromQuery reads the result of the previous import, which Seed
+// passes along in its redirect.
+func seedResultFromQuery(c echo.Context) *SeedResult {
+	if c.QueryParam("seeded") == "" {
+		return nil
+	}
+	counts := strings.Split(c.QueryParam("seeded"), ",")
+	values := make([]int, 4)
+	for i := range values {
+		if i < len(counts) {
+			values[i], _ = strconv.Atoi(counts[i])
+		}
+	}
+	return &SeedResult{Languages: values[0], Countries: values[1], Links: values[2], Defaults: values[3]}
+}
+
+// Seed imports the embedded ISO 639-1 languages and ISO 3166-1 countries.
+// Only what is missing is added: existing names are kept, languages are
+// linked to countries that do not speak them yet, and a default language
+// is set only for countries without one. Running it again changes nothing.
+func (h *LanguageHandler) Seed(c echo.Context) error {
+	languages, err := i18n.ISOLanguages()
+	if err != nil {
+		return err
+	}
+	countries, err := i18n.ISOCountries()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var result SeedResult
+	for _, l := range languages {
+		tag, err := tx.Exec(ctx,
+			`INSERT INTO iraven.languages (name, code)
+			SELECT $1, $2 WHERE NOT EXISTS (SELECT 1 FROM iraven.languages WHERE lower(code) = lower($2))`,
+			l.Name, l.Code)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to import language "+l.Code+": "+err.Error())
+		}
+		result.Languages += int(tag.RowsAffected())
+	}
+
+	for _, country := range countries {
+		tag, err := tx.Exec(ctx,
+			`INSERT INTO iraven.countries (name, code)
+			SELECT $1, $2 WHERE NOT EXISTS (SELECT 1 FROM iraven.countries WHERE upper(code) = $2)`,
+			country.Name, country.Code)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to import country "+country.Code+": "+err.Error())
+		}
+		result.Countries += int(tag.RowsAffected())
+
+		for _, code := range country.Languages {
+			tag, err := tx.Exec(ctx,
+				`INSERT INTO iraven.country_languages (country_id, language_id)
+				SELECT c.id, l.id FROM iraven.countries c, iraven.languages l
+				WHERE upper(c.code) = $1 AND lower(l.code) = $2
+				AND NOT EXISTS (SELECT 1 FROM iraven.country_languages cl
+					WHERE cl.country_id = c.id AND cl.language_id = l.id)`,
+				country.Code, code)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest,
+					fmt.Sprintf("Failed to link %s to %s: %s", code, country.Code, err.Error()))
+			}
+			result.Links += int(tag.RowsAffected())
+		}
+
+		if len(country.Languages) > 0 {
+			tag, err := tx.Exec(ctx,
+				`UPDATE iraven.countries c SET default_language_id = l.id, updated_at = NOW()
+				FROM iraven.languages l
+				WHERE upper(c.code) = $1 AND lower(l.code) = $2 AND c.default_language_id IS NULL`,
+				country.Code, country.Languages[0])
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest,
+					"Failed to set default language of "+country.Code+": "+err.Error())
+			}
+			result.Defaults += int(tag.RowsAffected())
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	returnTo := "/languages"
+	if c.FormValue("return_to") == "countries" {
+		returnTo = "/countries"
+	}
+	return c.Redirect(http.StatusFound, fmt.Sprintf("%s?seeded=%d,%d,%d,%d",
+		returnTo, result.Languages, result.Countries, result.Links, result.Defaults))
+}
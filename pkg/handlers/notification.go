@@ -0,0 +1,651 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/iraven/iraven-admin/pkg/database"
+	"github.com/iraven/iraven-admin/pkg/i18n"
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/iraven/iraven-admin/pkg/notify"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+var notificationTargets = []string{"user", "role", "application", "segment"}
+
+// preferenceColumns are the iraven.notification_preferences flags that
+// opt a user in to each channel. In-app notifications are always
+// delivered.
+var preferenceColumns = map[string]string{
+	notify.Email: "email_notifications",
+	notify.Push:  "push_notifications",
+	notify.SMS:   "sms_notifications",
+}
+
+type NotificationHandler struct {
+	db              *database.Database
+	providers       notify.Providers
+	defaultLanguage string
+	deviceStaleDays int
+}
+
+func NewNotificationHandler(db *database.Database, providers notify.Providers, defaultLanguage string, deviceStaleDays int) *NotificationHandler {
+	return &NotificationHandler{db: db, providers: providers, defaultLanguage: defaultLanguage, deviceStaleDays: deviceStaleDays}
+}
+
+// DeliveryCounts tallies deliveries by status. InApp counts the in-app
+// notifications delivered, of which Read have been read.
+type DeliveryCounts struct {
+	Queued   int
+	Sent     int
+	Failed   int
+	Skipped  int
+	OptedOut int
+	InApp    int
+	Read     int
+}
+
+// deliveryCountColumns computes DeliveryCounts, in field order, over
+// deliveries d joined to their in-app notifications n.
+const deliveryCountColumns = `
+	COUNT(d.id) FILTER (WHERE d.status IN ('queued', 'sending')),
+	COUNT(d.id) FILTER (WHERE d.status = 'sent'),
+	COUNT(d.id) FILTER (WHERE d.status = 'failed'),
+	COUNT(d.id) FILTER (WHERE d.status = 'skipped'),
+	COUNT(d.id) FILTER (WHERE d.status = 'opted_out'),
+	COUNT(d.id) FILTER (WHERE d.status = 'sent' AND d.channel = 'in_app'),
+	COUNT(n.id) FILTER (WHERE n.read)`
+
+func (d *DeliveryCounts) fields() []interface{} {
+	return []interface{}{&d.Queued, &d.Sent, &d.Failed, &d.Skipped, &d.OptedOut, &d.InApp, &d.Read}
+}
+
+func (d DeliveryCounts) Total() int {
+	return d.Queued + d.Sent + d.Failed + d.Skipped + d.OptedOut
+}
+
+// DeliveryRate is the percentage of attempted deliveries that were sent.
+func (d DeliveryCounts) DeliveryRate() int {
+	return percent(d.Sent, d.Sent+d.Failed)
+}
+
+// ReadRate is the percentage of delivered in-app notifications read.
+func (d DeliveryCounts) ReadRate() int {
+	return percent(d.Read, d.InApp)
+}
+
+func percent(n, of int) int {
+	if of == 0 {
+		return 0
+	}
+	return n * 100 / of
+}
+
+// SendSummary is a send with its target's name and delivery counts.
+type SendSummary struct {
+	models.NotificationSend
+	TargetName  string
+	AuthorEmail *string
+	TemplateKey *string
+	Counts      DeliveryCounts
+}
+
+func (h *NotificationHandler) List(c echo.Context) error {
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize := 20
+
+	var total int
+	h.db.Pool.QueryRow(context.Background(), "SELECT COUNT(*) FROM iraven.notification_sends").Scan(&total)
+
+	sends, err := h.sendSummaries("TRUE", nil, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{
+		"Title":      "Notifications",
+		"Sends":      sends,
+		"Page":       page,
+		"TotalPages": (total + pageSize - 1) / pageSize,
+	}
+
+	return c.Render(http.StatusOK, "notifications/list", data)
+}
+
+// sendSummaries lists the sends matching where, a condition on sends s,
+// newest first.
+func (h *NotificationHandler) sendSummaries(where string, args []interface{}, limit, offset int) ([]SendSummary, error) {
+	args = append(args, limit, offset)
+	rows, err := h.db.Pool.Query(context.Background(),
+		fmt.Sprintf(`SELECT s.id, s.title, s.target_type, s.target_id, s.channels, s.recipients, s.created_at,
+			u.email, t.key, %s
+		FROM iraven.notification_sends s
+		LEFT JOIN iraven.users u ON u.id = s.created_by
+		LEFT JOIN iraven.notification_templates t ON t.id = s.template_id
+		LEFT JOIN iraven.notification_deliveries d ON d.send_id = s.id
+		LEFT JOIN iraven.notifications n ON n.id = d.notification_id
+		WHERE %s
+		GROUP BY s.id, u.email, t.key
+		ORDER BY s.created_at DESC LIMIT $%d OFFSET $%d`, deliveryCountColumns, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var sends []SendSummary
+	for rows.Next() {
+		var s SendSummary
+		dest := append([]interface{}{&s.ID, &s.Title, &s.TargetType, &s.TargetID, &s.Channels, &s.Recipients,
+			&s.CreatedAt, &s.AuthorEmail, &s.TemplateKey}, s.Counts.fields()...)
+		if err := rows.Scan(dest...); err != nil {
+			continue
+		}
+		sends = append(sends, s)
+	}
+	rows.Close()
+
+	for i := range sends {
+		sends[i].TargetName = h.targetName(sends[i].TargetType, sends[i].TargetID)
+	}
+	return sends, nil
+}
+
+// New shows the composer. target_type and target_id preselect a target,
+// and template a template, so other pages can link to it.
+func (h *NotificationHandler) New(c echo.Context) error {
+	roles, err := listRoles(h.db)
+	if err != nil {
+		return err
+	}
+	applications, err := listApplications(h.db)
+	if err != nil {
+		return err
+	}
+	segments, err := h.listSegments()
+	if err != nil {
+		return err
+	}
+	templates, err := h.listTemplates()
+	if err != nil {
+		return err
+	}
+
+	targetType := c.QueryParam("target_type")
+	targetID, _ := strconv.ParseInt(c.QueryParam("target_id"), 10, 64)
+	var targetUser string
+	if targetType == "user" && targetID != 0 {
+		h.db.Pool.QueryRow(context.Background(),
+			"SELECT email FROM iraven.users WHERE id = $1", targetID).Scan(&targetUser)
+	}
+	if targetType == "" {
+		targetType = "user"
+	}
+
+	data := map[string]interface{}{
+		"Title":        "Compose Notification",
+		"Roles":        roles,
+		"Applications": applications,
+		"Segments":     segments,
+		"Templates":    templates,
+		"TemplateKey":  c.QueryParam("template"),
+		"Channels":     notify.Channels,
+		"TargetType":   targetType,
+		"TargetID":     targetID,
+		"TargetUser":   targetUser,
+	}
+
+	return c.Render(http.StatusOK, "notifications/new", data)
+}
+
+// Create sends a notification to everyone in the chosen target, either
+// written here or from a template. Title and body may use placeholders,
+// which are filled in for each recipient.
+func (h *NotificationHandler) Create(c echo.Context) error {
+	ctx := context.Background()
+	o := outgoing{CreatedBy: currentUserIDPtr(c)}
+
+	if key := c.FormValue("template_key"); key != "" {
+		t, err := h.templateByKey(ctx, key)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Template not found")
+		}
+		o.Template = t
+	} else {
+		o.Title = strings.TrimSpace(c.FormValue("title"))
+		o.Body = strings.TrimSpace(c.FormValue("body"))
+		if o.Title == "" || o.Body == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "Title and body are required")
+		}
+		if err := notify.Check(o.Title, o.Body); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid placeholder: "+err.Error())
+		}
+	}
+
+	if raw := strings.TrimSpace(c.FormValue("data")); raw != "" {
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &object); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Data must be a JSON object: "+err.Error())
+		}
+		o.Data = &raw
+	}
+
+	o.TargetType = c.FormValue("target_type")
+	targetID, err := h.targetID(c, o.TargetType)
+	if err != nil {
+		return err
+	}
+	o.TargetID = targetID
+
+	form, err := c.FormParams()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid form")
+	}
+	o.Channels = form["channels"]
+
+	sendID, err := h.send(ctx, o)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to send notification: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/notifications/%d", sendID))
+}
+
+// SendTemplate sends the current version of the template with the given
+// key to a target, for automated triggers. data, when not empty, is a JSON
+// object the template can refer to as .Data.
+func (h *NotificationHandler) SendTemplate(ctx context.Context, key, targetType string, targetID int64, channels []string, data string) (int64, error) {
+	t, err := h.templateByKey(ctx, key)
+	if err != nil {
+		return 0, fmt.Errorf("template %q not found", key)
+	}
+	o := outgoing{Template: t, TargetType: targetType, TargetID: targetID, Channels: channels}
+	if data != "" {
+		o.Data = &data
+	}
+	return h.send(ctx, o)
+}
+
+// outgoing is a notification about to be sent. Title and Body are used
+// when there is no Template.
+type outgoing struct {
+	Title      string
+	Body       string
+	Data       *string
+	Template   *templateContent
+	TargetType string
+	TargetID   int64
+	Channels   []string
+	CreatedBy  *int64
+}
+
+// send records a send and its deliveries. In-app notifications are written
+// at once; every other channel is queued for the delivery job, or recorded
+// as opted out for users whose preferences exclude it.
+func (h *NotificationHandler) send(ctx context.Context, o outgoing) (int64, error) {
+	var channels []string
+	for _, channel := range notify.Channels {
+		for _, chosen := range o.Channels {
+			if chosen == channel {
+				channels = append(channels, channel)
+			}
+		}
+	}
+	if len(channels) == 0 {
+		return 0, fmt.Errorf("choose at least one channel")
+	}
+
+	userIDs, err := h.recipients(ctx, o.TargetType, o.TargetID)
+	if err != nil {
+		return 0, err
+	}
+	if len(userIDs) == 0 {
+		return 0, fmt.Errorf("no users match this target")
+	}
+
+	messages, err := h.render(ctx, userIDs, o)
+	if err != nil {
+		return 0, err
+	}
+
+	// The send keeps the source text: the template's variant in the
+	// default language, or the closest one it has
+	title, body := o.Title, o.Body
+	var templateID *int64
+	var templateVersion *int
+	if o.Template != nil {
+		v := o.Template.variant(i18n.FallbackChain("", "", h.defaultLanguage))
+		title, body = v.Title, v.Body
+		templateID, templateVersion = &o.Template.TemplateID, &o.Template.Version
+	}
+
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var sendID int64
+	err = tx.QueryRow(ctx,
+		`INSERT INTO iraven.notification_sends
+			(title, body, data, target_type, target_id, channels, recipients, created_by, template_id, template_version)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`,
+		title, body, o.Data, o.TargetType, o.TargetID, channels, len(userIDs), o.CreatedBy,
+		templateID, templateVersion).Scan(&sendID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, channel := range channels {
+		if channel == notify.InApp {
+			err = queueInApp(ctx, tx, sendID, userIDs, messages, o.Data)
+		} else {
+			err = queueChannel(ctx, tx, sendID, userIDs, messages, channel)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	// Start delivering now rather than at the job's next tick
+	go h.DeliverQueued(context.Background())
+
+	return sendID, nil
+}
+
+// render fills in the title and body for every recipient, in the
+// recipient's language when a template is used.
+func (h *NotificationHandler) render(ctx context.Context, userIDs []int64, o outgoing) (map[int64]notify.Message, error) {
+	people, err := h.recipientVars(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	var data map[string]string
+	if o.Data != nil {
+		data = notify.DataVars(*o.Data)
+	}
+
+	messages := make(map[int64]notify.Message, len(userIDs))
+	for _, userID := range userIDs {
+		p := people[userID]
+		p.Vars.Data = data
+		title, body := o.Title, o.Body
+		if o.Template != nil {
+			v := o.Template.variant(p.Chain)
+			title, body = v.Title, v.Body
+		}
+		msg, err := notify.Render(title, body, p.Vars)
+		if err != nil {
+			return nil, fmt.Errorf("rendering for user %d: %w", userID, err)
+		}
+		messages[userID] = msg
+	}
+	return messages, nil
+}
+
+// recipientVars describes a user to templates, with the languages to try
+// for them: their profile language, then their country's default.
+type recipientVars struct {
+	Vars  notify.Vars
+	Chain []string
+}
+
+func (h *NotificationHandler) recipientVars(ctx context.Context, userIDs []int64) (map[int64]recipientVars, error) {
+	rows, err := h.db.Pool.Query(ctx,
+		`SELECT u.id, u.name, u.email, COALESCE(l.code, ''), COALESCE(cl.code, ''), COALESCE(c.code, '')
+		FROM iraven.users u
+		LEFT JOIN iraven.profiles p ON p.user_id = u.id
+		LEFT JOIN iraven.languages l ON l.id = p.language_id
+		LEFT JOIN iraven.countries c ON c.id = p.country_id
+		LEFT JOIN iraven.languages cl ON cl.id = c.default_language_id
+		WHERE u.id = ANY($1)`, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	people := make(map[int64]recipientVars, len(userIDs))
+	for rows.Next() {
+		var v notify.Vars
+		var countryDefault string
+		if err := rows.Scan(&v.User.ID, &v.User.Name, &v.User.Email, &v.Language, &countryDefault, &v.Country); err != nil {
+			continue
+		}
+		v.Language = i18n.NormalizeCode(v.Language)
+		people[v.User.ID] = recipientVars{
+			Vars:  v,
+			Chain: i18n.FallbackChain(v.Language, countryDefault, h.defaultLanguage),
+		}
+	}
+	return people, rows.Err()
+}
+
+func (h *NotificationHandler) Show(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	var s SendSummary
+	err := h.db.Pool.QueryRow(context.Background(),
+		`SELECT s.id, s.title, s.body, s.data::text, s.target_type, s.target_id, s.channels, s.recipients,
+			s.created_at, u.email, s.template_id, s.template_version, t.key
+		FROM iraven.notification_sends s
+		LEFT JOIN iraven.users u ON u.id = s.created_by
+		LEFT JOIN iraven.notification_templates t ON t.id = s.template_id
+		WHERE s.id = $1`, id).
+		Scan(&s.ID, &s.Title, &s.Body, &s.Data, &s.TargetType, &s.TargetID, &s.Channels, &s.Recipients,
+			&s.CreatedAt, &s.AuthorEmail, &s.TemplateID, &s.TemplateVersion, &s.TemplateKey)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Notification not found")
+	}
+	s.TargetName = h.targetName(s.TargetType, s.TargetID)
+
+	counts, err := h.channelCounts("d.send_id = $1", id)
+	if err != nil {
+		return err
+	}
+	var byChannel []ChannelCounts
+	for _, channel := range s.Channels {
+		cc := ChannelCounts{Channel: channel}
+		for _, c := range counts {
+			if c.Channel == channel {
+				cc = c
+			}
+		}
+		byChannel = append(byChannel, cc)
+	}
+
+	// Deliveries that did not go out, most recent first
+	rows, err := h.db.Pool.Query(context.Background(),
+		`SELECT d.id, d.user_id, u.email, d.channel, d.status, d.error, d.attempts, d.updated_at
+		FROM iraven.notification_deliveries d
+		INNER JOIN iraven.users u ON u.id = d.user_id
+		WHERE d.send_id = $1 AND d.status <> 'sent'
+		ORDER BY d.updated_at DESC LIMIT 100`, id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type problem struct {
+		models.NotificationDelivery
+		Email string
+	}
+	var problems []problem
+	for rows.Next() {
+		var p problem
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Email, &p.Channel, &p.Status, &p.Error, &p.Attempts, &p.UpdatedAt); err != nil {
+			continue
+		}
+		problems = append(problems, p)
+	}
+
+	data := map[string]interface{}{
+		"Title":     "Notification",
+		"Send":      s,
+		"ByChannel": byChannel,
+		"Problems":  problems,
+	}
+
+	return c.Render(http.StatusOK, "notifications/show", data)
+}
+
+// targetID reads the ID of the chosen target from its own form field. A
+// user may be given by ID or email address.
+func (h *NotificationHandler) targetID(c echo.Context, targetType string) (int64, error) {
+	var id int64
+	switch targetType {
+	case "user":
+		value := strings.TrimSpace(c.FormValue("user"))
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			id = n
+		} else if value != "" {
+			h.db.Pool.QueryRow(context.Background(),
+				"SELECT id FROM iraven.users WHERE lower(email) = lower($1)", value).Scan(&id)
+		}
+	case "role", "application", "segment":
+		id, _ = strconv.ParseInt(c.FormValue(targetType+"_id"), 10, 64)
+	default:
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid target")
+	}
+	if id == 0 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "Choose a "+targetType)
+	}
+	return id, nil
+}
+
+// recipients lists the IDs of the users a target currently covers.
+func (h *NotificationHandler) recipients(ctx context.Context, targetType string, targetID int64) ([]int64, error) {
+	var query string
+	args := []interface{}{targetID}
+	switch targetType {
+	case "user":
+		query = "SELECT id FROM iraven.users WHERE id = $1"
+	case "role":
+		query = "SELECT DISTINCT user_id FROM iraven.user_roles WHERE role_id = $1"
+	case "application":
+		query = "SELECT DISTINCT user_id FROM (" + applicationUsers + ") a"
+	case "segment":
+		var raw string
+		err := h.db.Pool.QueryRow(ctx,
+			"SELECT filter::text FROM iraven.notification_segments WHERE id = $1", targetID).Scan(&raw)
+		if err != nil {
+			return nil, fmt.Errorf("segment not found")
+		}
+		var filter SegmentFilter
+		if err := json.Unmarshal([]byte(raw), &filter); err != nil {
+			return nil, err
+		}
+		var where string
+		where, args = filter.where(nil)
+		query = "SELECT u.id FROM iraven.users u WHERE " + where
+	default:
+		return nil, fmt.Errorf("invalid target %q", targetType)
+	}
+
+	rows, err := h.db.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// targetName names a target for display.
+func (h *NotificationHandler) targetName(targetType string, targetID int64) string {
+	queries := map[string]string{
+		"user":        "SELECT email FROM iraven.users WHERE id = $1",
+		"role":        "SELECT name FROM iraven.roles WHERE id = $1",
+		"application": "SELECT name FROM iraven.applications WHERE id = $1",
+		"segment":     "SELECT name FROM iraven.notification_segments WHERE id = $1",
+	}
+	var name string
+	if query, ok := queries[targetType]; ok {
+		h.db.Pool.QueryRow(context.Background(), query, targetID).Scan(&name)
+	}
+	if name == "" {
+		name = fmt.Sprintf("%s #%d", targetType, targetID)
+	}
+	return name
+}
+
+// queueInApp writes the in-app notifications and records them as sent.
+// The rows are inserted one by one, batched, so the data column's type in
+// the API's schema decides how the JSON is stored.
+func queueInApp(ctx context.Context, tx pgx.Tx, sendID int64, userIDs []int64, messages map[int64]notify.Message, data *string) error {
+	batch := &pgx.Batch{}
+	for _, userID := range userIDs {
+		msg := messages[userID]
+		batch.Queue("INSERT INTO iraven.notifications (user_id, title, body, data) VALUES ($1, $2, $3, $4) RETURNING id",
+			userID, msg.Title, msg.Body, data)
+	}
+	results := tx.SendBatch(ctx, batch)
+	notificationIDs := make([]int64, len(userIDs))
+	for i := range userIDs {
+		if err := results.QueryRow().Scan(&notificationIDs[i]); err != nil {
+			results.Close()
+			return err
+		}
+	}
+	if err := results.Close(); err != nil {
+		return err
+	}
+
+	titles, bodies := messageColumns(userIDs, messages)
+	_, err := tx.Exec(ctx,
+		`INSERT INTO iraven.notification_deliveries
+			(send_id, user_id, channel, status, notification_id, provider, attempts, sent_at, title, body)
+		SELECT $1, r.user_id, 'in_app', 'sent', r.notification_id, 'in_app', 1, NOW(), r.title, r.body
+		FROM unnest($2::bigint[], $3::bigint[], $4::text[], $5::text[]) AS r(user_id, notification_id, title, body)`,
+		sendID, userIDs, notificationIDs, titles, bodies)
+	return err
+}
+
+// queueChannel queues a delivery on channel for every user, recording
+// those whose preferences opt them out as opted out. Users without
+// preferences are opted in.
+func queueChannel(ctx context.Context, q querier, sendID int64, userIDs []int64, messages map[int64]notify.Message, channel string) error {
+	column, ok := preferenceColumns[channel]
+	if !ok {
+		return fmt.Errorf("unknown channel %q", channel)
+	}
+
+	titles, bodies := messageColumns(userIDs, messages)
+	_, err := q.Exec(ctx,
+		`INSERT INTO iraven.notification_deliveries (send_id, user_id, channel, status, title, body)
+		SELECT $1, r.user_id, $2,
+			CASE WHEN COALESCE(p.`+column+`, TRUE) THEN 'queued' ELSE 'opted_out' END,
+			r.title, r.body
+		FROM unnest($3::bigint[], $4::text[], $5::text[]) AS r(user_id, title, body)
+		LEFT JOIN iraven.notification_preferences p ON p.user_id = r.user_id`,
+		sendID, channel, userIDs, titles, bodies)
+	return err
+}
+
+// messageColumns lines up the rendered titles and bodies with userIDs.
+func messageColumns(userIDs []int64, messages map[int64]notify.Message) ([]string, []string) {
+	titles := make([]string, len(userIDs))
+	bodies := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		titles[i] = messages[userID].Title
+		bodies[i] = messages[userID].Body
+	}
+	return titles, bodies
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/iraven/iraven-admin/pkg/notify"
+	"github.com/labstack/echo/v4"
+)
+
+// analyticsPeriods are the windows the analytics page offers, in days.
+var analyticsPeriods = []int{7, 30, 90, 365}
+
+// ChannelCounts are the delivery counts of one channel.
+type ChannelCounts struct {
+	Channel string
+	DeliveryCounts
+}
+
+// ReadRateDay is the read rate of the in-app notifications sent on a day.
+type ReadRateDay struct {
+	Day       time.Time
+	Delivered int
+	Read      int
+}
+
+func (d ReadRateDay) Rate() int {
+	return percent(d.Read, d.Delivered)
+}
+
+// FailureReason is an error and how many deliveries failed with it.
+type FailureReason struct {
+	Channel string
+	Error   string
+	Count   int
+}
+
+// FailedDelivery is a failed delivery with its recipient and send.
+type FailedDelivery struct {
+	models.NotificationDelivery
+	Email     string
+	SendTitle string
+}
+
+// Analytics reports how notifications sent in the chosen period fared, per
+// channel, per send and per day.
+func (h *NotificationHandler) Analytics(c echo.Context) error {
+	days := analyticsDays(c)
+	ctx := context.Background()
+
+	channels, err := h.channelCounts("s.created_at >= NOW() - make_interval(days => $1::int)", days)
+	if err != nil {
+		return err
+	}
+	var totals DeliveryCounts
+	for _, cc := range channels {
+		totals.Queued += cc.Queued
+		totals.Sent += cc.Sent
+		totals.Failed += cc.Failed
+		totals.Skipped += cc.Skipped
+		totals.OptedOut += cc.OptedOut
+		totals.InApp += cc.InApp
+		totals.Read += cc.Read
+	}
+
+	sends, err := h.sendSummaries("s.created_at >= NOW() - make_interval(days => $1::int)",
+		[]interface{}{days}, 50, 0)
+	if err != nil {
+		return err
+	}
+
+	readRates, err := h.readRates(ctx, days)
+	if err != nil {
+		return err
+	}
+
+	rows, err := h.db.Pool.Query(ctx,
+		`SELECT d.channel, COALESCE(d.error, ''), COUNT(*)
+		FROM iraven.notification_deliveries d
+		INNER JOIN iraven.notification_sends s ON s.id = d.send_id
+		WHERE d.status = 'failed' AND s.created_at >= NOW() - make_interval(days => $1::int)
+		GROUP BY 1, 2 ORDER BY 3 DESC LIMIT 10`, days)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var reasons []FailureReason
+	for rows.Next() {
+		var r FailureReason
+		if err := rows.Scan(&r.Channel, &r.Error, &r.Count); err != nil {
+			continue
+		}
+		reasons = append(reasons, r)
+	}
+
+	data := map[string]interface{}{
+		"Title":     "Notification Analytics",
+		"Days":      days,
+		"Periods":   analyticsPeriods,
+		"Totals":    totals,
+		"Channels":  channels,
+		"Sends":     sends,
+		"ReadRates": readRates,
+		"Reasons":   reasons,
+	}
+
+	return c.Render(http.StatusOK, "notifications/analytics", data)
+}
+
+// Failures lists failed deliveries with their errors, filtered by send,
+// channel and error.
+func (h *NotificationHandler) Failures(c echo.Context) error {
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize := 50
+	days := analyticsDays(c)
+	sendID, _ := strconv.ParseInt(c.QueryParam("send_id"), 10, 64)
+	channel := c.QueryParam("channel")
+	reason := c.QueryParam("error")
+
+	conditions := []string{"d.status = 'failed'"}
+	var args []interface{}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", fmt.Sprintf("$%d", len(args))))
+	}
+	if sendID != 0 {
+		add("d.send_id = ?", sendID)
+	} else {
+		add("s.created_at >= NOW() - make_interval(days => ?::int)", days)
+	}
+	if channel != "" {
+		add("d.channel = ?", channel)
+	}
+	if reason != "" {
+		add("COALESCE(d.error, '') = ?", reason)
+	}
+	where := strings.Join(conditions, " AND ")
+
+	ctx := context.Background()
+	var total int
+	h.db.Pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM iraven.notification_deliveries d
+		INNER JOIN iraven.notification_sends s ON s.id = d.send_id
+		WHERE `+where, args...).Scan(&total)
+
+	rows, err := h.db.Pool.Query(ctx,
+		fmt.Sprintf(`SELECT d.id, d.send_id, s.title, d.user_id, u.email, d.channel, d.error, d.attempts, d.updated_at
+		FROM iraven.notification_deliveries d
+		INNER JOIN iraven.notification_sends s ON s.id = d.send_id
+		INNER JOIN iraven.users u ON u.id = d.user_id
+		WHERE %s
+		ORDER BY d.updated_at DESC LIMIT %d OFFSET %d`, where, pageSize, (page-1)*pageSize), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var failures []FailedDelivery
+	for rows.Next() {
+		var f FailedDelivery
+		if err := rows.Scan(&f.ID, &f.SendID, &f.SendTitle, &f.UserID, &f.Email, &f.Channel, &f.Error,
+			&f.Attempts, &f.UpdatedAt); err != nil {
+			continue
+		}
+		failures = append(failures, f)
+	}
+
+	data := map[string]interface{}{
+		"Title":      "Failed Deliveries",
+		"Failures":   failures,
+		"Total":      total,
+		"Days":       days,
+		"Periods":    analyticsPeriods,
+		"SendID":     sendID,
+		"Channel":    channel,
+		"Error":      reason,
+		"Channels":   notify.Channels,
+		"Page":       page,
+		"TotalPages": (total + pageSize - 1) / pageSize,
+	}
+
+	return c.Render(http.StatusOK, "notifications/failures", data)
+}
+
+// channelCounts tallies deliveries per channel for the sends matching
+// where, a condition on sends s or deliveries d.
+func (h *NotificationHandler) channelCounts(where string, args ...interface{}) ([]ChannelCounts, error) {
+	rows, err := h.db.Pool.Query(context.Background(),
+		`SELECT d.channel, `+deliveryCountColumns+`
+		FROM iraven.notification_deliveries d
+		INNER JOIN iraven.notification_sends s ON s.id = d.send_id
+		LEFT JOIN iraven.notifications n ON n.id = d.notification_id
+		WHERE `+where+`
+		GROUP BY d.channel`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byChannel := map[string]ChannelCounts{}
+	for rows.Next() {
+		var cc ChannelCounts
+		if err := rows.Scan(append([]interface{}{&cc.Channel}, cc.fields()...)...); err != nil {
+			continue
+		}
+		byChannel[cc.Channel] = cc
+	}
+
+	var counts []ChannelCounts
+	for _, channel := range notify.Channels {
+		if cc, ok := byChannel[channel]; ok {
+			counts = append(counts, cc)
+		}
+	}
+	return counts, rows.Err()
+}
+
+// readRates returns the read rate of in-app notifications for each day of
+// the period, by the day they were sent. Days without sends are included.
+func (h *NotificationHandler) readRates(ctx context.Context, days int) ([]ReadRateDay, error) {
+	rows, err := h.db.Pool.Query(ctx,
+		`SELECT date_trunc('day', s.created_at)::date, COUNT(d.id), COUNT(n.id) FILTER (WHERE n.read)
+		FROM iraven.notification_deliveries d
+		INNER JOIN iraven.notification_sends s ON s.id = d.send_id
+		LEFT JOIN iraven.notifications n ON n.id = d.notification_id
+		WHERE d.channel = 'in_app' AND d.status = 'sent'
+			AND s.created_at >= date_trunc('day', NOW()) - make_interval(days => $1::int - 1)
+		GROUP BY 1`, days)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byDay := map[string]ReadRateDay{}
+	for rows.Next() {
+		var d ReadRateDay
+		if err := rows.Scan(&d.Day, &d.Delivered, &d.Read); err != nil {
+			continue
+		}
+		byDay[d.Day.Format("2006-01-02")] = d
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	rates := make([]ReadRateDay, 0, days)
+	for i := days - 1; i >= 0; i-- {
+		day := today.AddDate(0, 0, -i)
+		d, ok := byDay[day.Format("2006-01-02")]
+		if !ok {
+			d = ReadRateDay{Day: day}
+		}
+		rates = append(rates, d)
+	}
+	return rates, nil
+}
+
+func analyticsDays(c echo.Context) int {
+	days, _ := strconv.Atoi(c.QueryParam("days"))
+	for _, period := range analyticsPeriods {
+		if days == period {
+			return days
+		}
+	}
+	return 30
+}
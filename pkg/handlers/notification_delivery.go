@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/iraven/iraven-admin/pkg/notify"
+)
+
+const (
+	deliveryBatchSize   = 100
+	deliveryMaxAttempts = 5
+	deliveryTimeout     = 30 * time.Second
+)
+
+// claimDeliveries marks a batch of due deliveries as sending and returns
+// them. Rows stuck in sending, left behind by a crash, are claimed again.
+const claimDeliveries = `
+	UPDATE iraven.notification_deliveries SET status = 'sending', attempts = attempts + 1, updated_at = NOW()
+	WHERE id IN (
+		SELECT id FROM iraven.notification_deliveries
+		WHERE (status = 'queued' AND next_attempt_at <= NOW())
+			OR (status = 'sending' AND updated_at < NOW() - INTERVAL '10 minutes')
+		ORDER BY next_attempt_at
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, send_id, user_id, channel, attempts, title, body`
+
+type claimedDelivery struct {
+	id       int64
+	sendID   int64
+	userID   int64
+	channel  string
+	attempts int
+	// Rendered for the recipient; nil for deliveries that use the send's
+	title *string
+	body  *string
+}
+
+// DeliverQueued sends queued email, push and SMS deliveries through the
+// configured providers until none are due. Failures are retried with
+// backoff; recipients without an address on the channel are skipped.
+func (h *NotificationHandler) DeliverQueued(ctx context.Context) error {
+	messages := map[int64]notify.Message{}
+	for {
+		batch, err := h.claimDeliveries(ctx)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		for _, d := range batch {
+			msg, ok := messages[d.sendID]
+			if !ok {
+				msg, err = h.sendMessage(ctx, d.sendID)
+				if err != nil {
+					h.deliveryFailed(ctx, h.db.Pool, d, err)
+					continue
+				}
+				messages[d.sendID] = msg
+			}
+			if d.title != nil && d.body != nil {
+				msg.Title, msg.Body = *d.title, *d.body
+			}
+			h.deliver(ctx, h.db.Pool, d, msg)
+		}
+	}
+}
+
+func (h *NotificationHandler) claimDeliveries(ctx context.Context) ([]claimedDelivery, error) {
+	rows, err := h.db.Pool.Query(ctx, claimDeliveries, deliveryBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []claimedDelivery
+	for rows.Next() {
+		var d claimedDelivery
+		if err := rows.Scan(&d.id, &d.sendID, &d.userID, &d.channel, &d.attempts, &d.title, &d.body); err != nil {
+			continue
+		}
+		batch = append(batch, d)
+	}
+	return batch, rows.Err()
+}
+
+func (h *NotificationHandler) deliver(ctx context.Context, q querier, d claimedDelivery, msg notify.Message) {
+	provider, ok := h.providers[d.channel]
+	if !ok {
+		h.deliverySkipped(ctx, q, d, "No provider configured")
+		return
+	}
+
+	to, err := h.recipient(ctx, q, d.userID)
+	if err != nil {
+		h.deliveryFailed(ctx, q, d, err)
+		return
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	result, err := provider.Send(sendCtx, to, msg)
+	cancel()
+
+	if d.channel == notify.Push {
+		h.recordPushResult(ctx, q, to.DeviceTokens, result, err)
+		if err == nil && len(to.DeviceTokens) > 0 && len(result.InvalidTokens) >= len(to.DeviceTokens) {
+			h.deliverySkipped(ctx, q, d, "All device tokens are invalid")
+			return
+		}
+	}
+
+	if errors.Is(err, notify.ErrNoAddress) || errors.Is(err, notify.ErrNotSent) {
+		h.deliverySkipped(ctx, q, d, err.Error())
+		return
+	}
+	if err != nil {
+		h.deliveryFailed(ctx, q, d, err)
+		return
+	}
+
+	_, err = q.Exec(ctx,
+		`UPDATE iraven.notification_deliveries
+		SET status = 'sent', provider = $1, provider_message_id = NULLIF($2, ''), error = NULL,
+			sent_at = NOW(), updated_at = NOW()
+		WHERE id = $3`, provider.Name(), result.MessageID, d.id)
+	if err != nil {
+		log.Printf("notifications: delivery %d was sent but not recorded: %v", d.id, err)
+	}
+}
+
+func (h *NotificationHandler) deliverySkipped(ctx context.Context, q querier, d claimedDelivery, reason string) {
+	q.Exec(ctx,
+		"UPDATE iraven.notification_deliveries SET status = 'skipped', error = $1, updated_at = NOW() WHERE id = $2",
+		reason, d.id)
+}
+
+// deliveryFailed requeues a delivery with exponential backoff, one minute
+// after the first attempt, or fails it for good after the last.
+func (h *NotificationHandler) deliveryFailed(ctx context.Context, q querier, d claimedDelivery, cause error) {
+	if d.attempts >= deliveryMaxAttempts {
+		q.Exec(ctx,
+			"UPDATE iraven.notification_deliveries SET status = 'failed', error = $1, updated_at = NOW() WHERE id = $2",
+			cause.Error(), d.id)
+		return
+	}
+
+	backoff := time.Minute << (d.attempts - 1)
+	q.Exec(ctx,
+		`UPDATE iraven.notification_deliveries
+		SET status = 'queued', error = $1, next_attempt_at = NOW() + make_interval(secs => $2), updated_at = NOW()
+		WHERE id = $3`, cause.Error(), backoff.Seconds(), d.id)
+}
+
+func (h *NotificationHandler) sendMessage(ctx context.Context, sendID int64) (notify.Message, error) {
+	var msg notify.Message
+	var data *string
+	err := h.db.Pool.QueryRow(ctx,
+		"SELECT title, body, data::text FROM iraven.notification_sends WHERE id = $1", sendID).
+		Scan(&msg.Title, &msg.Body, &data)
+	if data != nil {
+		msg.Data = *data
+	}
+	return msg, err
+}
+
+// recipient loads a user's addresses. The phone number is read from the
+// profile's extra data, where the API keeps it.
+func (h *NotificationHandler) recipient(ctx context.Context, q querier, userID int64) (notify.Recipient, error) {
+	to := notify.Recipient{UserID: userID}
+	var extraData *string
+	err := q.QueryRow(ctx,
+		`SELECT u.name, u.email, p.extra_data::text
+		FROM iraven.users u
+		LEFT JOIN iraven.profiles p ON p.user_id = u.id
+		WHERE u.id = $1`, userID).Scan(&to.Name, &to.Email, &extraData)
+	if err != nil {
+		return to, err
+	}
+
+	if extraData != nil {
+		var extra map[string]interface{}
+		if json.Unmarshal([]byte(*extraData), &extra) == nil {
+			if phone, ok := extra["phone"].(string); ok {
+				to.Phone = phone
+			}
+		}
+	}
+
+	rows, err := q.Query(ctx,
+		`SELECT device_token FROM iraven.notification_devices
+		WHERE user_id = $1 AND invalid_at IS NULL ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return to, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var token string
+		if err := rows.Scan(&token); err != nil {
+			continue
+		}
+		to.DeviceTokens = append(to.DeviceTokens, token)
+	}
+	return to, nil
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/iraven/iraven-admin/pkg/notify"
+)
+
+var notificationMigrations = []string{
+	"012_notification_sends.sql", "013_notification_templates.sql", "014_notification_opt_outs.sql",
+	"015_notification_devices.sql", "023_notification_device_refreshes.sql",
+}
+
+// insertSend adds a notification send for the deliveries of a test.
+func insertSend(t *testing.T, q querier) int64 {
+	t.Helper()
+	var id int64
+	err := q.QueryRow(context.Background(),
+		`INSERT INTO iraven.notification_sends (title, body, target_type, target_id, channels)
+		VALUES ('Hello', 'Hi', 'user', 1, '{email,push,sms}') RETURNING id`).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestQueueChannel(t *testing.T) {
+	tx := testTx(t, notificationMigrations...)
+	ctx := context.Background()
+
+	exec(t, tx, `INSERT INTO iraven.users (id, email) VALUES
+		(1, 'no-preferences@example.com'), (2, 'no-email@example.com'), (3, 'no-push@example.com')`)
+	exec(t, tx, `INSERT INTO iraven.notification_preferences (user_id, email_notifications, push_notifications) VALUES
+		(2, FALSE, TRUE), (3, TRUE, FALSE)`)
+	sendID := insertSend(t, tx)
+	messages := map[int64]notify.Message{
+		1: {Title: "Hello Ann", Body: "Hi Ann"},
+		2: {Title: "Hello Bob", Body: "Hi Bob"},
+		3: {Title: "Hello Cy", Body: "Hi Cy"},
+	}
+
+	for _, channel := range []string{notify.Email, notify.Push} {
+		if err := queueChannel(ctx, tx, sendID, []int64{1, 2, 3}, messages, channel); err != nil {
+			t.Fatalf("queueChannel(%s): %v", channel, err)
+		}
+	}
+	if err := queueChannel(ctx, tx, sendID, []int64{1}, messages, "fax"); err == nil {
+		t.Error("unknown channel: expected an error")
+	}
+
+	got, err := collectStrings(tx.Query(ctx,
+		`SELECT channel || ':' || user_id || ':' || status || ':' || title
+		FROM iraven.notification_deliveries WHERE send_id = $1
+		ORDER BY channel COLLATE "C", user_id`, sendID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"email:1:queued:Hello Ann", "email:2:opted_out:Hello Bob", "email:3:queued:Hello Cy",
+		"push:1:queued:Hello Ann", "push:2:queued:Hello Bob", "push:3:opted_out:Hello Cy",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("deliveries:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	tx := testTx(t, notificationMigrations...)
+	ctx := context.Background()
+
+	exec(t, tx, "INSERT INTO iraven.users (id, email) VALUES (1, 'a@example.com'), (2, 'b@example.com')")
+	exec(t, tx, `INSERT INTO iraven.profiles (user_id, extra_data) VALUES (1, '{"phone": "+15550100"}')`)
+	exec(t, tx, "INSERT INTO iraven.notification_devices (user_id, device_token) VALUES (1, 'tok-1'), (2, 'tok-2')")
+	sendID := insertSend(t, tx)
+
+	delivered := notify.NewStub(notify.Email)
+	delivered.Deliver = func(to notify.Recipient) notify.Result { return notify.Result{MessageID: "msg-1"} }
+	unreachable := notify.NewStub(notify.SMS)
+	unreachable.Fail = func(to notify.Recipient) error { return errors.New("carrier refused") }
+	invalidated := notify.NewStub(notify.Push)
+	invalidated.Deliver = func(to notify.Recipient) notify.Result { return notify.Result{InvalidTokens: to.DeviceTokens} }
+
+	tests := []struct {
+		name      string
+		providers notify.Providers
+		userID    int64
+		channel   string
+		attempts  int
+		// The row afterwards: status, error, provider message ID and the
+		// seconds until the next attempt
+		status    string
+		err       string
+		messageID string
+		retryIn   int
+	}{
+		{"sent", notify.Providers{notify.Email: delivered}, 1, notify.Email, 1, "sent", "", "msg-1", 0},
+		{"logged by the stub", notify.Providers{notify.Email: notify.NewStub(notify.Email)}, 1, notify.Email, 1,
+			"skipped", notify.ErrNotSent.Error(), "", 0},
+		{"no address", notify.Providers{notify.SMS: notify.NewStub(notify.SMS)}, 2, notify.SMS, 1,
+			"skipped", notify.ErrNoAddress.Error(), "", 0},
+		{"no provider", notify.Providers{}, 1, notify.SMS, 1, "skipped", "No provider configured", "", 0},
+		{"all tokens invalid", notify.Providers{notify.Push: invalidated}, 2, notify.Push, 1,
+			"skipped", "All device tokens are invalid", "", 0},
+		{"first retry", notify.Providers{notify.SMS: unreachable}, 1, notify.SMS, 1, "queued", "carrier refused", "", 60},
+		{"third retry", notify.Providers{notify.SMS: unreachable}, 1, notify.SMS, 3, "queued", "carrier refused", "", 240},
+		{"last attempt", notify.Providers{notify.SMS: unreachable}, 1, notify.SMS, deliveryMaxAttempts,
+			"failed", "carrier refused", "", 0},
+	}
+	for _, tt := range tests {
+		d := claimedDelivery{sendID: sendID, userID: tt.userID, channel: tt.channel, attempts: tt.attempts}
+		err := tx.QueryRow(ctx,
+			`INSERT INTO iraven.notification_deliveries (send_id, user_id, channel, status, attempts)
+			VALUES ($1, $2, $3, 'sending', $4) RETURNING id`, sendID, tt.userID, tt.channel, tt.attempts).Scan(&d.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h := &NotificationHandler{providers: tt.providers}
+		h.deliver(ctx, tx, d, notify.Message{Title: "Hello", Body: "Hi"})
+
+		var status, cause, messageID string
+		var retryIn int
+		err = tx.QueryRow(ctx,
+			`SELECT status, COALESCE(error, ''), COALESCE(provider_message_id, ''),
+				EXTRACT(EPOCH FROM next_attempt_at - NOW())::int
+			FROM iraven.notification_deliveries WHERE id = $1`, d.id).Scan(&status, &cause, &messageID, &retryIn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status || cause != tt.err || messageID != tt.messageID || retryIn != tt.retryIn {
+			t.Errorf("%s: got %s, %q, %q, retry in %ds; want %s, %q, %q, retry in %ds",
+				tt.name, status, cause, messageID, retryIn, tt.status, tt.err, tt.messageID, tt.retryIn)
+		}
+	}
+
+	invalid, err := collectStrings(tx.Query(ctx,
+		"SELECT device_token FROM iraven.notification_devices WHERE invalid_at IS NOT NULL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(invalid, []string{"tok-2"}) {
+		t.Errorf("invalid devices = %v, want [tok-2]", invalid)
+	}
+}
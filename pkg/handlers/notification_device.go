@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/iraven/iraven-admin/pkg/database"
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/iraven/iraven-admin/pkg/notify"
+	"github.com/labstack/echo/v4"
+)
+
+// DeviceTypeStats counts the devices of one type by health.
+type DeviceTypeStats struct {
+	DeviceType string
+	Active     int
+	Failing    int
+	Invalid    int
+}
+
+// UserDevice is a device on the user page.
+type UserDevice struct {
+	models.NotificationDevice
+}
+
+// Status is "invalid" once the push service rejected the token, "failing"
+// when the last push to it failed, and otherwise "active".
+func (d UserDevice) Status() string {
+	switch {
+	case d.InvalidAt != nil:
+		return "invalid"
+	case d.LastErrorAt != nil && (d.LastSuccessAt == nil || d.LastSuccessAt.Before(*d.LastErrorAt)):
+		return "failing"
+	}
+	return "active"
+}
+
+// DeviceRemoval is a removed device with the admin who removed it.
+type DeviceRemoval struct {
+	models.NotificationDeviceRemoval
+	Email        string
+	RemovedEmail *string
+}
+
+// Devices summarizes registered devices by type and lists recent
+// removals.
+func (h *NotificationHandler) Devices(c echo.Context) error {
+	ctx := context.Background()
+
+	rows, err := h.db.Pool.Query(ctx,
+		`SELECT COALESCE(device_type, ''),
+			COUNT(*) FILTER (WHERE invalid_at IS NULL AND (last_error_at IS NULL OR last_success_at > last_error_at)),
+			COUNT(*) FILTER (WHERE invalid_at IS NULL AND last_error_at IS NOT NULL
+				AND (last_success_at IS NULL OR last_success_at < last_error_at)),
+			COUNT(*) FILTER (WHERE invalid_at IS NOT NULL)
+		FROM iraven.notification_devices
+		GROUP BY 1 ORDER BY 1`)
+	if err != nil {
+		return err
+	}
+	var stats []DeviceTypeStats
+	for rows.Next() {
+		var s DeviceTypeStats
+		if err := rows.Scan(&s.DeviceType, &s.Active, &s.Failing, &s.Invalid); err != nil {
+			continue
+		}
+		stats = append(stats, s)
+	}
+	rows.Close()
+
+	rows, err = h.db.Pool.Query(ctx,
+		`SELECT r.id, r.user_id, u.email, r.device_token, r.device_type, r.reason, r.removed_by, a.email, r.created_at
+		FROM iraven.notification_device_removals r
+		INNER JOIN iraven.users u ON u.id = r.user_id
+		LEFT JOIN iraven.users a ON a.id = r.removed_by
+		ORDER BY r.created_at DESC LIMIT 50`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var removals []DeviceRemoval
+	for rows.Next() {
+		var r DeviceRemoval
+		if err := rows.Scan(&r.ID, &r.UserID, &r.Email, &r.DeviceToken, &r.DeviceType, &r.Reason, &r.RemovedBy,
+			&r.RemovedEmail, &r.CreatedAt); err != nil {
+			continue
+		}
+		removals = append(removals, r)
+	}
+
+	data := map[string]interface{}{
+		"Title":     "Devices",
+		"Stats":     stats,
+		"Removals":  removals,
+		"StaleDays": h.staleDays(),
+	}
+
+	return c.Render(http.StatusOK, "notifications/devices", data)
+}
+
+// TestPush sends a test push to one device and records the outcome on it.
+// The result is passed back to the user page as push_test.
+func (h *NotificationHandler) TestPush(c echo.Context) error {
+	userID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	deviceID, _ := strconv.ParseInt(c.Param("device"), 10, 64)
+	ctx := context.Background()
+
+	var to notify.Recipient
+	var token string
+	err := h.db.Pool.QueryRow(ctx,
+		`SELECT u.id, u.name, u.email, d.device_token
+		FROM iraven.notification_devices d
+		INNER JOIN iraven.users u ON u.id = d.user_id
+		WHERE d.id = $1 AND d.user_id = $2`, deviceID, userID).Scan(&to.UserID, &to.Name, &to.Email, &token)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Device not found")
+	}
+	to.DeviceTokens = []string{token}
+
+	provider, ok := h.providers[notify.Push]
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "No push provider configured")
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	result, err := provider.Send(sendCtx, to, notify.Message{
+		Title: "Test notification",
+		Body:  "This is a test push sent from the admin dashboard.",
+		Data:  `{"test":true}`,
+	})
+	cancel()
+	h.recordPushResult(ctx, h.db.Pool, to.DeviceTokens, result, err)
+
+	outcome := "sent"
+	switch {
+	case errors.Is(err, notify.ErrNotSent):
+		outcome = "stub"
+	case err != nil:
+		outcome = "failed"
+	case len(result.InvalidTokens) > 0:
+		outcome = "invalid"
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/users/%d?push_test=%s#devices", userID, outcome))
+}
+
+// RevokeDevice removes a device so that it receives no more pushes. The
+// app registers it again on the user's next sign-in.
+func (h *NotificationHandler) RevokeDevice(c echo.Context) error {
+	userID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	deviceID, _ := strconv.ParseInt(c.Param("device"), 10, 64)
+
+	_, err := h.db.Pool.Exec(context.Background(),
+		`WITH removed AS (
+			DELETE FROM iraven.notification_devices WHERE id = $1 AND user_id = $2
+			RETURNING user_id, device_token, device_type
+		)
+		INSERT INTO iraven.notification_device_removals (user_id, device_token, device_type, reason, removed_by)
+		SELECT user_id, device_token, device_type, 'revoked', $3 FROM removed`,
+		deviceID, userID, currentUserIDPtr(c))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to revoke device: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/users/%d#devices", userID))
+}
+
+// PruneDevices removes devices the push service reported as invalid, and
+// devices the app has not registered or refreshed within the configured
+// number of days.
+func (h *NotificationHandler) PruneDevices(ctx context.Context) error {
+	pruned, err := pruneDevices(ctx, h.db.Pool, h.staleDays())
+	if err != nil {
+		return err
+	}
+
+	log.Printf("notifications: pruned %d devices", pruned)
+	return nil
+}
+
+// staleDays is the number of days after which unrefreshed devices are
+// pruned, or zero to keep them. Nothing is pruned as stale with the stub
+// push driver, which is only used where real apps do not register.
+func (h *NotificationHandler) staleDays() int {
+	if provider, ok := h.providers[notify.Push]; !ok || provider.Name() == "stub" {
+		return 0
+	}
+	return h.deviceStaleDays
+}
+
+// pruneDevices deletes invalid devices and, when staleDays is positive,
+// devices not refreshed for that many days, recording each removal.
+func pruneDevices(ctx context.Context, q querier, staleDays int) (int64, error) {
+	tag, err := q.Exec(ctx,
+		`WITH removed AS (
+			DELETE FROM iraven.notification_devices
+			WHERE invalid_at IS NOT NULL
+				OR ($1 > 0 AND refreshed_at < NOW() - make_interval(days => $1::int))
+			RETURNING user_id, device_token, device_type, invalid_at
+		)
+		INSERT INTO iraven.notification_device_removals (user_id, device_token, device_type, reason)
+		SELECT user_id, device_token, device_type, CASE WHEN invalid_at IS NOT NULL THEN 'invalid' ELSE 'stale' END
+		FROM removed`, staleDays)
+	return tag.RowsAffected(), err
+}
+
+// recordPushResult stores the outcome of a push on the devices it went
+// to: tokens the service rejected are marked invalid, and the others
+// record the success or error.
+func (h *NotificationHandler) recordPushResult(ctx context.Context, q querier, tokens []string, result notify.Result, sendErr error) {
+	if len(result.InvalidTokens) > 0 {
+		q.Exec(ctx,
+			`UPDATE iraven.notification_devices
+			SET invalid_at = NOW(), last_error = 'Token no longer registered', last_error_at = NOW()
+			WHERE device_token = ANY($1) AND invalid_at IS NULL`, result.InvalidTokens)
+	}
+
+	switch {
+	case sendErr == nil:
+		// An empty rather than nil list, which would match no tokens
+		invalid := append([]string{}, result.InvalidTokens...)
+		q.Exec(ctx,
+			`UPDATE iraven.notification_devices SET last_success_at = NOW()
+			WHERE device_token = ANY($1) AND device_token <> ALL($2::text[])`, tokens, invalid)
+	case !errors.Is(sendErr, notify.ErrNoAddress) && !errors.Is(sendErr, notify.ErrNotSent):
+		q.Exec(ctx,
+			`UPDATE iraven.notification_devices SET last_error = $1, last_error_at = NOW()
+			WHERE device_token = ANY($2) AND invalid_at IS NULL`, sendErr.Error(), tokens)
+	}
+}
+
+// listDevices lists a user's devices, newest first.
+func listDevices(db *database.Database, userID int64) ([]UserDevice, error) {
+	rows, err := db.Pool.Query(context.Background(),
+		`SELECT id, user_id, device_token, COALESCE(device_type, ''), created_at,
+			last_success_at, last_error, last_error_at, invalid_at
+		FROM iraven.notification_devices WHERE user_id = $1
+		ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []UserDevice
+	for rows.Next() {
+		var d UserDevice
+		if err := rows.Scan(&d.ID, &d.UserID, &d.DeviceToken, &d.DeviceType, &d.CreatedAt,
+			&d.LastSuccessAt, &d.LastError, &d.LastErrorAt, &d.InvalidAt); err != nil {
+			continue
+		}
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/iraven/iraven-admin/pkg/notify"
+)
+
+func TestPruneDevices(t *testing.T) {
+	tx := testTx(t, "012_notification_sends.sql", "015_notification_devices.sql", "023_notification_device_refreshes.sql")
+	ctx := context.Background()
+
+	exec(t, tx, "INSERT INTO iraven.users (id, email) VALUES (1, 'a@example.com')")
+	exec(t, tx, `INSERT INTO iraven.notification_devices (user_id, device_token, device_type, created_at) VALUES
+		(1, 'fresh', 'ios', NOW()),
+		(1, 'registered-long-ago', 'ios', NOW() - INTERVAL '400 days'),
+		(1, 'stale', 'android', NOW() - INTERVAL '400 days'),
+		(1, 'stale-but-pushed', 'android', NOW() - INTERVAL '400 days'),
+		(1, 're-registered', 'android', NOW() - INTERVAL '400 days'),
+		(1, 'invalid', 'ios', NOW())`)
+	// Inserting stamps refreshed_at; backdate the devices not refreshed since
+	exec(t, tx, `UPDATE iraven.notification_devices SET refreshed_at = NOW() - INTERVAL '300 days'
+		WHERE device_token IN ('stale', 'stale-but-pushed', 're-registered')`)
+	exec(t, tx, "UPDATE iraven.notification_devices SET last_success_at = NOW() WHERE device_token = 'stale-but-pushed'")
+	exec(t, tx, "UPDATE iraven.notification_devices SET invalid_at = NOW() WHERE device_token = 'invalid'")
+	// The API writing the registration again counts as a refresh
+	exec(t, tx, "UPDATE iraven.notification_devices SET device_type = 'android' WHERE device_token = 're-registered'")
+
+	// Without stale pruning only the invalid device goes
+	pruned, err := pruneDevices(ctx, tx, 0)
+	if err != nil {
+		t.Fatalf("pruneDevices: %v", err)
+	}
+	if pruned != 1 {
+		t.Errorf("pruned %d devices without stale pruning, want 1", pruned)
+	}
+
+	pruned, err = pruneDevices(ctx, tx, 270)
+	if err != nil {
+		t.Fatalf("pruneDevices: %v", err)
+	}
+	if pruned != 2 {
+		t.Errorf("pruned %d stale devices, want 2", pruned)
+	}
+
+	remaining, err := collectStrings(tx.Query(ctx,
+		`SELECT device_token FROM iraven.notification_devices ORDER BY device_token COLLATE "C"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"fresh", "re-registered", "registered-long-ago"}; !reflect.DeepEqual(remaining, want) {
+		t.Errorf("remaining devices = %v, want %v", remaining, want)
+	}
+
+	removals, err := collectStrings(tx.Query(ctx,
+		`SELECT device_token || ':' || reason FROM iraven.notification_device_removals ORDER BY device_token COLLATE "C"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"invalid:invalid", "stale-but-pushed:stale", "stale:stale"}; !reflect.DeepEqual(removals, want) {
+		t.Errorf("removals = %v, want %v", removals, want)
+	}
+}
+
+func TestStaleDays(t *testing.T) {
+	tests := []struct {
+		name      string
+		providers notify.Providers
+		want      int
+	}{
+		{"stub", notify.Providers{notify.Push: notify.NewStub(notify.Push)}, 0},
+		{"webhook", notify.Providers{notify.Push: notify.NewWebhookPush("https://push.example.com", "")}, 270},
+		{"no push provider", notify.Providers{}, 0},
+	}
+	for _, tt := range tests {
+		h := &NotificationHandler{providers: tt.providers, deviceStaleDays: 270}
+		if got := h.staleDays(); got != tt.want {
+			t.Errorf("%s: staleDays() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
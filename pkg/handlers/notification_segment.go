@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/labstack/echo/v4"
+)
+
+// SegmentFilter is the saved definition of a segment. Users must match
+// every criterion that is set; list criteria match any of their values.
+type SegmentFilter struct {
+	RoleIDs           []int64 `json:"role_ids,omitempty"`
+	ApplicationIDs    []int64 `json:"application_ids,omitempty"`
+	LanguageIDs       []int64 `json:"language_ids,omitempty"`
+	CountryIDs        []int64 `json:"country_ids,omitempty"`
+	EmailVerified     *bool   `json:"email_verified,omitempty"`
+	ActiveWithinDays  int     `json:"active_within_days,omitempty"`
+	InactiveForDays   int     `json:"inactive_for_days,omitempty"`
+	CreatedWithinDays int     `json:"created_within_days,omitempty"`
+}
+
+// where turns the filter into a condition on iraven.users aliased u, with
+// its parameters appended to args.
+func (f SegmentFilter) where(args []interface{}) (string, []interface{}) {
+	conditions := []string{"TRUE"}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	if len(f.RoleIDs) > 0 {
+		add("u.id IN (SELECT user_id FROM iraven.user_roles WHERE role_id = ANY(?))", f.RoleIDs)
+	}
+	if len(f.ApplicationIDs) > 0 {
+		add(`u.id IN (SELECT ur.user_id FROM iraven.user_roles ur
+			INNER JOIN iraven.application_roles ar ON ar.role_id = ur.role_id
+			WHERE ar.application_id = ANY(?))`, f.ApplicationIDs)
+	}
+	if len(f.LanguageIDs) > 0 {
+		add("u.id IN (SELECT user_id FROM iraven.profiles WHERE language_id = ANY(?))", f.LanguageIDs)
+	}
+	if len(f.CountryIDs) > 0 {
+		add("u.id IN (SELECT user_id FROM iraven.profiles WHERE country_id = ANY(?))", f.CountryIDs)
+	}
+	if f.EmailVerified != nil {
+		add("u.email_verified = ?", *f.EmailVerified)
+	}
+	if f.ActiveWithinDays > 0 {
+		add("u.last_login >= NOW() - make_interval(days => ?::int)", f.ActiveWithinDays)
+	}
+	if f.InactiveForDays > 0 {
+		add("(u.last_login IS NULL OR u.last_login < NOW() - make_interval(days => ?::int))", f.InactiveForDays)
+	}
+	if f.CreatedWithinDays > 0 {
+		add("u.created_at >= NOW() - make_interval(days => ?::int)", f.CreatedWithinDays)
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// SegmentWithSize is a segment with how many users match it now.
+type SegmentWithSize struct {
+	models.NotificationSegment
+	Users int
+}
+
+func (h *NotificationHandler) Segments(c echo.Context) error {
+	segments, err := h.listSegments()
+	if err != nil {
+		return err
+	}
+
+	for i := range segments {
+		var filter SegmentFilter
+		json.Unmarshal([]byte(segments[i].Filter), &filter)
+		where, args := filter.where(nil)
+		h.db.Pool.QueryRow(context.Background(),
+			"SELECT COUNT(*) FROM iraven.users u WHERE "+where, args...).Scan(&segments[i].Users)
+	}
+
+	data := map[string]interface{}{
+		"Title":    "Segments",
+		"Segments": segments,
+	}
+
+	return c.Render(http.StatusOK, "notifications/segments", data)
+}
+
+func (h *NotificationHandler) NewSegment(c echo.Context) error {
+	return h.renderSegmentForm(c, models.NotificationSegment{}, SegmentFilter{})
+}
+
+func (h *NotificationHandler) CreateSegment(c echo.Context) error {
+	name, description, filter, err := segmentForm(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.Pool.Exec(context.Background(),
+		"INSERT INTO iraven.notification_segments (name, description, filter, created_by) VALUES ($1, $2, $3, $4)",
+		name, description, filter, currentUserIDPtr(c))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to create segment: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, "/notifications/segments")
+}
+
+func (h *NotificationHandler) EditSegment(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	var s models.NotificationSegment
+	err := h.db.Pool.QueryRow(context.Background(),
+		"SELECT id, name, description, filter::text FROM iraven.notification_segments WHERE id = $1", id).
+		Scan(&s.ID, &s.Name, &s.Description, &s.Filter)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Segment not found")
+	}
+
+	var filter SegmentFilter
+	json.Unmarshal([]byte(s.Filter), &filter)
+	return h.renderSegmentForm(c, s, filter)
+}
+
+func (h *NotificationHandler) UpdateSegment(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	name, description, filter, err := segmentForm(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.Pool.Exec(context.Background(),
+		"UPDATE iraven.notification_segments SET name = $1, description = $2, filter = $3, updated_at = NOW() WHERE id = $4",
+		name, description, filter, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to update segment: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, "/notifications/segments")
+}
+
+func (h *NotificationHandler) DeleteSegment(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	_, err := h.db.Pool.Exec(context.Background(), "DELETE FROM iraven.notification_segments WHERE id = $1", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to delete segment: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, "/notifications/segments")
+}
+
+func (h *NotificationHandler) renderSegmentForm(c echo.Context, segment models.NotificationSegment, filter SegmentFilter) error {
+	roles, err := listRoles(h.db)
+	if err != nil {
+		return err
+	}
+	applications, err := listApplications(h.db)
+	if err != nil {
+		return err
+	}
+	languages, err := listLanguages(h.db)
+	if err != nil {
+		return err
+	}
+	countries, err := (&CountryHandler{db: h.db}).countries("", 0, 0)
+	if err != nil {
+		return err
+	}
+
+	selected := func(ids []int64) map[int64]bool {
+		m := map[int64]bool{}
+		for _, id := range ids {
+			m[id] = true
+		}
+		return m
+	}
+
+	emailVerified := ""
+	if filter.EmailVerified != nil {
+		emailVerified = "no"
+		if *filter.EmailVerified {
+			emailVerified = "yes"
+		}
+	}
+
+	title := "New Segment"
+	if segment.ID != 0 {
+		title = "Edit Segment"
+	}
+
+	data := map[string]interface{}{
+		"Title":                title,
+		"Segment":              segment,
+		"Filter":               filter,
+		"Roles":                roles,
+		"Applications":         applications,
+		"Languages":            languages,
+		"Countries":            countries,
+		"SelectedRoles":        selected(filter.RoleIDs),
+		"SelectedApplications": selected(filter.ApplicationIDs),
+		"SelectedLanguages":    selected(filter.LanguageIDs),
+		"SelectedCountries":    selected(filter.CountryIDs),
+		"EmailVerified":        emailVerified,
+	}
+
+	return c.Render(http.StatusOK, "notifications/segment_form", data)
+}
+
+func (h *NotificationHandler) listSegments() ([]SegmentWithSize, error) {
+	rows, err := h.db.Pool.Query(context.Background(),
+		`SELECT id, name, description, filter::text, created_by, created_at, updated_at
+		FROM iraven.notification_segments ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var segments []SegmentWithSize
+	for rows.Next() {
+		var s SegmentWithSize
+		if err := rows.Scan(&s.ID, &s.Name, &s.Description, &s.Filter, &s.CreatedBy, &s.CreatedAt, &s.UpdatedAt); err != nil {
+			continue
+		}
+		segments = append(segments, s)
+	}
+	return segments, nil
+}
+
+// segmentForm reads a segment, returning its filter as JSON.
+func segmentForm(c echo.Context) (string, *string, string, error) {
+	name := strings.TrimSpace(c.FormValue("name"))
+	if name == "" {
+		return "", nil, "", echo.NewHTTPError(http.StatusBadRequest, "Name is required")
+	}
+	var description *string
+	if d := strings.TrimSpace(c.FormValue("description")); d != "" {
+		description = &d
+	}
+
+	form, err := c.FormParams()
+	if err != nil {
+		return "", nil, "", echo.NewHTTPError(http.StatusBadRequest, "Invalid form")
+	}
+
+	days := func(field string) int {
+		n, _ := strconv.Atoi(form.Get(field))
+		if n < 0 {
+			return 0
+		}
+		return n
+	}
+
+	filter := SegmentFilter{
+		RoleIDs:           parseIDs(form["role_ids"]),
+		ApplicationIDs:    parseIDs(form["application_ids"]),
+		LanguageIDs:       parseIDs(form["language_ids"]),
+		CountryIDs:        parseIDs(form["country_ids"]),
+		ActiveWithinDays:  days("active_within_days"),
+		InactiveForDays:   days("inactive_for_days"),
+		CreatedWithinDays: days("created_within_days"),
+	}
+	switch form.Get("email_verified") {
+	case "yes":
+		verified := true
+		filter.EmailVerified = &verified
+	case "no":
+		verified := false
+		filter.EmailVerified = &verified
+	}
+
+	encoded, err := json.Marshal(filter)
+	if err != nil {
+		return "", nil, "", err
+	}
+	return name, description, string(encoded), nil
+}
@@ -0,0 +1,565 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/iraven/iraven-admin/pkg/i18n"
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/iraven/iraven-admin/pkg/notify"
+	"github.com/labstack/echo/v4"
+)
+
+// Template keys are what code refers to, so they are kept simple
+var templateKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// templateContent is one version of a template with its variants.
+type templateContent struct {
+	TemplateID int64
+	Key        string
+	Version    int
+	Variants   []models.NotificationTemplateVariant
+}
+
+// variant returns the variant for the first language of chain the
+// template has, or its first variant.
+func (t *templateContent) variant(chain []string) models.NotificationTemplateVariant {
+	for _, code := range chain {
+		for _, v := range t.Variants {
+			if i18n.NormalizeCode(v.LanguageCode) == code {
+				return v
+			}
+		}
+	}
+	if len(t.Variants) == 0 {
+		return models.NotificationTemplateVariant{}
+	}
+	return t.Variants[0]
+}
+
+// TemplateSummary is a template in the template list.
+type TemplateSummary struct {
+	models.NotificationTemplate
+	Languages []string
+	Sends     int
+}
+
+// TemplateVariantRow is a variant in the template form, with the
+// languages it can be switched to.
+type TemplateVariantRow struct {
+	models.NotificationTemplateVariant
+	Languages []models.Language
+}
+
+// TemplateVersionSummary is a version in a template's history.
+type TemplateVersionSummary struct {
+	models.NotificationTemplateVersion
+	AuthorEmail *string
+	Languages   int
+}
+
+func (h *NotificationHandler) Templates(c echo.Context) error {
+	rows, err := h.db.Pool.Query(context.Background(),
+		`SELECT t.id, t.key, t.name, t.description, t.version, t.updated_at,
+			ARRAY(SELECT l.code FROM iraven.notification_template_variants tv
+				INNER JOIN iraven.languages l ON l.id = tv.language_id
+				WHERE tv.version_id = v.id ORDER BY l.code),
+			(SELECT COUNT(*) FROM iraven.notification_sends s WHERE s.template_id = t.id)
+		FROM iraven.notification_templates t
+		LEFT JOIN iraven.notification_template_versions v ON v.template_id = t.id AND v.version = t.version
+		ORDER BY t.key`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var templates []TemplateSummary
+	for rows.Next() {
+		var t TemplateSummary
+		if err := rows.Scan(&t.ID, &t.Key, &t.Name, &t.Description, &t.Version, &t.UpdatedAt, &t.Languages, &t.Sends); err != nil {
+			continue
+		}
+		templates = append(templates, t)
+	}
+
+	data := map[string]interface{}{
+		"Title":     "Notification Templates",
+		"Templates": templates,
+	}
+
+	return c.Render(http.StatusOK, "notifications/templates", data)
+}
+
+func (h *NotificationHandler) NewTemplate(c echo.Context) error {
+	var variants []models.NotificationTemplateVariant
+	if language, err := getLanguageByCode(h.db, h.defaultLanguage); err == nil {
+		variants = append(variants, models.NotificationTemplateVariant{LanguageID: language.ID})
+	}
+	return h.renderTemplateForm(c, models.NotificationTemplate{}, variants)
+}
+
+func (h *NotificationHandler) CreateTemplate(c echo.Context) error {
+	key, name, description, err := templateForm(c)
+	if err != nil {
+		return err
+	}
+	variants, err := templateVariantsForm(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	err = tx.QueryRow(ctx,
+		`INSERT INTO iraven.notification_templates (key, name, description, version, created_by)
+		VALUES ($1, $2, $3, 1, $4) RETURNING id`,
+		key, name, description, currentUserIDPtr(c)).Scan(&id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to create template: "+err.Error())
+	}
+	if err := saveTemplateVersion(ctx, tx, id, 1, variants, currentUserIDPtr(c)); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to create template: "+err.Error())
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/notifications/templates/%d", id))
+}
+
+func (h *NotificationHandler) EditTemplate(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	t, err := h.getTemplate(id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Template not found")
+	}
+	content, err := h.templateVersion(context.Background(), t.ID, t.Version)
+	if err != nil {
+		return err
+	}
+
+	return h.renderTemplateForm(c, *t, content.Variants)
+}
+
+// UpdateTemplate saves a template's details. Changed variants are saved as
+// a new version, which becomes current.
+func (h *NotificationHandler) UpdateTemplate(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	t, err := h.getTemplate(id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Template not found")
+	}
+	key, name, description, err := templateForm(c)
+	if err != nil {
+		return err
+	}
+	variants, err := templateVariantsForm(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	current, err := h.templateVersion(ctx, t.ID, t.Version)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	version := t.Version
+	if !sameVariants(current.Variants, variants) {
+		version, err = nextTemplateVersion(ctx, tx, t.ID)
+		if err == nil {
+			err = saveTemplateVersion(ctx, tx, t.ID, version, variants, currentUserIDPtr(c))
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to save template: "+err.Error())
+		}
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE iraven.notification_templates
+		SET key = $1, name = $2, description = $3, version = $4, updated_at = NOW()
+		WHERE id = $5`, key, name, description, version, t.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to update template: "+err.Error())
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/notifications/templates/%d", t.ID))
+}
+
+// RestoreTemplateVersion makes an earlier version current again by saving
+// a copy of it as a new version.
+func (h *NotificationHandler) RestoreTemplateVersion(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	restore, _ := strconv.Atoi(c.Param("version"))
+
+	ctx := context.Background()
+	old, err := h.templateVersion(ctx, id, restore)
+	if err != nil || len(old.Variants) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "Version not found")
+	}
+
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	version, err := nextTemplateVersion(ctx, tx, id)
+	if err == nil {
+		err = saveTemplateVersion(ctx, tx, id, version, old.Variants, currentUserIDPtr(c))
+	}
+	if err == nil {
+		_, err = tx.Exec(ctx,
+			"UPDATE iraven.notification_templates SET version = $1, updated_at = NOW() WHERE id = $2", version, id)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to restore version: "+err.Error())
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/notifications/templates/%d", id))
+}
+
+func (h *NotificationHandler) DeleteTemplate(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	_, err := h.db.Pool.Exec(context.Background(), "DELETE FROM iraven.notification_templates WHERE id = $1", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to delete template: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, "/notifications/templates")
+}
+
+// PreviewTemplate renders a version of a template for a real user, in the
+// language they would receive it in.
+func (h *NotificationHandler) PreviewTemplate(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	ctx := context.Background()
+
+	t, err := h.getTemplate(id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Template not found")
+	}
+	version, _ := strconv.Atoi(c.QueryParam("version"))
+	if version == 0 {
+		version = t.Version
+	}
+	content, err := h.templateVersion(ctx, t.ID, version)
+	if err != nil || len(content.Variants) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "Version not found")
+	}
+
+	user := strings.TrimSpace(c.QueryParam("user"))
+	rawData := strings.TrimSpace(c.QueryParam("data"))
+	data := map[string]interface{}{
+		"Title":    "Preview Template",
+		"Template": t,
+		"Version":  version,
+		"User":     user,
+		"Data":     rawData,
+	}
+
+	if user != "" {
+		var userID int64
+		if n, err := strconv.ParseInt(user, 10, 64); err == nil {
+			userID = n
+		} else {
+			h.db.Pool.QueryRow(ctx, "SELECT id FROM iraven.users WHERE lower(email) = lower($1)", user).Scan(&userID)
+		}
+
+		people, err := h.recipientVars(ctx, []int64{userID})
+		if err != nil {
+			return err
+		}
+		p, ok := people[userID]
+		if !ok {
+			data["Error"] = "User not found"
+			return c.Render(http.StatusOK, "notifications/template_preview", data)
+		}
+		p.Vars.Data = notify.DataVars(rawData)
+
+		variant := content.variant(p.Chain)
+		msg, err := notify.Render(variant.Title, variant.Body, p.Vars)
+		if err != nil {
+			data["Error"] = err.Error()
+		}
+		data["Recipient"] = p.Vars
+		data["Chain"] = p.Chain
+		data["Variant"] = variant
+		data["Message"] = msg
+	}
+
+	return c.Render(http.StatusOK, "notifications/template_preview", data)
+}
+
+func (h *NotificationHandler) renderTemplateForm(c echo.Context, t models.NotificationTemplate, variants []models.NotificationTemplateVariant) error {
+	languages, err := listLanguages(h.db)
+	if err != nil {
+		return err
+	}
+
+	var versions []TemplateVersionSummary
+	if t.ID != 0 {
+		versions, err = h.templateVersions(t.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	rows := make([]TemplateVariantRow, len(variants))
+	for i, v := range variants {
+		rows[i] = TemplateVariantRow{NotificationTemplateVariant: v, Languages: languages}
+	}
+
+	title := "New Template"
+	if t.ID != 0 {
+		title = "Edit Template"
+	}
+
+	data := map[string]interface{}{
+		"Title":        title,
+		"Template":     t,
+		"Variants":     rows,
+		"BlankVariant": TemplateVariantRow{Languages: languages},
+		"Versions":     versions,
+	}
+
+	return c.Render(http.StatusOK, "notifications/template_form", data)
+}
+
+func (h *NotificationHandler) getTemplate(id int64) (*models.NotificationTemplate, error) {
+	var t models.NotificationTemplate
+	err := h.db.Pool.QueryRow(context.Background(),
+		`SELECT id, key, name, description, version, created_by, created_at, updated_at
+		FROM iraven.notification_templates WHERE id = $1`, id).
+		Scan(&t.ID, &t.Key, &t.Name, &t.Description, &t.Version, &t.CreatedBy, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// templateByKey loads the current version of a template.
+func (h *NotificationHandler) templateByKey(ctx context.Context, key string) (*templateContent, error) {
+	var id int64
+	var version int
+	err := h.db.Pool.QueryRow(ctx,
+		"SELECT id, version FROM iraven.notification_templates WHERE key = $1", key).Scan(&id, &version)
+	if err != nil {
+		return nil, err
+	}
+	t, err := h.templateVersion(ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
+	if len(t.Variants) == 0 {
+		return nil, fmt.Errorf("template %q has no variants", key)
+	}
+	t.Key = key
+	return t, nil
+}
+
+func (h *NotificationHandler) templateVersion(ctx context.Context, templateID int64, version int) (*templateContent, error) {
+	rows, err := h.db.Pool.Query(ctx,
+		`SELECT tv.version_id, tv.language_id, l.code, l.name, tv.title, tv.body
+		FROM iraven.notification_template_versions v
+		INNER JOIN iraven.notification_template_variants tv ON tv.version_id = v.id
+		INNER JOIN iraven.languages l ON l.id = tv.language_id
+		WHERE v.template_id = $1 AND v.version = $2
+		ORDER BY l.name`, templateID, version)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	t := &templateContent{TemplateID: templateID, Version: version}
+	for rows.Next() {
+		var v models.NotificationTemplateVariant
+		if err := rows.Scan(&v.VersionID, &v.LanguageID, &v.LanguageCode, &v.LanguageName, &v.Title, &v.Body); err != nil {
+			continue
+		}
+		t.Variants = append(t.Variants, v)
+	}
+	return t, rows.Err()
+}
+
+func (h *NotificationHandler) templateVersions(templateID int64) ([]TemplateVersionSummary, error) {
+	rows, err := h.db.Pool.Query(context.Background(),
+		`SELECT v.id, v.version, v.author_id, u.email, v.created_at,
+			(SELECT COUNT(*) FROM iraven.notification_template_variants tv WHERE tv.version_id = v.id)
+		FROM iraven.notification_template_versions v
+		LEFT JOIN iraven.users u ON u.id = v.author_id
+		WHERE v.template_id = $1
+		ORDER BY v.version DESC`, templateID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []TemplateVersionSummary
+	for rows.Next() {
+		var v TemplateVersionSummary
+		if err := rows.Scan(&v.ID, &v.Version, &v.AuthorID, &v.AuthorEmail, &v.CreatedAt, &v.Languages); err != nil {
+			continue
+		}
+		v.TemplateID = templateID
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// listTemplates lists templates by key, for choosing one in the composer.
+func (h *NotificationHandler) listTemplates() ([]models.NotificationTemplate, error) {
+	rows, err := h.db.Pool.Query(context.Background(),
+		"SELECT id, key, name, version FROM iraven.notification_templates ORDER BY key")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []models.NotificationTemplate
+	for rows.Next() {
+		var t models.NotificationTemplate
+		if err := rows.Scan(&t.ID, &t.Key, &t.Name, &t.Version); err != nil {
+			continue
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+func nextTemplateVersion(ctx context.Context, q querier, templateID int64) (int, error) {
+	var version int
+	err := q.QueryRow(ctx,
+		`SELECT COALESCE(MAX(version), 0) + 1 FROM iraven.notification_template_versions WHERE template_id = $1`,
+		templateID).Scan(&version)
+	return version, err
+}
+
+func saveTemplateVersion(ctx context.Context, q querier, templateID int64, version int, variants []models.NotificationTemplateVariant, authorID *int64) error {
+	var versionID int64
+	err := q.QueryRow(ctx,
+		`INSERT INTO iraven.notification_template_versions (template_id, version, author_id)
+		VALUES ($1, $2, $3) RETURNING id`, templateID, version, authorID).Scan(&versionID)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range variants {
+		_, err := q.Exec(ctx,
+			`INSERT INTO iraven.notification_template_variants (version_id, language_id, title, body)
+			VALUES ($1, $2, $3, $4)`, versionID, v.LanguageID, v.Title, v.Body)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameVariants reports whether two sets of variants have the same text in
+// the same languages.
+func sameVariants(a, b []models.NotificationTemplateVariant) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	byLanguage := map[int64]models.NotificationTemplateVariant{}
+	for _, v := range a {
+		byLanguage[v.LanguageID] = v
+	}
+	for _, v := range b {
+		old, ok := byLanguage[v.LanguageID]
+		if !ok || old.Title != v.Title || old.Body != v.Body {
+			return false
+		}
+	}
+	return true
+}
+
+func templateForm(c echo.Context) (string, string, *string, error) {
+	key := strings.ToLower(strings.TrimSpace(c.FormValue("key")))
+	name := strings.TrimSpace(c.FormValue("name"))
+	if key == "" || name == "" {
+		return "", "", nil, echo.NewHTTPError(http.StatusBadRequest, "Key and name are required")
+	}
+	if !templateKeyPattern.MatchString(key) {
+		return "", "", nil, echo.NewHTTPError(http.StatusBadRequest,
+			"Key may only contain lowercase letters, digits, dots, dashes and underscores")
+	}
+	var description *string
+	if d := strings.TrimSpace(c.FormValue("description")); d != "" {
+		description = &d
+	}
+	return key, name, description, nil
+}
+
+// templateVariantsForm reads the variant rows of the template form. Rows
+// left blank are ignored; every other row needs a language, a title and a
+// body that render.
+func templateVariantsForm(c echo.Context) ([]models.NotificationTemplateVariant, error) {
+	form, err := c.FormParams()
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid form")
+	}
+	languageIDs := form["variant_language_id"]
+	titles := form["variant_title"]
+	bodies := form["variant_body"]
+	if len(titles) != len(languageIDs) || len(bodies) != len(languageIDs) {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid form")
+	}
+
+	var variants []models.NotificationTemplateVariant
+	seen := map[int64]bool{}
+	for i := range languageIDs {
+		title := strings.TrimSpace(titles[i])
+		body := strings.TrimSpace(bodies[i])
+		if title == "" && body == "" {
+			continue
+		}
+		languageID, _ := strconv.ParseInt(languageIDs[i], 10, 64)
+		if languageID == 0 || title == "" || body == "" {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "Every variant needs a language, a title and a body")
+		}
+		if seen[languageID] {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "Each language may only have one variant")
+		}
+		seen[languageID] = true
+		if err := notify.Check(title, body); err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Variant %d has an invalid placeholder: %v", i+1, err))
+		}
+		variants = append(variants, models.NotificationTemplateVariant{LanguageID: languageID, Title: title, Body: body})
+	}
+	if len(variants) == 0 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Add at least one language")
+	}
+
+	sort.Slice(variants, func(i, j int) bool { return variants[i].LanguageID < variants[j].LanguageID })
+	return variants, nil
+}
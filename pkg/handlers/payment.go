@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iraven/iraven-admin/pkg/billing"
+	"github.com/iraven/iraven-admin/pkg/database"
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/iraven/iraven-admin/pkg/money"
+	"github.com/labstack/echo/v4"
+)
+
+const stripeDashboardURL = "https://dashboard.stripe.com"
+
+type PaymentHandler struct {
+	db            *database.Database
+	provider      billing.Provider
+	webhookSecret string
+}
+
+func NewPaymentHandler(db *database.Database, provider billing.Provider, webhookSecret string) *PaymentHandler {
+	return &PaymentHandler{db: db, provider: provider, webhookSecret: webhookSecret}
+}
+
+// PaymentFilter holds the payment list filters as entered. Amounts are in
+// major units of each payment's currency.
+type PaymentFilter struct {
+	Status    string
+	Currency  string
+	From      string
+	To        string
+	User      string
+	MinAmount string
+	MaxAmount string
+}
+
+// where turns the filter into a condition on iraven.payments aliased p
+// joined to iraven.users aliased u, with its parameters appended to args.
+func (f PaymentFilter) where(args []interface{}) (string, []interface{}, error) {
+	conditions := []string{"TRUE"}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	if f.Status != "" {
+		add("p.status = ?", f.Status)
+	}
+	if f.Currency != "" {
+		add("upper(p.currency) = upper(?)", f.Currency)
+	}
+	if f.From != "" {
+		from, err := time.Parse("2006-01-02", f.From)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid from date")
+		}
+		add("p.created_at >= ?", from)
+	}
+	if f.To != "" {
+		to, err := time.Parse("2006-01-02", f.To)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid to date")
+		}
+		add("p.created_at < ?", to.AddDate(0, 0, 1))
+	}
+	if f.User != "" {
+		if id, err := strconv.ParseInt(f.User, 10, 64); err == nil {
+			add("p.user_id = ?", id)
+		} else {
+			add("u.email ILIKE '%' || ? || '%'", f.User)
+		}
+	}
+	for _, bound := range []struct{ value, op string }{{f.MinAmount, ">="}, {f.MaxAmount, "<="}} {
+		if bound.value == "" {
+			continue
+		}
+		if _, err := strconv.ParseFloat(bound.value, 64); err != nil {
+			return "", nil, fmt.Errorf("invalid amount %q", bound.value)
+		}
+		add("p.amount "+bound.op+" ?::text::numeric * "+minorUnitScale("p.currency"), bound.value)
+	}
+
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+// minorUnitScale is an SQL expression for the number of minor units in a
+// major unit of the currency in column.
+func minorUnitScale(column string) string {
+	exponents := money.Exponents()
+	currencies := make([]string, 0, len(exponents))
+	for currency := range exponents {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	var b strings.Builder
+	b.WriteString("CASE upper(" + column + ")")
+	for _, currency := range currencies {
+		scale := 1
+		for i := 0; i < exponents[currency]; i++ {
+			scale *= 10
+		}
+		fmt.Fprintf(&b, " WHEN '%s' THEN %d", currency, scale)
+	}
+	b.WriteString(" ELSE 100 END")
+	return b.String()
+}
+
+// CurrencyTotal sums payments in one currency.
+type CurrencyTotal struct {
+	Currency string
+	Count    int
+	Amount   int64
+}
+
+func (h *PaymentHandler) List(c echo.Context) error {
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize := 20
+
+	filter := PaymentFilter{
+		Status:    c.QueryParam("status"),
+		Currency:  strings.ToUpper(strings.TrimSpace(c.QueryParam("currency"))),
+		From:      c.QueryParam("from"),
+		To:        c.QueryParam("to"),
+		User:      strings.TrimSpace(c.QueryParam("user")),
+		MinAmount: strings.TrimSpace(c.QueryParam("min")),
+		MaxAmount: strings.TrimSpace(c.QueryParam("max")),
+	}
+	where, args, err := filter.where(nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid filter: "+err.Error())
+	}
+
+	payments, err := queryPayments(h.db, fmt.Sprintf("WHERE %s ORDER BY p.created_at DESC LIMIT %d OFFSET %d",
+		where, pageSize, (page-1)*pageSize), args...)
+	if err != nil {
+		return err
+	}
+
+	totals, err := paymentTotals(h.db, where, args...)
+	if err != nil {
+		return err
+	}
+	var total int
+	for _, t := range totals {
+		total += t.Count
+	}
+
+	statuses, _ := distinctPaymentValues(h.db, "status")
+	currencies, _ := distinctPaymentValues(h.db, "upper(currency)")
+
+	data := map[string]interface{}{
+		"Title":      "Payments",
+		"Payments":   payments,
+		"Filter":     filter,
+		"Totals":     totals,
+		"Total":      total,
+		"Statuses":   statuses,
+		"Currencies": currencies,
+		"Page":       page,
+		"TotalPages": (total + pageSize - 1) / pageSize,
+	}
+
+	return c.Render(http.StatusOK, "payments/list", data)
+}
+
+func (h *PaymentHandler) Show(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	payments, err := queryPayments(h.db, "WHERE p.id = $1", id)
+	if err != nil {
+		return err
+	}
+	if len(payments) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "Payment not found")
+	}
+	p := payments[0]
+
+	others, err := queryPayments(h.db,
+		"WHERE p.user_id = $1 AND p.id <> $2 ORDER BY p.created_at DESC LIMIT 10", p.UserID, p.ID)
+	if err != nil {
+		return err
+	}
+
+	actions, err := paymentActions(h.db, p.ID)
+	if err != nil {
+		return err
+	}
+	refunded := refundedAmount(actions)
+
+	events, err := stripeEvents(h.db, p.ID)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{
+		"Title":      "Payment",
+		"Payment":    p,
+		"Others":     others,
+		"StripeURL":  stripePaymentURL(p.StripePaymentID),
+		"Actions":    actions,
+		"Events":     events,
+		"Refunded":   refunded,
+		"Refundable": p.Amount - refunded,
+		"CanRefund":  h.provider != nil && refundable(p.Status) && p.Amount > refunded && p.StripePaymentID != "",
+		"CanCancel":  h.provider != nil && cancelable(p.Status),
+		"NoProvider": h.provider == nil,
+		"AmountStep": amountStep(p.Currency),
+		"Result":     c.QueryParam("action"),
+	}
+
+	return c.Render(http.StatusOK, "payments/show", data)
+}
+
+// stripePaymentURL links a payment intent or charge to the Stripe
+// dashboard.
+func stripePaymentURL(stripeID string) string {
+	if stripeID == "" {
+		return ""
+	}
+	return stripeDashboardURL + "/payments/" + stripeID
+}
+
+// queryPayments lists payments with their users. clause follows the FROM,
+// with payments aliased p and users u.
+func queryPayments(db *database.Database, clause string, args ...interface{}) ([]models.PaymentWithUser, error) {
+	rows, err := db.Pool.Query(context.Background(),
+		`SELECT p.id, p.user_id, p.amount, p.currency, p.status, COALESCE(p.stripe_payment_id, ''), p.description,
+			p.created_at, p.updated_at, COALESCE(u.email, ''), COALESCE(u.name, '')
+		FROM iraven.payments p
+		LEFT JOIN iraven.users u ON u.id = p.user_id `+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []models.PaymentWithUser
+	for rows.Next() {
+		var p models.PaymentWithUser
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Amount, &p.Currency, &p.Status, &p.StripePaymentID, &p.Description,
+			&p.CreatedAt, &p.UpdatedAt, &p.UserEmail, &p.UserName); err != nil {
+			continue
+		}
+		payments = append(payments, p)
+	}
+	return payments, nil
+}
+
+// paymentTotals sums the payments matching where per currency.
+func paymentTotals(db *database.Database, where string, args ...interface{}) ([]CurrencyTotal, error) {
+	rows, err := db.Pool.Query(context.Background(),
+		`SELECT upper(p.currency), COUNT(*), COALESCE(SUM(p.amount), 0)
+		FROM iraven.payments p
+		LEFT JOIN iraven.users u ON u.id = p.user_id
+		WHERE `+where+`
+		GROUP BY 1 ORDER BY 2 DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []CurrencyTotal
+	for rows.Next() {
+		var t CurrencyTotal
+		if err := rows.Scan(&t.Currency, &t.Count, &t.Amount); err != nil {
+			continue
+		}
+		totals = append(totals, t)
+	}
+	return totals, nil
+}
+
+// distinctPaymentValues lists the values of a payments column in use, for
+// filter options.
+func distinctPaymentValues(db *database.Database, column string) ([]string, error) {
+	rows, err := db.Pool.Query(context.Background(),
+		"SELECT DISTINCT "+column+" FROM iraven.payments ORDER BY 1")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			continue
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iraven/iraven-admin/pkg/billing"
+	"github.com/iraven/iraven-admin/pkg/database"
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/iraven/iraven-admin/pkg/money"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+const paymentActionTimeout = 30 * time.Second
+
+// lockedPayment is a payment row locked for an action on it.
+type lockedPayment struct {
+	ID       int64
+	Amount   int64
+	Currency string
+	Status   string
+	StripeID string
+}
+
+// errNoPaymentProvider refuses payment actions when the payments
+// configuration is incomplete.
+var errNoPaymentProvider = echo.NewHTTPError(http.StatusServiceUnavailable,
+	"Refunds and cancellations are disabled: no payment provider is configured")
+
+// Refund returns all or part of a succeeded payment through the payment
+// provider. The amount is in major units and defaults to what has not been
+// refunded yet. The attempt is recorded in the payment's audit trail,
+// whether or not the provider accepts it.
+func (h *PaymentHandler) Refund(c echo.Context) error {
+	if h.provider == nil {
+		return errNoPaymentProvider
+	}
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	reason := strings.TrimSpace(c.FormValue("reason"))
+	if reason == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "A reason is required")
+	}
+	ctx := context.Background()
+
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	p, err := lockPayment(ctx, tx, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Payment not found")
+	}
+	if !refundable(p.Status) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Payments with status "+p.Status+" cannot be refunded")
+	}
+	if p.StripeID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Payment has no Stripe payment to refund")
+	}
+
+	var refunded int64
+	err = tx.QueryRow(ctx,
+		`SELECT COALESCE(SUM(amount), 0) FROM iraven.payment_actions
+		WHERE payment_id = $1 AND action = 'refund' AND status = 'succeeded'`, id).Scan(&refunded)
+	if err != nil {
+		return err
+	}
+	remaining := p.Amount - refunded
+
+	amount := remaining
+	if v := strings.TrimSpace(c.FormValue("amount")); v != "" {
+		amount, err = money.Parse(v, p.Currency)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid refund amount: "+err.Error())
+		}
+	}
+	if amount <= 0 || amount > remaining {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			"Refund amount must be more than zero and at most "+money.Format(remaining, p.Currency))
+	}
+
+	actionCtx, cancel := context.WithTimeout(ctx, paymentActionTimeout)
+	refund, refundErr := h.provider.Refund(actionCtx, billing.RefundRequest{
+		PaymentID: p.StripeID,
+		Amount:    amount,
+		Currency:  p.Currency,
+		Reason:    reason,
+		// A resubmitted form refunds once
+		IdempotencyKey: fmt.Sprintf("payment-%d-refund-%d-%d", id, refunded, amount),
+	})
+	cancel()
+
+	err = h.recordAction(ctx, tx, c, p, "refund", amount, reason, refund.ID, refundErr)
+	if err != nil {
+		return err
+	}
+	if refundErr == nil {
+		status := "partially_refunded"
+		if amount == remaining {
+			status = "refunded"
+		}
+		if err := setPaymentStatus(ctx, tx, id, status); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/payments/%d?action=%s#actions", id, actionResult("refunded", refundErr)))
+}
+
+// Cancel stops a payment that has not completed. Payments never created
+// at the provider are canceled here only.
+func (h *PaymentHandler) Cancel(c echo.Context) error {
+	if h.provider == nil {
+		return errNoPaymentProvider
+	}
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	reason := strings.TrimSpace(c.FormValue("reason"))
+	if reason == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "A reason is required")
+	}
+	ctx := context.Background()
+
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	p, err := lockPayment(ctx, tx, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Payment not found")
+	}
+	if !cancelable(p.Status) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Payments with status "+p.Status+" cannot be canceled")
+	}
+
+	var cancelErr error
+	if p.StripeID != "" {
+		actionCtx, cancel := context.WithTimeout(ctx, paymentActionTimeout)
+		cancelErr = h.provider.Cancel(actionCtx, p.StripeID, fmt.Sprintf("payment-%d-cancel", id))
+		cancel()
+	}
+
+	if err := h.recordAction(ctx, tx, c, p, "cancel", 0, reason, "", cancelErr); err != nil {
+		return err
+	}
+	if cancelErr == nil {
+		if err := setPaymentStatus(ctx, tx, id, "canceled"); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/payments/%d?action=%s#actions", id, actionResult("canceled", cancelErr)))
+}
+
+// recordAction adds an action to the payment's audit trail.
+func (h *PaymentHandler) recordAction(ctx context.Context, q querier, c echo.Context, p lockedPayment,
+	action string, amount int64, reason, providerRef string, actionErr error) error {
+	status := "succeeded"
+	var errText *string
+	if actionErr != nil {
+		status = "failed"
+		msg := actionErr.Error()
+		errText = &msg
+	}
+	var ref *string
+	if providerRef != "" {
+		ref = &providerRef
+	}
+
+	_, err := q.Exec(ctx,
+		`INSERT INTO iraven.payment_actions
+			(payment_id, action, amount, currency, reason, status, provider, provider_ref, error, performed_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		p.ID, action, amount, p.Currency, reason, status, h.provider.Name(), ref, errText, currentUserIDPtr(c))
+	return err
+}
+
+func lockPayment(ctx context.Context, tx pgx.Tx, id int64) (lockedPayment, error) {
+	var p lockedPayment
+	err := tx.QueryRow(ctx,
+		`SELECT id, amount, currency, status, COALESCE(stripe_payment_id, '')
+		FROM iraven.payments WHERE id = $1 FOR UPDATE`, id).
+		Scan(&p.ID, &p.Amount, &p.Currency, &p.Status, &p.StripeID)
+	return p, err
+}
+
+func setPaymentStatus(ctx context.Context, q querier, id int64, status string) error {
+	_, err := q.Exec(ctx, "UPDATE iraven.payments SET status = $2, updated_at = NOW() WHERE id = $1", id, status)
+	return err
+}
+
+// paymentActions lists a payment's audit trail, newest first.
+func paymentActions(db *database.Database, paymentID int64) ([]models.PaymentAction, error) {
+	rows, err := db.Pool.Query(context.Background(),
+		`SELECT a.id, a.payment_id, a.action, a.amount, a.currency, a.reason, a.status, a.provider,
+			a.provider_ref, a.error, a.performed_by, a.created_at, COALESCE(u.email, '')
+		FROM iraven.payment_actions a
+		LEFT JOIN iraven.users u ON u.id = a.performed_by
+		WHERE a.payment_id = $1
+		ORDER BY a.created_at DESC, a.id DESC`, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var actions []models.PaymentAction
+	for rows.Next() {
+		var a models.PaymentAction
+		if err := rows.Scan(&a.ID, &a.PaymentID, &a.Action, &a.Amount, &a.Currency, &a.Reason, &a.Status, &a.Provider,
+			&a.ProviderRef, &a.Error, &a.PerformedBy, &a.CreatedAt, &a.PerformerEmail); err != nil {
+			continue
+		}
+		actions = append(actions, a)
+	}
+	return actions, nil
+}
+
+// refundedAmount sums the succeeded refunds in an audit trail.
+func refundedAmount(actions []models.PaymentAction) int64 {
+	var total int64
+	for _, a := range actions {
+		if a.Action == "refund" && a.Status == "succeeded" {
+			total += a.Amount
+		}
+	}
+	return total
+}
+
+func refundable(status string) bool {
+	return status == "succeeded" || status == "partially_refunded"
+}
+
+func cancelable(status string) bool {
+	return status == "pending" || status == "processing"
+}
+
+// actionResult is the outcome passed back to the payment page.
+func actionResult(done string, err error) string {
+	if err != nil {
+		return "failed"
+	}
+	return done
+}
+
+// amountStep is the smallest amount of currency in major units, for
+// amount inputs.
+func amountStep(currency string) string {
+	exp := money.Exponent(currency)
+	if exp == 0 {
+		return "1"
+	}
+	return "0." + strings.Repeat("0", exp-1) + "1"
+}
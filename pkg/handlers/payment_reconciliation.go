@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iraven/iraven-admin/pkg/billing"
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+const reconciliationMaxBytes = 20 << 20
+
+// IssueCount is the number of issues of one kind in a reconciliation.
+type IssueCount struct {
+	Kind  string
+	Count int
+}
+
+// Reconciliations lists past reconciliations with the upload form.
+func (h *PaymentHandler) Reconciliations(c echo.Context) error {
+	rows, err := h.db.Pool.Query(context.Background(),
+		`SELECT `+reconciliationColumns+`
+		FROM iraven.payment_reconciliations
+		ORDER BY created_at DESC
+		LIMIT 50`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var runs []models.PaymentReconciliation
+	for rows.Next() {
+		r, err := scanReconciliation(rows)
+		if err != nil {
+			continue
+		}
+		runs = append(runs, r)
+	}
+
+	data := map[string]interface{}{
+		"Title":           "Payment Reconciliation",
+		"Reconciliations": runs,
+	}
+
+	return c.Render(http.StatusOK, "payments/reconciliations", data)
+}
+
+// UploadReconciliation queues a Stripe balance export for comparison with
+// the payments table. The file is checked here so that a wrong export is
+// rejected straight away.
+func (h *PaymentHandler) UploadReconciliation(c echo.Context) error {
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Choose a Stripe balance export")
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	content, err := io.ReadAll(io.LimitReader(f, reconciliationMaxBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(content) > reconciliationMaxBytes {
+		return echo.NewHTTPError(http.StatusBadRequest, "The export is larger than 20 MB; upload a shorter period")
+	}
+	if _, err := billing.ParseBalanceCSV(bytes.NewReader(content)); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to read "+fh.Filename+": "+err.Error())
+	}
+
+	var id int64
+	err = h.db.Pool.QueryRow(context.Background(),
+		`INSERT INTO iraven.payment_reconciliations (file_name, csv, created_by)
+		VALUES ($1, $2, $3) RETURNING id`,
+		fh.Filename, string(content), currentUserIDPtr(c)).Scan(&id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to queue reconciliation: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/payments/reconciliations/%d", id))
+}
+
+// Reconciliation shows the issues a reconciliation found, optionally of
+// one kind.
+func (h *PaymentHandler) Reconciliation(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	kind := c.QueryParam("kind")
+	ctx := context.Background()
+
+	run, err := scanReconciliation(h.db.Pool.QueryRow(ctx,
+		`SELECT `+reconciliationColumns+` FROM iraven.payment_reconciliations WHERE id = $1`, id))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Reconciliation not found")
+	}
+
+	counts := make([]IssueCount, 0, len(billing.IssueKinds))
+	byKind := map[string]int{}
+	rows, err := h.db.Pool.Query(ctx,
+		`SELECT kind, COUNT(*) FROM iraven.payment_reconciliation_issues
+		WHERE reconciliation_id = $1 GROUP BY kind`, id)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var k string
+		var n int
+		if err := rows.Scan(&k, &n); err != nil {
+			continue
+		}
+		byKind[k] = n
+	}
+	rows.Close()
+	for _, k := range billing.IssueKinds {
+		counts = append(counts, IssueCount{Kind: k, Count: byKind[k]})
+	}
+
+	rows, err = h.db.Pool.Query(ctx,
+		`SELECT id, reconciliation_id, kind, stripe_id, payment_id, expected, actual
+		FROM iraven.payment_reconciliation_issues
+		WHERE reconciliation_id = $1 AND ($2 = '' OR kind = $2)
+		ORDER BY kind, id
+		LIMIT 1000`, id, kind)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var issues []models.PaymentReconciliationIssue
+	for rows.Next() {
+		var i models.PaymentReconciliationIssue
+		if err := rows.Scan(&i.ID, &i.ReconciliationID, &i.Kind, &i.StripeID, &i.PaymentID, &i.Expected, &i.Actual); err != nil {
+			continue
+		}
+		issues = append(issues, i)
+	}
+
+	data := map[string]interface{}{
+		"Title":          "Payment Reconciliation",
+		"Reconciliation": run,
+		"Counts":         counts,
+		"Issues":         issues,
+		"Kind":           kind,
+	}
+
+	return c.Render(http.StatusOK, "payments/reconciliation", data)
+}
+
+// ReconcileQueued runs the queued reconciliations, oldest first.
+func (h *PaymentHandler) ReconcileQueued(ctx context.Context) error {
+	for {
+		var id int64
+		var content string
+		err := h.db.Pool.QueryRow(ctx,
+			`UPDATE iraven.payment_reconciliations SET status = 'running'
+			WHERE id = (
+				SELECT id FROM iraven.payment_reconciliations
+				WHERE status = 'queued'
+				ORDER BY id
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, csv`).Scan(&id, &content)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := h.reconcile(ctx, id, content); err != nil {
+			log.Printf("payments: reconciliation %d failed: %v", id, err)
+			h.db.Pool.Exec(ctx,
+				`UPDATE iraven.payment_reconciliations SET status = 'failed', error = $2, finished_at = NOW()
+				WHERE id = $1`, id, err.Error())
+		}
+	}
+}
+
+// reconcile compares one export with the payments created in its period
+// and those it refers to, and stores the issues found.
+func (h *PaymentHandler) reconcile(ctx context.Context, id int64, content string) error {
+	rows, err := billing.ParseBalanceCSV(strings.NewReader(content))
+	if err != nil {
+		return err
+	}
+	first, last := billing.Period(rows)
+	from := first.Truncate(24 * time.Hour)
+	to := last.Truncate(24*time.Hour).AddDate(0, 0, 1)
+
+	var ids []string
+	for _, row := range rows {
+		for _, v := range []string{row.PaymentIntentID, row.SourceID} {
+			if v != "" {
+				ids = append(ids, v)
+			}
+		}
+	}
+
+	found, err := queryPayments(h.db,
+		"WHERE (p.created_at >= $1 AND p.created_at < $2) OR p.stripe_payment_id = ANY($3) ORDER BY p.id",
+		from, to, ids)
+	if err != nil {
+		return err
+	}
+	payments := make([]models.Payment, len(found))
+	for i, p := range found {
+		payments[i] = p.Payment
+	}
+
+	matched, issues := billing.Reconcile(rows, payments, from, to)
+
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	batch := &pgx.Batch{}
+	for _, issue := range issues {
+		var paymentID *int64
+		if issue.PaymentID != 0 {
+			paymentID = &issue.PaymentID
+		}
+		batch.Queue(
+			`INSERT INTO iraven.payment_reconciliation_issues
+				(reconciliation_id, kind, stripe_id, payment_id, expected, actual)
+			VALUES ($1, $2, NULLIF($3, ''), $4, NULLIF($5, ''), NULLIF($6, ''))`,
+			id, issue.Kind, issue.StripeID, paymentID, issue.Expected, issue.Actual)
+	}
+	batch.Queue(
+		`UPDATE iraven.payment_reconciliations
+		SET status = 'done', error = NULL, transactions = $2, period_from = $3, period_to = $4,
+			matched = $5, issues = $6, finished_at = NOW()
+		WHERE id = $1`, id, len(rows), first, last, matched, len(issues))
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+const reconciliationColumns = `id, file_name, status, error, transactions, period_from, period_to,
+	matched, issues, created_by, created_at, finished_at`
+
+func scanReconciliation(row pgx.Row) (models.PaymentReconciliation, error) {
+	var r models.PaymentReconciliation
+	err := row.Scan(&r.ID, &r.FileName, &r.Status, &r.Error, &r.Transactions, &r.PeriodFrom, &r.PeriodTo,
+		&r.Matched, &r.Issues, &r.CreatedBy, &r.CreatedAt, &r.FinishedAt)
+	return r, err
+}
@@ -0,0 +1,447 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/iraven/iraven-admin/pkg/money"
+	"github.com/labstack/echo/v4"
+)
+
+// reportPeriods are the windows the payment reports offer, in months back
+// from the start of this month; zero is all time.
+var reportPeriods = []int{1, 3, 12, 0}
+
+// reportGroupings are the revenue series intervals, as date_trunc units.
+var reportGroupings = []string{"day", "week", "month"}
+
+// RevenueTotals are the payment counts and amounts of one currency over
+// a period or one interval of it. Amounts are in minor units.
+type RevenueTotals struct {
+	Currency         string
+	Payments         int
+	Paid             int
+	RefundedPayments int
+	Failed           int
+	Gross            int64
+	Refunded         int64
+}
+
+// Net is the paid amount less refunds. Stripe's fees are not known here.
+func (t RevenueTotals) Net() int64 {
+	return t.Gross - t.Refunded
+}
+
+// RefundRate is the share of paid payments that were refunded, in full or
+// in part.
+func (t RevenueTotals) RefundRate() int {
+	return percent(t.RefundedPayments, t.Paid)
+}
+
+// FailureRate is the share of attempted payments that failed.
+func (t RevenueTotals) FailureRate() int {
+	return percent(t.Failed, t.Paid+t.Failed)
+}
+
+// RevenuePoint is one interval of the revenue series, with bar heights
+// relative to the largest interval.
+type RevenuePoint struct {
+	Start time.Time
+	RevenueTotals
+	GrossHeight int
+	NetHeight   int
+}
+
+// UserRevenue is what one user paid.
+type UserRevenue struct {
+	UserID   int64
+	Email    string
+	Name     string
+	Payments int
+	Gross    int64
+	Refunded int64
+}
+
+func (u UserRevenue) Net() int64 {
+	return u.Gross - u.Refunded
+}
+
+// ApplicationRevenue is what the users of an application paid. A user of
+// several applications counts towards each.
+type ApplicationRevenue struct {
+	ID       int64
+	Name     string
+	Users    int
+	Payments int
+	Gross    int64
+	Refunded int64
+}
+
+func (a ApplicationRevenue) Net() int64 {
+	return a.Gross - a.Refunded
+}
+
+// reportParams are the report filters of a request.
+type reportParams struct {
+	Months   int
+	By       string
+	Currency string
+	// Since is the first day covered, or nil for all time
+	Since *time.Time
+}
+
+func parseReportParams(c echo.Context) reportParams {
+	p := reportParams{Months: 3, Currency: c.QueryParam("currency"), By: c.QueryParam("by")}
+	if v := c.QueryParam("months"); v != "" {
+		months, _ := strconv.Atoi(v)
+		for _, period := range reportPeriods {
+			if months == period {
+				p.Months = months
+			}
+		}
+	}
+	if p.Months > 0 {
+		now := time.Now().UTC()
+		since := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1-p.Months, 0)
+		p.Since = &since
+	}
+
+	valid := false
+	for _, g := range reportGroupings {
+		valid = valid || p.By == g
+	}
+	if !valid {
+		switch p.Months {
+		case 1:
+			p.By = "day"
+		case 3:
+			p.By = "week"
+		default:
+			p.By = "month"
+		}
+	}
+	return p
+}
+
+// Reports shows revenue over time and by currency, refund and failure
+// rates, top paying users and revenue per application. Everything is read
+// from the summaries, which are up to a refresh interval old.
+func (h *PaymentHandler) Reports(c echo.Context) error {
+	ctx := context.Background()
+	p := parseReportParams(c)
+
+	currencies, err := h.revenueByCurrency(ctx, p)
+	if err != nil {
+		return err
+	}
+	if p.Currency == "" && len(currencies) > 0 {
+		p.Currency = currencies[0].Currency
+	}
+
+	var totals RevenueTotals
+	for _, t := range currencies {
+		if t.Currency == p.Currency {
+			totals = t
+		}
+	}
+
+	series, err := h.revenueSeries(ctx, p)
+	if err != nil {
+		return err
+	}
+	users, err := h.topUsers(ctx, p, 10)
+	if err != nil {
+		return err
+	}
+	applications, err := h.revenueByApplication(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	var refreshedAt *time.Time
+	h.db.Pool.QueryRow(ctx, "SELECT MAX(refreshed_at) FROM iraven.payment_daily_summaries").Scan(&refreshedAt)
+
+	data := map[string]interface{}{
+		"Title":        "Payment Reports",
+		"Params":       p,
+		"Periods":      reportPeriods,
+		"Groupings":    reportGroupings,
+		"Currencies":   currencies,
+		"Totals":       totals,
+		"Series":       series,
+		"Users":        users,
+		"Applications": applications,
+		"RefreshedAt":  refreshedAt,
+	}
+
+	return c.Render(http.StatusOK, "payments/reports", data)
+}
+
+// ExportReport writes one report of the dashboard as CSV, with amounts in
+// major units.
+func (h *PaymentHandler) ExportReport(c echo.Context) error {
+	ctx := context.Background()
+	p := parseReportParams(c)
+	report := c.QueryParam("report")
+
+	var header []string
+	var records [][]string
+	switch report {
+	case "revenue":
+		series, err := h.revenueSeries(ctx, p)
+		if err != nil {
+			return err
+		}
+		header = []string{p.By, "currency", "payments", "paid", "refunded_payments", "failed", "gross", "refunded", "net"}
+		for _, s := range series {
+			records = append(records, append([]string{s.Start.Format("2006-01-02")}, totalsRecord(s.RevenueTotals)...))
+		}
+	case "currencies":
+		currencies, err := h.revenueByCurrency(ctx, p)
+		if err != nil {
+			return err
+		}
+		header = []string{"currency", "payments", "paid", "refunded_payments", "failed", "gross", "refunded", "net", "refund_rate", "failure_rate"}
+		for _, t := range currencies {
+			records = append(records, append(totalsRecord(t), strconv.Itoa(t.RefundRate()), strconv.Itoa(t.FailureRate())))
+		}
+	case "users":
+		users, err := h.topUsers(ctx, p, 1000)
+		if err != nil {
+			return err
+		}
+		header = []string{"user_id", "email", "name", "currency", "payments", "gross", "refunded", "net"}
+		for _, u := range users {
+			records = append(records, []string{strconv.FormatInt(u.UserID, 10), u.Email, u.Name, p.Currency,
+				strconv.Itoa(u.Payments), money.Major(u.Gross, p.Currency), money.Major(u.Refunded, p.Currency), money.Major(u.Net(), p.Currency)})
+		}
+	case "applications":
+		applications, err := h.revenueByApplication(ctx, p)
+		if err != nil {
+			return err
+		}
+		header = []string{"application_id", "application", "currency", "users", "payments", "gross", "refunded", "net"}
+		for _, a := range applications {
+			records = append(records, []string{strconv.FormatInt(a.ID, 10), a.Name, p.Currency, strconv.Itoa(a.Users),
+				strconv.Itoa(a.Payments), money.Major(a.Gross, p.Currency), money.Major(a.Refunded, p.Currency), money.Major(a.Net(), p.Currency)})
+		}
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "Unknown report")
+	}
+
+	since := "all"
+	if p.Since != nil {
+		since = p.Since.Format("2006-01-02")
+	}
+	filename := fmt.Sprintf("payments-%s-%s.csv", report, since)
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	c.Response().WriteHeader(http.StatusOK)
+
+	w := csv.NewWriter(c.Response())
+	w.Write(header)
+	w.WriteAll(records)
+	return w.Error()
+}
+
+// RefreshReports refreshes the summaries now rather than at the next
+// scheduled refresh.
+func (h *PaymentHandler) RefreshReports(c echo.Context) error {
+	if err := h.RefreshSummaries(context.Background()); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to refresh reports: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, "/payments/reports?"+c.QueryString())
+}
+
+// RefreshSummaries recomputes the materialized payment summaries. Readers
+// see the previous contents until it completes.
+func (h *PaymentHandler) RefreshSummaries(ctx context.Context) error {
+	for _, view := range []string{"iraven.payment_daily_summaries", "iraven.payment_monthly_user_summaries"} {
+		if _, err := h.db.Pool.Exec(ctx, "REFRESH MATERIALIZED VIEW CONCURRENTLY "+view); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func totalsRecord(t RevenueTotals) []string {
+	return []string{t.Currency, strconv.Itoa(t.Payments), strconv.Itoa(t.Paid), strconv.Itoa(t.RefundedPayments),
+		strconv.Itoa(t.Failed), money.Major(t.Gross, t.Currency), money.Major(t.Refunded, t.Currency), money.Major(t.Net(), t.Currency)}
+}
+
+const revenueTotalsColumns = `COALESCE(SUM(payments), 0)::bigint, COALESCE(SUM(paid), 0)::bigint,
+	COALESCE(SUM(refunded_payments), 0)::bigint, COALESCE(SUM(failed), 0)::bigint,
+	COALESCE(SUM(gross), 0)::bigint, COALESCE(SUM(refunded), 0)::bigint`
+
+// revenueByCurrency totals the period per currency, the currency with
+// the most paid payments first.
+func (h *PaymentHandler) revenueByCurrency(ctx context.Context, p reportParams) ([]RevenueTotals, error) {
+	rows, err := h.db.Pool.Query(ctx,
+		`SELECT currency, `+revenueTotalsColumns+`
+		FROM iraven.payment_daily_summaries
+		WHERE $1::date IS NULL OR day >= $1
+		GROUP BY currency
+		ORDER BY SUM(paid) DESC, currency`, p.Since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []RevenueTotals
+	for rows.Next() {
+		var t RevenueTotals
+		if err := rows.Scan(&t.Currency, &t.Payments, &t.Paid, &t.RefundedPayments, &t.Failed, &t.Gross, &t.Refunded); err != nil {
+			continue
+		}
+		totals = append(totals, t)
+	}
+	return totals, nil
+}
+
+// revenueSeries totals the period's payments in the report currency per
+// interval, including intervals without payments.
+func (h *PaymentHandler) revenueSeries(ctx context.Context, p reportParams) ([]RevenuePoint, error) {
+	rows, err := h.db.Pool.Query(ctx,
+		`SELECT date_trunc($1, day::timestamp)::date, `+revenueTotalsColumns+`
+		FROM iraven.payment_daily_summaries
+		WHERE currency = $2 AND ($3::date IS NULL OR day >= $3)
+		GROUP BY 1
+		ORDER BY 1`, p.By, p.Currency, p.Since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byStart := map[string]RevenuePoint{}
+	var first time.Time
+	for rows.Next() {
+		point := RevenuePoint{RevenueTotals: RevenueTotals{Currency: p.Currency}}
+		if err := rows.Scan(&point.Start, &point.Payments, &point.Paid, &point.RefundedPayments, &point.Failed,
+			&point.Gross, &point.Refunded); err != nil {
+			continue
+		}
+		if first.IsZero() {
+			first = point.Start
+		}
+		byStart[point.Start.Format("2006-01-02")] = point
+	}
+
+	start := first
+	if p.Since != nil {
+		start = truncateTo(*p.Since, p.By)
+	}
+	if start.IsZero() {
+		return nil, nil
+	}
+
+	var series []RevenuePoint
+	var max int64
+	for t := start; !t.After(time.Now()); t = nextInterval(t, p.By) {
+		point, ok := byStart[t.Format("2006-01-02")]
+		if !ok {
+			point = RevenuePoint{Start: t, RevenueTotals: RevenueTotals{Currency: p.Currency}}
+		}
+		if point.Gross > max {
+			max = point.Gross
+		}
+		series = append(series, point)
+	}
+	for i := range series {
+		if max > 0 {
+			series[i].GrossHeight = int(series[i].Gross * 100 / max)
+			series[i].NetHeight = int(series[i].Net() * 100 / max)
+		}
+	}
+	return series, nil
+}
+
+// topUsers lists the users who paid the most in the report currency over
+// the period, net of refunds.
+func (h *PaymentHandler) topUsers(ctx context.Context, p reportParams, limit int) ([]UserRevenue, error) {
+	rows, err := h.db.Pool.Query(ctx,
+		`SELECT s.user_id, COALESCE(u.email, ''), COALESCE(u.name, ''),
+			SUM(s.payments)::bigint, SUM(s.gross)::bigint, SUM(s.refunded)::bigint
+		FROM iraven.payment_monthly_user_summaries s
+		LEFT JOIN iraven.users u ON u.id = s.user_id
+		WHERE s.currency = $1 AND ($2::date IS NULL OR s.month >= $2)
+		GROUP BY s.user_id, u.email, u.name
+		ORDER BY SUM(s.gross) - SUM(s.refunded) DESC
+		LIMIT $3`, p.Currency, p.Since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []UserRevenue
+	for rows.Next() {
+		var u UserRevenue
+		if err := rows.Scan(&u.UserID, &u.Email, &u.Name, &u.Payments, &u.Gross, &u.Refunded); err != nil {
+			continue
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// revenueByApplication totals what the users of each application paid in
+// the report currency over the period.
+func (h *PaymentHandler) revenueByApplication(ctx context.Context, p reportParams) ([]ApplicationRevenue, error) {
+	rows, err := h.db.Pool.Query(ctx,
+		`WITH users AS (
+			SELECT user_id, SUM(payments) AS payments, SUM(gross) AS gross, SUM(refunded) AS refunded
+			FROM iraven.payment_monthly_user_summaries
+			WHERE currency = $1 AND ($2::date IS NULL OR month >= $2)
+			GROUP BY user_id
+		), application_users AS (
+			SELECT DISTINCT ar.application_id, ur.user_id
+			FROM iraven.application_roles ar
+			INNER JOIN iraven.user_roles ur ON ur.role_id = ar.role_id
+		)
+		SELECT a.id, a.name, COUNT(u.user_id), SUM(u.payments)::bigint, SUM(u.gross)::bigint, SUM(u.refunded)::bigint
+		FROM iraven.applications a
+		INNER JOIN application_users au ON au.application_id = a.id
+		INNER JOIN users u ON u.user_id = au.user_id
+		GROUP BY a.id, a.name
+		ORDER BY SUM(u.gross) - SUM(u.refunded) DESC`, p.Currency, p.Since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var applications []ApplicationRevenue
+	for rows.Next() {
+		var a ApplicationRevenue
+		if err := rows.Scan(&a.ID, &a.Name, &a.Users, &a.Payments, &a.Gross, &a.Refunded); err != nil {
+			continue
+		}
+		applications = append(applications, a)
+	}
+	return applications, nil
+}
+
+// truncateTo returns the start of the day, ISO week or month t falls in.
+func truncateTo(t time.Time, unit string) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch unit {
+	case "week":
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case "month":
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return day
+}
+
+func nextInterval(t time.Time, unit string) time.Time {
+	switch unit {
+	case "week":
+		return t.AddDate(0, 0, 7)
+	case "month":
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
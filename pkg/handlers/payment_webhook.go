@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/iraven/iraven-admin/pkg/billing"
+	"github.com/iraven/iraven-admin/pkg/database"
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+const webhookMaxBytes = 1 << 20
+
+// StripeWebhook receives Stripe events and applies the payment status
+// they report. Stripe redelivers an event until it gets a 2xx reply, so
+// every event is recorded and applied once; an event older than one
+// already applied to the same payment is recorded as stale and not
+// applied.
+func (h *PaymentHandler) StripeWebhook(c echo.Context) error {
+	payload, err := io.ReadAll(io.LimitReader(c.Request().Body, webhookMaxBytes))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to read body")
+	}
+
+	event, err := billing.ParseWebhook(payload, c.Request().Header.Get("Stripe-Signature"), h.webhookSecret, time.Now())
+	if err != nil {
+		log.Printf("payments: rejected webhook: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid webhook")
+	}
+
+	if err := h.applyEvent(context.Background(), event); err != nil {
+		log.Printf("payments: webhook %s: %v", event.ID, err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to apply event")
+	}
+
+	return c.JSON(http.StatusOK, map[string]bool{"received": true})
+}
+
+func (h *PaymentHandler) applyEvent(ctx context.Context, event billing.Event) error {
+	created := time.Unix(event.Created, 0)
+
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Claiming the event first makes a concurrent redelivery wait here and
+	// then find it taken
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO iraven.stripe_events (id, type, outcome, event_created)
+		VALUES ($1, $2, 'ignored', $3)
+		ON CONFLICT (id) DO NOTHING`, event.ID, event.Type, created)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+
+	update, ok := event.PaymentUpdate()
+	if !ok {
+		return tx.Commit(ctx)
+	}
+
+	outcome := "unmatched"
+	var paymentID *int64
+	var id int64
+	var current string
+	err = tx.QueryRow(ctx,
+		`SELECT id, status FROM iraven.payments
+		WHERE stripe_payment_id = ANY($1)
+		ORDER BY id LIMIT 1 FOR UPDATE`, update.PaymentIDs).Scan(&id, &current)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+	case err != nil:
+		return err
+	default:
+		paymentID = &id
+
+		var newer bool
+		err := tx.QueryRow(ctx,
+			`SELECT EXISTS (
+				SELECT 1 FROM iraven.stripe_events
+				WHERE payment_id = $1 AND outcome IN ('applied', 'unchanged') AND event_created > $2
+			)`, id, created).Scan(&newer)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case newer:
+			outcome = "stale"
+		case current == update.Status:
+			outcome = "unchanged"
+		default:
+			if err := setPaymentStatus(ctx, tx, id, update.Status); err != nil {
+				return err
+			}
+			outcome = "applied"
+		}
+	}
+
+	_, err = tx.Exec(ctx,
+		"UPDATE iraven.stripe_events SET payment_id = $2, status = $3, outcome = $4 WHERE id = $1",
+		event.ID, paymentID, update.Status, outcome)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// stripeEvents lists the webhook events received for a payment, newest
+// first.
+func stripeEvents(db *database.Database, paymentID int64) ([]models.StripeEvent, error) {
+	rows, err := db.Pool.Query(context.Background(),
+		`SELECT id, type, payment_id, status, outcome, event_created, received_at
+		FROM iraven.stripe_events
+		WHERE payment_id = $1
+		ORDER BY event_created DESC, received_at DESC
+		LIMIT 50`, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.StripeEvent
+	for rows.Next() {
+		var e models.StripeEvent
+		if err := rows.Scan(&e.ID, &e.Type, &e.PaymentID, &e.Status, &e.Outcome, &e.EventCreated, &e.ReceivedAt); err != nil {
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
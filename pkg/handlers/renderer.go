@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"fmt"
 	"html/template"
 	"io"
 	"path/filepath"
 	"time"
 
+	"github.com/iraven/iraven-admin/pkg/money"
 	"github.com/labstack/echo/v4"
 )
 
@@ -27,6 +29,19 @@ func NewTemplateRenderer(templatesDir string) (*TemplateRenderer, error) {
 		"sub": func(a, b int) int {
 			return a - b
 		},
+		"formatBytes": func(n int64) string {
+			const unit = 1024
+			if n < unit {
+				return fmt.Sprintf("%d B", n)
+			}
+			div, exp := int64(unit), 0
+			for m := n / unit; m >= unit; m /= unit {
+				div *= unit
+				exp++
+			}
+			return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+		},
+		"formatMoney": money.Format,
 	}
 
 	tmpl := template.New("").Funcs(funcMap)
@@ -43,8 +58,8 @@ func NewTemplateRenderer(templatesDir string) (*TemplateRenderer, error) {
 		}
 
 		// Parse subdirectories
-		dirs := []string{"layouts", "users", "roles", "applications", "clients", "content",
-			"files", "languages", "countries", "notifications", "payments", "system", "supabase", "dashboard"}
+		dirs := []string{"layouts", "users", "roles", "applications", "clients", "content", "content_types",
+			"files", "languages", "countries", "translations", "notifications", "payments", "system", "supabase", "dashboard"}
 
 		for _, dir := range dirs {
 			pattern := filepath.Join(templatesDir, dir, "*.html")
@@ -164,6 +164,9 @@ func (h *RoleHandler) Delete(c echo.Context) error {
 			fmt.Sprintf("Cannot delete role: %d users have this role", count))
 	}
 
+	// Detach from applications first
+	h.db.Pool.Exec(context.Background(), "DELETE FROM iraven.application_roles WHERE role_id = $1", id)
+
 	_, err := h.db.Pool.Exec(context.Background(), "DELETE FROM iraven.roles WHERE id = $1", id)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Failed to delete role: "+err.Error())
@@ -171,3 +174,21 @@ func (h *RoleHandler) Delete(c echo.Context) error {
 
 	return c.Redirect(http.StatusFound, "/roles")
 }
+
+func listRoles(db *database.Database) ([]models.Role, error) {
+	rows, err := db.Pool.Query(context.Background(), "SELECT id, name FROM iraven.roles ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+	for rows.Next() {
+		var r models.Role
+		if err := rows.Scan(&r.ID, &r.Name); err != nil {
+			continue
+		}
+		roles = append(roles, r)
+	}
+	return roles, nil
+}
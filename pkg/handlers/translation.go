@@ -0,0 +1,481 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iraven/iraven-admin/pkg/database"
+	"github.com/iraven/iraven-admin/pkg/i18n"
+	"github.com/iraven/iraven-admin/pkg/middleware"
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/iraven/iraven-admin/pkg/translation"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	translationNamespace = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,99}$`)
+	translationKey       = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.:/-]{0,254}$`)
+)
+
+// translationPageSize is how many keys the editor grid shows at once.
+const translationPageSize = 50
+
+type TranslationHandler struct {
+	db             *database.Database
+	sourceLanguage string
+}
+
+func NewTranslationHandler(db *database.Database, sourceLanguage string) *TranslationHandler {
+	return &TranslationHandler{db: db, sourceLanguage: i18n.NormalizeCode(sourceLanguage)}
+}
+
+// TranslationRow is a key with its values by language ID.
+type TranslationRow struct {
+	models.TranslationKey
+	Values map[int64]string
+}
+
+// LanguageCoverage counts how many keys a language has translated.
+type LanguageCoverage struct {
+	models.Language
+	Translated int
+	Total      int
+}
+
+func (c LanguageCoverage) Percent() int {
+	if c.Total == 0 {
+		return 100
+	}
+	return c.Translated * 100 / c.Total
+}
+
+func (c LanguageCoverage) Missing() int {
+	return c.Total - c.Translated
+}
+
+// Index is the editor grid: a page of keys with a column per chosen
+// language. Missing translations are highlighted, and missing=1 keeps only
+// keys lacking a value in one of the columns.
+func (h *TranslationHandler) Index(c echo.Context) error {
+	namespace := c.QueryParam("namespace")
+	search := strings.TrimSpace(c.QueryParam("q"))
+	missing := c.QueryParam("missing") == "1"
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	languages, err := listLanguages(h.db)
+	if err != nil {
+		return err
+	}
+	coverage, err := h.coverage(namespace)
+	if err != nil {
+		return err
+	}
+
+	// Columns default to the languages that have translations
+	chosen := map[string]bool{}
+	for _, code := range c.QueryParams()["lang"] {
+		chosen[strings.ToLower(code)] = true
+	}
+	if len(chosen) == 0 {
+		for _, l := range coverage {
+			chosen[strings.ToLower(l.Code)] = true
+		}
+	}
+	var columns []models.Language
+	var columnIDs []int64
+	for _, l := range languages {
+		if chosen[strings.ToLower(l.Code)] {
+			columns = append(columns, l)
+			columnIDs = append(columnIDs, l.ID)
+		}
+	}
+
+	where := `WHERE ($1 = '' OR k.namespace = $1)
+		AND ($2 = '' OR k.key ILIKE '%' || $2 || '%' OR EXISTS (
+			SELECT 1 FROM iraven.translation_values sv WHERE sv.key_id = k.id AND sv.value ILIKE '%' || $2 || '%'))
+		AND (NOT $3 OR (SELECT COUNT(*) FROM iraven.translation_values mv
+			WHERE mv.key_id = k.id AND mv.language_id = ANY($4)) < cardinality($4::bigint[]))`
+	args := []interface{}{namespace, search, missing, columnIDs}
+
+	var total int
+	h.db.Pool.QueryRow(context.Background(),
+		"SELECT COUNT(*) FROM iraven.translation_keys k "+where, args...).Scan(&total)
+
+	rows, err := h.db.Pool.Query(context.Background(),
+		`SELECT k.id, k.namespace, k.key, k.description, k.created_at, k.updated_at
+		FROM iraven.translation_keys k `+where+`
+		ORDER BY k.namespace, k.key LIMIT $5 OFFSET $6`,
+		append(args, translationPageSize, (page-1)*translationPageSize)...)
+	if err != nil {
+		return err
+	}
+
+	var translations []TranslationRow
+	index := map[int64]int{}
+	for rows.Next() {
+		row := TranslationRow{Values: map[int64]string{}}
+		if err := rows.Scan(&row.ID, &row.Namespace, &row.Key, &row.Description, &row.CreatedAt, &row.UpdatedAt); err != nil {
+			continue
+		}
+		index[row.ID] = len(translations)
+		translations = append(translations, row)
+	}
+	rows.Close()
+
+	if len(translations) > 0 {
+		keyIDs := make([]int64, 0, len(translations))
+		for _, row := range translations {
+			keyIDs = append(keyIDs, row.ID)
+		}
+		rows, err := h.db.Pool.Query(context.Background(),
+			`SELECT key_id, language_id, value FROM iraven.translation_values
+			WHERE key_id = ANY($1) AND language_id = ANY($2)`, keyIDs, columnIDs)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var keyID, languageID int64
+			var value string
+			if err := rows.Scan(&keyID, &languageID, &value); err != nil {
+				continue
+			}
+			translations[index[keyID]].Values[languageID] = value
+		}
+		rows.Close()
+	}
+
+	namespaces, err := h.namespaces()
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{
+		"Title":          "Translations",
+		"Translations":   translations,
+		"Columns":        columns,
+		"Languages":      languages,
+		"Coverage":       coverage,
+		"Namespaces":     namespaces,
+		"Namespace":      namespace,
+		"Search":         search,
+		"Missing":        missing,
+		"SourceLanguage": h.sourceLanguage,
+		"Formats":        translation.Formats,
+		"Imported":       importResultFromQuery(c),
+		"Query":          c.QueryString(),
+		"Page":           page,
+		"TotalPages":     (total + translationPageSize - 1) / translationPageSize,
+		"Total":          total,
+	}
+
+	return c.Render(http.StatusOK, "translations/index", data)
+}
+
+// SaveValues stores the cells of the editor grid that were changed. Each
+// cell is posted with the value it was shown with, so cells left alone do
+// not overwrite edits made by someone else in the meantime. Emptying a
+// cell removes the translation.
+func (h *TranslationHandler) SaveValues(c echo.Context) error {
+	form, err := c.FormParams()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid form")
+	}
+
+	ctx := context.Background()
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	userID := currentUserIDPtr(c)
+	for name, values := range form {
+		ids, ok := strings.CutPrefix(name, "value_")
+		if !ok || len(values) == 0 {
+			continue
+		}
+		keyPart, languagePart, _ := strings.Cut(ids, "_")
+		keyID, err1 := strconv.ParseInt(keyPart, 10, 64)
+		languageID, err2 := strconv.ParseInt(languagePart, 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if values[0] == form.Get("orig_"+ids) {
+			continue
+		}
+		if err := setTranslation(ctx, tx, keyID, languageID, values[0], userID); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to save translation: "+err.Error())
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	query, _ := url.ParseQuery(c.FormValue("query"))
+	return c.Redirect(http.StatusFound, "/translations?"+query.Encode())
+}
+
+func (h *TranslationHandler) CreateKey(c echo.Context) error {
+	namespace, key, description, err := translationKeyForm(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var keyID int64
+	err = tx.QueryRow(ctx,
+		"INSERT INTO iraven.translation_keys (namespace, key, description) VALUES ($1, $2, $3) RETURNING id",
+		namespace, key, description).Scan(&keyID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to create translation key: "+err.Error())
+	}
+
+	// The text in the source language can be given with the key
+	if source := c.FormValue("source"); source != "" {
+		if languageID, err := sourceLanguageID(ctx, tx, h.sourceLanguage); err == nil {
+			if err := setTranslation(ctx, tx, keyID, languageID, source, currentUserIDPtr(c)); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "Failed to save translation: "+err.Error())
+			}
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusFound, "/translations?"+url.Values{"namespace": {namespace}, "q": {key}}.Encode())
+}
+
+// EditKey shows a key with its translation in every language that has
+// translations, plus any the key already has.
+func (h *TranslationHandler) EditKey(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	var k models.TranslationKey
+	err := h.db.Pool.QueryRow(context.Background(),
+		"SELECT id, namespace, key, description, created_at, updated_at FROM iraven.translation_keys WHERE id = $1", id).
+		Scan(&k.ID, &k.Namespace, &k.Key, &k.Description, &k.CreatedAt, &k.UpdatedAt)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Translation key not found")
+	}
+
+	rows, err := h.db.Pool.Query(context.Background(),
+		`SELECT l.id, l.name, l.code, v.value, v.updated_at
+		FROM iraven.languages l
+		LEFT JOIN iraven.translation_values v ON v.language_id = l.id AND v.key_id = $1
+		WHERE v.key_id IS NOT NULL OR `+normalizedCodeSQL("l.code")+` = $2
+			OR EXISTS (SELECT 1 FROM iraven.translation_values a WHERE a.language_id = l.id)
+		ORDER BY `+normalizedCodeSQL("l.code")+` <> $2, l.name`, id, h.sourceLanguage)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type keyValue struct {
+		models.Language
+		Value     *string
+		UpdatedAt *time.Time
+	}
+	var values []keyValue
+	for rows.Next() {
+		var v keyValue
+		if err := rows.Scan(&v.ID, &v.Name, &v.Code, &v.Value, &v.UpdatedAt); err != nil {
+			continue
+		}
+		values = append(values, v)
+	}
+
+	data := map[string]interface{}{
+		"Title":          "Edit Translation Key",
+		"Key":            k,
+		"Values":         values,
+		"SourceLanguage": h.sourceLanguage,
+	}
+
+	return c.Render(http.StatusOK, "translations/key", data)
+}
+
+func (h *TranslationHandler) UpdateKey(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	namespace, key, description, err := translationKeyForm(c)
+	if err != nil {
+		return err
+	}
+	form, err := c.FormParams()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid form")
+	}
+
+	ctx := context.Background()
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		"UPDATE iraven.translation_keys SET namespace = $1, key = $2, description = $3, updated_at = NOW() WHERE id = $4",
+		namespace, key, description, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to update translation key: "+err.Error())
+	}
+
+	userID := currentUserIDPtr(c)
+	for name, values := range form {
+		languagePart, ok := strings.CutPrefix(name, "value_")
+		if !ok || len(values) == 0 || values[0] == form.Get("orig_"+languagePart) {
+			continue
+		}
+		languageID, err := strconv.ParseInt(languagePart, 10, 64)
+		if err != nil {
+			continue
+		}
+		if err := setTranslation(ctx, tx, id, languageID, values[0], userID); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to save translation: "+err.Error())
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/translations/keys/%d", id))
+}
+
+func (h *TranslationHandler) DeleteKey(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	var namespace string
+	err := h.db.Pool.QueryRow(context.Background(),
+		"DELETE FROM iraven.translation_keys WHERE id = $1 RETURNING namespace", id).Scan(&namespace)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to delete translation key: "+err.Error())
+	}
+
+	return c.Redirect(http.StatusFound, "/translations?"+url.Values{"namespace": {namespace}}.Encode())
+}
+
+// coverage counts the translations of every language that has any, and of
+// the source language, against the keys in namespace (all when empty).
+func (h *TranslationHandler) coverage(namespace string) ([]LanguageCoverage, error) {
+	rows, err := h.db.Pool.Query(context.Background(),
+		`WITH keys AS (
+			SELECT id FROM iraven.translation_keys WHERE $1 = '' OR namespace = $1
+		)
+		SELECT l.id, l.name, l.code,
+			COUNT(v.key_id) FILTER (WHERE v.key_id IN (SELECT id FROM keys)),
+			(SELECT COUNT(*) FROM keys)
+		FROM iraven.languages l
+		LEFT JOIN iraven.translation_values v ON v.language_id = l.id
+		GROUP BY l.id, l.name, l.code
+		HAVING COUNT(v.key_id) > 0 OR `+normalizedCodeSQL("l.code")+` = $2
+		ORDER BY `+normalizedCodeSQL("l.code")+` <> $2, l.name`, namespace, h.sourceLanguage)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var coverage []LanguageCoverage
+	for rows.Next() {
+		var lc LanguageCoverage
+		if err := rows.Scan(&lc.ID, &lc.Name, &lc.Code, &lc.Translated, &lc.Total); err != nil {
+			continue
+		}
+		coverage = append(coverage, lc)
+	}
+	return coverage, nil
+}
+
+func (h *TranslationHandler) namespaces() ([]string, error) {
+	rows, err := h.db.Pool.Query(context.Background(),
+		"SELECT DISTINCT namespace FROM iraven.translation_keys ORDER BY namespace")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var namespaces []string
+	for rows.Next() {
+		var namespace string
+		if err := rows.Scan(&namespace); err != nil {
+			continue
+		}
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces, nil
+}
+
+func translationKeyForm(c echo.Context) (string, string, *string, error) {
+	namespace := strings.ToLower(strings.TrimSpace(c.FormValue("namespace")))
+	if namespace == "" {
+		namespace = "common"
+	}
+	key := strings.TrimSpace(c.FormValue("key"))
+
+	if !translationNamespace.MatchString(namespace) {
+		return "", "", nil, echo.NewHTTPError(http.StatusBadRequest,
+			"Namespace may only contain lowercase letters, digits, dots, dashes and underscores")
+	}
+	if !translationKey.MatchString(key) {
+		return "", "", nil, echo.NewHTTPError(http.StatusBadRequest,
+			"Key is required and may only contain letters, digits and . _ - : /")
+	}
+
+	var description *string
+	if d := strings.TrimSpace(c.FormValue("description")); d != "" {
+		description = &d
+	}
+	return namespace, key, description, nil
+}
+
+// sourceLanguageID finds the language the keys are written in. Languages
+// are matched by normalized code, preferring an exact match, so a source of
+// "pt" finds a stored "pt-BR".
+func sourceLanguageID(ctx context.Context, q querier, code string) (int64, error) {
+	var id int64
+	err := q.QueryRow(ctx,
+		`SELECT id FROM iraven.languages WHERE `+normalizedCodeSQL("code")+` = $1
+		ORDER BY lower(code) <> $1, code LIMIT 1`, code).Scan(&id)
+	return id, err
+}
+
+// setTranslation stores a translation, or removes it when value is empty.
+func setTranslation(ctx context.Context, q querier, keyID, languageID int64, value string, userID *int64) error {
+	if value == "" {
+		_, err := q.Exec(ctx,
+			"DELETE FROM iraven.translation_values WHERE key_id = $1 AND language_id = $2", keyID, languageID)
+		return err
+	}
+	_, err := q.Exec(ctx,
+		`INSERT INTO iraven.translation_values (key_id, language_id, value, updated_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key_id, language_id) DO UPDATE SET
+			value = EXCLUDED.value, updated_by = EXCLUDED.updated_by, updated_at = NOW()`,
+		keyID, languageID, value, userID)
+	return err
+}
+
+// currentUserIDPtr is the signed-in user's ID for nullable author columns.
+func currentUserIDPtr(c echo.Context) *int64 {
+	if userID := middleware.CurrentUserID(c); userID != 0 {
+		return &userID
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/labstack/echo/v4"
+)
+
+// BundleVersion is a published bundle with its language.
+type BundleVersion struct {
+	models.TranslationBundle
+	LanguageName   string
+	LanguageCode   string
+	PublisherEmail *string
+}
+
+// Bundles lists the published bundle versions, newest first.
+func (h *TranslationHandler) Bundles(c echo.Context) error {
+	rows, err := h.db.Pool.Query(context.Background(),
+		`SELECT b.id, b.language_id, b.version, b.checksum, b.key_count, b.published_by, b.published_at,
+			l.name, l.code, u.email
+		FROM iraven.translation_bundles b
+		INNER JOIN iraven.languages l ON l.id = b.language_id
+		LEFT JOIN iraven.users u ON u.id = b.published_by
+		ORDER BY b.published_at DESC, l.name LIMIT 100`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var bundles []BundleVersion
+	latest := map[int64]bool{}
+	var current []BundleVersion
+	for rows.Next() {
+		var b BundleVersion
+		if err := rows.Scan(&b.ID, &b.LanguageID, &b.Version, &b.Checksum, &b.KeyCount, &b.PublishedBy,
+			&b.PublishedAt, &b.LanguageName, &b.LanguageCode, &b.PublisherEmail); err != nil {
+			continue
+		}
+		bundles = append(bundles, b)
+		if !latest[b.LanguageID] {
+			latest[b.LanguageID] = true
+			current = append(current, b)
+		}
+	}
+
+	data := map[string]interface{}{
+		"Title":   "Translation Bundles",
+		"Bundles": bundles,
+		"Current": current,
+	}
+
+	return c.Render(http.StatusOK, "translations/bundles", data)
+}
+
+// PublishBundles snapshots the translations of every language into a new
+// bundle version. Languages whose translations have not changed since
+// their last bundle keep their current version.
+func (h *TranslationHandler) PublishBundles(c echo.Context) error {
+	ctx := context.Background()
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Serialize publishing so two admins cannot claim the same version
+	if _, err := tx.Exec(ctx, "LOCK TABLE iraven.translation_bundles IN EXCLUSIVE MODE"); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(ctx,
+		`SELECT v.language_id, k.namespace, k.key, v.value
+		FROM iraven.translation_values v
+		INNER JOIN iraven.translation_keys k ON k.id = v.key_id
+		ORDER BY v.language_id`)
+	if err != nil {
+		return err
+	}
+	bundles := map[int64]map[string]map[string]string{}
+	counts := map[int64]int{}
+	for rows.Next() {
+		var languageID int64
+		var namespace, key, value string
+		if err := rows.Scan(&languageID, &namespace, &key, &value); err != nil {
+			continue
+		}
+		if bundles[languageID] == nil {
+			bundles[languageID] = map[string]map[string]string{}
+		}
+		if bundles[languageID][namespace] == nil {
+			bundles[languageID][namespace] = map[string]string{}
+		}
+		bundles[languageID][namespace][key] = value
+		counts[languageID]++
+	}
+	rows.Close()
+
+	// Languages that lost all their translations get an empty bundle
+	rows, err = tx.Query(ctx, "SELECT DISTINCT language_id FROM iraven.translation_bundles")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var languageID int64
+		if err := rows.Scan(&languageID); err != nil {
+			continue
+		}
+		if bundles[languageID] == nil {
+			bundles[languageID] = map[string]map[string]string{}
+		}
+	}
+	rows.Close()
+
+	userID := currentUserIDPtr(c)
+	for languageID, bundle := range bundles {
+		// Maps marshal with sorted keys, so equal bundles hash equally
+		data, err := json.Marshal(bundle)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		checksum := hex.EncodeToString(sum[:])
+
+		var version int
+		var latest string
+		tx.QueryRow(ctx,
+			`SELECT version, checksum FROM iraven.translation_bundles
+			WHERE language_id = $1 ORDER BY version DESC LIMIT 1`, languageID).Scan(&version, &latest)
+		if latest == checksum {
+			continue
+		}
+
+		_, err = tx.Exec(ctx,
+			`INSERT INTO iraven.translation_bundles (language_id, version, checksum, data, key_count, published_by)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			languageID, version+1, checksum, string(data), counts[languageID], userID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to publish bundle: "+err.Error())
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusFound, "/translations/bundles")
+}
+
+// Bundle shows a language's bundle to signed-in admins: the latest
+// version, or the one given by ?version=. The version and checksum are
+// sent as headers and the checksum doubles as the ETag. Apps do not fetch
+// bundles from here; the API reads iraven.translation_bundles directly.
+func (h *TranslationHandler) Bundle(c echo.Context) error {
+	language, err := getLanguageByCode(h.db, c.Param("code"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Language not found")
+	}
+	version, _ := strconv.Atoi(c.QueryParam("version"))
+
+	var b models.TranslationBundle
+	err = h.db.Pool.QueryRow(context.Background(),
+		`SELECT version, checksum, data FROM iraven.translation_bundles
+		WHERE language_id = $1 AND ($2 = 0 OR version = $2)
+		ORDER BY version DESC LIMIT 1`, language.ID, version).
+		Scan(&b.Version, &b.Checksum, &b.Data)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Bundle not published")
+	}
+
+	etag := `"` + b.Checksum + `"`
+	c.Response().Header().Set("ETag", etag)
+	c.Response().Header().Set("X-Bundle-Version", strconv.Itoa(b.Version))
+	if c.Request().Header.Get("If-None-Match") == etag {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf("inline; filename=%q", fmt.Sprintf("%s.v%d.json", language.Code, b.Version)))
+	return c.Blob(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, []byte(b.Data))
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/iraven/iraven-admin/pkg/translation"
+	"github.com/labstack/echo/v4"
+)
+
+// ImportResult counts what an import did.
+type ImportResult struct {
+	Created int
+	Updated int
+	Skipped int
+	NewKeys int
+}
+
+// importResultFromQuery reads the result of the previous import, which
+// Import passes along in its redirect.
+func importResultFromQuery(c echo.Context) *ImportResult {
+	if c.QueryParam("imported") == "" {
+		return nil
+	}
+	counts := strings.Split(c.QueryParam("imported"), ",")
+	values := make([]int, 4)
+	for i := range values {
+		if i < len(counts) {
+			values[i], _ = strconv.Atoi(counts[i])
+		}
+	}
+	return &ImportResult{Created: values[0], Updated: values[1], Skipped: values[2], NewKeys: values[3]}
+}
+
+// Import loads a JSON, PO or XLIFF file into one language and namespace.
+// Existing translations are only replaced when overwrite is ticked, and
+// keys missing from the namespace are skipped unless create_keys is
+// ticked, in which case the file's source text is also stored for the
+// source language.
+func (h *TranslationHandler) Import(c echo.Context) error {
+	namespace := strings.ToLower(strings.TrimSpace(c.FormValue("namespace")))
+	if namespace == "" {
+		namespace = "common"
+	}
+	if !translationNamespace.MatchString(namespace) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid namespace")
+	}
+	overwrite := c.FormValue("overwrite") == "on"
+	createKeys := c.FormValue("create_keys") == "on"
+
+	language, err := getLanguageByCode(h.db, c.FormValue("language"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Unknown language")
+	}
+
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Choose a file to import")
+	}
+	format, err := translation.DetectFormat(c.FormValue("format"), fh.Filename)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entries, err := translation.Parse(format, f)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to read "+fh.Filename+": "+err.Error())
+	}
+
+	ctx := context.Background()
+	sourceID, _ := sourceLanguageID(ctx, h.db.Pool, h.sourceLanguage)
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	userID := currentUserIDPtr(c)
+	var result ImportResult
+	for _, e := range entries {
+		if !translationKey.MatchString(e.Key) {
+			result.Skipped++
+			continue
+		}
+
+		var keyID int64
+		err := tx.QueryRow(ctx,
+			"SELECT id FROM iraven.translation_keys WHERE namespace = $1 AND key = $2", namespace, e.Key).Scan(&keyID)
+		if err != nil {
+			if !createKeys {
+				result.Skipped++
+				continue
+			}
+			var description *string
+			if e.Note != "" {
+				description = &e.Note
+			}
+			err = tx.QueryRow(ctx,
+				"INSERT INTO iraven.translation_keys (namespace, key, description) VALUES ($1, $2, $3) RETURNING id",
+				namespace, e.Key, description).Scan(&keyID)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "Failed to create key "+e.Key+": "+err.Error())
+			}
+			result.NewKeys++
+
+			if sourceID != 0 && sourceID != language.ID && e.Source != "" && e.Source != e.Key {
+				if err := setTranslation(ctx, tx, keyID, sourceID, e.Source, userID); err != nil {
+					return echo.NewHTTPError(http.StatusBadRequest, "Failed to import "+e.Key+": "+err.Error())
+				}
+			}
+		}
+
+		var current *string
+		tx.QueryRow(ctx,
+			"SELECT value FROM iraven.translation_values WHERE key_id = $1 AND language_id = $2",
+			keyID, language.ID).Scan(&current)
+		switch {
+		case current != nil && (*current == e.Value || !overwrite):
+			result.Skipped++
+			continue
+		case current != nil:
+			result.Updated++
+		default:
+			result.Created++
+		}
+
+		if err := setTranslation(ctx, tx, keyID, language.ID, e.Value, userID); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to import "+e.Key+": "+err.Error())
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	query := url.Values{
+		"namespace": {namespace},
+		"imported":  {fmt.Sprintf("%d,%d,%d,%d", result.Created, result.Updated, result.Skipped, result.NewKeys)},
+	}
+	return c.Redirect(http.StatusFound, "/translations?"+query.Encode())
+}
+
+// Export downloads every key of a namespace with its translation in one
+// language, empty where missing, and the source language text alongside.
+func (h *TranslationHandler) Export(c echo.Context) error {
+	namespace := c.QueryParam("namespace")
+	if namespace == "" {
+		namespace = "common"
+	}
+	format, err := translation.DetectFormat(c.QueryParam("format"), "")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	language, err := getLanguageByCode(h.db, c.QueryParam("language"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Unknown language")
+	}
+
+	rows, err := h.db.Pool.Query(context.Background(),
+		`SELECT k.key, COALESCE(k.description, ''), COALESCE(t.value, ''), COALESCE(s.value, '')
+		FROM iraven.translation_keys k
+		LEFT JOIN iraven.translation_values t ON t.key_id = k.id AND t.language_id = $2
+		LEFT JOIN iraven.translation_values s ON s.key_id = k.id
+			AND s.language_id = (SELECT id FROM iraven.languages WHERE `+normalizedCodeSQL("code")+` = $3
+				ORDER BY lower(code) <> $3, code LIMIT 1)
+		WHERE k.namespace = $1
+		ORDER BY k.key`, namespace, language.ID, h.sourceLanguage)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	file := translation.File{
+		SourceLanguage: h.sourceLanguage,
+		TargetLanguage: language.Code,
+		Namespace:      namespace,
+	}
+	for rows.Next() {
+		var e translation.Entry
+		if err := rows.Scan(&e.Key, &e.Note, &e.Value, &e.Source); err != nil {
+			continue
+		}
+		file.Entries = append(file.Entries, e)
+	}
+
+	filename := namespace + "." + language.Code + translation.Extension(format)
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	c.Response().Header().Set(echo.HeaderContentType, translation.ContentType(format))
+	c.Response().WriteHeader(http.StatusOK)
+	return translation.Write(format, c.Response(), file)
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestSourceLanguageID(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+
+	exec(t, tx, "INSERT INTO iraven.languages (id, code) VALUES (1, 'pt-BR'), (2, 'de_AT'), (3, 'de'), (4, 'EN-gb')")
+
+	tests := []struct {
+		code string
+		want int64
+	}{
+		// A stored region still matches its language
+		{"pt", 1},
+		{"en", 4},
+		// The language without a region wins
+		{"de", 3},
+	}
+	for _, tt := range tests {
+		got, err := sourceLanguageID(ctx, tx, tt.code)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.code, err)
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %d, want %d", tt.code, got, tt.want)
+		}
+	}
+
+	if _, err := sourceLanguageID(ctx, tx, "fr"); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("fr: got %v, want no rows", err)
+	}
+}
@@ -5,19 +5,25 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/iraven/iraven-admin/pkg/database"
 	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/iraven/iraven-admin/pkg/storage"
 	"github.com/labstack/echo/v4"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type UserHandler struct {
-	db *database.Database
+	db     *database.Database
+	store  storage.Storage
+	quotas QuotaDefaults
+	// Days a requested erasure can be canceled
+	erasureGraceDays int
 }
 
-func NewUserHandler(db *database.Database) *UserHandler {
-	return &UserHandler{db: db}
+func NewUserHandler(db *database.Database, store storage.Storage, quotas QuotaDefaults, erasureGraceDays int) *UserHandler {
+	return &UserHandler{db: db, store: store, quotas: quotas, erasureGraceDays: erasureGraceDays}
 }
 
 func (h *UserHandler) List(c echo.Context) error {
@@ -93,10 +99,65 @@ func (h *UserHandler) Show(c echo.Context) error {
 		roles = append(roles, r)
 	}
 
+	files, err := queryFiles(h.db, "WHERE f.uploaded_by = $1 ORDER BY f.created_at DESC LIMIT 12", id)
+	if err != nil {
+		return err
+	}
+
+	usage, err := userStorageUsage(context.Background(), h.db.Pool, h.quotas, id)
+	if err != nil {
+		return err
+	}
+
+	devices, err := listDevices(h.db, id)
+	if err != nil {
+		return err
+	}
+
+	payments, err := queryPayments(h.db, "WHERE p.user_id = $1 ORDER BY p.created_at DESC LIMIT 10", id)
+	if err != nil {
+		return err
+	}
+
+	profile, err := userProfile(h.db, id)
+	if err != nil {
+		return err
+	}
+
+	erasure, err := pendingErasure(h.db, id)
+	if err != nil {
+		return err
+	}
+
+	timelinePage, _ := strconv.Atoi(c.QueryParam("timeline_page"))
+	timeline, err := userTimeline(h.db, id, c.QueryParam("timeline"), timelinePage)
+	if err != nil {
+		return err
+	}
+
 	data := map[string]interface{}{
-		"Title": "User Details",
-		"User":  u,
-		"Roles": roles,
+		"Title":    "User Details",
+		"User":     u,
+		"Profile":  profile,
+		"Roles":    roles,
+		"Files":    files,
+		"Storage":  usage,
+		"Devices":  devices,
+		"PushTest": c.QueryParam("push_test"),
+		"Payments": payments,
+		"Timeline": timeline,
+		"Erasure":  erasure,
+	}
+
+	// Show the stored thumbnail when the picture is one of our files
+	if u.Picture != nil {
+		pictures, err := filesByURL(h.db, []string{*u.Picture})
+		if err != nil {
+			return err
+		}
+		if len(pictures) > 0 && pictures[0].Thumbnail {
+			data["PictureFile"] = pictures[0]
+		}
 	}
 
 	return c.Render(http.StatusOK, "users/show", data)
@@ -155,12 +216,8 @@ func (h *UserHandler) Create(c echo.Context) error {
 
 	// Assign roles if provided
 	if err := c.Request().ParseForm(); err == nil {
-		roleIDs := c.Request().Form["role_ids"]
-		for _, roleIDStr := range roleIDs {
-			roleID, _ := strconv.ParseInt(roleIDStr, 10, 64)
-			h.db.Pool.Exec(context.Background(),
-				"INSERT INTO iraven.user_roles (user_id, role_id) VALUES ($1, $2)",
-				userID, roleID)
+		if err := h.setRoles(userID, formRoleIDs(c.Request().Form["role_ids"]), currentUserIDPtr(c)); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to assign roles: "+err.Error())
 		}
 	}
 
@@ -231,8 +288,16 @@ func (h *UserHandler) Update(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Name is required")
 	}
 
+	var before models.User
+	err := h.db.Pool.QueryRow(context.Background(),
+		"SELECT name, email_verified FROM iraven.users WHERE id = $1", id).
+		Scan(&before.Name, &before.EmailVerified)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "User not found")
+	}
+
 	// Update user
-	_, err := h.db.Pool.Exec(context.Background(),
+	_, err = h.db.Pool.Exec(context.Background(),
 		"UPDATE iraven.users SET name = $1, email_verified = $2, updated_at = NOW() WHERE id = $3",
 		name, emailVerified, id)
 
@@ -240,35 +305,116 @@ func (h *UserHandler) Update(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Failed to update user: "+err.Error())
 	}
 
-	// Update roles
-	// First, delete all existing roles
-	h.db.Pool.Exec(context.Background(), "DELETE FROM iraven.user_roles WHERE user_id = $1", id)
+	var changes []string
+	if before.Name != name {
+		changes = append(changes, fmt.Sprintf("name %q to %q", before.Name, name))
+	}
+	if before.EmailVerified != emailVerified {
+		changes = append(changes, fmt.Sprintf("email verified %t to %t", before.EmailVerified, emailVerified))
+	}
+	if len(changes) > 0 {
+		recordUserEvent(h.db.Pool, id, "updated", strings.Join(changes, ", "), currentUserIDPtr(c))
+	}
 
-	// Then add new roles
+	// Update roles
+	var roleIDs []int64
 	if err := c.Request().ParseForm(); err == nil {
-		roleIDs := c.Request().Form["role_ids"]
-		for _, roleIDStr := range roleIDs {
-			roleID, _ := strconv.ParseInt(roleIDStr, 10, 64)
-			h.db.Pool.Exec(context.Background(),
-				"INSERT INTO iraven.user_roles (user_id, role_id) VALUES ($1, $2)",
-				id, roleID)
-		}
+		roleIDs = formRoleIDs(c.Request().Form["role_ids"])
+	}
+	if err := h.setRoles(id, roleIDs, currentUserIDPtr(c)); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to update roles: "+err.Error())
 	}
 
 	return c.Redirect(http.StatusFound, fmt.Sprintf("/users/%d", id))
 }
 
+// setRoles makes roleIDs the user's roles. Only the difference is written,
+// so roles kept keep their grant date, and each grant and removal goes to
+// the audit trail.
+func (h *UserHandler) setRoles(userID int64, roleIDs []int64, performedBy *int64) error {
+	if roleIDs == nil {
+		roleIDs = []int64{}
+	}
+
+	ctx := context.Background()
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	removed, err := collectIDs(tx.Query(ctx,
+		`DELETE FROM iraven.user_roles WHERE user_id = $1 AND NOT (role_id = ANY($2))
+		RETURNING role_id`, userID, roleIDs))
+	if err != nil {
+		return err
+	}
+	added, err := collectIDs(tx.Query(ctx,
+		`INSERT INTO iraven.user_roles (user_id, role_id)
+		SELECT $1, r FROM unnest($2::bigint[]) r
+		WHERE NOT EXISTS (SELECT 1 FROM iraven.user_roles WHERE user_id = $1 AND role_id = r)
+		RETURNING role_id`, userID, roleIDs))
+	if err != nil {
+		return err
+	}
+
+	names, err := roleNames(tx, append(append([]int64{}, removed...), added...))
+	if err != nil {
+		return err
+	}
+	for _, roleID := range removed {
+		if err := recordUserEvent(tx, userID, "role_removed", names[roleID], performedBy); err != nil {
+			return err
+		}
+	}
+	for _, roleID := range added {
+		if err := recordUserEvent(tx, userID, "role_added", names[roleID], performedBy); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Delete removes a user with their personal data, in dependency order.
+// Payments, authored content and uploads are kept, so a user with any of
+// them cannot be deleted outright and is erased instead, which anonymizes
+// the user row they reference.
 func (h *UserHandler) Delete(c echo.Context) error {
 	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
 
-	// Delete user roles first
-	h.db.Pool.Exec(context.Background(), "DELETE FROM iraven.user_roles WHERE user_id = $1", id)
+	ctx := context.Background()
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	payments, content, files, err := retainedRecords(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if payments+content+files > 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf(
+			"User has %d payment(s), %d content item(s) and %d file(s) that are kept; request an erasure from Data & Privacy instead",
+			payments, content, files))
+	}
+
+	if _, err := deletePersonalData(ctx, tx, id); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to delete user: "+err.Error())
+	}
 
 	// Delete user
-	_, err := h.db.Pool.Exec(context.Background(), "DELETE FROM iraven.users WHERE id = $1", id)
+	_, err = tx.Exec(ctx, "DELETE FROM iraven.users WHERE id = $1", id)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Failed to delete user: "+err.Error())
 	}
+	if err := recordUserEvent(tx, id, "deleted", "", currentUserIDPtr(c)); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
 
 	return c.Redirect(http.StatusFound, "/users")
 }
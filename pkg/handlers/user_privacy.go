@@ -0,0 +1,560 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iraven/iraven-admin/pkg/database"
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// userDataTable is a table holding a user's data, with what erasing the
+// user does to its rows.
+type userDataTable struct {
+	Table string
+	// Rows selects the user's rows for the preview and the export, $1
+	// being the user. Secrets such as the password hash are left out.
+	Rows string
+	// Erasure is delete, anonymize or keep
+	Erasure string
+	Note    string
+}
+
+// userData lists every table tied to a user, in the order erasure works
+// through them: rows that reference others first, the user row last.
+var userData = []userDataTable{
+	{"notification_deliveries", `SELECT id, send_id, channel, status, title, body, error, created_at, sent_at
+		FROM iraven.notification_deliveries WHERE user_id = $1`, "delete", ""},
+	{"notifications", "SELECT * FROM iraven.notifications WHERE user_id = $1", "delete", ""},
+	{"notification_preferences", "SELECT * FROM iraven.notification_preferences WHERE user_id = $1", "delete", ""},
+	{"notification_devices", "SELECT * FROM iraven.notification_devices WHERE user_id = $1", "delete", ""},
+	{"notification_device_removals", "SELECT * FROM iraven.notification_device_removals WHERE user_id = $1", "delete", ""},
+	{"user_roles", `SELECT ur.role_id, r.name AS role, ur.created_at
+		FROM iraven.user_roles ur JOIN iraven.roles r ON r.id = ur.role_id WHERE ur.user_id = $1`, "delete", ""},
+	{"profiles", "SELECT * FROM iraven.profiles WHERE user_id = $1", "delete", ""},
+	{"storage_quotas", "SELECT * FROM iraven.storage_quotas WHERE subject_type = 'user' AND subject_id = $1", "delete", ""},
+	{"files", `SELECT id, name, original_name, mime_type, size, url, created_at, updated_at
+		FROM iraven.files WHERE uploaded_by = $1`, "delete",
+		"Files attached to content are kept with the content"},
+	{"content", `SELECT id, slug, title, data, status, published_at, created_at, updated_at
+		FROM iraven.content WHERE created_by = $1`, "keep",
+		"Stays published, credited to the anonymized user"},
+	{"payments", "SELECT * FROM iraven.payments WHERE user_id = $1", "keep",
+		"Kept for accounting, credited to the anonymized user"},
+	{"payment_actions", `SELECT pa.id, pa.payment_id, pa.action, pa.amount, pa.currency, pa.reason, pa.status, pa.created_at
+		FROM iraven.payment_actions pa JOIN iraven.payments p ON p.id = pa.payment_id WHERE p.user_id = $1`, "keep",
+		"Kept with the payments"},
+	{"user_audit_events", "SELECT id, action, details, created_at FROM iraven.user_audit_events WHERE user_id = $1", "anonymize",
+		"Details naming the user are cleared"},
+	{"users", `SELECT id, email, name, picture, google_id, email_verified, last_login, created_at, updated_at
+		FROM iraven.users WHERE id = $1`, "anonymize",
+		"Name, email, picture and login are replaced; the row stays for what is kept"},
+}
+
+// UserDataCount is the number of a user's rows in one table.
+type UserDataCount struct {
+	userDataTable
+	Count int64
+}
+
+// Privacy shows what an export or erasure of a user covers, row counts per
+// table, and the user's erasure requests.
+func (h *UserHandler) Privacy(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	u, err := h.getUser(id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "User not found")
+	}
+
+	counts, err := userDataCounts(h.db, id)
+	if err != nil {
+		return err
+	}
+	erasures, err := userErasures(h.db, id)
+	if err != nil {
+		return err
+	}
+
+	var pending *UserErasure
+	for i := range erasures {
+		if erasures[i].Status == "pending" {
+			pending = &erasures[i]
+		}
+	}
+
+	data := map[string]interface{}{
+		"Title":     "Data & Privacy",
+		"User":      u,
+		"Counts":    counts,
+		"Erasures":  erasures,
+		"Pending":   pending,
+		"GraceDays": h.erasureGraceDays,
+		"Action":    c.QueryParam("action"),
+	}
+
+	return c.Render(http.StatusOK, "users/privacy", data)
+}
+
+func userDataCounts(db *database.Database, userID int64) ([]UserDataCount, error) {
+	var counts []UserDataCount
+	for _, t := range userData {
+		n := UserDataCount{userDataTable: t}
+		err := db.Pool.QueryRow(context.Background(),
+			"SELECT COUNT(*) FROM ("+t.Rows+") t", userID).Scan(&n.Count)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.Table, err)
+		}
+		counts = append(counts, n)
+	}
+	return counts, nil
+}
+
+// Export downloads everything tied to a user as a ZIP: one JSON file per
+// table, the stored contents of the user's uploads, and a manifest. The
+// export is recorded in the user's audit trail.
+func (h *UserHandler) Export(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	if _, err := h.getUser(id); err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "User not found")
+	}
+
+	ctx := c.Request().Context()
+	tables := make(map[string][]byte, len(userData))
+	manifest := struct {
+		UserID       int64            `json:"user_id"`
+		GeneratedAt  time.Time        `json:"generated_at"`
+		Rows         map[string]int64 `json:"rows"`
+		Files        []string         `json:"files"`
+		MissingFiles []string         `json:"missing_files,omitempty"`
+	}{UserID: id, GeneratedAt: time.Now().UTC(), Rows: map[string]int64{}, Files: []string{}}
+
+	for _, t := range userData {
+		var rows int64
+		var raw string
+		err := h.db.Pool.QueryRow(ctx,
+			`SELECT COUNT(*), COALESCE(json_agg(row_to_json(t)), '[]')::text FROM (`+t.Rows+`) t`, id).
+			Scan(&rows, &raw)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to export "+t.Table+": "+err.Error())
+		}
+		var out bytes.Buffer
+		if err := json.Indent(&out, []byte(raw), "", "  "); err != nil {
+			return err
+		}
+		tables[t.Table] = out.Bytes()
+		manifest.Rows[t.Table] = rows
+	}
+
+	files, err := queryFiles(h.db, "WHERE f.uploaded_by = $1 ORDER BY f.id", id)
+	if err != nil {
+		return err
+	}
+
+	recordUserEvent(h.db.Pool, id, "data_exported",
+		fmt.Sprintf("%d tables, %d files", len(userData), len(files)), currentUserIDPtr(c))
+
+	filename := fmt.Sprintf("user-%d-export-%s.zip", id, manifest.GeneratedAt.Format("2006-01-02"))
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	c.Response().Header().Set(echo.HeaderContentType, "application/zip")
+	c.Response().WriteHeader(http.StatusOK)
+
+	// Past this point the status is sent; failures can only cut the archive
+	// short, which the client sees as a corrupt download
+	zw := zip.NewWriter(c.Response())
+	for _, t := range userData {
+		w, err := zw.Create(t.Table + ".json")
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(tables[t.Table]); err != nil {
+			return err
+		}
+	}
+	for _, f := range files {
+		name := fmt.Sprintf("files/%d-%s", f.ID, exportFileName(f.OriginalName))
+		if err := h.exportObject(ctx, zw, name, f.Path); err != nil {
+			log.Printf("users: export of user %d is missing file %d: %v", id, f.ID, err)
+			manifest.MissingFiles = append(manifest.MissingFiles, name)
+			continue
+		}
+		manifest.Files = append(manifest.Files, name)
+	}
+
+	w, err := zw.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// exportObject copies a stored object into the archive.
+func (h *UserHandler) exportObject(ctx context.Context, zw *zip.Writer, name, key string) error {
+	r, err := h.store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// exportFileName makes an uploaded file's name safe to use inside the
+// archive.
+func exportFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		return "file"
+	}
+	return name
+}
+
+// RequestErasure schedules a user's erasure after the grace period. The
+// admin confirms by typing the user's email.
+func (h *UserHandler) RequestErasure(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	u, err := h.getUser(id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "User not found")
+	}
+	reason := strings.TrimSpace(c.FormValue("reason"))
+	if reason == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "A reason is required")
+	}
+	if !strings.EqualFold(strings.TrimSpace(c.FormValue("confirm_email")), u.Email) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Type the user's email to confirm the erasure")
+	}
+
+	scheduledFor := time.Now().AddDate(0, 0, h.erasureGraceDays)
+
+	ctx := context.Background()
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO iraven.user_erasures (user_id, reason, scheduled_for, requested_by)
+		VALUES ($1, $2, $3, $4)`,
+		id, reason, scheduledFor, currentUserIDPtr(c))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to request erasure: "+err.Error())
+	}
+	err = recordUserEvent(tx, id, "erasure_requested",
+		"scheduled for "+scheduledFor.Format("2006-01-02 15:04")+": "+reason, currentUserIDPtr(c))
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/users/%d/privacy?action=requested", id))
+}
+
+// CancelErasure withdraws a user's pending erasure.
+func (h *UserHandler) CancelErasure(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	ctx := context.Background()
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE iraven.user_erasures SET status = 'canceled', canceled_by = $2, updated_at = NOW()
+		WHERE user_id = $1 AND status = 'pending'`,
+		id, currentUserIDPtr(c))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to cancel erasure: "+err.Error())
+	}
+	if tag.RowsAffected() == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "No pending erasure to cancel")
+	}
+	if err := recordUserEvent(tx, id, "erasure_canceled", "", currentUserIDPtr(c)); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/users/%d/privacy?action=canceled", id))
+}
+
+// EraseDue carries out the erasures whose grace period is over. A failed
+// erasure stays pending with its error and is retried at the next run.
+func (h *UserHandler) EraseDue(ctx context.Context) error {
+	rows, err := h.db.Pool.Query(ctx,
+		`SELECT id, user_id FROM iraven.user_erasures
+		WHERE status = 'pending' AND scheduled_for <= NOW() ORDER BY scheduled_for`)
+	if err != nil {
+		return err
+	}
+	type due struct{ id, userID int64 }
+	var erasures []due
+	for rows.Next() {
+		var e due
+		if err := rows.Scan(&e.id, &e.userID); err != nil {
+			continue
+		}
+		erasures = append(erasures, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var failed int
+	for _, e := range erasures {
+		if err := h.erase(ctx, e.id, e.userID); err != nil {
+			failed++
+			log.Printf("users: erasure %d of user %d failed: %v", e.id, e.userID, err)
+			h.db.Pool.Exec(ctx,
+				"UPDATE iraven.user_erasures SET error = $2, updated_at = NOW() WHERE id = $1", e.id, err.Error())
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d erasures failed", failed, len(erasures))
+	}
+	return nil
+}
+
+// erase carries out one erasure in a transaction: personal rows are
+// deleted, the user's unattached uploads removed, and the user row
+// anonymized so that kept payments and content still reference it. Stored
+// objects are deleted once the transaction commits.
+func (h *UserHandler) erase(ctx context.Context, erasureID, userID int64) error {
+	tx, err := h.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the request so that a cancel cannot slip in mid-erasure
+	var status string
+	err = tx.QueryRow(ctx, "SELECT status FROM iraven.user_erasures WHERE id = $1 FOR UPDATE", erasureID).Scan(&status)
+	if err != nil {
+		return err
+	}
+	if status != "pending" {
+		return nil
+	}
+
+	summary, err := deletePersonalData(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	// Uploads nothing else uses go; those attached to content stay with it
+	files := &FileHandler{db: h.db, store: h.store}
+	fileIDs, err := collectIDs(tx.Query(ctx,
+		`SELECT f.id FROM iraven.files f
+		WHERE f.uploaded_by = $1
+			AND NOT EXISTS (SELECT 1 FROM iraven.file_attachments a WHERE a.file_id = f.id)
+			AND NOT EXISTS (SELECT 1 FROM iraven.users p WHERE p.picture = f.url AND p.id <> $1)
+			AND NOT EXISTS (SELECT 1 FROM iraven.profiles p WHERE p.photo = f.url)`, userID))
+	if err != nil {
+		return err
+	}
+	variants, err := files.variantPaths(ctx, fileIDs)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	pathRows, err := tx.Query(ctx,
+		"DELETE FROM iraven.files WHERE id = ANY($1) RETURNING id, path", fileIDs)
+	if err != nil {
+		return err
+	}
+	for pathRows.Next() {
+		var id int64
+		var key string
+		if err := pathRows.Scan(&id, &key); err != nil {
+			continue
+		}
+		keys = append(keys, key)
+		keys = append(keys, variants[id]...)
+	}
+	pathRows.Close()
+	if err := pathRows.Err(); err != nil {
+		return err
+	}
+	summary["files"] = erasureCount{Deleted: int64(len(fileIDs))}
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE iraven.users SET email = 'erased-' || id || '@erased.invalid', name = 'Erased user',
+			password = '', picture = NULL, google_id = NULL, email_verified = false, last_login = NULL,
+			updated_at = NOW()
+		WHERE id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	summary["users"] = erasureCount{Anonymized: tag.RowsAffected()}
+
+	for _, kept := range []struct{ table, query string }{
+		{"files", "SELECT COUNT(*) FROM iraven.files WHERE uploaded_by = $1"},
+		{"content", "SELECT COUNT(*) FROM iraven.content WHERE created_by = $1"},
+		{"payments", "SELECT COUNT(*) FROM iraven.payments WHERE user_id = $1"},
+	} {
+		n := summary[kept.table]
+		if err := tx.QueryRow(ctx, kept.query, userID).Scan(&n.Kept); err != nil {
+			return err
+		}
+		summary[kept.table] = n
+	}
+
+	summaryJSON, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx,
+		`UPDATE iraven.user_erasures SET status = 'completed', summary = $2, error = NULL,
+			completed_at = NOW(), updated_at = NOW()
+		WHERE id = $1`, erasureID, string(summaryJSON))
+	if err != nil {
+		return err
+	}
+	if err := recordUserEvent(tx, userID, "erased", fmt.Sprintf("erasure %d", erasureID), nil); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	files.deleteObjects(ctx, keys)
+	return nil
+}
+
+// erasureCount is what an erasure did to one table.
+type erasureCount struct {
+	Deleted    int64 `json:"deleted,omitempty"`
+	Anonymized int64 `json:"anonymized,omitempty"`
+	Kept       int64 `json:"kept,omitempty"`
+}
+
+// deletePersonalData removes a user's rows from every table that only
+// holds personal data, in dependency order, and clears audit details that
+// name the user. Files, content, payments and the user row are left to the
+// caller.
+func deletePersonalData(ctx context.Context, tx pgx.Tx, userID int64) (map[string]erasureCount, error) {
+	summary := make(map[string]erasureCount)
+	for _, step := range []struct{ table, query string }{
+		{"notification_deliveries", "DELETE FROM iraven.notification_deliveries WHERE user_id = $1"},
+		{"notifications", "DELETE FROM iraven.notifications WHERE user_id = $1"},
+		{"notification_preferences", "DELETE FROM iraven.notification_preferences WHERE user_id = $1"},
+		{"notification_devices", "DELETE FROM iraven.notification_devices WHERE user_id = $1"},
+		{"notification_device_removals", "DELETE FROM iraven.notification_device_removals WHERE user_id = $1"},
+		{"user_roles", "DELETE FROM iraven.user_roles WHERE user_id = $1"},
+		{"profiles", "DELETE FROM iraven.profiles WHERE user_id = $1"},
+		{"storage_quotas", "DELETE FROM iraven.storage_quotas WHERE subject_type = 'user' AND subject_id = $1"},
+	} {
+		tag, err := tx.Exec(ctx, step.query, userID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", step.table, err)
+		}
+		summary[step.table] = erasureCount{Deleted: tag.RowsAffected()}
+	}
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE iraven.user_audit_events SET details = NULL
+		WHERE user_id = $1 AND action IN ('updated', 'profile_photo_set') AND details IS NOT NULL`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("user_audit_events: %w", err)
+	}
+	summary["user_audit_events"] = erasureCount{Anonymized: tag.RowsAffected()}
+	return summary, nil
+}
+
+// retainedRecords counts what a user must not be deleted from under:
+// payments kept for accounting, authored content and uploads.
+func retainedRecords(ctx context.Context, q querier, userID int64) (payments, content, files int64, err error) {
+	err = q.QueryRow(ctx,
+		`SELECT (SELECT COUNT(*) FROM iraven.payments WHERE user_id = $1),
+			(SELECT COUNT(*) FROM iraven.content WHERE created_by = $1),
+			(SELECT COUNT(*) FROM iraven.files WHERE uploaded_by = $1)`, userID).
+		Scan(&payments, &content, &files)
+	return
+}
+
+// UserErasure is an erasure request with its summary decoded for display.
+type UserErasure struct {
+	models.UserErasure
+}
+
+// Counts is what the erasure did per table, for completed erasures.
+func (e UserErasure) Counts() map[string]erasureCount {
+	counts := map[string]erasureCount{}
+	if e.Summary != nil {
+		json.Unmarshal([]byte(*e.Summary), &counts)
+	}
+	return counts
+}
+
+func userErasures(db *database.Database, userID int64) ([]UserErasure, error) {
+	rows, err := db.Pool.Query(context.Background(),
+		`SELECT e.id, e.user_id, e.reason, e.status, e.scheduled_for, e.requested_by, e.canceled_by, e.summary,
+			e.error, e.completed_at, e.created_at, e.updated_at, COALESCE(u.email, '')
+		FROM iraven.user_erasures e
+		LEFT JOIN iraven.users u ON u.id = e.requested_by
+		WHERE e.user_id = $1
+		ORDER BY e.created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var erasures []UserErasure
+	for rows.Next() {
+		var e UserErasure
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Reason, &e.Status, &e.ScheduledFor, &e.RequestedBy, &e.CanceledBy,
+			&e.Summary, &e.Error, &e.CompletedAt, &e.CreatedAt, &e.UpdatedAt, &e.RequesterEmail); err != nil {
+			continue
+		}
+		erasures = append(erasures, e)
+	}
+	return erasures, rows.Err()
+}
+
+// pendingErasure returns a user's pending erasure, or nil.
+func pendingErasure(db *database.Database, userID int64) (*models.UserErasure, error) {
+	var e models.UserErasure
+	err := db.Pool.QueryRow(context.Background(),
+		`SELECT id, user_id, reason, status, scheduled_for, error, created_at
+		FROM iraven.user_erasures WHERE user_id = $1 AND status = 'pending'`, userID).
+		Scan(&e.ID, &e.UserID, &e.Reason, &e.Status, &e.ScheduledFor, &e.Error, &e.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
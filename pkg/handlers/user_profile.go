@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/iraven/iraven-admin/pkg/database"
+	"github.com/iraven/iraven-admin/pkg/imaging"
+	"github.com/iraven/iraven-admin/pkg/middleware"
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/iraven/iraven-admin/pkg/storage"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// UserProfile is a user's row in iraven.profiles with the names of its
+// language and country. Exists is false when the user has no profile yet.
+type UserProfile struct {
+	models.Profile
+	Exists       bool
+	LanguageName string
+	LanguageCode string
+	CountryName  string
+	CountryCode  string
+	// PhotoFile is the recorded file behind Photo, if it is one of ours
+	PhotoFile *models.FileWithUploader
+}
+
+// ExtraDataIndented is the extra data pretty-printed for display.
+func (p UserProfile) ExtraDataIndented() string {
+	if p.ExtraData == nil {
+		return ""
+	}
+	return indentJSON(*p.ExtraData)
+}
+
+func userProfile(db *database.Database, userID int64) (UserProfile, error) {
+	p := UserProfile{Profile: models.Profile{UserID: userID}}
+	err := db.Pool.QueryRow(context.Background(),
+		`SELECT p.language_id, p.country_id, p.photo, p.extra_data::text, p.created_at, p.updated_at,
+			COALESCE(l.name, ''), COALESCE(l.code, ''), COALESCE(c.name, ''), COALESCE(c.code, '')
+		FROM iraven.profiles p
+		LEFT JOIN iraven.languages l ON l.id = p.language_id
+		LEFT JOIN iraven.countries c ON c.id = p.country_id
+		WHERE p.user_id = $1`, userID).
+		Scan(&p.LanguageID, &p.CountryID, &p.Photo, &p.ExtraData, &p.CreatedAt, &p.UpdatedAt,
+			&p.LanguageName, &p.LanguageCode, &p.CountryName, &p.CountryCode)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return p, nil
+	}
+	if err != nil {
+		return p, err
+	}
+	p.Exists = true
+
+	if p.Photo != nil {
+		files, err := filesByURL(db, []string{*p.Photo})
+		if err != nil {
+			return p, err
+		}
+		if len(files) > 0 {
+			p.PhotoFile = &files[0]
+		}
+	}
+	return p, nil
+}
+
+// EditProfile shows the profile form of a user.
+func (h *UserHandler) EditProfile(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	u, err := h.getUser(id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "User not found")
+	}
+	profile, err := userProfile(h.db, id)
+	if err != nil {
+		return err
+	}
+
+	return h.renderProfileForm(c, http.StatusOK, u, profile, profile.ExtraDataIndented(), "")
+}
+
+// UpdateProfile saves the language, country and extra data of a user's
+// profile, creating the profile if the user has none. Extra data must be
+// a JSON object; an empty editor clears it.
+func (h *UserHandler) UpdateProfile(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	u, err := h.getUser(id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "User not found")
+	}
+	profile, err := userProfile(h.db, id)
+	if err != nil {
+		return err
+	}
+
+	profile.LanguageID = optionalID(c.FormValue("language_id"))
+	profile.CountryID = optionalID(c.FormValue("country_id"))
+
+	extraData := strings.TrimSpace(c.FormValue("extra_data"))
+	profile.ExtraData, err = parseExtraData(extraData)
+	if err != nil {
+		return h.renderProfileForm(c, http.StatusBadRequest, u, profile, extraData,
+			"Extra data must be a JSON object: "+err.Error())
+	}
+
+	_, err = h.db.Pool.Exec(context.Background(),
+		`INSERT INTO iraven.profiles (user_id, language_id, country_id, extra_data)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET language_id = EXCLUDED.language_id, country_id = EXCLUDED.country_id,
+			extra_data = EXCLUDED.extra_data, updated_at = NOW()`,
+		id, profile.LanguageID, profile.CountryID, profile.ExtraData)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to update profile: "+err.Error())
+	}
+	recordUserEvent(h.db.Pool, id, "profile_updated", "", currentUserIDPtr(c))
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/users/%d#profile", id))
+}
+
+// RemoveProfilePhoto clears a user's profile photo. The file itself stays
+// until the orphan cleanup removes it.
+func (h *UserHandler) RemoveProfilePhoto(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	_, err := h.db.Pool.Exec(context.Background(),
+		"UPDATE iraven.profiles SET photo = NULL, updated_at = NOW() WHERE user_id = $1", id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to remove photo: "+err.Error())
+	}
+	recordUserEvent(h.db.Pool, id, "profile_photo_removed", "", currentUserIDPtr(c))
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/users/%d/profile", id))
+}
+
+func (h *UserHandler) renderProfileForm(c echo.Context, status int, u models.User, profile UserProfile,
+	extraData, errMsg string) error {
+	languages, err := listLanguages(h.db)
+	if err != nil {
+		return err
+	}
+	countries, err := (&CountryHandler{h.db}).countries("", 0, 0)
+	if err != nil {
+		return err
+	}
+
+	// The selects compare against plain IDs, 0 being none
+	var languageID, countryID int64
+	if profile.LanguageID != nil {
+		languageID = *profile.LanguageID
+	}
+	if profile.CountryID != nil {
+		countryID = *profile.CountryID
+	}
+
+	data := map[string]interface{}{
+		"Title":      "Edit Profile",
+		"User":       u,
+		"Profile":    profile,
+		"LanguageID": languageID,
+		"CountryID":  countryID,
+		"ExtraData":  extraData,
+		"Languages":  languages,
+		"Countries":  countries,
+		"Error":      errMsg,
+	}
+
+	return c.Render(status, "users/profile", data)
+}
+
+func (h *UserHandler) getUser(id int64) (models.User, error) {
+	var u models.User
+	err := h.db.Pool.QueryRow(context.Background(),
+		"SELECT id, email, name, picture FROM iraven.users WHERE id = $1", id).
+		Scan(&u.ID, &u.Email, &u.Name, &u.Picture)
+	return u, err
+}
+
+// parseExtraData checks that extra data is a JSON object. Empty input
+// clears it.
+func parseExtraData(s string) (*string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &object); err != nil {
+		return nil, err
+	}
+	if object == nil {
+		return nil, errors.New("got null")
+	}
+	return &s, nil
+}
+
+// UploadProfilePhoto stores an image as a user's profile photo, through
+// the same pipeline as other uploads, and replaces the previous photo. The
+// profile keeps the file's public URL, which apps load directly.
+func (h *FileHandler) UploadProfilePhoto(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	var exists bool
+	h.db.Pool.QueryRow(context.Background(), "SELECT EXISTS (SELECT 1 FROM iraven.users WHERE id = $1)", id).Scan(&exists)
+	if !exists {
+		return echo.NewHTTPError(http.StatusNotFound, "User not found")
+	}
+
+	fh, err := c.FormFile("photo")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Choose a photo to upload")
+	}
+	if h.maxUploadSize > 0 && fh.Size > h.maxUploadSize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("%s is larger than the %d byte upload limit", fh.Filename, h.maxUploadSize))
+	}
+	if mimeType, err := sniffUpload(fh); err != nil || !imaging.CanDecode(mimeType) {
+		return echo.NewHTTPError(http.StatusBadRequest, "The photo must be a JPEG, PNG or GIF image")
+	}
+	if err := checkQuota(c.Request().Context(), h.db.Pool, h.quotas, middleware.CurrentUserID(c), fh.Size); err != nil {
+		return err
+	}
+
+	f, err := h.saveUpload(c, fh)
+	if err != nil {
+		return uploadError(fh, err)
+	}
+
+	_, err = h.db.Pool.Exec(context.Background(),
+		`INSERT INTO iraven.profiles (user_id, photo)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET photo = EXCLUDED.photo, updated_at = NOW()`,
+		id, f.URL)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Failed to set photo: "+err.Error())
+	}
+	recordUserEvent(h.db.Pool, id, "profile_photo_set", f.OriginalName, currentUserIDPtr(c))
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/users/%d/profile", id))
+}
+
+// sniffUpload detects the type of an uploaded file from its contents.
+func sniffUpload(fh *multipart.FileHeader) (string, error) {
+	src, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	head := make([]byte, storage.SniffLen)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return storage.DetectMIME(head[:n], fh.Filename), nil
+}
+
+func optionalID(value string) *int64 {
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id == 0 {
+		return nil
+	}
+	return &id
+}
+
+// indentJSON pretty-prints a JSON document, or returns it unchanged if it
+// is not valid.
+func indentJSON(s string) string {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(s), "", "  "); err != nil {
+		return s
+	}
+	return buf.String()
+}
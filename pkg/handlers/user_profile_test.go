@@ -0,0 +1,34 @@
+package handlers
+
+import "testing"
+
+func TestParseExtraData(t *testing.T) {
+	tests := []struct {
+		input string
+		valid bool
+	}{
+		{"", true},
+		{`{}`, true},
+		{`{"plan": "pro", "tags": ["a"]}`, true},
+		{`null`, false},
+		{`[]`, false},
+		{`"text"`, false},
+		{`42`, false},
+		{`{"a": 1`, false},
+	}
+	for _, tt := range tests {
+		got, err := parseExtraData(tt.input)
+		if (err == nil) != tt.valid {
+			t.Errorf("%q: error %v, want valid %v", tt.input, err, tt.valid)
+			continue
+		}
+		switch {
+		case err != nil && got != nil:
+			t.Errorf("%q: got %q with an error", tt.input, *got)
+		case err == nil && tt.input == "" && got != nil:
+			t.Errorf("empty input: got %q, want nil", *got)
+		case err == nil && tt.input != "" && (got == nil || *got != tt.input):
+			t.Errorf("%q: got %v", tt.input, got)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/iraven/iraven-admin/pkg/database"
+	"github.com/iraven/iraven-admin/pkg/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// timelineKinds are the event types a user's timeline can be filtered by,
+// in the order of the filter buttons.
+var timelineKinds = []string{"login", "role", "notification", "payment", "file", "content", "audit"}
+
+// timelineSummaries describes each timeline action.
+var timelineSummaries = map[string]string{
+	"login":                  "Last login",
+	"created":                "Account created",
+	"updated":                "Account edited",
+	"role_added":             "Role granted",
+	"role_removed":           "Role removed",
+	"profile_updated":        "Profile edited",
+	"profile_photo_set":      "Profile photo uploaded",
+	"profile_photo_removed":  "Profile photo removed",
+	"quota_set":              "Storage quota set",
+	"quota_reset":            "Storage quota reset to default",
+	"device_removed":         "Device removed",
+	"data_exported":          "Personal data exported",
+	"erasure_requested":      "Erasure requested",
+	"erasure_canceled":       "Erasure canceled",
+	"erased":                 "Personal data erased",
+	"notification_received":  "Notification received",
+	"notification_read":      "Notification read",
+	"notification_delivered": "Notification delivered",
+	"payment":                "Payment",
+	"refund":                 "Refund",
+	"cancel":                 "Cancellation",
+	"file_uploaded":          "File uploaded",
+	"content_created":        "Content created",
+}
+
+const timelinePageSize = 20
+
+// userTimelineEvents merges everything recorded by or about a user into one
+// stream: the last login, role changes and other admin changes from the
+// audit trail, notifications received, read and delivered on other
+// channels, payments with their refunds and cancellations, uploads,
+// authored content and device removals. $1 is the user. A notification
+// links to the send of its first delivery, so that it appears once however
+// many delivery rows point at it.
+const userTimelineEvents = `
+	SELECT 'login' AS kind, 'login' AS action, NULL::text AS details, NULL::text AS link,
+		NULL::text AS actor_email, NULL::bigint AS amount, NULL::text AS currency, u.last_login AS occurred_at
+	FROM iraven.users u WHERE u.id = $1 AND u.last_login IS NOT NULL
+	UNION ALL
+	SELECT 'audit', 'created', NULL, NULL, NULL, NULL, NULL, u.created_at
+	FROM iraven.users u WHERE u.id = $1
+	UNION ALL
+	SELECT CASE WHEN e.action IN ('role_added', 'role_removed') THEN 'role' ELSE 'audit' END,
+		e.action, e.details, NULL, a.email, NULL, NULL, e.created_at
+	FROM iraven.user_audit_events e
+	LEFT JOIN iraven.users a ON a.id = e.performed_by
+	WHERE e.user_id = $1
+	UNION ALL
+	SELECT 'audit', 'device_removed', r.reason || COALESCE(': ' || r.device_type, ''), NULL, a.email, NULL, NULL, r.created_at
+	FROM iraven.notification_device_removals r
+	LEFT JOIN iraven.users a ON a.id = r.removed_by
+	WHERE r.user_id = $1
+	UNION ALL
+	SELECT 'notification', 'notification_received', n.title, '/notifications/' || d.send_id, NULL, NULL, NULL, n.created_at
+	FROM iraven.notifications n
+	LEFT JOIN LATERAL (
+		SELECT send_id FROM iraven.notification_deliveries WHERE notification_id = n.id ORDER BY id LIMIT 1
+	) d ON true
+	WHERE n.user_id = $1
+	UNION ALL
+	SELECT 'notification', 'notification_read', n.title, '/notifications/' || d.send_id, NULL, NULL, NULL, n.read_at
+	FROM iraven.notifications n
+	LEFT JOIN LATERAL (
+		SELECT send_id FROM iraven.notification_deliveries WHERE notification_id = n.id ORDER BY id LIMIT 1
+	) d ON true
+	WHERE n.user_id = $1 AND n.read_at IS NOT NULL
+	UNION ALL
+	SELECT 'notification', 'notification_delivered', d.channel || ': ' || COALESCE(d.title, s.title), '/notifications/' || d.send_id,
+		NULL, NULL, NULL, COALESCE(d.sent_at, d.updated_at)
+	FROM iraven.notification_deliveries d
+	JOIN iraven.notification_sends s ON s.id = d.send_id
+	WHERE d.user_id = $1 AND d.channel <> 'in_app' AND d.status = 'sent'
+	UNION ALL
+	SELECT 'payment', 'payment', p.status || COALESCE(': ' || p.description, ''), '/payments/' || p.id,
+		NULL, p.amount, p.currency, p.created_at
+	FROM iraven.payments p WHERE p.user_id = $1
+	UNION ALL
+	SELECT 'payment', pa.action, pa.status || ': ' || pa.reason, '/payments/' || pa.payment_id || '#actions',
+		a.email, NULLIF(pa.amount, 0), pa.currency, pa.created_at
+	FROM iraven.payment_actions pa
+	JOIN iraven.payments p ON p.id = pa.payment_id
+	LEFT JOIN iraven.users a ON a.id = pa.performed_by
+	WHERE p.user_id = $1
+	UNION ALL
+	SELECT 'file', 'file_uploaded', f.original_name, '/files/' || f.id, NULL, NULL, NULL, f.created_at
+	FROM iraven.files f WHERE f.uploaded_by = $1
+	UNION ALL
+	SELECT 'content', 'content_created', c.title, '/content/' || c.id, NULL, NULL, NULL, c.created_at
+	FROM iraven.content c WHERE c.created_by = $1`
+
+// UserTimeline is one page of a user's timeline.
+type UserTimeline struct {
+	Events []models.TimelineEvent
+	// Kind is the event type filtered on, empty for all
+	Kind       string
+	Kinds      []string
+	Counts     map[string]int
+	Total      int
+	Page       int
+	TotalPages int
+}
+
+// userTimeline loads a page of a user's timeline, newest first, optionally
+// limited to one kind of event.
+func userTimeline(db *database.Database, userID int64, kind string, page int) (UserTimeline, error) {
+	t := UserTimeline{Kinds: timelineKinds, Counts: map[string]int{}, Page: page}
+	for _, k := range timelineKinds {
+		if k == kind {
+			t.Kind = kind
+		}
+	}
+	if t.Page < 1 {
+		t.Page = 1
+	}
+
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx,
+		"SELECT kind, COUNT(*) FROM ("+userTimelineEvents+") t GROUP BY kind", userID)
+	if err != nil {
+		return t, err
+	}
+	for rows.Next() {
+		var k string
+		var n int
+		if err := rows.Scan(&k, &n); err != nil {
+			continue
+		}
+		t.Counts[k] = n
+		if t.Kind == "" || t.Kind == k {
+			t.Total += n
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return t, err
+	}
+	t.TotalPages = (t.Total + timelinePageSize - 1) / timelinePageSize
+
+	rows, err = db.Pool.Query(ctx,
+		`SELECT kind, action, details, link, actor_email, amount, currency, occurred_at
+		FROM (`+userTimelineEvents+`) t
+		WHERE $2 = '' OR kind = $2
+		ORDER BY occurred_at DESC, kind, action
+		LIMIT $3 OFFSET $4`,
+		userID, t.Kind, timelinePageSize, (t.Page-1)*timelinePageSize)
+	if err != nil {
+		return t, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.TimelineEvent
+		if err := rows.Scan(&e.Kind, &e.Action, &e.Details, &e.Link, &e.ActorEmail,
+			&e.Amount, &e.Currency, &e.OccurredAt); err != nil {
+			continue
+		}
+		e.Summary = timelineSummaries[e.Action]
+		if e.Summary == "" {
+			e.Summary = e.Action
+		}
+		t.Events = append(t.Events, e)
+	}
+	return t, rows.Err()
+}
+
+// recordUserEvent adds an entry to a user's audit trail. An empty details
+// is stored as NULL.
+func recordUserEvent(q querier, userID int64, action, details string, performedBy *int64) error {
+	_, err := q.Exec(context.Background(),
+		`INSERT INTO iraven.user_audit_events (user_id, action, details, performed_by)
+		VALUES ($1, $2, NULLIF($3, ''), $4)`,
+		userID, action, details, performedBy)
+	return err
+}
+
+// roleNames maps role IDs to their names, for audit details.
+func roleNames(q querier, ids []int64) (map[int64]string, error) {
+	names := make(map[int64]string)
+	rows, err := q.Query(context.Background(),
+		"SELECT id, name FROM iraven.roles WHERE id = ANY($1)", ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			continue
+		}
+		names[id] = name
+	}
+	return names, rows.Err()
+}
+
+// collectIDs reads a single column of IDs, such as the RETURNING of a
+// write, from a query.
+func collectIDs(rows pgx.Rows, err error) ([]int64, error) {
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[int64])
+}
+
+// formRoleIDs reads the role_ids checkboxes of the user forms.
+func formRoleIDs(values []string) []int64 {
+	ids := []int64{}
+	seen := make(map[int64]bool)
+	for _, v := range values {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
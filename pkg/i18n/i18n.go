@@ -0,0 +1,88 @@
+package i18n
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// NormalizeCode lowercases a language code and drops any region subtag, so
+// "pt-BR" and "pt_br" both map to "pt".
+func NormalizeCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if i := strings.IndexAny(code, "-_"); i >= 0 {
+		code = code[:i]
+	}
+	return code
+}
+
+// CanonicalCode formats a language tag the usual way: the language
+// lowercase, a four-letter script titlecased, a two-letter region
+// uppercase and "_" replaced by "-", so "ZH_hant_tw" becomes "zh-Hant-TW".
+func CanonicalCode(code string) string {
+	parts := strings.Split(strings.ReplaceAll(strings.TrimSpace(code), "_", "-"), "-")
+	for i, part := range parts {
+		part = strings.ToLower(part)
+		switch {
+		case i > 0 && len(part) == 2:
+			part = strings.ToUpper(part)
+		case i > 0 && len(part) == 4:
+			part = strings.ToUpper(part[:1]) + part[1:]
+		}
+		parts[i] = part
+	}
+	return strings.Join(parts, "-")
+}
+
+// FallbackChain lists the language codes to try, in order: the requested
+// language, then the country's default language, then the site default.
+// Empty and repeated codes are skipped.
+func FallbackChain(requested, countryDefault, siteDefault string) []string {
+	var chain []string
+	seen := map[string]bool{}
+	for _, code := range []string{requested, countryDefault, siteDefault} {
+		code = NormalizeCode(code)
+		if code == "" || seen[code] {
+			continue
+		}
+		seen[code] = true
+		chain = append(chain, code)
+	}
+	return chain
+}
+
+// Completeness reports how much of the source a translation covers: the
+// title plus every top-level key of the source JSON object counts as one
+// field, and a field is translated when the translation has a non-empty
+// value for it.
+func Completeness(sourceData, title, translatedData string) (translated, total int) {
+	total = 1
+	if strings.TrimSpace(title) != "" {
+		translated = 1
+	}
+
+	var source, target map[string]interface{}
+	json.Unmarshal([]byte(sourceData), &source)
+	json.Unmarshal([]byte(translatedData), &target)
+
+	for key := range source {
+		total++
+		if v, ok := target[key]; ok && !isEmpty(v) {
+			translated++
+		}
+	}
+	return translated, total
+}
+
+func isEmpty(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(val) == ""
+	case []interface{}:
+		return len(val) == 0
+	case map[string]interface{}:
+		return len(val) == 0
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package i18n
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeCode(t *testing.T) {
+	tests := map[string]string{
+		"de":         "de",
+		"pt-BR":      "pt",
+		"pt_br":      "pt",
+		" EN-gb ":    "en",
+		"zh-Hant-TW": "zh",
+		"":           "",
+		"-":          "",
+		"sr_Latn_RS": "sr",
+		"fil":        "fil",
+		"es-419":     "es",
+		"DE_at":      "de",
+	}
+	for code, want := range tests {
+		if got := NormalizeCode(code); got != want {
+			t.Errorf("NormalizeCode(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func TestCanonicalCode(t *testing.T) {
+	tests := map[string]string{
+		"ZH_hant_tw": "zh-Hant-TW",
+		"pt_br":      "pt-BR",
+		"en":         "en",
+		"es-419":     "es-419",
+	}
+	for code, want := range tests {
+		if got := CanonicalCode(code); got != want {
+			t.Errorf("CanonicalCode(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func TestFallbackChain(t *testing.T) {
+	tests := []struct {
+		requested, countryDefault, siteDefault string
+		want                                   []string
+	}{
+		{"fr", "de", "en", []string{"fr", "de", "en"}},
+		{"pt-BR", "pt_PT", "en", []string{"pt", "en"}},
+		{"", "de", "en", []string{"de", "en"}},
+		{"", "", "en", []string{"en"}},
+		{"EN", "", "en-US", []string{"en"}},
+		{"de-CH", "fr", "de", []string{"de", "fr"}},
+		{"", "", "", nil},
+	}
+	for _, tt := range tests {
+		got := FallbackChain(tt.requested, tt.countryDefault, tt.siteDefault)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("FallbackChain(%q, %q, %q) = %v, want %v",
+				tt.requested, tt.countryDefault, tt.siteDefault, got, tt.want)
+		}
+	}
+}
+
+func TestCompleteness(t *testing.T) {
+	source := `{"summary": "A lamp", "body": "Bright", "tags": ["home"], "specs": {"watts": 5}, "price": 10}`
+	tests := []struct {
+		name        string
+		title       string
+		translated  string
+		want, total int
+	}{
+		{"untranslated", "", "", 0, 6},
+		{"title only", "Lampe", "{}", 1, 6},
+		{"complete", "Lampe", `{"summary": "Eine Lampe", "body": "Hell", "tags": ["Haus"], "specs": {"watts": 5}, "price": 10}`, 6, 6},
+		{"empty values", "  ", `{"summary": " ", "body": null, "tags": [], "specs": {}, "price": 0}`, 1, 6},
+		{"keys the source lacks", "Lampe", `{"summary": "Eine Lampe", "colour": "rot"}`, 2, 6},
+		{"invalid translation", "Lampe", `{`, 1, 6},
+	}
+	for _, tt := range tests {
+		got, total := Completeness(source, tt.title, tt.translated)
+		if got != tt.want || total != tt.total {
+			t.Errorf("%s: Completeness = %d of %d, want %d of %d", tt.name, got, total, tt.want, tt.total)
+		}
+	}
+
+	if got, total := Completeness("", "Lampe", ""); got != 1 || total != 1 {
+		t.Errorf("no source data: Completeness = %d of %d, want 1 of 1", got, total)
+	}
+}
@@ -0,0 +1,73 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/csv"
+	"fmt"
+	"strings"
+)
+
+//go:embed iso/*.csv
+var isoFiles embed.FS
+
+// ISOLanguage is an ISO 639-1 language.
+type ISOLanguage struct {
+	Code string
+	Name string
+}
+
+// ISOCountry is an ISO 3166-1 country with the ISO 639-1 codes of the
+// languages spoken there, the first being its default.
+type ISOCountry struct {
+	Code      string
+	Name      string
+	Languages []string
+}
+
+// ISOLanguages returns the embedded ISO 639-1 reference list.
+func ISOLanguages() ([]ISOLanguage, error) {
+	records, err := readISOFile("iso/languages.csv", 2)
+	if err != nil {
+		return nil, err
+	}
+
+	languages := make([]ISOLanguage, 0, len(records))
+	for _, r := range records {
+		languages = append(languages, ISOLanguage{Code: r[0], Name: r[1]})
+	}
+	return languages, nil
+}
+
+// ISOCountries returns the embedded ISO 3166-1 alpha-2 reference list.
+func ISOCountries() ([]ISOCountry, error) {
+	records, err := readISOFile("iso/countries.csv", 3)
+	if err != nil {
+		return nil, err
+	}
+
+	countries := make([]ISOCountry, 0, len(records))
+	for _, r := range records {
+		countries = append(countries, ISOCountry{Code: r[0], Name: r[1], Languages: strings.Fields(r[2])})
+	}
+	return countries, nil
+}
+
+// readISOFile reads an embedded CSV file, dropping its header row.
+func readISOFile(name string, fields int) ([][]string, error) {
+	f, err := isoFiles.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = fields
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	return records[1:], nil
+}
@@ -0,0 +1,137 @@
+// Package imaging prepares uploaded images for serving, in pure Go: it
+// strips identifying metadata and renders thumbnails and resized variants.
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+// MaxPixels bounds the images that are decoded, so a small file claiming
+// huge dimensions cannot exhaust memory.
+const MaxPixels = 50_000_000
+
+// Spec describes a variant rendered for every processed image.
+type Spec struct {
+	Name string
+	// Width and Height bound the variant. Cropped variants are filled to
+	// exactly that size; others are scaled to fit and only rendered when
+	// the image is larger.
+	Width, Height int
+	Crop          bool
+}
+
+var Specs = []Spec{
+	{Name: "thumb", Width: 160, Height: 160, Crop: true},
+	{Name: "small", Width: 480, Height: 480},
+	{Name: "medium", Width: 1200, Height: 1200},
+}
+
+type Variant struct {
+	Name          string
+	Width, Height int
+	MimeType      string
+	Data          []byte
+}
+
+type Result struct {
+	// Data is the image to store in place of the upload: stripped of
+	// metadata and, when the upload relied on an EXIF orientation,
+	// rotated upright.
+	Data []byte
+	// Width and Height are zero when the format cannot be decoded.
+	Width, Height int
+	Variants      []Variant
+}
+
+// CanDecode reports whether dimensions and variants can be produced for
+// the format.
+func CanDecode(mimeType string) bool {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// Process strips metadata from an uploaded image and renders its variants.
+func Process(data []byte, mimeType string) (*Result, error) {
+	stripped, orientation, err := StripMetadata(data, mimeType)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Data: stripped}
+	if !CanDecode(mimeType) {
+		return result, nil
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(stripped))
+	if err != nil {
+		return nil, fmt.Errorf("imaging: %w", err)
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return nil, fmt.Errorf("imaging: %dx%d image is too large to process", cfg.Width, cfg.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(stripped))
+	if err != nil {
+		return nil, fmt.Errorf("imaging: %w", err)
+	}
+
+	if orientation > 1 {
+		img = Orient(img, orientation)
+		if result.Data, err = encode(img, mimeType, 92); err != nil {
+			return nil, err
+		}
+	}
+	result.Width, result.Height = img.Bounds().Dx(), img.Bounds().Dy()
+
+	// Variants of JPEGs stay JPEG; anything that may be transparent, and
+	// the first frame of GIFs, become PNG
+	variantType := "image/png"
+	if mimeType == "image/jpeg" {
+		variantType = "image/jpeg"
+	}
+
+	for _, spec := range Specs {
+		var v image.Image
+		if spec.Crop {
+			v = Fill(img, spec.Width, spec.Height)
+		} else {
+			if result.Width <= spec.Width && result.Height <= spec.Height {
+				continue
+			}
+			v = Fit(img, spec.Width, spec.Height)
+		}
+		encoded, err := encode(v, variantType, 82)
+		if err != nil {
+			return nil, err
+		}
+		result.Variants = append(result.Variants, Variant{
+			Name:     spec.Name,
+			Width:    v.Bounds().Dx(),
+			Height:   v.Bounds().Dy(),
+			MimeType: variantType,
+			Data:     encoded,
+		})
+	}
+	return result, nil
+}
+
+func encode(img image.Image, mimeType string, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch mimeType {
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	case "image/gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		err = png.Encode(&buf, img)
+	}
+	return buf.Bytes(), err
+}
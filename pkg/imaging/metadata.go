@@ -0,0 +1,336 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errMalformed = errors.New("imaging: malformed image")
+
+// StripMetadata removes EXIF, XMP, IPTC, text and comment metadata, which
+// can carry GPS coordinates, camera serial numbers and author names, from
+// JPEG, PNG, WebP and GIF files without re-encoding them. It also returns
+// the EXIF orientation (1 when there is none), which is lost along with
+// the EXIF data and has to be applied to the pixels instead. WebP cannot
+// be decoded here, so its orientation is kept in an EXIF block of its own.
+func StripMetadata(data []byte, mimeType string) ([]byte, int, error) {
+	switch mimeType {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		return stripPNG(data)
+	case "image/webp":
+		return stripWebP(data)
+	case "image/gif":
+		return stripGIF(data)
+	}
+	return data, 1, nil
+}
+
+// CanStrip reports whether StripMetadata understands the format.
+func CanStrip(mimeType string) bool {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/webp", "image/gif":
+		return true
+	}
+	return false
+}
+
+func stripJPEG(data []byte) ([]byte, int, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, 0, errMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	orientation := 1
+
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF {
+			return nil, 0, errMalformed
+		}
+		// Markers may be preceded by any number of fill bytes
+		for i < len(data) && data[i] == 0xFF {
+			i++
+		}
+		if i >= len(data) {
+			return nil, 0, errMalformed
+		}
+		marker := data[i]
+		i++
+
+		if marker == 0xD9 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out.Write([]byte{0xFF, marker})
+			continue
+		}
+		if i+2 > len(data) {
+			return nil, 0, errMalformed
+		}
+		length := int(binary.BigEndian.Uint16(data[i:]))
+		if length < 2 || i+length > len(data) {
+			return nil, 0, errMalformed
+		}
+		segment := data[i : i+length]
+
+		if marker == 0xDA {
+			// Start of scan: the entropy-coded data and everything after
+			// it is image data
+			out.Write([]byte{0xFF, marker})
+			out.Write(data[i:])
+			return out.Bytes(), orientation, nil
+		}
+
+		keep := true
+		switch {
+		case marker == 0xE1:
+			if bytes.HasPrefix(segment[2:], []byte("Exif\x00\x00")) {
+				orientation = exifOrientation(segment[8:])
+			}
+			keep = false
+		case marker == 0xFE:
+			keep = false
+		case marker >= 0xE3 && marker <= 0xEF && marker != 0xEE:
+			// APP3 and up hold IPTC, Photoshop and vendor data; APP0
+			// (JFIF), APP2 (ICC profile) and APP14 (Adobe colour
+			// transform) affect how the image looks and are kept
+			keep = false
+		}
+		if keep {
+			out.Write([]byte{0xFF, marker})
+			out.Write(segment)
+		}
+		i += length
+	}
+	return nil, 0, errMalformed
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+func stripPNG(data []byte) ([]byte, int, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, 0, errMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(pngSignature)
+	orientation := 1
+
+	i := len(pngSignature)
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, 0, errMalformed
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if end > len(data) {
+			return nil, 0, errMalformed
+		}
+		chunkType := string(data[i+4 : i+8])
+
+		switch chunkType {
+		case "eXIf":
+			orientation = exifOrientation(data[i+8 : i+8+length])
+		case "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+		if chunkType == "IEND" {
+			return out.Bytes(), orientation, nil
+		}
+	}
+	return nil, 0, errMalformed
+}
+
+func stripWebP(data []byte) ([]byte, int, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, 0, errMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+	vp8x := -1
+	hasEXIF := false
+
+	i := 12
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, 0, errMalformed
+		}
+		fourCC := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if i+8+size > len(data) {
+			return nil, 0, errMalformed
+		}
+		if end > len(data) {
+			end = len(data)
+		}
+
+		switch fourCC {
+		case "EXIF":
+			// Replace the block with one holding nothing but the
+			// orientation, in its place after the image data
+			if orientation := exifOrientation(bytes.TrimPrefix(data[i+8:i+8+size], []byte("Exif\x00\x00"))); orientation > 1 {
+				exif := orientationEXIF(orientation)
+				out.WriteString("EXIF")
+				binary.Write(out, binary.LittleEndian, uint32(len(exif)))
+				out.Write(exif)
+				hasEXIF = true
+			}
+		case "XMP ":
+		default:
+			if fourCC == "VP8X" {
+				vp8x = out.Len()
+			}
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+
+	result := out.Bytes()
+	if vp8x >= 0 && vp8x+8 < len(result) {
+		// Clear the XMP presence flag, and the EXIF one unless the
+		// orientation was kept
+		result[vp8x+8] &^= 0x04
+		if !hasEXIF {
+			result[vp8x+8] &^= 0x08
+		}
+	}
+	binary.LittleEndian.PutUint32(result[4:], uint32(len(result)-8))
+	return result, 1, nil
+}
+
+// orientationEXIF returns a little-endian TIFF block whose only tag is the
+// orientation.
+func orientationEXIF(orientation int) []byte {
+	return []byte{
+		'I', 'I', 42, 0, 8, 0, 0, 0, // header, IFD at offset 8
+		1, 0, // one entry
+		0x12, 0x01, 3, 0, 1, 0, 0, 0, byte(orientation), 0, 0, 0, // orientation, SHORT, count 1
+		0, 0, 0, 0, // no next IFD
+	}
+}
+
+var gifHeaders = []string{"GIF87a", "GIF89a"}
+
+// stripGIF drops the comment extensions and the application extensions
+// other than the looping ones of Netscape and AnimExts; XMP is stored in
+// an application extension.
+func stripGIF(data []byte) ([]byte, int, error) {
+	if len(data) < 13 || (string(data[:6]) != gifHeaders[0] && string(data[:6]) != gifHeaders[1]) {
+		return nil, 0, errMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+
+	// Header, logical screen descriptor and global colour table
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+	if i > len(data) {
+		return nil, 0, errMalformed
+	}
+	out.Write(data[:i])
+
+	// skipSubBlocks returns the offset after the data sub-blocks at j
+	skipSubBlocks := func(j int) (int, error) {
+		for {
+			if j >= len(data) {
+				return 0, errMalformed
+			}
+			n := int(data[j])
+			j += 1 + n
+			if n == 0 {
+				return j, nil
+			}
+		}
+	}
+
+	for i < len(data) {
+		start := i
+		switch data[i] {
+		case 0x3B:
+			// Trailer
+			out.WriteByte(0x3B)
+			return out.Bytes(), 1, nil
+		case 0x2C:
+			// Image descriptor, local colour table, LZW code size and
+			// image data
+			if i+10 > len(data) {
+				return nil, 0, errMalformed
+			}
+			j := i + 10
+			if data[i+9]&0x80 != 0 {
+				j += 3 << (data[i+9]&0x07 + 1)
+			}
+			end, err := skipSubBlocks(j + 1)
+			if err != nil {
+				return nil, 0, err
+			}
+			out.Write(data[start:end])
+			i = end
+		case 0x21:
+			if i+2 > len(data) {
+				return nil, 0, errMalformed
+			}
+			label := data[i+1]
+			end, err := skipSubBlocks(i + 2)
+			if err != nil {
+				return nil, 0, err
+			}
+			keep := true
+			switch label {
+			case 0xFE:
+				keep = false
+			case 0xFF:
+				id := data[i+2 : min(i+14, end)]
+				keep = bytes.Equal(id, []byte("\x0bNETSCAPE2.0")) || bytes.Equal(id, []byte("\x0bANIMEXTS1.0"))
+			}
+			if keep {
+				out.Write(data[start:end])
+			}
+			i = end
+		default:
+			return nil, 0, errMalformed
+		}
+	}
+	// Some encoders leave off the trailer
+	out.WriteByte(0x3B)
+	return out.Bytes(), 1, nil
+}
+
+// exifOrientation reads the orientation tag from a TIFF-structured EXIF
+// block, returning 1 when it is missing or unreadable.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < entries; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			value := int(order.Uint16(tiff[entry+8:]))
+			if value >= 1 && value <= 8 {
+				return value
+			}
+			return 1
+		}
+	}
+	return 1
+}
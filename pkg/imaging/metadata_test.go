@@ -0,0 +1,314 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+const xmp = "<x:xmpmeta>secret</x:xmpmeta>"
+
+// testEXIF returns a little-endian TIFF block with an orientation tag and
+// a GPS IFD holding the latitude reference.
+func testEXIF(orientation int) []byte {
+	b := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	// IFD0 at 8: orientation and the GPS IFD pointer
+	b = append(b, 2, 0)
+	b = append(b, 0x12, 0x01, 3, 0, 1, 0, 0, 0, byte(orientation), 0, 0, 0)
+	b = append(b, 0x25, 0x88, 4, 0, 1, 0, 0, 0, 38, 0, 0, 0)
+	b = append(b, 0, 0, 0, 0)
+	// GPS IFD at 38: GPSLatitudeRef "N"
+	b = append(b, 1, 0)
+	b = append(b, 0x01, 0x00, 2, 0, 2, 0, 0, 0, 'N', 0, 0, 0)
+	return append(b, 0, 0, 0, 0)
+}
+
+// testImage is 4x2 with a red top-left pixel.
+func testImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			img.Set(x, y, color.RGBA{0, 0, 255, 255})
+		}
+	}
+	img.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	return img
+}
+
+func jpegSegment(marker byte, data []byte) []byte {
+	b := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(data)+2))
+	return append(b, data...)
+}
+
+func testJPEG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	out := append([]byte{}, encoded[:2]...)
+	out = append(out, jpegSegment(0xE1, append([]byte("Exif\x00\x00"), testEXIF(6)...))...)
+	out = append(out, jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00"+xmp))...)
+	out = append(out, jpegSegment(0xFE, []byte("secret comment"))...)
+	return append(out, encoded[2:]...)
+}
+
+func pngChunk(chunkType string, data []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	b = append(b, chunkType...)
+	b = append(b, data...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b[4:]))
+}
+
+func testPNG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	// After the signature and IHDR
+	const ihdrEnd = 8 + 25
+	out := append([]byte{}, encoded[:ihdrEnd]...)
+	out = append(out, pngChunk("tEXt", []byte("Author\x00secret"))...)
+	out = append(out, pngChunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"+xmp))...)
+	out = append(out, pngChunk("eXIf", testEXIF(6))...)
+	return append(out, encoded[ihdrEnd:]...)
+}
+
+func riffChunk(fourCC string, data []byte) []byte {
+	b := append([]byte(fourCC), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+	b = append(b, data...)
+	if len(data)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// testWebP builds an extended-format WebP with EXIF and XMP chunks. The
+// VP8L payload is opaque to StripMetadata and is not a real bitstream.
+func testWebP(orientation int) []byte {
+	vp8x := []byte{0x08 | 0x04, 0, 0, 0, 3, 0, 0, 1, 0, 0}
+	body := []byte("WEBP")
+	body = append(body, riffChunk("VP8X", vp8x)...)
+	body = append(body, riffChunk("VP8L", []byte("pixel data"))...)
+	body = append(body, riffChunk("EXIF", append([]byte("Exif\x00\x00"), testEXIF(orientation)...))...)
+	body = append(body, riffChunk("XMP ", []byte(xmp))...)
+	return append(append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...), body...)
+}
+
+func gifSubBlocks(data []byte) []byte {
+	var b []byte
+	for len(data) > 0 {
+		n := min(len(data), 255)
+		b = append(b, byte(n))
+		b = append(b, data[:n]...)
+		data = data[n:]
+	}
+	return append(b, 0)
+}
+
+// testGIF is a looping two-frame animation with a comment and an XMP
+// application extension.
+func testGIF(t *testing.T) []byte {
+	frame := image.NewPaletted(image.Rect(0, 0, 4, 2), palette.Plan9)
+	var buf bytes.Buffer
+	err := gif.EncodeAll(&buf, &gif.GIF{
+		Image:     []*image.Paletted{frame, frame},
+		Delay:     []int{10, 10},
+		LoopCount: 0,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	start := 13
+	if encoded[10]&0x80 != 0 {
+		start += 3 << (encoded[10]&0x07 + 1)
+	}
+	out := append([]byte{}, encoded[:start]...)
+	out = append(out, 0x21, 0xFE)
+	out = append(out, gifSubBlocks([]byte("secret comment"))...)
+	out = append(out, 0x21, 0xFF)
+	out = append(out, gifSubBlocks([]byte("XMP DataXMP"))[:12]...)
+	out = append(out, gifSubBlocks([]byte(xmp))...)
+	return append(out, encoded[start:]...)
+}
+
+func TestStripMetadata(t *testing.T) {
+	tests := []struct {
+		name        string
+		mimeType    string
+		data        []byte
+		orientation int
+		// The size after Process, which applies the orientation; zero
+		// when the format cannot be decoded
+		width, height int
+	}{
+		{"jpeg", "image/jpeg", testJPEG(t), 6, 2, 4},
+		{"png", "image/png", testPNG(t), 6, 2, 4},
+		{"webp", "image/webp", testWebP(6), 1, 0, 0},
+		{"gif", "image/gif", testGIF(t), 1, 4, 2},
+	}
+	for _, tt := range tests {
+		if !CanStrip(tt.mimeType) {
+			t.Errorf("%s: CanStrip = false", tt.name)
+		}
+		stripped, orientation, err := StripMetadata(tt.data, tt.mimeType)
+		if err != nil {
+			t.Errorf("%s: StripMetadata: %v", tt.name, err)
+			continue
+		}
+		if orientation != tt.orientation {
+			t.Errorf("%s: orientation = %d, want %d", tt.name, orientation, tt.orientation)
+		}
+		for _, leak := range []string{"secret", "xmpmeta", "Exif\x00\x00"} {
+			if bytes.Contains(stripped, []byte(leak)) {
+				t.Errorf("%s: stripped image still contains %q", tt.name, leak)
+			}
+		}
+		if bytes.Contains(stripped, testEXIF(6)) {
+			t.Errorf("%s: stripped image still contains the GPS data", tt.name)
+		}
+
+		result, err := Process(tt.data, tt.mimeType)
+		if err != nil {
+			t.Errorf("%s: Process: %v", tt.name, err)
+			continue
+		}
+		if result.Width != tt.width || result.Height != tt.height {
+			t.Errorf("%s: processed size = %dx%d, want %dx%d", tt.name, result.Width, result.Height, tt.width, tt.height)
+		}
+		if tt.width == 0 {
+			continue
+		}
+		if _, _, err := image.Decode(bytes.NewReader(result.Data)); err != nil {
+			t.Errorf("%s: processed image does not decode: %v", tt.name, err)
+		}
+	}
+}
+
+func TestStripPNGAppliesOrientation(t *testing.T) {
+	result, err := Process(testPNG(t), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(result.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rotated a quarter turn clockwise, the top-left pixel is top-right
+	if r, _, _, _ := img.At(1, 0).RGBA(); r>>8 != 255 {
+		t.Errorf("top-right pixel = %v, want red", img.At(1, 0))
+	}
+	if r, _, _, _ := img.At(0, 0).RGBA(); r>>8 != 0 {
+		t.Errorf("top-left pixel = %v, want blue", img.At(0, 0))
+	}
+}
+
+// webpChunks walks the chunks of a WebP file, failing on a malformed one.
+func webpChunks(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	if size := binary.LittleEndian.Uint32(data[4:]); int(size) != len(data)-8 {
+		t.Fatalf("RIFF size = %d, want %d", size, len(data)-8)
+	}
+	chunks := map[string][]byte{}
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			t.Fatalf("truncated chunk header at %d", i)
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		if i+8+size > len(data) {
+			t.Fatalf("chunk %q overruns the file", data[i:i+4])
+		}
+		chunks[string(data[i:i+4])] = data[i+8 : i+8+size]
+		i += 8 + size + size%2
+	}
+	return chunks
+}
+
+func TestStripWebP(t *testing.T) {
+	tests := []struct {
+		name        string
+		orientation int
+		keepsEXIF   bool
+	}{
+		{"rotated", 6, true},
+		{"upright", 1, false},
+	}
+	for _, tt := range tests {
+		stripped, _, err := stripWebP(testWebP(tt.orientation))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		chunks := webpChunks(t, stripped)
+		if string(chunks["VP8L"]) != "pixel data" {
+			t.Errorf("%s: image data = %q", tt.name, chunks["VP8L"])
+		}
+		if _, ok := chunks["XMP "]; ok {
+			t.Errorf("%s: XMP chunk kept", tt.name)
+		}
+		flags := chunks["VP8X"][0]
+		if flags&0x04 != 0 {
+			t.Errorf("%s: XMP flag still set", tt.name)
+		}
+
+		exif, ok := chunks["EXIF"]
+		if ok != tt.keepsEXIF || (flags&0x08 != 0) != tt.keepsEXIF {
+			t.Errorf("%s: EXIF chunk kept = %v, flag = %v; want %v", tt.name, ok, flags&0x08 != 0, tt.keepsEXIF)
+		}
+		if !tt.keepsEXIF {
+			continue
+		}
+		if got := exifOrientation(exif); got != tt.orientation {
+			t.Errorf("%s: kept orientation = %d, want %d", tt.name, got, tt.orientation)
+		}
+		if !bytes.Equal(exif, orientationEXIF(tt.orientation)) {
+			t.Errorf("%s: EXIF chunk holds more than the orientation: % x", tt.name, exif)
+		}
+	}
+}
+
+func TestStripGIFKeepsAnimation(t *testing.T) {
+	stripped, _, err := stripGIF(testGIF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatalf("stripped GIF does not decode: %v", err)
+	}
+	if len(g.Image) != 2 || g.LoopCount != 0 {
+		t.Errorf("stripped GIF has %d frames and loop count %d, want 2 and 0", len(g.Image), g.LoopCount)
+	}
+}
+
+func TestStripMalformed(t *testing.T) {
+	jpegData, pngData, gifData := testJPEG(t), testPNG(t), testGIF(t)
+	tests := []struct {
+		name  string
+		strip func([]byte) ([]byte, int, error)
+		data  []byte
+	}{
+		{"jpeg without SOI", stripJPEG, []byte("not a jpeg")},
+		{"truncated jpeg", stripJPEG, jpegData[:40]},
+		{"png without signature", stripPNG, []byte("not a png")},
+		{"truncated png", stripPNG, pngData[:50]},
+		{"webp without header", stripWebP, []byte("RIFF\x00\x00\x00\x00AVI ")},
+		{"webp chunk overrun", stripWebP, append(testWebP(6)[:12], "VP8L\xff\x00\x00\x00"...)},
+		{"gif without header", stripGIF, []byte("GIF00a\x00\x00\x00\x00\x00\x00\x00")},
+		{"truncated gif", stripGIF, gifData[:len(gifData)-10]},
+	}
+	for _, tt := range tests {
+		if _, _, err := tt.strip(tt.data); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
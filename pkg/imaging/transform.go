@@ -0,0 +1,127 @@
+package imaging
+
+import (
+	"image"
+	"image/draw"
+)
+
+// toRGBA copies an image into an RGBA buffer with its origin at 0,0.
+func toRGBA(src image.Image) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+	return dst
+}
+
+// Orient applies an EXIF orientation (2-8) so the image displays upright
+// without the tag.
+func Orient(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	s := toRGBA(src)
+	w, h := s.Rect.Dx(), s.Rect.Dy()
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90 clockwise
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90 counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			si := s.PixOffset(x, y)
+			di := dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], s.Pix[si:si+4])
+		}
+	}
+	return dst
+}
+
+// Fit scales an image down to fit within maxW x maxH, keeping its aspect
+// ratio. Images that already fit are returned unchanged.
+func Fit(src image.Image, maxW, maxH int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxW && h <= maxH {
+		return src
+	}
+	dw, dh := maxW, h*maxW/w
+	if dh > maxH {
+		dw, dh = w*maxH/h, maxH
+	}
+	return scale(toRGBA(src), max(dw, 1), max(dh, 1))
+}
+
+// Fill scales and centre-crops an image to exactly w x h.
+func Fill(src image.Image, w, h int) image.Image {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+
+	// Largest centred region with the target aspect ratio
+	cw, ch := sw, sw*h/w
+	if ch > sh {
+		cw, ch = sh*w/h, sh
+	}
+	x0 := b.Min.X + (sw-cw)/2
+	y0 := b.Min.Y + (sh-ch)/2
+
+	cropped := image.NewRGBA(image.Rect(0, 0, cw, ch))
+	draw.Draw(cropped, cropped.Bounds(), src, image.Pt(x0, y0), draw.Src)
+	if cw <= w && ch <= h {
+		return cropped
+	}
+	return scale(cropped, w, h)
+}
+
+// scale resizes with a box filter: each destination pixel averages the
+// source pixels it covers, which gives clean results when shrinking.
+func scale(src *image.RGBA, dw, dh int) *image.RGBA {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for dy := 0; dy < dh; dy++ {
+		sy0 := dy * sh / dh
+		sy1 := max((dy+1)*sh/dh, sy0+1)
+		for dx := 0; dx < dw; dx++ {
+			sx0 := dx * sw / dw
+			sx1 := max((dx+1)*sw/dw, sx0+1)
+
+			var r, g, b, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				i := src.PixOffset(sx0, sy)
+				for sx := sx0; sx < sx1; sx++ {
+					r += uint64(src.Pix[i])
+					g += uint64(src.Pix[i+1])
+					b += uint64(src.Pix[i+2])
+					a += uint64(src.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+			j := dst.PixOffset(dx, dy)
+			dst.Pix[j] = uint8(r / n)
+			dst.Pix[j+1] = uint8(g / n)
+			dst.Pix[j+2] = uint8(b / n)
+			dst.Pix[j+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
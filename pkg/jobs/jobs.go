@@ -0,0 +1,65 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+type Func func(ctx context.Context) error
+
+type job struct {
+	name     string
+	interval time.Duration
+	run      Func
+}
+
+// Runner executes background jobs on fixed intervals inside the admin
+// process. Each job runs in its own goroutine, so a slow job never delays
+// the others.
+type Runner struct {
+	jobs []job
+}
+
+func NewRunner() *Runner {
+	return &Runner{}
+}
+
+func (r *Runner) Add(name string, interval time.Duration, run Func) {
+	r.jobs = append(r.jobs, job{name: name, interval: interval, run: run})
+}
+
+// Start launches all jobs and returns immediately. Jobs stop when ctx is
+// cancelled.
+func (r *Runner) Start(ctx context.Context) {
+	for _, j := range r.jobs {
+		go r.loop(ctx, j)
+	}
+}
+
+func (r *Runner) loop(ctx context.Context, j job) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		r.runOnce(ctx, j)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Runner) runOnce(ctx context.Context, j job) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("Job %s panicked: %v", j.name, rec)
+		}
+	}()
+
+	if err := j.run(ctx); err != nil {
+		log.Printf("Job %s failed: %v", j.name, err)
+	}
+}
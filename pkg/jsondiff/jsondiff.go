@@ -0,0 +1,139 @@
+package jsondiff
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	Equal   = "equal"
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// Row is one line of a side-by-side diff. A zero line number means the side
+// has no line at this position.
+type Row struct {
+	Kind      string
+	Left      string
+	Right     string
+	LeftLine  int
+	RightLine int
+}
+
+// Format pretty-prints a JSON document with sorted keys so that two versions
+// diff by value rather than by formatting. Input that is not valid JSON is
+// returned unchanged.
+func Format(doc string) string {
+	var v interface{}
+	if err := json.Unmarshal([]byte(doc), &v); err != nil {
+		return doc
+	}
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return doc
+	}
+	return string(out)
+}
+
+// maxTableCells caps the LCS table at about 8 MB. When the changed middle of
+// two documents is larger than that, it is shown as one replaced block.
+const maxTableCells = 1 << 20
+
+// SideBySide diffs two JSON documents line by line.
+func SideBySide(left, right string) []Row {
+	a := splitLines(Format(left))
+	b := splitLines(Format(right))
+
+	// Lines both documents start and end with need no table
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+
+	// Longest common subsequence table of the middle
+	var lcs [][]int
+	if (len(ma)+1)*(len(mb)+1) <= maxTableCells {
+		lcs = make([][]int, len(ma)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(mb)+1)
+		}
+		for i := len(ma) - 1; i >= 0; i-- {
+			for j := len(mb) - 1; j >= 0; j-- {
+				if ma[i] == mb[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+	}
+
+	var rows []Row
+	var removed, added []Row
+	flush := func() {
+		// Pair removals with additions so edited lines sit side by side
+		n := len(removed)
+		if len(added) > n {
+			n = len(added)
+		}
+		for k := 0; k < n; k++ {
+			var row Row
+			switch {
+			case k < len(removed) && k < len(added):
+				row = Row{Kind: Changed, Left: removed[k].Left, LeftLine: removed[k].LeftLine,
+					Right: added[k].Right, RightLine: added[k].RightLine}
+			case k < len(removed):
+				row = removed[k]
+			default:
+				row = added[k]
+			}
+			rows = append(rows, row)
+		}
+		removed, added = nil, nil
+	}
+
+	for k := 0; k < pre; k++ {
+		rows = append(rows, Row{Kind: Equal, Left: a[k], Right: b[k], LeftLine: k + 1, RightLine: k + 1})
+	}
+
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		switch {
+		case lcs != nil && i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+			flush()
+			rows = append(rows, Row{Kind: Equal, Left: ma[i], Right: mb[j], LeftLine: pre + i + 1, RightLine: pre + j + 1})
+			i++
+			j++
+		case j < len(mb) && (i == len(ma) || lcs != nil && lcs[i][j+1] >= lcs[i+1][j]):
+			added = append(added, Row{Kind: Added, Right: mb[j], RightLine: pre + j + 1})
+			j++
+		default:
+			removed = append(removed, Row{Kind: Removed, Left: ma[i], LeftLine: pre + i + 1})
+			i++
+		}
+	}
+	flush()
+
+	for k := 0; k < suf; k++ {
+		l, r := len(a)-suf+k, len(b)-suf+k
+		rows = append(rows, Row{Kind: Equal, Left: a[l], Right: b[r], LeftLine: l + 1, RightLine: r + 1})
+	}
+
+	return rows
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimRight(s, "\n"), "\n")
+}
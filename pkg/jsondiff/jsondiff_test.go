@@ -0,0 +1,139 @@
+package jsondiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	got := Format(`{"b":1,"a":[true,null]}`)
+	want := "{\n  \"a\": [\n    true,\n    null\n  ],\n  \"b\": 1\n}"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := Format("{not json"); got != "{not json" {
+		t.Errorf("invalid JSON: got %q", got)
+	}
+}
+
+func TestSideBySide(t *testing.T) {
+	tests := []struct {
+		name        string
+		left, right string
+		want        []Row
+	}{
+		{
+			name: "equal apart from formatting",
+			left: `{"a":1,"b":2}`, right: "{\"b\": 2,\n\"a\": 1}",
+			want: []Row{
+				{Kind: Equal, Left: "{", Right: "{", LeftLine: 1, RightLine: 1},
+				{Kind: Equal, Left: `  "a": 1,`, Right: `  "a": 1,`, LeftLine: 2, RightLine: 2},
+				{Kind: Equal, Left: `  "b": 2`, Right: `  "b": 2`, LeftLine: 3, RightLine: 3},
+				{Kind: Equal, Left: "}", Right: "}", LeftLine: 4, RightLine: 4},
+			},
+		},
+		{
+			name: "changed value",
+			left: `{"a":1,"b":2,"c":3}`, right: `{"a":1,"b":5,"c":3}`,
+			want: []Row{
+				{Kind: Equal, Left: "{", Right: "{", LeftLine: 1, RightLine: 1},
+				{Kind: Equal, Left: `  "a": 1,`, Right: `  "a": 1,`, LeftLine: 2, RightLine: 2},
+				{Kind: Changed, Left: `  "b": 2,`, Right: `  "b": 5,`, LeftLine: 3, RightLine: 3},
+				{Kind: Equal, Left: `  "c": 3`, Right: `  "c": 3`, LeftLine: 4, RightLine: 4},
+				{Kind: Equal, Left: "}", Right: "}", LeftLine: 5, RightLine: 5},
+			},
+		},
+		{
+			name: "added and removed",
+			left: `{"a":1,"b":2,"d":4}`, right: `{"b":2,"c":3,"d":4}`,
+			want: []Row{
+				{Kind: Equal, Left: "{", Right: "{", LeftLine: 1, RightLine: 1},
+				{Kind: Removed, Left: `  "a": 1,`, LeftLine: 2},
+				{Kind: Equal, Left: `  "b": 2,`, Right: `  "b": 2,`, LeftLine: 3, RightLine: 2},
+				{Kind: Added, Right: `  "c": 3,`, RightLine: 3},
+				{Kind: Equal, Left: `  "d": 4`, Right: `  "d": 4`, LeftLine: 4, RightLine: 4},
+				{Kind: Equal, Left: "}", Right: "}", LeftLine: 5, RightLine: 5},
+			},
+		},
+		{
+			name: "from empty",
+			left: "", right: `{"a":1}`,
+			want: []Row{
+				{Kind: Added, Right: "{", RightLine: 1},
+				{Kind: Added, Right: `  "a": 1`, RightLine: 2},
+				{Kind: Added, Right: "}", RightLine: 3},
+			},
+		},
+	}
+	for _, tt := range tests {
+		if got := SideBySide(tt.left, tt.right); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func array(n int, value func(int) string) string {
+	items := make([]string, n)
+	for i := range items {
+		items[i] = value(i)
+	}
+	out, _ := json.Marshal(items)
+	return string(out)
+}
+
+func TestSideBySideLarge(t *testing.T) {
+	// One edit in a long array: the shared ends are matched without a table
+	left := array(50000, func(i int) string { return fmt.Sprint(i) })
+	right := array(50000, func(i int) string {
+		if i == 25000 {
+			return "edited"
+		}
+		return fmt.Sprint(i)
+	})
+	rows := SideBySide(left, right)
+	if len(rows) != 50002 {
+		t.Fatalf("got %d rows, want 50002", len(rows))
+	}
+	for k, row := range rows {
+		want := Equal
+		if k == 25001 {
+			want = Changed
+		}
+		if row.Kind != want {
+			t.Fatalf("row %d: got %s, want %s", k, row.Kind, want)
+		}
+	}
+	if r := rows[25001]; r.Left != `  "25000",` || r.Right != `  "edited",` || r.LeftLine != 25002 || r.RightLine != 25002 {
+		t.Errorf("changed row: %+v", r)
+	}
+
+	// A middle too large for the table is shown as one replaced block
+	left = array(3000, func(i int) string { return fmt.Sprint("a", i) })
+	right = array(2000, func(i int) string { return fmt.Sprint("b", i) })
+	rows = SideBySide(left, right)
+	if len(rows) != 3002 {
+		t.Fatalf("got %d rows, want 3002", len(rows))
+	}
+	for k, row := range rows {
+		var want string
+		switch {
+		case k == 0 || k == 3001:
+			want = Equal
+		case k <= 2000:
+			want = Changed
+		default:
+			want = Removed
+		}
+		if row.Kind != want {
+			t.Fatalf("row %d: got %s, want %s", k, row.Kind, want)
+		}
+	}
+	if r := rows[3000]; r.LeftLine != 3001 || r.RightLine != 0 {
+		t.Errorf("last removed row: %+v", r)
+	}
+	if r := rows[3001]; r.LeftLine != 3002 || r.RightLine != 2002 {
+		t.Errorf("closing row: %+v", r)
+	}
+}
@@ -0,0 +1,184 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// FieldPrefix namespaces generated form inputs so they cannot collide with
+// the rest of the form.
+const FieldPrefix = "field."
+
+const (
+	WidgetText     = "text"
+	WidgetTextarea = "textarea"
+	WidgetNumber   = "number"
+	WidgetCheckbox = "checkbox"
+	WidgetSelect   = "select"
+	WidgetJSON     = "json"
+	WidgetDate     = "date"
+)
+
+// Field describes one top-level property as an HTML form control.
+type Field struct {
+	Name        string
+	InputName   string
+	Label       string
+	Description string
+	Widget      string
+	Required    bool
+	Options     []string
+	Value       string
+	Checked     bool
+	Step        string
+	Error       string
+}
+
+// Fields lays out the schema's top-level properties as form controls,
+// pre-filled from doc (a JSON object) and annotated with errs.
+func (s *Schema) Fields(doc string, errs []FieldError) []Field {
+	values := map[string]interface{}{}
+	if doc != "" {
+		json.Unmarshal([]byte(doc), &values)
+	}
+
+	errByField := map[string]string{}
+	for _, e := range errs {
+		name := e.Path
+		if i := strings.IndexAny(name, ".["); i >= 0 {
+			name = name[:i]
+		}
+		if _, seen := errByField[name]; !seen {
+			errByField[name] = e.Error()
+		}
+	}
+
+	var fields []Field
+	for _, name := range s.PropertyOrder {
+		prop := s.Properties[name]
+		f := Field{
+			Name:        name,
+			InputName:   FieldPrefix + name,
+			Label:       prop.Title,
+			Description: prop.Description,
+			Required:    s.IsRequired(name),
+			Error:       errByField[name],
+		}
+		if f.Label == "" {
+			f.Label = name
+		}
+
+		v, present := values[name]
+		if !present {
+			v = prop.Default
+		}
+
+		switch t := prop.PrimaryType(); {
+		case len(prop.Enum) > 0 && (t == "string" || t == ""):
+			f.Widget = WidgetSelect
+			for _, opt := range prop.Enum {
+				f.Options = append(f.Options, fmt.Sprint(opt))
+			}
+			if v != nil {
+				f.Value = fmt.Sprint(v)
+			}
+		case t == "string":
+			f.Widget = WidgetText
+			if prop.Format == "date" {
+				f.Widget = WidgetDate
+			} else if prop.Format == "" && (prop.MaxLength == nil || *prop.MaxLength > 255) {
+				f.Widget = WidgetTextarea
+			}
+			if sv, ok := v.(string); ok {
+				f.Value = sv
+			}
+		case t == "integer" || t == "number":
+			f.Widget = WidgetNumber
+			f.Step = "any"
+			if t == "integer" {
+				f.Step = "1"
+			}
+			if nv, ok := v.(float64); ok {
+				f.Value = strconv.FormatFloat(nv, 'f', -1, 64)
+			}
+		case t == "boolean":
+			f.Widget = WidgetCheckbox
+			f.Checked, _ = v.(bool)
+		default:
+			f.Widget = WidgetJSON
+			if v != nil {
+				b, _ := json.MarshalIndent(v, "", "  ")
+				f.Value = string(b)
+			}
+		}
+
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// FromForm builds a JSON document from the inputs rendered by Fields, on top
+// of base so that properties the schema does not describe are kept. It
+// reports conversion problems (for example a non-numeric number field) as
+// field errors; schema validation is left to Validate.
+func (s *Schema) FromForm(form url.Values, base string) (string, []FieldError) {
+	doc := map[string]interface{}{}
+	if base != "" {
+		json.Unmarshal([]byte(base), &doc)
+	}
+	var errs []FieldError
+
+	for _, name := range s.PropertyOrder {
+		prop := s.Properties[name]
+		delete(doc, name)
+		raw, submitted := form[FieldPrefix+name]
+		value := ""
+		if len(raw) > 0 {
+			value = strings.TrimSpace(raw[0])
+		}
+
+		t := prop.PrimaryType()
+		if t == "boolean" {
+			doc[name] = submitted && value != "" && value != "false"
+			continue
+		}
+		if value == "" {
+			continue
+		}
+
+		switch t {
+		case "string", "":
+			doc[name] = value
+		case "integer":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				errs = append(errs, FieldError{Path: name, Message: "must be a whole number"})
+				continue
+			}
+			doc[name] = n
+		case "number":
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				errs = append(errs, FieldError{Path: name, Message: "must be a number"})
+				continue
+			}
+			doc[name] = n
+		default:
+			var v interface{}
+			if err := json.Unmarshal([]byte(value), &v); err != nil {
+				errs = append(errs, FieldError{Path: name, Message: "not valid JSON: " + err.Error()})
+				continue
+			}
+			doc[name] = v
+		}
+	}
+
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return "", append(errs, FieldError{Message: err.Error()})
+	}
+	return string(b), errs
+}
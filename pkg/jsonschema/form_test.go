@@ -0,0 +1,132 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+const formSchema = `{
+	"type": "object",
+	"properties": {
+		"title": {"type": "string", "maxLength": 80},
+		"body": {"type": "string"},
+		"status": {"type": "string", "enum": ["draft", "live"], "default": "draft"},
+		"launch": {"type": "string", "format": "date"},
+		"stock": {"type": "integer"},
+		"price": {"type": "number"},
+		"featured": {"type": "boolean"},
+		"tags": {"type": "array", "items": {"type": "string"}}
+	},
+	"required": ["title"]
+}`
+
+// submit fills in a form the way a browser posts the fields: unticked
+// checkboxes are left out.
+func submit(fields []Field, edits map[string]string) url.Values {
+	form := url.Values{}
+	for _, f := range fields {
+		value := f.Value
+		if f.Widget == WidgetCheckbox {
+			if !f.Checked {
+				continue
+			}
+			value = "on"
+		}
+		form.Set(f.InputName, value)
+	}
+	for name, value := range edits {
+		if value == "" {
+			form.Del(FieldPrefix + name)
+		} else {
+			form.Set(FieldPrefix+name, value)
+		}
+	}
+	return form
+}
+
+func TestFields(t *testing.T) {
+	s, err := Parse(formSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := `{"title": "Lamp", "stock": 3, "price": 12.5, "featured": true, "tags": ["home"]}`
+	errs := []FieldError{{Path: "tags[0]", Message: "is too short"}, {Path: "tags[1]", Message: "is too short"}}
+
+	var widgets, values []string
+	for _, f := range s.Fields(doc, errs) {
+		widgets = append(widgets, f.Widget)
+		values = append(values, f.Value)
+		switch f.Name {
+		case "title":
+			if !f.Required || f.Label != "title" {
+				t.Errorf("title = %+v", f)
+			}
+		case "featured":
+			if !f.Checked {
+				t.Error("featured is not checked")
+			}
+		case "stock":
+			if f.Step != "1" {
+				t.Errorf("stock step = %q, want 1", f.Step)
+			}
+		case "tags":
+			if f.Error != "tags[0]: is too short" {
+				t.Errorf("tags error = %q", f.Error)
+			}
+		}
+	}
+	wantWidgets := []string{WidgetText, WidgetTextarea, WidgetSelect, WidgetDate, WidgetNumber, WidgetNumber, WidgetCheckbox, WidgetJSON}
+	if !reflect.DeepEqual(widgets, wantWidgets) {
+		t.Errorf("widgets = %v, want %v", widgets, wantWidgets)
+	}
+	wantValues := []string{"Lamp", "", "draft", "", "3", "12.5", "", "[\n  \"home\"\n]"}
+	if !reflect.DeepEqual(values, wantValues) {
+		t.Errorf("values = %q, want %q", values, wantValues)
+	}
+}
+
+func TestFromFormRoundTrip(t *testing.T) {
+	s, err := Parse(formSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := `{"title": "Lamp", "stock": 3, "price": 12.5, "featured": true, "tags": ["home"], "legacy": {"id": 7}}`
+
+	tests := []struct {
+		name  string
+		edits map[string]string
+		want  string
+		errs  []FieldError
+	}{
+		{"unchanged", nil,
+			`{"featured": true, "legacy": {"id": 7}, "price": 12.5, "status": "draft", "stock": 3, "tags": ["home"], "title": "Lamp"}`, nil},
+		{"checkbox unticked", map[string]string{"featured": ""},
+			`{"featured": false, "legacy": {"id": 7}, "price": 12.5, "status": "draft", "stock": 3, "tags": ["home"], "title": "Lamp"}`, nil},
+		{"cleared fields are removed", map[string]string{"price": " ", "tags": "", "status": "live"},
+			`{"featured": true, "legacy": {"id": 7}, "status": "live", "stock": 3, "title": "Lamp"}`, nil},
+		{"integer parse error", map[string]string{"stock": "3.5", "price": "abc"},
+			`{"featured": true, "legacy": {"id": 7}, "status": "draft", "tags": ["home"], "title": "Lamp"}`,
+			[]FieldError{{"stock", "must be a whole number"}, {"price", "must be a number"}}},
+		{"edited", map[string]string{"title": "  Desk lamp ", "stock": "-2", "tags": `["home", "desk"]`},
+			`{"featured": true, "legacy": {"id": 7}, "price": 12.5, "status": "draft", "stock": -2, "tags": ["home", "desk"], "title": "Desk lamp"}`, nil},
+	}
+	for _, tt := range tests {
+		got, errs := s.FromForm(submit(s.Fields(base, nil), tt.edits), base)
+		if !reflect.DeepEqual(errs, tt.errs) {
+			t.Errorf("%s: errors = %v, want %v", tt.name, errs, tt.errs)
+		}
+		var gotDoc, wantDoc interface{}
+		json.Unmarshal([]byte(got), &gotDoc)
+		json.Unmarshal([]byte(tt.want), &wantDoc)
+		if !reflect.DeepEqual(gotDoc, wantDoc) {
+			t.Errorf("%s:\n got %s\nwant %s", tt.name, got, tt.want)
+		}
+	}
+
+	_, errs := s.FromForm(url.Values{FieldPrefix + "tags": {"[oops"}}, "")
+	if len(errs) != 1 || errs[0].Path != "tags" {
+		t.Errorf("invalid JSON field: errors = %v", errs)
+	}
+}
@@ -0,0 +1,198 @@
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// Schema is the subset of JSON Schema (draft 7) that content types use:
+// type, properties, required, enum, string/number/array bounds, pattern and
+// a handful of formats.
+type Schema struct {
+	Type                 interface{}        `json:"type,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Default              interface{}        `json:"default,omitempty"`
+
+	// PropertyOrder keeps properties in the order they were declared, so
+	// generated forms follow the schema author's layout.
+	PropertyOrder []string `json:"-"`
+
+	pattern *regexp.Regexp
+	// itemsNull is set when items was given as JSON null, which decodes
+	// the same as no items at all
+	itemsNull bool
+}
+
+var knownTypes = map[string]bool{
+	"string": true, "number": true, "integer": true, "boolean": true,
+	"object": true, "array": true, "null": true,
+}
+
+// Parse decodes and checks a schema document.
+func Parse(raw string) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal([]byte(raw), &s); err != nil {
+		return nil, fmt.Errorf("invalid schema JSON: %w", err)
+	}
+	if err := s.compile(""); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (s *Schema) UnmarshalJSON(b []byte) error {
+	type plain Schema
+	if err := json.Unmarshal(b, (*plain)(s)); err != nil {
+		return err
+	}
+
+	var raw struct {
+		Properties json.RawMessage `json:"properties"`
+		Items      json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	s.itemsNull = string(raw.Items) == "null"
+	if len(raw.Properties) == 0 {
+		return nil
+	}
+	order, err := objectKeys(raw.Properties)
+	if err != nil {
+		return err
+	}
+	s.PropertyOrder = order
+	return nil
+}
+
+// Types returns the declared type(s) of the schema.
+func (s *Schema) Types() []string {
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		var out []string
+		for _, v := range t {
+			if name, ok := v.(string); ok {
+				out = append(out, name)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// PrimaryType returns the first declared type other than null.
+func (s *Schema) PrimaryType() string {
+	for _, t := range s.Types() {
+		if t != "null" {
+			return t
+		}
+	}
+	if len(s.Properties) > 0 {
+		return "object"
+	}
+	return ""
+}
+
+func (s *Schema) IsRequired(name string) bool {
+	for _, r := range s.Required {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schema) compile(path string) error {
+	for _, t := range s.Types() {
+		if !knownTypes[t] {
+			return fmt.Errorf("%s: unknown type %q", displayPath(path), t)
+		}
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("%s: invalid pattern: %w", displayPath(path), err)
+		}
+		s.pattern = re
+	}
+	// A subschema given as null decodes to a nil *Schema
+	for _, name := range s.PropertyOrder {
+		if s.Properties[name] == nil {
+			return fmt.Errorf("%s: schema must be an object", join(path, name))
+		}
+	}
+	if s.itemsNull {
+		return fmt.Errorf("%s[]: schema must be an object", path)
+	}
+	for _, name := range s.Required {
+		if s.Properties != nil && s.Properties[name] == nil {
+			return fmt.Errorf("%s: required property %q is not defined", displayPath(path), name)
+		}
+	}
+	for _, name := range s.PropertyOrder {
+		if err := s.Properties[name].compile(join(path, name)); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		if err := s.Items.compile(path + "[]"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func objectKeys(b []byte) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := tok.(string)
+		keys = append(keys, key)
+
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "(root)"
+	}
+	return path
+}
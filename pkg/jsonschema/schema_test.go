@@ -0,0 +1,42 @@
+package jsonschema
+
+import "testing"
+
+func TestParseRejectsNullSubschemas(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{`{"type":"object","properties":{"a":null}}`, "a: schema must be an object"},
+		{`{"type":"object","properties":{"a":null},"required":["a"]}`, "a: schema must be an object"},
+		{`{"type":"object","properties":{"a":{"type":"object","properties":{"b":null}}}}`, "a.b: schema must be an object"},
+		{`{"type":"array","items":null}`, "[]: schema must be an object"},
+		{`{"type":"object","properties":{"tags":{"type":"array","items":null}}}`, "tags[]: schema must be an object"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.raw)
+		if err == nil {
+			t.Errorf("Parse(%s): expected an error", tt.raw)
+			continue
+		}
+		if err.Error() != tt.want {
+			t.Errorf("Parse(%s) = %q, want %q", tt.raw, err, tt.want)
+		}
+	}
+}
+
+func TestParseKeepsPropertyOrder(t *testing.T) {
+	s, err := Parse(`{"type":"object","properties":{"b":{"type":"string"},"a":{"type":"array","items":{"type":"integer"}}},"required":["a"]}`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(s.PropertyOrder) != 2 || s.PropertyOrder[0] != "b" || s.PropertyOrder[1] != "a" {
+		t.Errorf("PropertyOrder = %v, want [b a]", s.PropertyOrder)
+	}
+	if !s.IsRequired("a") || s.IsRequired("b") {
+		t.Errorf("IsRequired: a should be required and b not")
+	}
+	if s.Properties["a"].Items == nil || s.Properties["a"].Items.PrimaryType() != "integer" {
+		t.Errorf("items of a not decoded")
+	}
+}
@@ -0,0 +1,187 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"net/url"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+type FieldError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return displayPath(e.Path) + ": " + e.Message
+}
+
+// ValidateJSON parses doc and validates it against the schema. A document
+// that is not valid JSON yields a single root-level error.
+func (s *Schema) ValidateJSON(doc string) []FieldError {
+	var v interface{}
+	if err := json.Unmarshal([]byte(doc), &v); err != nil {
+		return []FieldError{{Message: "not valid JSON: " + err.Error()}}
+	}
+	return s.Validate(v)
+}
+
+// Validate checks a decoded JSON value against the schema.
+func (s *Schema) Validate(v interface{}) []FieldError {
+	var errs []FieldError
+	s.validate("", v, &errs)
+	return errs
+}
+
+func (s *Schema) validate(path string, v interface{}, errs *[]FieldError) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if types := s.Types(); len(types) > 0 {
+		matched := false
+		for _, t := range types {
+			if hasType(v, t) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			if len(types) == 1 {
+				fail("must be of type %s", types[0])
+			} else {
+				fail("must be one of types %v", types)
+			}
+			return
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if equal(allowed, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %v", s.Enum)
+		}
+	}
+
+	switch val := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(val)
+		if s.MinLength != nil && n < *s.MinLength {
+			if *s.MinLength == 1 {
+				fail("must not be empty")
+			} else {
+				fail("must be at least %d characters", *s.MinLength)
+			}
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			fail("must be at most %d characters", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(val) {
+			fail("must match pattern %s", s.Pattern)
+		}
+		if msg := checkFormat(s.Format, val); msg != "" {
+			fail("%s", msg)
+		}
+	case float64:
+		if s.Minimum != nil && val < *s.Minimum {
+			fail("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && val > *s.Maximum {
+			fail("must be at most %v", *s.Maximum)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(val) < *s.MinItems {
+			fail("must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(val) > *s.MaxItems {
+			fail("must have at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range val {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := val[name]; !ok {
+				*errs = append(*errs, FieldError{Path: join(path, name), Message: "is required"})
+			}
+		}
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if prop, ok := s.Properties[k]; ok {
+				prop.validate(join(path, k), val[k], errs)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				*errs = append(*errs, FieldError{Path: join(path, k), Message: "is not an allowed property"})
+			}
+		}
+	}
+}
+
+func hasType(v interface{}, t string) bool {
+	switch t {
+	case "null":
+		return v == nil
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	}
+	return false
+}
+
+func equal(a, b interface{}) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return string(ja) == string(jb)
+}
+
+func checkFormat(format, v string) string {
+	switch format {
+	case "email":
+		if _, err := mail.ParseAddress(v); err != nil {
+			return "must be a valid email address"
+		}
+	case "uri", "url":
+		if u, err := url.Parse(v); err != nil || u.Scheme == "" || u.Host == "" {
+			return "must be an absolute URL"
+		}
+	case "date":
+		if _, err := time.Parse("2006-01-02", v); err != nil {
+			return "must be a date (YYYY-MM-DD)"
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, v); err != nil {
+			return "must be an RFC 3339 date-time"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,105 @@
+package jsonschema
+
+import (
+	"reflect"
+	"testing"
+)
+
+const productSchema = `{
+	"type": "object",
+	"properties": {
+		"name": {"type": "string", "minLength": 1, "maxLength": 10},
+		"sku": {"type": "string", "pattern": "^[A-Z]{3}-[0-9]+$"},
+		"status": {"type": "string", "enum": ["draft", "live"]},
+		"price": {"type": "number", "minimum": 0, "maximum": 1000},
+		"stock": {"type": "integer"},
+		"note": {"type": ["string", "null"]},
+		"contact": {"type": "string", "format": "email"},
+		"website": {"type": "string", "format": "uri"},
+		"launch": {"type": "string", "format": "date"},
+		"updated": {"type": "string", "format": "date-time"},
+		"tags": {"type": "array", "minItems": 1, "maxItems": 2, "items": {"type": "string", "minLength": 2}},
+		"dimensions": {
+			"type": "object",
+			"required": ["width"],
+			"additionalProperties": false,
+			"properties": {"width": {"type": "number", "minimum": 1}, "height": {"type": "number"}}
+		}
+	},
+	"required": ["name", "status"]
+}`
+
+func TestValidate(t *testing.T) {
+	s, err := Parse(productSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		doc  string
+		want []FieldError
+	}{
+		{"valid", `{"name": "Lamp", "status": "live", "price": 12.5, "stock": 3, "note": null,
+			"contact": "Shop <shop@example.com>", "website": "https://example.com/lamp",
+			"launch": "2024-03-01", "updated": "2024-03-01T10:00:00Z", "tags": ["home"],
+			"dimensions": {"width": 2, "height": 3}, "extra": true}`, nil},
+		{"required", `{}`, []FieldError{{"name", "is required"}, {"status", "is required"}}},
+		{"type", `{"name": 5, "status": "live", "stock": 1.5, "note": 3}`, []FieldError{
+			{"name", "must be of type string"},
+			{"note", "must be one of types [string null]"},
+			{"stock", "must be of type integer"},
+		}},
+		{"enum", `{"name": "Lamp", "status": "gone"}`, []FieldError{{"status", "must be one of [draft live]"}}},
+		{"string bounds", `{"name": "", "status": "live", "sku": "abc-1"}`, []FieldError{
+			{"name", "must not be empty"},
+			{"sku", "must match pattern ^[A-Z]{3}-[0-9]+$"},
+		}},
+		{"too long", `{"name": "Lämpchen ist", "status": "live"}`, []FieldError{{"name", "must be at most 10 characters"}}},
+		{"number bounds", `{"name": "Lamp", "status": "live", "price": -1}`, []FieldError{{"price", "must be at least 0"}}},
+		{"number maximum", `{"name": "Lamp", "status": "live", "price": 1000.5}`, []FieldError{{"price", "must be at most 1000"}}},
+		{"formats", `{"name": "Lamp", "status": "live", "contact": "shop", "website": "/lamp",
+			"launch": "01/03/2024", "updated": "2024-03-01"}`, []FieldError{
+			{"contact", "must be a valid email address"},
+			{"launch", "must be a date (YYYY-MM-DD)"},
+			{"updated", "must be an RFC 3339 date-time"},
+			{"website", "must be an absolute URL"},
+		}},
+		{"array bounds", `{"name": "Lamp", "status": "live", "tags": []}`, []FieldError{{"tags", "must have at least 1 items"}}},
+		{"array items", `{"name": "Lamp", "status": "live", "tags": ["a", "ok", "b"]}`, []FieldError{
+			{"tags", "must have at most 2 items"},
+			{"tags[0]", "must be at least 2 characters"},
+			{"tags[2]", "must be at least 2 characters"},
+		}},
+		{"nested", `{"name": "Lamp", "status": "live", "dimensions": {"width": 0, "depth": 1}}`, []FieldError{
+			{"dimensions.depth", "is not an allowed property"},
+			{"dimensions.width", "must be at least 1"},
+		}},
+		{"nested required", `{"name": "Lamp", "status": "live", "dimensions": {}}`, []FieldError{
+			{"dimensions.width", "is required"},
+		}},
+	}
+	for _, tt := range tests {
+		if got := s.ValidateJSON(tt.doc); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\n got %v\nwant %v", tt.name, got, tt.want)
+		}
+	}
+
+	errs := s.ValidateJSON(`{"name": `)
+	if len(errs) != 1 || errs[0].Path != "" {
+		t.Errorf("invalid JSON: got %v, want one root-level error", errs)
+	}
+}
+
+func TestFieldErrorPath(t *testing.T) {
+	tests := map[FieldError]string{
+		{Path: "dimensions.width", Message: "is required"}: "dimensions.width: is required",
+		{Path: "tags[2]", Message: "is too short"}:         "tags[2]: is too short",
+		{Message: "not valid JSON"}:                        "(root): not valid JSON",
+	}
+	for e, want := range tests {
+		if got := e.Error(); got != want {
+			t.Errorf("Error() = %q, want %q", got, want)
+		}
+	}
+}
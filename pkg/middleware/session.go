@@ -35,3 +35,23 @@ func GetSession(c echo.Context) (*sessions.Session, error) {
 func SaveSession(c echo.Context, session *sessions.Session) error {
 	return session.Save(c.Request(), c.Response())
 }
+
+// CurrentUserID returns the ID of the signed-in admin, or 0 if there is none.
+func CurrentUserID(c echo.Context) int64 {
+	session, err := GetSession(c)
+	if err != nil {
+		return 0
+	}
+	userID, _ := session.Values[UserIDKey].(int64)
+	return userID
+}
+
+// CurrentUserRoles returns the role names of the signed-in admin.
+func CurrentUserRoles(c echo.Context) []string {
+	session, err := GetSession(c)
+	if err != nil {
+		return nil
+	}
+	roles, _ := session.Values[UserRolesKey].([]string)
+	return roles
+}
@@ -30,3 +30,23 @@ type ApplicationRole struct {
 	RoleID        int64     `json:"role_id" db:"role_id"`
 	CreatedAt     time.Time `json:"created_at" db:"created_at"`
 }
+
+type DomainVerification struct {
+	ApplicationID int64      `json:"application_id" db:"application_id"`
+	Domain        string     `json:"domain" db:"domain"`
+	Method        string     `json:"method" db:"method"`
+	Token         string     `json:"token" db:"token"`
+	Verified      bool       `json:"verified" db:"verified"`
+	VerifiedAt    *time.Time `json:"verified_at,omitempty" db:"verified_at"`
+	LastCheckedAt *time.Time `json:"last_checked_at,omitempty" db:"last_checked_at"`
+	LastError     *string    `json:"last_error,omitempty" db:"last_error"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	Expired       bool       `json:"expired" db:"-"` // pending and past ExpiresAt
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+type ApplicationWithVerification struct {
+	Application
+	Verification *DomainVerification `json:"verification,omitempty"`
+}
@@ -3,11 +3,58 @@ package models
 import "time"
 
 type Content struct {
+	ID          int64      `json:"id" db:"id"`
+	Slug        string     `json:"slug" db:"slug"`
+	Title       string     `json:"title" db:"title"`
+	Data        *string    `json:"data,omitempty" db:"data"` // JSON
+	CreatedBy   int64      `json:"created_by" db:"created_by"`
+	TypeID      *int64     `json:"content_type_id,omitempty" db:"content_type_id"`
+	Status      string     `json:"status" db:"status"`
+	PublishedAt *time.Time `json:"published_at,omitempty" db:"published_at"`
+	ScheduledAt *time.Time `json:"scheduled_at,omitempty" db:"scheduled_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+type ContentRevision struct {
+	ID         int64     `json:"id" db:"id"`
+	ContentID  int64     `json:"content_id" db:"content_id"`
+	Revision   int       `json:"revision" db:"revision"`
+	Slug       string    `json:"slug" db:"slug"`
+	Title      string    `json:"title" db:"title"`
+	Data       *string   `json:"data,omitempty" db:"data"` // JSON
+	AuthorID   *int64    `json:"author_id,omitempty" db:"author_id"`
+	AuthorName *string   `json:"author_name,omitempty" db:"-"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+type ContentType struct {
+	ID          int64     `json:"id" db:"id"`
+	Name        string    `json:"name" db:"name"`
+	Key         string    `json:"key" db:"key"`
+	Description *string   `json:"description,omitempty" db:"description"`
+	Schema      string    `json:"schema" db:"schema"` // JSON Schema
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type ContentVariant struct {
+	ID           int64     `json:"id" db:"id"`
+	ContentID    int64     `json:"content_id" db:"content_id"`
+	LanguageID   int64     `json:"language_id" db:"language_id"`
+	LanguageCode string    `json:"language_code" db:"-"`
+	Title        string    `json:"title" db:"title"`
+	Data         *string   `json:"data,omitempty" db:"data"` // JSON
+	UpdatedBy    *int64    `json:"updated_by,omitempty" db:"updated_by"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type SlugRedirect struct {
 	ID        int64     `json:"id" db:"id"`
-	Slug      string    `json:"slug" db:"slug"`
-	Title     string    `json:"title" db:"title"`
-	Data      *string   `json:"data,omitempty" db:"data"` // JSON
-	CreatedBy int64     `json:"created_by" db:"created_by"`
+	FromSlug  string    `json:"from_slug" db:"from_slug"`
+	ToSlug    string    `json:"to_slug" db:"to_slug"`
+	ContentID int64     `json:"content_id" db:"content_id"`
+	CreatedBy *int64    `json:"created_by,omitempty" db:"created_by"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
 }
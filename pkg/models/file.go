@@ -23,3 +23,52 @@ type FileAttachment struct {
 	EntityID   int64     `json:"entity_id" db:"entity_id"`
 	CreatedAt  time.Time `json:"created_at" db:"created_at"`
 }
+
+type FileImage struct {
+	FileID      int64     `json:"file_id" db:"file_id"`
+	Width       int       `json:"width" db:"width"`
+	Height      int       `json:"height" db:"height"`
+	ProcessedAt time.Time `json:"processed_at" db:"processed_at"`
+}
+
+type FileVariant struct {
+	ID        int64     `json:"id" db:"id"`
+	FileID    int64     `json:"file_id" db:"file_id"`
+	Name      string    `json:"name" db:"name"`
+	Width     int       `json:"width" db:"width"`
+	Height    int       `json:"height" db:"height"`
+	MimeType  string    `json:"mime_type" db:"mime_type"`
+	Size      int64     `json:"size" db:"size"`
+	Path      string    `json:"path" db:"path"`
+	URL       string    `json:"url" db:"url"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type FileWithUploader struct {
+	File
+	UploaderEmail string `json:"uploader_email"`
+	Attachments   int    `json:"attachments"`
+	Thumbnail     bool   `json:"thumbnail"`
+}
+
+type FileCleanupRun struct {
+	ID                  int64     `json:"id" db:"id"`
+	DryRun              bool      `json:"dry_run" db:"dry_run"`
+	UnattachedFiles     int       `json:"unattached_files" db:"unattached_files"`
+	DanglingAttachments int       `json:"dangling_attachments" db:"dangling_attachments"`
+	UntrackedObjects    int       `json:"untracked_objects" db:"untracked_objects"`
+	ReclaimableBytes    int64     `json:"reclaimable_bytes" db:"reclaimable_bytes"`
+	RunBy               *int64    `json:"run_by,omitempty" db:"run_by"`
+	CreatedAt           time.Time `json:"created_at" db:"created_at"`
+}
+
+type StorageQuota struct {
+	ID          int64     `json:"id" db:"id"`
+	SubjectType string    `json:"subject_type" db:"subject_type"`
+	SubjectID   int64     `json:"subject_id" db:"subject_id"`
+	QuotaBytes  int64     `json:"quota_bytes" db:"quota_bytes"`
+	Reason      *string   `json:"reason,omitempty" db:"reason"`
+	UpdatedBy   *int64    `json:"updated_by,omitempty" db:"updated_by"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
@@ -24,3 +24,31 @@ type CountryLanguage struct {
 	LanguageID int64     `json:"language_id" db:"language_id"`
 	CreatedAt  time.Time `json:"created_at" db:"created_at"`
 }
+
+type TranslationKey struct {
+	ID          int64     `json:"id" db:"id"`
+	Namespace   string    `json:"namespace" db:"namespace"`
+	Key         string    `json:"key" db:"key"`
+	Description *string   `json:"description,omitempty" db:"description"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type TranslationValue struct {
+	KeyID      int64     `json:"key_id" db:"key_id"`
+	LanguageID int64     `json:"language_id" db:"language_id"`
+	Value      string    `json:"value" db:"value"`
+	UpdatedBy  *int64    `json:"updated_by,omitempty" db:"updated_by"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type TranslationBundle struct {
+	ID          int64     `json:"id" db:"id"`
+	LanguageID  int64     `json:"language_id" db:"language_id"`
+	Version     int       `json:"version" db:"version"`
+	Checksum    string    `json:"checksum" db:"checksum"`
+	Data        string    `json:"data" db:"data"` // JSON
+	KeyCount    int       `json:"key_count" db:"key_count"`
+	PublishedBy *int64    `json:"published_by,omitempty" db:"published_by"`
+	PublishedAt time.Time `json:"published_at" db:"published_at"`
+}
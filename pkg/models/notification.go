@@ -10,6 +10,8 @@ type Notification struct {
 	Data      *string   `json:"data,omitempty" db:"data"` // JSON
 	Read      bool      `json:"read" db:"read"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	// Set by a trigger when Read becomes true
+	ReadAt *time.Time `json:"read_at,omitempty" db:"read_at"`
 }
 
 type NotificationPreference struct {
@@ -27,4 +29,92 @@ type NotificationDevice struct {
 	DeviceToken string  `json:"device_token" db:"device_token"`
 	DeviceType  string  `json:"device_type" db:"device_type"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	// Push diagnostics
+	LastSuccessAt *time.Time `json:"last_success_at,omitempty" db:"last_success_at"`
+	LastError     *string    `json:"last_error,omitempty" db:"last_error"`
+	LastErrorAt   *time.Time `json:"last_error_at,omitempty" db:"last_error_at"`
+	InvalidAt     *time.Time `json:"invalid_at,omitempty" db:"invalid_at"`
+}
+
+type NotificationSegment struct {
+	ID          int64     `json:"id" db:"id"`
+	Name        string    `json:"name" db:"name"`
+	Description *string   `json:"description,omitempty" db:"description"`
+	Filter      string    `json:"filter" db:"filter"` // JSON
+	CreatedBy   *int64    `json:"created_by,omitempty" db:"created_by"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type NotificationSend struct {
+	ID         int64     `json:"id" db:"id"`
+	Title      string    `json:"title" db:"title"`
+	Body       string    `json:"body" db:"body"`
+	Data       *string   `json:"data,omitempty" db:"data"` // JSON
+	TargetType string    `json:"target_type" db:"target_type"`
+	TargetID   int64     `json:"target_id" db:"target_id"`
+	Channels   []string  `json:"channels" db:"channels"`
+	Recipients int       `json:"recipients" db:"recipients"`
+	CreatedBy  *int64    `json:"created_by,omitempty" db:"created_by"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	// Set when the send used a template
+	TemplateID      *int64 `json:"template_id,omitempty" db:"template_id"`
+	TemplateVersion *int   `json:"template_version,omitempty" db:"template_version"`
+}
+
+type NotificationDelivery struct {
+	ID                int64      `json:"id" db:"id"`
+	SendID            int64      `json:"send_id" db:"send_id"`
+	UserID            int64      `json:"user_id" db:"user_id"`
+	Channel           string     `json:"channel" db:"channel"`
+	Status            string     `json:"status" db:"status"`
+	NotificationID    *int64     `json:"notification_id,omitempty" db:"notification_id"`
+	Provider          *string    `json:"provider,omitempty" db:"provider"`
+	ProviderMessageID *string    `json:"provider_message_id,omitempty" db:"provider_message_id"`
+	Error             *string    `json:"error,omitempty" db:"error"`
+	Title             *string    `json:"title,omitempty" db:"title"`
+	Body              *string    `json:"body,omitempty" db:"body"`
+	Attempts          int        `json:"attempts" db:"attempts"`
+	NextAttemptAt     time.Time  `json:"next_attempt_at" db:"next_attempt_at"`
+	CreatedAt         time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at" db:"updated_at"`
+	SentAt            *time.Time `json:"sent_at,omitempty" db:"sent_at"`
+}
+
+type NotificationTemplate struct {
+	ID          int64     `json:"id" db:"id"`
+	Key         string    `json:"key" db:"key"`
+	Name        string    `json:"name" db:"name"`
+	Description *string   `json:"description,omitempty" db:"description"`
+	Version     int       `json:"version" db:"version"`
+	CreatedBy   *int64    `json:"created_by,omitempty" db:"created_by"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type NotificationTemplateVersion struct {
+	ID         int64     `json:"id" db:"id"`
+	TemplateID int64     `json:"template_id" db:"template_id"`
+	Version    int       `json:"version" db:"version"`
+	AuthorID   *int64    `json:"author_id,omitempty" db:"author_id"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+type NotificationTemplateVariant struct {
+	VersionID    int64  `json:"version_id" db:"version_id"`
+	LanguageID   int64  `json:"language_id" db:"language_id"`
+	LanguageCode string `json:"language_code" db:"-"`
+	LanguageName string `json:"language_name" db:"-"`
+	Title        string `json:"title" db:"title"`
+	Body         string `json:"body" db:"body"`
+}
+
+type NotificationDeviceRemoval struct {
+	ID          int64     `json:"id" db:"id"`
+	UserID      int64     `json:"user_id" db:"user_id"`
+	DeviceToken string    `json:"device_token" db:"device_token"`
+	DeviceType  *string   `json:"device_type,omitempty" db:"device_type"`
+	Reason      string    `json:"reason" db:"reason"`
+	RemovedBy   *int64    `json:"removed_by,omitempty" db:"removed_by"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 }
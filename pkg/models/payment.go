@@ -13,3 +13,65 @@ type Payment struct {
 	CreatedAt       time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at" db:"updated_at"`
 }
+
+type PaymentWithUser struct {
+	Payment
+	UserEmail string `json:"user_email"`
+	UserName  string `json:"user_name"`
+}
+
+// PaymentAction is a refund or cancellation of a payment, as recorded in
+// the audit trail. Amount is zero for cancellations.
+type PaymentAction struct {
+	ID          int64     `json:"id" db:"id"`
+	PaymentID   int64     `json:"payment_id" db:"payment_id"`
+	Action      string    `json:"action" db:"action"`
+	Amount      int64     `json:"amount" db:"amount"`
+	Currency    string    `json:"currency" db:"currency"`
+	Reason      string    `json:"reason" db:"reason"`
+	Status      string    `json:"status" db:"status"`
+	Provider    string    `json:"provider" db:"provider"`
+	ProviderRef *string   `json:"provider_ref,omitempty" db:"provider_ref"`
+	Error       *string   `json:"error,omitempty" db:"error"`
+	PerformedBy *int64    `json:"performed_by,omitempty" db:"performed_by"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	// PerformerEmail is joined from iraven.users
+	PerformerEmail string `json:"performer_email,omitempty" db:"-"`
+}
+
+// StripeEvent is a webhook event received from Stripe and what applying
+// it did.
+type StripeEvent struct {
+	ID           string    `json:"id" db:"id"`
+	Type         string    `json:"type" db:"type"`
+	PaymentID    *int64    `json:"payment_id,omitempty" db:"payment_id"`
+	Status       *string   `json:"status,omitempty" db:"status"`
+	Outcome      string    `json:"outcome" db:"outcome"`
+	EventCreated time.Time `json:"event_created" db:"event_created"`
+	ReceivedAt   time.Time `json:"received_at" db:"received_at"`
+}
+
+type PaymentReconciliation struct {
+	ID           int64      `json:"id" db:"id"`
+	FileName     string     `json:"file_name" db:"file_name"`
+	Status       string     `json:"status" db:"status"`
+	Error        *string    `json:"error,omitempty" db:"error"`
+	Transactions int        `json:"transactions" db:"transactions"`
+	PeriodFrom   *time.Time `json:"period_from,omitempty" db:"period_from"`
+	PeriodTo     *time.Time `json:"period_to,omitempty" db:"period_to"`
+	Matched      int        `json:"matched" db:"matched"`
+	Issues       int        `json:"issues" db:"issues"`
+	CreatedBy    *int64     `json:"created_by,omitempty" db:"created_by"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty" db:"finished_at"`
+}
+
+type PaymentReconciliationIssue struct {
+	ID               int64   `json:"id" db:"id"`
+	ReconciliationID int64   `json:"reconciliation_id" db:"reconciliation_id"`
+	Kind             string  `json:"kind" db:"kind"`
+	StripeID         *string `json:"stripe_id,omitempty" db:"stripe_id"`
+	PaymentID        *int64  `json:"payment_id,omitempty" db:"payment_id"`
+	Expected         *string `json:"expected,omitempty" db:"expected"`
+	Actual           *string `json:"actual,omitempty" db:"actual"`
+}
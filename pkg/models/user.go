@@ -44,3 +44,48 @@ type UserWithRoles struct {
 	User
 	Roles []Role `json:"roles,omitempty"`
 }
+
+// UserAuditEvent is a change an admin made to a user account. Details
+// holds what changed, such as the role name for role_added.
+type UserAuditEvent struct {
+	ID          int64     `json:"id" db:"id"`
+	UserID      int64     `json:"user_id" db:"user_id"`
+	Action      string    `json:"action" db:"action"`
+	Details     *string   `json:"details,omitempty" db:"details"`
+	PerformedBy *int64    `json:"performed_by,omitempty" db:"performed_by"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// TimelineEvent is one entry of a user's timeline, merged from the tables
+// that record activity by or about the user.
+type TimelineEvent struct {
+	Kind       string    `json:"kind"`
+	Action     string    `json:"action"`
+	Summary    string    `json:"summary"`
+	Details    *string   `json:"details,omitempty"`
+	Link       *string   `json:"link,omitempty"`
+	ActorEmail *string   `json:"actor_email,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+	// Set for payments, refunds and cancellations
+	Amount   *int64  `json:"amount,omitempty"`
+	Currency *string `json:"currency,omitempty"`
+}
+
+// UserErasure is a request to erase a user's personal data, carried out
+// once ScheduledFor has passed unless canceled first.
+type UserErasure struct {
+	ID           int64      `json:"id" db:"id"`
+	UserID       int64      `json:"user_id" db:"user_id"`
+	Reason       string     `json:"reason" db:"reason"`
+	Status       string     `json:"status" db:"status"`
+	ScheduledFor time.Time  `json:"scheduled_for" db:"scheduled_for"`
+	RequestedBy  *int64     `json:"requested_by,omitempty" db:"requested_by"`
+	CanceledBy   *int64     `json:"canceled_by,omitempty" db:"canceled_by"`
+	Summary      *string    `json:"summary,omitempty" db:"summary"` // JSON
+	Error        *string    `json:"error,omitempty" db:"error"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+	// RequesterEmail is joined from iraven.users
+	RequesterEmail string `json:"requester_email,omitempty" db:"-"`
+}
@@ -0,0 +1,131 @@
+package money
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// exponents lists the ISO 4217 currencies whose minor unit is not a
+// hundredth. Stripe stores amounts in minor units, so 1000 is ¥1000 but
+// $10.00.
+var exponents = map[string]int{
+	// No minor unit
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	// Thousandths
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// Exponent returns the number of decimal places of a currency's minor
+// unit.
+func Exponent(currency string) int {
+	if e, ok := exponents[strings.ToUpper(currency)]; ok {
+		return e
+	}
+	return 2
+}
+
+// Exponents returns the currencies whose exponent is not 2.
+func Exponents() map[string]int {
+	m := make(map[string]int, len(exponents))
+	for currency, e := range exponents {
+		m[currency] = e
+	}
+	return m
+}
+
+// Format writes an amount in minor units in major units with thousands
+// separators, followed by the currency code: "1,234.50 EUR", "1,000 JPY".
+func Format(amount int64, currency string) string {
+	currency = strings.ToUpper(currency)
+	exp := Exponent(currency)
+
+	sign, abs := split(amount)
+	scale := uint64(1)
+	for i := 0; i < exp; i++ {
+		scale *= 10
+	}
+
+	major := group(strconv.FormatUint(abs/scale, 10))
+	if exp > 0 {
+		major += fmt.Sprintf(".%0*d", exp, abs%scale)
+	}
+	return strings.TrimSpace(sign + major + " " + currency)
+}
+
+// Major writes an amount in minor units as a plain decimal in major units,
+// "-1234.50", for exports.
+func Major(amount int64, currency string) string {
+	exp := Exponent(currency)
+	if exp == 0 {
+		return strconv.FormatInt(amount, 10)
+	}
+
+	sign, abs := split(amount)
+	scale := uint64(1)
+	for i := 0; i < exp; i++ {
+		scale *= 10
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, abs/scale, exp, abs%scale)
+}
+
+// split returns the sign and magnitude of an amount. The magnitude is
+// unsigned because the most negative int64 has no positive counterpart.
+func split(amount int64) (string, uint64) {
+	if amount < 0 {
+		return "-", -uint64(amount)
+	}
+	return "", uint64(amount)
+}
+
+// Parse reads an amount in major units, such as "12.5", into minor units
+// of currency. It rejects more decimals than the currency has.
+func Parse(s, currency string) (int64, error) {
+	s = strings.ReplaceAll(strings.TrimSpace(s), ",", "")
+	exp := Exponent(currency)
+
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" && fraction == "" {
+		return 0, fmt.Errorf("invalid amount")
+	}
+	if len(fraction) > exp {
+		return 0, fmt.Errorf("%s amounts have at most %d decimal places", strings.ToUpper(currency), exp)
+	}
+	fraction += strings.Repeat("0", exp-len(fraction))
+
+	digits := whole + fraction
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("invalid amount %q", s)
+		}
+	}
+	amount, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+func group(digits string) string {
+	if len(digits) <= 3 {
+		return digits
+	}
+	var b strings.Builder
+	head := len(digits) % 3
+	if head > 0 {
+		b.WriteString(digits[:head])
+	}
+	for i := head; i < len(digits); i += 3 {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(digits[i : i+3])
+	}
+	return b.String()
+}
@@ -0,0 +1,113 @@
+package money
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		amount   int64
+		currency string
+		want     string
+	}{
+		{0, "EUR", "0.00 EUR"},
+		{5, "usd", "0.05 USD"},
+		{123450, "EUR", "1,234.50 EUR"},
+		{-123450, "EUR", "-1,234.50 EUR"},
+		{100000000, "EUR", "1,000,000.00 EUR"},
+		{1000, "JPY", "1,000 JPY"},
+		{-999, "JPY", "-999 JPY"},
+		{1234567, "KWD", "1,234.567 KWD"},
+		{-5, "BHD", "-0.005 BHD"},
+		{math.MinInt64, "EUR", "-92,233,720,368,547,758.08 EUR"},
+		{math.MaxInt64, "JPY", "9,223,372,036,854,775,807 JPY"},
+		{150, "", "1.50"},
+	}
+	for _, tt := range tests {
+		if got := Format(tt.amount, tt.currency); got != tt.want {
+			t.Errorf("Format(%d, %s) = %q, want %q", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestMajor(t *testing.T) {
+	tests := []struct {
+		amount   int64
+		currency string
+		want     string
+	}{
+		{0, "EUR", "0.00"},
+		{123450, "EUR", "1234.50"},
+		{-7, "EUR", "-0.07"},
+		{1000, "JPY", "1000"},
+		{-1000, "JPY", "-1000"},
+		{1234567, "KWD", "1234.567"},
+		{math.MinInt64, "EUR", "-92233720368547758.08"},
+	}
+	for _, tt := range tests {
+		if got := Major(tt.amount, tt.currency); got != tt.want {
+			t.Errorf("Major(%d, %s) = %q, want %q", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		s        string
+		currency string
+		want     int64
+	}{
+		{"0", "EUR", 0},
+		{"12.5", "EUR", 1250},
+		{"12.50", "eur", 1250},
+		{".5", "EUR", 50},
+		{"-12.34", "EUR", -1234},
+		{"1,234.50", "EUR", 123450},
+		{" 7 ", "EUR", 700},
+		{"1000", "JPY", 1000},
+		{"-1,000", "JPY", -1000},
+		{"1.234", "KWD", 1234},
+		{"1.5", "KWD", 1500},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.s, tt.currency)
+		if err != nil || got != tt.want {
+			t.Errorf("Parse(%q, %s) = %d, %v; want %d", tt.s, tt.currency, got, err, tt.want)
+		}
+	}
+
+	invalid := []struct {
+		s        string
+		currency string
+	}{
+		{"1.234", "EUR"},
+		{"1.5", "JPY"},
+		{"1.2345", "KWD"},
+		{"abc", "EUR"},
+		{"-", "EUR"},
+		{"", "EUR"},
+		{".", "EUR"},
+		{"--5", "EUR"},
+		{"+5", "EUR"},
+		{"1.2.3", "EUR"},
+		{"1e3", "EUR"},
+		{"99999999999999999999", "JPY"},
+	}
+	for _, tt := range invalid {
+		if got, err := Parse(tt.s, tt.currency); err == nil {
+			t.Errorf("Parse(%q, %s) = %d, want an error", tt.s, tt.currency, got)
+		}
+	}
+}
+
+func TestParseFormatRoundTrip(t *testing.T) {
+	for _, currency := range []string{"EUR", "JPY", "KWD"} {
+		for _, amount := range []int64{0, 1, -1, 99, 123456789, -123456789} {
+			got, err := Parse(Major(amount, currency), currency)
+			if err != nil || got != amount {
+				t.Errorf("%s: Parse(Major(%d)) = %d, %v", currency, amount, got, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/iraven/iraven-admin/pkg/config"
+)
+
+// Delivery channels. In-app notifications are rows in iraven.notifications
+// that the API serves; the others go through a Provider.
+const (
+	InApp = "in_app"
+	Email = "email"
+	Push  = "push"
+	SMS   = "sms"
+)
+
+var Channels = []string{InApp, Email, Push, SMS}
+
+// ErrNoAddress means the recipient cannot be reached on the channel, such
+// as a push to a user without devices. Deliveries failing with it are not
+// retried.
+var ErrNoAddress = errors.New("recipient has no address for this channel")
+
+// ErrNotSent means the provider is a stub that logged the message instead
+// of sending it. Deliveries failing with it are skipped, not marked sent.
+var ErrNotSent = errors.New("stub driver does not send messages")
+
+// Message is what a recipient is sent. Data is a JSON object, or empty.
+type Message struct {
+	Title string
+	Body  string
+	Data  string
+}
+
+// Recipient holds the addresses of a user on every channel.
+type Recipient struct {
+	UserID       int64
+	Name         string
+	Email        string
+	Phone        string
+	DeviceTokens []string
+}
+
+// Result describes an accepted message. InvalidTokens lists device tokens
+// the push service reported as no longer registered.
+type Result struct {
+	MessageID     string
+	InvalidTokens []string
+}
+
+// Provider sends messages on one channel.
+type Provider interface {
+	Name() string
+	Send(ctx context.Context, to Recipient, msg Message) (Result, error)
+}
+
+// Providers maps a channel to its provider.
+type Providers map[string]Provider
+
+// New creates the providers selected in cfg. Every channel needs a
+// driver; "stub" has to be chosen explicitly.
+func New(cfg *config.NotifyConfig) (Providers, error) {
+	providers := Providers{}
+
+	switch cfg.Email.Driver {
+	case "":
+		return nil, errors.New("no email driver configured")
+	case "stub":
+		providers[Email] = NewStub(Email)
+	case "smtp":
+		providers[Email] = NewSMTP(cfg.Email.Host, cfg.Email.Port, cfg.Email.Username, cfg.Email.Password, cfg.Email.From)
+	default:
+		return nil, fmt.Errorf("unknown email driver %q", cfg.Email.Driver)
+	}
+
+	switch cfg.Push.Driver {
+	case "":
+		return nil, errors.New("no push driver configured")
+	case "stub":
+		providers[Push] = NewStub(Push)
+	case "webhook":
+		providers[Push] = NewWebhookPush(cfg.Push.URL, cfg.Push.Token)
+	default:
+		return nil, fmt.Errorf("unknown push driver %q", cfg.Push.Driver)
+	}
+
+	switch cfg.SMS.Driver {
+	case "":
+		return nil, errors.New("no SMS driver configured")
+	case "stub":
+		providers[SMS] = NewStub(SMS)
+	case "twilio":
+		providers[SMS] = NewTwilio(cfg.SMS.AccountSID, cfg.SMS.AuthToken, cfg.SMS.From)
+	default:
+		return nil, fmt.Errorf("unknown SMS driver %q", cfg.SMS.Driver)
+	}
+
+	return providers, nil
+}
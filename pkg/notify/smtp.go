@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTP sends email through a mail server, authenticating with PLAIN when a
+// username is set. The server must offer STARTTLS for that.
+type SMTP struct {
+	addr string
+	auth smtp.Auth
+	from string
+	send func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func NewSMTP(host string, port int, username, password, from string) *SMTP {
+	s := &SMTP{addr: host + ":" + strconv.Itoa(port), from: from, send: smtp.SendMail}
+	if username != "" {
+		s.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return s
+}
+
+func (s *SMTP) Name() string {
+	return "smtp"
+}
+
+func (s *SMTP) Send(ctx context.Context, to Recipient, msg Message) (Result, error) {
+	if to.Email == "" {
+		return Result{}, ErrNoAddress
+	}
+	from, err := mail.ParseAddress(s.from)
+	if err != nil {
+		return Result{}, fmt.Errorf("invalid sender address: %w", err)
+	}
+	recipient := &mail.Address{Name: to.Name, Address: to.Email}
+
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return Result{}, err
+	}
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+	messageID := "<" + hex.EncodeToString(b) + "@" + domain + ">"
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", from.String())
+	fmt.Fprintf(&body, "To: %s\r\n", recipient.String())
+	fmt.Fprintf(&body, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Title))
+	fmt.Fprintf(&body, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&body, "Message-ID: %s\r\n", messageID)
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	body.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	body.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	body.WriteString("\r\n")
+
+	if err := s.send(s.addr, s.auth, from.Address, []string{to.Email}, []byte(body.String())); err != nil {
+		return Result{}, err
+	}
+	return Result{MessageID: messageID}, nil
+}
@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// stubKeep is how many of the latest messages a Stub keeps.
+const stubKeep = 100
+
+// Sent is a message logged by a Stub.
+type Sent struct {
+	To  Recipient
+	Msg Message
+}
+
+// Stub logs messages instead of sending them, for tests and local
+// development, and keeps the latest in memory. It checks the recipient
+// has an address on its channel, then reports ErrNotSent, so that nothing
+// is recorded as delivered. Fail, when set, can reject chosen recipients,
+// and Deliver, when set, reports the others delivered with its result.
+type Stub struct {
+	channel string
+	Fail    func(to Recipient) error
+	Deliver func(to Recipient) Result
+
+	mu   sync.Mutex
+	sent []Sent
+}
+
+func NewStub(channel string) *Stub {
+	return &Stub{channel: channel}
+}
+
+func (s *Stub) Name() string {
+	return "stub"
+}
+
+func (s *Stub) Send(ctx context.Context, to Recipient, msg Message) (Result, error) {
+	switch s.channel {
+	case Email:
+		if to.Email == "" {
+			return Result{}, ErrNoAddress
+		}
+	case Push:
+		if len(to.DeviceTokens) == 0 {
+			return Result{}, ErrNoAddress
+		}
+	case SMS:
+		if to.Phone == "" {
+			return Result{}, ErrNoAddress
+		}
+	}
+	if s.Fail != nil {
+		if err := s.Fail(to); err != nil {
+			return Result{}, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.sent) == stubKeep {
+		s.sent = append(s.sent[:0], s.sent[1:]...)
+	}
+	s.sent = append(s.sent, Sent{To: to, Msg: msg})
+	log.Printf("Stub %s to user %d: %s", s.channel, to.UserID, msg.Title)
+	if s.Deliver != nil {
+		return s.Deliver(to), nil
+	}
+	return Result{}, ErrNotSent
+}
+
+// Sent returns the latest messages logged, oldest first.
+func (s *Stub) Sent() []Sent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Sent(nil), s.sent...)
+}
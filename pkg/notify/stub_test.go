@@ -0,0 +1,136 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/iraven/iraven-admin/pkg/config"
+)
+
+func TestNewRequiresDrivers(t *testing.T) {
+	stub := config.NotifyConfig{
+		Email: config.EmailConfig{Driver: "stub"},
+		Push:  config.PushConfig{Driver: "stub"},
+		SMS:   config.SMSConfig{Driver: "stub"},
+	}
+	providers, err := New(&stub)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for _, channel := range []string{Email, Push, SMS} {
+		if p := providers[channel]; p == nil || p.Name() != "stub" {
+			t.Errorf("%s provider = %v, want the stub", channel, p)
+		}
+	}
+
+	for _, cfg := range []config.NotifyConfig{
+		{Push: stub.Push, SMS: stub.SMS},
+		{Email: stub.Email, SMS: stub.SMS},
+		{Email: stub.Email, Push: stub.Push},
+		{Email: config.EmailConfig{Driver: "sendgrid"}, Push: stub.Push, SMS: stub.SMS},
+	} {
+		if _, err := New(&cfg); err == nil {
+			t.Errorf("New(%+v): expected an error", cfg)
+		}
+	}
+}
+
+func TestStubDoesNotReportSent(t *testing.T) {
+	s := NewStub(Email)
+	to := Recipient{UserID: 1, Email: "a@example.com"}
+	msg := Message{Title: "Hello", Body: "Hi"}
+
+	result, err := s.Send(context.Background(), to, msg)
+	if !errors.Is(err, ErrNotSent) {
+		t.Fatalf("Send: got %v, want ErrNotSent", err)
+	}
+	if result.MessageID != "" {
+		t.Errorf("MessageID = %q, want none", result.MessageID)
+	}
+	sent := s.Sent()
+	if len(sent) != 1 || sent[0].To.Email != "a@example.com" || sent[0].Msg.Title != "Hello" {
+		t.Errorf("Sent = %+v", sent)
+	}
+}
+
+func TestStubChecksAddress(t *testing.T) {
+	tests := []struct {
+		channel string
+		to      Recipient
+	}{
+		{Email, Recipient{UserID: 1, Phone: "+15550100"}},
+		{Push, Recipient{UserID: 1, Email: "a@example.com"}},
+		{SMS, Recipient{UserID: 1, DeviceTokens: []string{"tok"}}},
+	}
+	for _, tt := range tests {
+		s := NewStub(tt.channel)
+		if _, err := s.Send(context.Background(), tt.to, Message{Title: "x"}); !errors.Is(err, ErrNoAddress) {
+			t.Errorf("%s: got %v, want ErrNoAddress", tt.channel, err)
+		}
+		if len(s.Sent()) != 0 {
+			t.Errorf("%s: message without an address was kept", tt.channel)
+		}
+	}
+}
+
+func TestStubFail(t *testing.T) {
+	s := NewStub(SMS)
+	refused := errors.New("carrier refused")
+	s.Fail = func(to Recipient) error {
+		if to.UserID == 2 {
+			return refused
+		}
+		return nil
+	}
+
+	if _, err := s.Send(context.Background(), Recipient{UserID: 2, Phone: "+15550100"}, Message{}); !errors.Is(err, refused) {
+		t.Errorf("user 2: got %v, want the Fail error", err)
+	}
+	if _, err := s.Send(context.Background(), Recipient{UserID: 3, Phone: "+15550101"}, Message{}); !errors.Is(err, ErrNotSent) {
+		t.Errorf("user 3: got %v, want ErrNotSent", err)
+	}
+	if sent := s.Sent(); len(sent) != 1 || sent[0].To.UserID != 3 {
+		t.Errorf("Sent = %+v", sent)
+	}
+}
+
+func TestStubDeliver(t *testing.T) {
+	s := NewStub(Push)
+	s.Deliver = func(to Recipient) Result {
+		return Result{MessageID: fmt.Sprintf("msg-%d", to.UserID), InvalidTokens: to.DeviceTokens[1:]}
+	}
+
+	result, err := s.Send(context.Background(), Recipient{UserID: 7, DeviceTokens: []string{"good", "gone"}}, Message{Title: "Hi"})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if result.MessageID != "msg-7" || len(result.InvalidTokens) != 1 || result.InvalidTokens[0] != "gone" {
+		t.Errorf("Result = %+v", result)
+	}
+	if len(s.Sent()) != 1 {
+		t.Errorf("Sent = %+v", s.Sent())
+	}
+
+	// The address is still checked first
+	if _, err := s.Send(context.Background(), Recipient{UserID: 8}, Message{}); !errors.Is(err, ErrNoAddress) {
+		t.Errorf("no address: got %v, want ErrNoAddress", err)
+	}
+}
+
+func TestStubKeepsLatest(t *testing.T) {
+	s := NewStub(Push)
+	to := Recipient{UserID: 1, DeviceTokens: []string{"tok"}}
+	for i := 0; i < stubKeep+5; i++ {
+		s.Send(context.Background(), to, Message{Title: fmt.Sprint(i)})
+	}
+
+	sent := s.Sent()
+	if len(sent) != stubKeep {
+		t.Fatalf("kept %d messages, want %d", len(sent), stubKeep)
+	}
+	if sent[0].Msg.Title != "5" || sent[len(sent)-1].Msg.Title != fmt.Sprint(stubKeep+4) {
+		t.Errorf("kept %s to %s", sent[0].Msg.Title, sent[len(sent)-1].Msg.Title)
+	}
+}
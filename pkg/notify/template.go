@@ -0,0 +1,84 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+)
+
+// Vars are the values a notification's title and body can refer to, such
+// as {{.User.Name}} or {{.Data.order_id}}. Data holds the top-level values
+// of the message's JSON data as text; missing keys render empty.
+type Vars struct {
+	User     UserVars
+	Language string
+	Country  string
+	Data     map[string]string
+}
+
+type UserVars struct {
+	ID    int64
+	Name  string
+	Email string
+}
+
+// SampleVars stand in for a real user when checking that a template only
+// refers to known values.
+var SampleVars = Vars{
+	User:     UserVars{ID: 1, Name: "Jane Doe", Email: "jane@example.com"},
+	Language: "en",
+	Country:  "US",
+	Data:     map[string]string{},
+}
+
+// DataVars flattens a JSON object into Vars.Data. Strings are used as they
+// are and other values as their JSON.
+func DataVars(data string) map[string]string {
+	vars := map[string]string{}
+	var object map[string]json.RawMessage
+	if json.Unmarshal([]byte(data), &object) != nil {
+		return vars
+	}
+	for key, raw := range object {
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			vars[key] = s
+		} else {
+			vars[key] = string(raw)
+		}
+	}
+	return vars
+}
+
+// Render fills in the placeholders of a title and body.
+func Render(title, body string, vars Vars) (Message, error) {
+	var msg Message
+	var err error
+	if msg.Title, err = execute("title", title, vars); err != nil {
+		return msg, err
+	}
+	if msg.Body, err = execute("body", body, vars); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+// Check reports whether a title and body are valid templates that render
+// for SampleVars.
+func Check(title, body string) error {
+	_, err := Render(title, body, SampleVars)
+	return err
+}
+
+func execute(name, text string, vars Vars) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,71 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Twilio sends SMS through Twilio's Messages API.
+type Twilio struct {
+	BaseURL    string
+	AccountSID string
+	AuthToken  string
+	From       string
+	Client     *http.Client
+}
+
+func NewTwilio(accountSID, authToken, from string) *Twilio {
+	return &Twilio{
+		BaseURL:    "https://api.twilio.com",
+		AccountSID: accountSID,
+		AuthToken:  authToken,
+		From:       from,
+		Client:     &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (t *Twilio) Name() string {
+	return "twilio"
+}
+
+func (t *Twilio) Send(ctx context.Context, to Recipient, msg Message) (Result, error) {
+	if to.Phone == "" {
+		return Result{}, ErrNoAddress
+	}
+
+	text := msg.Body
+	if msg.Title != "" {
+		text = msg.Title + "\n" + msg.Body
+	}
+	form := url.Values{"To": {to.Phone}, "From": {t.From}, "Body": {text}}
+
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", t.BaseURL, url.PathEscape(t.AccountSID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Result{}, err
+	}
+	req.SetBasicAuth(t.AccountSID, t.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+
+	var reply struct {
+		SID     string `json:"sid"`
+		Message string `json:"message"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&reply)
+	if resp.StatusCode >= 300 {
+		return Result{}, fmt.Errorf("twilio: %s: %s", resp.Status, reply.Message)
+	}
+	return Result{MessageID: reply.SID}, nil
+}
@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// WebhookPush hands push notifications to a push gateway over HTTP. It
+// posts {"tokens", "title", "body", "data"} as JSON and expects a 2xx reply,
+// optionally {"id": ..., "invalid_tokens": [...]}, listing the tokens the
+// platform no longer accepts.
+type WebhookPush struct {
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+func NewWebhookPush(url, token string) *WebhookPush {
+	return &WebhookPush{URL: url, Token: token, Client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+func (w *WebhookPush) Name() string {
+	return "webhook"
+}
+
+func (w *WebhookPush) Send(ctx context.Context, to Recipient, msg Message) (Result, error) {
+	if len(to.DeviceTokens) == 0 {
+		return Result{}, ErrNoAddress
+	}
+
+	payload := map[string]interface{}{
+		"user_id": to.UserID,
+		"tokens":  to.DeviceTokens,
+		"title":   msg.Title,
+		"body":    msg.Body,
+	}
+	if msg.Data != "" {
+		payload["data"] = json.RawMessage(msg.Data)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return Result{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.Token)
+	}
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return Result{}, fmt.Errorf("push gateway: %s: %s", resp.Status, bytes.TrimSpace(snippet))
+	}
+
+	var reply struct {
+		ID            string   `json:"id"`
+		InvalidTokens []string `json:"invalid_tokens"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&reply)
+	return Result{MessageID: reply.ID, InvalidTokens: reply.InvalidTokens}, nil
+}
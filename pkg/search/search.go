@@ -0,0 +1,162 @@
+// Package search holds the pieces of content full-text search that live
+// outside SQL: picking a PostgreSQL text search configuration for a
+// language, flattening JSON data into indexable text and turning
+// ts_headline output into safe HTML.
+package search
+
+import (
+	"encoding/json"
+	"html"
+	"html/template"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/iraven/iraven-admin/pkg/i18n"
+	"github.com/iraven/iraven-admin/pkg/models"
+)
+
+// DefaultDictionary is used for languages PostgreSQL has no stemmer for.
+const DefaultDictionary = "simple"
+
+// dictionaries maps ISO 639-1 codes to the text search configurations that
+// ship with PostgreSQL 16. Older servers lack some of them, such as
+// armenian, serbian and yiddish; see SetInstalled.
+var dictionaries = map[string]string{
+	"ar": "arabic",
+	"ca": "catalan",
+	"da": "danish",
+	"de": "german",
+	"el": "greek",
+	"en": "english",
+	"es": "spanish",
+	"eu": "basque",
+	"fi": "finnish",
+	"fr": "french",
+	"ga": "irish",
+	"hi": "hindi",
+	"hu": "hungarian",
+	"hy": "armenian",
+	"id": "indonesian",
+	"it": "italian",
+	"lt": "lithuanian",
+	"ne": "nepali",
+	"nl": "dutch",
+	"no": "norwegian",
+	"nb": "norwegian",
+	"nn": "norwegian",
+	"pt": "portuguese",
+	"ro": "romanian",
+	"ru": "russian",
+	"sr": "serbian",
+	"sv": "swedish",
+	"ta": "tamil",
+	"tr": "turkish",
+	"yi": "yiddish",
+}
+
+// installed holds the configurations the database has, once SetInstalled
+// was called; until then every mapped configuration is taken to exist.
+var installed atomic.Pointer[map[string]bool]
+
+// SetInstalled records the text search configurations the database has,
+// from pg_ts_config. Languages whose configuration is missing fall back to
+// DefaultDictionary. It returns the missing configurations, sorted.
+func SetInstalled(names []string) []string {
+	have := make(map[string]bool, len(names))
+	for _, name := range names {
+		have[name] = true
+	}
+	installed.Store(&have)
+
+	seen := map[string]bool{}
+	var missing []string
+	for _, d := range dictionaries {
+		if !have[d] && !seen[d] {
+			seen[d] = true
+			missing = append(missing, d)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// Dictionary returns the text search configuration used to index and query
+// content in the given language.
+func Dictionary(language models.Language) string {
+	return DictionaryForCode(language.Code)
+}
+
+// DictionaryForCode is Dictionary for a bare language code.
+func DictionaryForCode(code string) string {
+	d, ok := dictionaries[i18n.NormalizeCode(code)]
+	if !ok {
+		return DefaultDictionary
+	}
+	if have := installed.Load(); have != nil && !(*have)[d] {
+		return DefaultDictionary
+	}
+	return d
+}
+
+// Text flattens a JSON document into the text worth searching: every string
+// value, one per line, with object keys visited in sorted order so the
+// output is stable. Data that is not JSON is indexed as-is.
+func Text(data string) string {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		return data
+	}
+	var parts []string
+	collect(doc, &parts)
+	return strings.Join(parts, "\n")
+}
+
+func collect(v interface{}, parts *[]string) {
+	switch v := v.(type) {
+	case string:
+		if s := strings.TrimSpace(v); s != "" {
+			*parts = append(*parts, s)
+		}
+	case []interface{}:
+		for _, item := range v {
+			collect(item, parts)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			collect(v[k], parts)
+		}
+	}
+}
+
+// Markers passed to ts_headline as StartSel and StopSel. They are private
+// use code points, so they cannot clash with real content and survive
+// escaping untouched.
+const (
+	startMark = "\uE000"
+	stopMark  = "\uE001"
+)
+
+// HeadlineOptions returns the ts_headline options for snippets; whole
+// enables HighlightAll, for short fields such as titles.
+func HeadlineOptions(whole bool) string {
+	opts := `StartSel="` + startMark + `", StopSel="` + stopMark + `"`
+	if whole {
+		return opts + ", HighlightAll=true"
+	}
+	return opts + `, MaxFragments=2, MaxWords=30, MinWords=12, FragmentDelimiter=" … "`
+}
+
+// Highlight escapes a ts_headline result and wraps the matched terms in
+// <mark> elements.
+func Highlight(headline string) template.HTML {
+	escaped := html.EscapeString(headline)
+	escaped = strings.ReplaceAll(escaped, startMark, "<mark>")
+	escaped = strings.ReplaceAll(escaped, stopMark, "</mark>")
+	return template.HTML(escaped)
+}
@@ -0,0 +1,41 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDictionaryFallsBackToSimple(t *testing.T) {
+	defer installed.Store(nil)
+
+	if got := DictionaryForCode("hy"); got != "armenian" {
+		t.Errorf("before SetInstalled: hy = %s, want armenian", got)
+	}
+
+	// The configurations of PostgreSQL 15, which lacks armenian, basque,
+	// catalan, hindi, serbian and yiddish
+	missing := SetInstalled([]string{
+		"simple", "arabic", "danish", "dutch", "english", "finnish", "french", "german", "greek",
+		"hungarian", "indonesian", "irish", "italian", "lithuanian", "nepali", "norwegian",
+		"portuguese", "romanian", "russian", "spanish", "swedish", "tamil", "turkish",
+	})
+	want := []string{"armenian", "basque", "catalan", "hindi", "serbian", "yiddish"}
+	if !reflect.DeepEqual(missing, want) {
+		t.Errorf("missing = %v, want %v", missing, want)
+	}
+
+	tests := map[string]string{
+		"hy":    DefaultDictionary,
+		"sr":    DefaultDictionary,
+		"yi":    DefaultDictionary,
+		"de":    "german",
+		"pt-BR": "portuguese",
+		"nb":    "norwegian",
+		"xx":    DefaultDictionary,
+	}
+	for code, want := range tests {
+		if got := DictionaryForCode(code); got != want {
+			t.Errorf("DictionaryForCode(%s) = %s, want %s", code, got, want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package slug
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const MaxLength = 100
+
+// Make turns a title into a URL slug: letters are transliterated to ASCII,
+// everything else collapses into single dashes.
+func Make(title string) string {
+	var b strings.Builder
+	dash := false
+	write := func(s string) {
+		for _, r := range s {
+			if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+				b.WriteRune(r)
+				dash = false
+			} else if !dash && b.Len() > 0 {
+				b.WriteByte('-')
+				dash = true
+			}
+		}
+	}
+
+	for _, r := range strings.ToLower(title) {
+		if t, ok := transliterations[r]; ok {
+			write(t)
+			continue
+		}
+		// Decompose accented letters and drop the combining marks: é -> e,
+		// ά -> α -> a
+		for _, d := range norm.NFD.String(string(r)) {
+			if unicode.Is(unicode.Mn, d) {
+				continue
+			}
+			if t, ok := transliterations[d]; ok {
+				write(t)
+			} else {
+				write(string(d))
+			}
+		}
+	}
+
+	s := strings.Trim(b.String(), "-")
+	if len(s) > MaxLength {
+		s = s[:MaxLength]
+		if i := strings.LastIndexByte(s, '-'); i > MaxLength/2 {
+			s = s[:i]
+		}
+		s = strings.Trim(s, "-")
+	}
+	return s
+}
+
+// Unique returns base, or base with the lowest numeric suffix ("-2", "-3",
+// ...) that taken reports as free.
+func Unique(base string, taken func(string) (bool, error)) (string, error) {
+	candidate := base
+	for n := 2; ; n++ {
+		used, err := taken(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !used {
+			return candidate, nil
+		}
+		suffix := fmt.Sprintf("-%d", n)
+		trimmed := base
+		if len(trimmed)+len(suffix) > MaxLength {
+			trimmed = strings.TrimRight(trimmed[:MaxLength-len(suffix)], "-")
+		}
+		candidate = trimmed + suffix
+	}
+}
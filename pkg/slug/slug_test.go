@@ -0,0 +1,125 @@
+package slug
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var wellFormed = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+
+func TestMake(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Hello, World!", "hello-world"},
+		{"  --Already-a-slug--  ", "already-a-slug"},
+		{"Crème Brûlée", "creme-brulee"},
+		{"Straße & Ærø", "strasse-and-aero"},
+		{"Łódź", "lodz"},
+		{"Привет, мир", "privet-mir"},
+		{"Щука и ёж", "shchuka-i-yozh"},
+		{"Ελληνικά", "ellinika"},
+		{"Αθήνα", "athina"},
+		{"سلام دنیا", "slam-dnya"},
+		{"قیمت ۱۲۳", "ghymt-123"},
+		{"٢٠٢٤ مرحبا", "2024-mrhba"},
+		// Scripts without a transliteration drop out
+		{"東京 2024", "2024"},
+		{"東京", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Make(tt.title); got != tt.want {
+			t.Errorf("Make(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestMakeMaxLength(t *testing.T) {
+	tests := []struct {
+		name  string
+		title string
+		want  string
+	}{
+		// Cut back to the last word boundary past the middle
+		{"words", strings.Repeat("abcdefghi ", 15), strings.TrimSuffix(strings.Repeat("abcdefghi-", 10), "-")},
+		// No boundary past the middle: cut mid-word
+		{"one word", strings.Repeat("x", 150), strings.Repeat("x", MaxLength)},
+		// A cut that lands on a dash does not leave it behind
+		{"dash at the cut", strings.Repeat("a", 99) + " bcd", strings.Repeat("a", 99)},
+		// Multi-byte input is measured after transliteration
+		{"cyrillic", strings.Repeat("щ", 30), strings.Repeat("shch", 25)},
+	}
+	for _, tt := range tests {
+		got := Make(tt.title)
+		if got != tt.want {
+			t.Errorf("%s: got %q (%d), want %q (%d)", tt.name, got, len(got), tt.want, len(tt.want))
+		}
+		if len(got) > MaxLength || !wellFormed.MatchString(got) {
+			t.Errorf("%s: %q is not a well-formed slug", tt.name, got)
+		}
+	}
+}
+
+func TestUnique(t *testing.T) {
+	long := strings.Repeat("a", MaxLength-3) + "-bb"
+
+	tests := []struct {
+		name  string
+		base  string
+		taken []string
+		want  string
+	}{
+		{"free", "lamp", nil, "lamp"},
+		{"taken", "lamp", []string{"lamp"}, "lamp-2"},
+		{"lowest free suffix", "lamp", []string{"lamp", "lamp-2", "lamp-3", "lamp-5"}, "lamp-4"},
+		{"suffix is not confused with a slug ending in a number", "lamp-2", []string{"lamp-2"}, "lamp-2-2"},
+		{"trimmed to fit", strings.Repeat("a", MaxLength), []string{strings.Repeat("a", MaxLength)}, strings.Repeat("a", MaxLength-2) + "-2"},
+		{"trimmed past a dash", long, []string{long}, strings.Repeat("a", MaxLength-3) + "-2"},
+		{"longer suffix trims more", strings.Repeat("a", MaxLength), taken(strings.Repeat("a", MaxLength), 9), strings.Repeat("a", MaxLength-3) + "-10"},
+	}
+	for _, tt := range tests {
+		used := map[string]bool{}
+		for _, s := range tt.taken {
+			used[s] = true
+		}
+		got, err := Unique(tt.base, func(s string) (bool, error) { return used[s], nil })
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+		if len(got) > MaxLength {
+			t.Errorf("%s: %q is longer than %d", tt.name, got, MaxLength)
+		}
+	}
+}
+
+// taken lists base and its suffixed forms up to "-n", trimmed the way Unique
+// trims them.
+func taken(base string, n int) []string {
+	s := []string{base}
+	for i := 2; i <= n; i++ {
+		s = append(s, base[:MaxLength-2]+"-"+string(rune('0'+i)))
+	}
+	return s
+}
+
+func TestUniqueError(t *testing.T) {
+	boom := errors.New("boom")
+	calls := 0
+	_, err := Unique("lamp", func(string) (bool, error) {
+		calls++
+		if calls == 3 {
+			return false, boom
+		}
+		return true, nil
+	})
+	if !errors.Is(err, boom) {
+		t.Errorf("got %v, want %v", err, boom)
+	}
+}
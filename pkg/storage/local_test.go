@@ -0,0 +1,16 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalURL(t *testing.T) {
+	store := NewLocal("storage", "files", "http://localhost:8081/media/")
+	if got := store.URL("uploads/a b+c.txt"); got != "http://localhost:8081/media/uploads/a%20b%2Bc.txt" {
+		t.Errorf("URL = %s", got)
+	}
+	if got := store.Dir(); got != filepath.Join("storage", "files") {
+		t.Errorf("Dir = %s", got)
+	}
+}
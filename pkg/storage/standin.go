@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3StandIn is an in-memory, path-style S3 server covering the calls the S3
+// backend makes, for tests and local development without a real service:
+//
+//	srv := httptest.NewServer(storage.NewS3StandIn())
+//	store, _ := storage.NewS3(storage.S3Options{Endpoint: srv.URL, Bucket: "files", PathStyle: true})
+//
+// Requests must be signed, but signatures are not verified.
+type S3StandIn struct {
+	mu      sync.Mutex
+	objects map[string]map[string]standInObject
+}
+
+type standInObject struct {
+	data        []byte
+	contentType string
+	modified    time.Time
+}
+
+func NewS3StandIn() *S3StandIn {
+	return &S3StandIn{objects: map[string]map[string]standInObject{}}
+}
+
+func (s *S3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=") {
+		standInError(w, http.StatusForbidden, "AccessDenied", "Missing signature")
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" {
+		standInError(w, http.StatusBadRequest, "InvalidBucketName", "No bucket in path")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	objects := s.objects[bucket]
+	if objects == nil {
+		objects = map[string]standInObject{}
+		s.objects[bucket] = objects
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		s.list(w, r, objects)
+	case key == "":
+		standInError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "Unsupported bucket operation")
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			standInError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+			return
+		}
+		objects[key] = standInObject{data: data, contentType: r.Header.Get("Content-Type"), modified: time.Now().UTC()}
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet:
+		obj, ok := objects[key]
+		if !ok {
+			standInError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+		if obj.contentType != "" {
+			w.Header().Set("Content-Type", obj.contentType)
+		}
+		w.Write(obj.data)
+	case r.Method == http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		standInError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "Unsupported object operation")
+	}
+}
+
+func (s *S3StandIn) list(w http.ResponseWriter, r *http.Request, objects map[string]standInObject) {
+	prefix := r.URL.Query().Get("prefix")
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	type content struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	}
+	result := struct {
+		XMLName     xml.Name  `xml:"ListBucketResult"`
+		Contents    []content `xml:"Contents"`
+		IsTruncated bool      `xml:"IsTruncated"`
+	}{}
+	for _, key := range keys {
+		obj := objects[key]
+		result.Contents = append(result.Contents, content{Key: key, Size: int64(len(obj.data)), LastModified: obj.modified})
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func standInError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+}
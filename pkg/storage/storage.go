@@ -0,0 +1,132 @@
+// Package storage keeps the contents of uploaded files. A backend stores
+// objects under keys inside one bucket and knows the public URL clients
+// reach each object at.
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/iraven/iraven-admin/pkg/config"
+)
+
+// ErrNotFound is returned when an object does not exist.
+var ErrNotFound = errors.New("storage: object not found")
+
+// Object describes a stored object.
+type Object struct {
+	Key        string
+	Size       int64
+	ModifiedAt time.Time
+}
+
+// Storage is implemented by every storage backend.
+type Storage interface {
+	// Bucket names the bucket objects are stored in.
+	Bucket() string
+	// URL is the public URL of the object stored under key.
+	URL(key string) string
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes an object; deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+	// List returns every object whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]Object, error)
+}
+
+// New creates the backend selected by the configuration.
+func New(cfg *config.StorageConfig) (Storage, error) {
+	switch cfg.Driver {
+	case "", "local":
+		return NewLocal(cfg.LocalPath, cfg.Bucket, cfg.PublicURL), nil
+	case "s3":
+		return NewS3(S3Options{
+			Endpoint:  cfg.S3.Endpoint,
+			Region:    cfg.S3.Region,
+			Bucket:    cfg.Bucket,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+			PathStyle: cfg.S3.PathStyle,
+			PublicURL: cfg.PublicURL,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+	}
+}
+
+// KeyPrefix is where uploaded objects are stored.
+const KeyPrefix = "uploads/"
+
+// NewKey generates a random key for a new upload, grouped by month and
+// keeping the original file's extension.
+func NewKey(filename string, now time.Time) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return KeyPrefix + now.UTC().Format("2006/01/") + hex.EncodeToString(b) + extension(filename), nil
+}
+
+func extension(filename string) string {
+	ext := strings.ToLower(path.Ext(strings.ReplaceAll(filename, "\\", "/")))
+	if len(ext) < 2 || len(ext) > 10 {
+		return ""
+	}
+	for _, r := range ext[1:] {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9') {
+			return ""
+		}
+	}
+	return ext
+}
+
+// SniffLen is how many leading bytes DetectMIME looks at.
+const SniffLen = 512
+
+// DetectMIME works out a file's media type from its leading bytes. The file
+// name is consulted only when the content gives nothing more specific than
+// generic binary or text; the client's declared type is never trusted.
+func DetectMIME(head []byte, filename string) string {
+	detected := http.DetectContentType(head)
+	mediaType, _, _ := mime.ParseMediaType(detected)
+
+	if mediaType == "application/octet-stream" || mediaType == "text/plain" || mediaType == "application/zip" {
+		if byExt := mime.TypeByExtension(extension(filename)); byExt != "" {
+			byExtType, _, _ := mime.ParseMediaType(byExt)
+			// Never let an extension turn text or binary into something a
+			// browser would execute
+			if !strings.HasPrefix(byExtType, "text/html") && byExtType != "image/svg+xml" &&
+				!strings.Contains(byExtType, "javascript") {
+				return byExtType
+			}
+		}
+	}
+	return mediaType
+}
+
+// Previewable reports whether a file of this type can be shown inline.
+func Previewable(mimeType string) bool {
+	switch mimeType {
+	case "image/png", "image/jpeg", "image/gif", "image/webp", "image/bmp", "application/pdf":
+		return true
+	}
+	return false
+}
+
+// CleanKey normalizes a key and rejects keys that would escape the bucket.
+func CleanKey(key string) (string, error) {
+	cleaned := strings.TrimPrefix(path.Clean("/"+key), "/")
+	if cleaned == "" || cleaned != strings.TrimPrefix(key, "/") {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return cleaned, nil
+}
@@ -0,0 +1,66 @@
+package translation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// parseJSON reads an object mapping keys to strings. Nested objects, as
+// written by i18next and similar libraries, are flattened with dots:
+// {"nav": {"home": "Home"}} gives the key "nav.home".
+func parseJSON(r io.Reader) ([]Entry, error) {
+	var doc map[string]interface{}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	var entries []Entry
+	var walk func(prefix string, v map[string]interface{}) error
+	walk = func(prefix string, v map[string]interface{}) error {
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			switch value := v[k].(type) {
+			case string:
+				entries = append(entries, Entry{Key: key, Value: value})
+			case map[string]interface{}:
+				if err := walk(key, value); err != nil {
+					return err
+				}
+			case nil:
+			default:
+				return fmt.Errorf("value of %q is not a string", key)
+			}
+		}
+		return nil
+	}
+
+	if err := walk("", doc); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// writeJSON writes a flat object of keys and translations. Untranslated
+// keys are included with empty values so translators can fill them in.
+func writeJSON(w io.Writer, f File) error {
+	doc := make(map[string]string, len(f.Entries))
+	for _, e := range f.Entries {
+		doc[e.Key] = e.Value
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(doc)
+}
@@ -0,0 +1,166 @@
+package translation
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// parsePO reads a gettext PO file. The key is the entry's msgctxt, or its
+// msgid when there is no context. Fuzzy entries are not yet reviewed and
+// only the first plural form is kept.
+func parsePO(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	var ctxt, id, str, notes []string
+	var field *[]string
+	var fuzzy, seenStr bool
+
+	flush := func() {
+		key := strings.Join(ctxt, "")
+		source := strings.Join(id, "")
+		if key == "" {
+			key = source
+		}
+		if key != "" && !fuzzy {
+			entries = append(entries, Entry{
+				Key:    key,
+				Source: source,
+				Value:  strings.Join(str, ""),
+				Note:   strings.Join(notes, "\n"),
+			})
+		}
+		ctxt, id, str, notes, field = nil, nil, nil, nil, nil
+		fuzzy, seenStr = false, false
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if n == 1 {
+			line = strings.TrimPrefix(line, "\uFEFF")
+		}
+
+		switch {
+		case line == "":
+			flush()
+			continue
+		case strings.HasPrefix(line, "#~"):
+			// Obsolete entry
+			continue
+		case strings.HasPrefix(line, "#"):
+			if seenStr {
+				flush()
+			}
+			if strings.HasPrefix(line, "#.") {
+				notes = append(notes, strings.TrimSpace(line[2:]))
+			} else if strings.HasPrefix(line, "#,") && strings.Contains(line, "fuzzy") {
+				fuzzy = true
+			}
+			continue
+		case strings.HasPrefix(line, `"`):
+			if field == nil {
+				return nil, fmt.Errorf("line %d: string outside of an entry", n)
+			}
+			s, err := strconv.Unquote(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid string", n)
+			}
+			*field = append(*field, s)
+			continue
+		}
+
+		keyword, rest, _ := strings.Cut(line, " ")
+		value, err := strconv.Unquote(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid string", n)
+		}
+
+		switch keyword {
+		case "msgctxt":
+			if seenStr {
+				flush()
+			}
+			ctxt = []string{value}
+			field = &ctxt
+		case "msgid":
+			if seenStr {
+				flush()
+			}
+			id = []string{value}
+			field = &id
+		case "msgstr", "msgstr[0]":
+			str = []string{value}
+			field = &str
+			seenStr = true
+		default:
+			if keyword != "msgid_plural" && !strings.HasPrefix(keyword, "msgstr[") {
+				return nil, fmt.Errorf("line %d: unknown keyword %q", n, keyword)
+			}
+			// Other plural forms are read and dropped
+			var ignored []string
+			field = &ignored
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+
+	return entries, nil
+}
+
+// writePO writes a PO file with each key as the msgctxt and the source
+// text, or the key when there is none, as the msgid.
+func writePO(w io.Writer, f File) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, `msgid ""`)
+	fmt.Fprintln(bw, `msgstr ""`)
+	fmt.Fprintln(bw, `"Content-Type: text/plain; charset=UTF-8\n"`)
+	fmt.Fprintln(bw, `"Content-Transfer-Encoding: 8bit\n"`)
+	fmt.Fprintf(bw, "%s\n", poQuote("Language: "+f.TargetLanguage+"\n"))
+	fmt.Fprintf(bw, "%s\n", poQuote("X-Source-Language: "+f.SourceLanguage+"\n"))
+	fmt.Fprintf(bw, "%s\n", poQuote("X-Namespace: "+f.Namespace+"\n"))
+
+	for _, e := range f.Entries {
+		source := e.Source
+		if source == "" {
+			source = e.Key
+		}
+
+		fmt.Fprintln(bw)
+		for _, note := range strings.Split(e.Note, "\n") {
+			if note != "" {
+				fmt.Fprintf(bw, "#. %s\n", note)
+			}
+		}
+		writePOField(bw, "msgctxt", e.Key)
+		writePOField(bw, "msgid", source)
+		writePOField(bw, "msgstr", e.Value)
+	}
+
+	return bw.Flush()
+}
+
+// writePOField writes a keyword and its string, splitting multi-line
+// strings after each newline the way gettext tools do.
+func writePOField(w io.Writer, keyword, s string) {
+	if !strings.Contains(strings.TrimSuffix(s, "\n"), "\n") {
+		fmt.Fprintf(w, "%s %s\n", keyword, poQuote(s))
+		return
+	}
+	fmt.Fprintf(w, "%s \"\"\n", keyword)
+	for _, line := range strings.SplitAfter(s, "\n") {
+		if line != "" {
+			fmt.Fprintln(w, poQuote(line))
+		}
+	}
+}
+
+func poQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\r", `\r`)
+	return `"` + r.Replace(s) + `"`
+}
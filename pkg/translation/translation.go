@@ -0,0 +1,118 @@
+package translation
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Formats a translation file can be imported from and exported to.
+const (
+	JSON  = "json"
+	PO    = "po"
+	XLIFF = "xliff"
+)
+
+var Formats = []string{JSON, PO, XLIFF}
+
+// Entry is one translated string. Source is the text in the source
+// language, which PO and XLIFF files carry next to the translation, and
+// Note is the description shown to translators.
+type Entry struct {
+	Key    string
+	Source string
+	Value  string
+	Note   string
+}
+
+// File describes the language pair of an exported file.
+type File struct {
+	SourceLanguage string
+	TargetLanguage string
+	Namespace      string
+	Entries        []Entry
+}
+
+// DetectFormat picks the format from an explicit choice or, failing that,
+// from the file name's extension.
+func DetectFormat(format, filename string) (string, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "" {
+		switch strings.ToLower(path.Ext(filename)) {
+		case ".json":
+			format = JSON
+		case ".po", ".pot":
+			format = PO
+		case ".xlf", ".xliff":
+			format = XLIFF
+		}
+	}
+	for _, f := range Formats {
+		if f == format {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown translation format %q", format)
+}
+
+// Parse reads the entries of a translation file. Entries without a
+// translation are dropped.
+func Parse(format string, r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	var err error
+	switch format {
+	case JSON:
+		entries, err = parseJSON(r)
+	case PO:
+		entries, err = parsePO(r)
+	case XLIFF:
+		entries, err = parseXLIFF(r)
+	default:
+		return nil, fmt.Errorf("unknown translation format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	translated := entries[:0]
+	for _, e := range entries {
+		if e.Key != "" && e.Value != "" {
+			translated = append(translated, e)
+		}
+	}
+	return translated, nil
+}
+
+// Write encodes f in the given format, with entries sorted by key.
+func Write(format string, w io.Writer, f File) error {
+	sort.Slice(f.Entries, func(i, j int) bool { return f.Entries[i].Key < f.Entries[j].Key })
+	switch format {
+	case JSON:
+		return writeJSON(w, f)
+	case PO:
+		return writePO(w, f)
+	case XLIFF:
+		return writeXLIFF(w, f)
+	}
+	return fmt.Errorf("unknown translation format %q", format)
+}
+
+// ContentType and Extension describe files of a format for downloads.
+func ContentType(format string) string {
+	switch format {
+	case PO:
+		return "text/x-gettext-translation; charset=utf-8"
+	case XLIFF:
+		return "application/xliff+xml; charset=utf-8"
+	}
+	return "application/json; charset=utf-8"
+}
+
+func Extension(format string) string {
+	if format == XLIFF {
+		return ".xlf"
+	}
+	return "." + format
+}
@@ -0,0 +1,210 @@
+package translation
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	// In key order, as Write sorts them
+	entries := []Entry{
+		{Key: "nav.home", Source: "Home", Value: "Startseite", Note: "Top navigation"},
+		{Key: "tab", Source: "A\tB\\C", Value: "A\tB\\C"},
+		{Key: "welcome", Source: "Welcome,\n\"%s\"!\n", Value: "Willkommen,\n„%s“!\n", Note: "First line\nSecond line"},
+		{Key: "untranslated", Source: "Later"},
+	}
+
+	for _, format := range Formats {
+		var buf bytes.Buffer
+		f := File{SourceLanguage: "en", TargetLanguage: "de", Namespace: "web", Entries: append([]Entry(nil), entries...)}
+		if err := Write(format, &buf, f); err != nil {
+			t.Fatalf("%s: Write: %v", format, err)
+		}
+		got, err := Parse(format, &buf)
+		if err != nil {
+			t.Fatalf("%s: Parse: %v\n%s", format, err, buf.String())
+		}
+
+		var want []Entry
+		for _, e := range entries[:3] {
+			if format == JSON {
+				// JSON carries only the translations
+				e.Source, e.Note = "", ""
+			}
+			want = append(want, e)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: round trip\n got %q\nwant %q", format, got, want)
+		}
+	}
+}
+
+func TestParsePO(t *testing.T) {
+	po := "\uFEFF" + `# Translator comment
+msgid ""
+msgstr ""
+"Language: de\n"
+
+#. Shown on the home page
+msgctxt "home.title"
+msgid "Welcome"
+msgstr "Willkommen"
+
+msgid "Plain"
+msgstr ""
+"Einfach "
+"und mehrzeilig"
+
+#, fuzzy
+msgctxt "fuzzy"
+msgid "Draft"
+msgstr "Entwurf"
+
+#, c-format
+msgctxt "files"
+msgid "%d file"
+msgid_plural "%d files"
+msgstr[0] "%d Datei"
+msgstr[1] "%d Dateien"
+
+#~ msgctxt "removed"
+#~ msgid "Old"
+#~ msgstr "Alt"
+msgid "Empty"
+msgstr ""
+msgctxt "no.blank.line"
+msgid "Next"
+msgstr "Weiter"
+`
+	got, err := Parse(PO, strings.NewReader(po))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{Key: "home.title", Source: "Welcome", Value: "Willkommen", Note: "Shown on the home page"},
+		{Key: "Plain", Source: "Plain", Value: "Einfach und mehrzeilig"},
+		{Key: "files", Source: "%d file", Value: "%d Datei"},
+		{Key: "no.blank.line", Source: "Next", Value: "Weiter"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+
+	invalid := map[string]string{
+		"unknown keyword":   "msgfoo \"x\"\n",
+		"unquoted string":   "msgid Welcome\n",
+		"orphaned string":   "\"dangling\"\n",
+		"unterminated line": "msgid \"Welcome\n",
+	}
+	for name, po := range invalid {
+		if _, err := Parse(PO, strings.NewReader(po)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestParseXLIFF(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"1.2", `<?xml version="1.0" encoding="UTF-8"?>
+<xliff version="1.2" xmlns="urn:oasis:names:tc:xliff:document:1.2">
+  <file source-language="en" target-language="de" datatype="plaintext" original="web">
+    <body>
+      <trans-unit id="1" resname="nav.home">
+        <source>Home</source>
+        <target state="translated">Startseite</target>
+        <note>Top navigation</note>
+      </trans-unit>
+      <group id="account">
+        <trans-unit id="account.logout">
+          <source>Log out</source>
+          <target>Abmelden</target>
+        </trans-unit>
+      </group>
+      <trans-unit id="later">
+        <source>Later</source>
+      </trans-unit>
+    </body>
+  </file>
+</xliff>`},
+		{"2.0", `<?xml version="1.0" encoding="UTF-8"?>
+<xliff version="2.0" xmlns="urn:oasis:names:tc:xliff:document:2.0" srcLang="en" trgLang="de">
+  <file id="web">
+    <unit id="1" name="nav.home">
+      <notes><note>Top navigation</note></notes>
+      <segment><source>Home</source><target>Startseite</target></segment>
+    </unit>
+    <group id="account">
+      <unit id="account.logout">
+        <segment><source>Log </source><target>Ab</target></segment>
+        <segment><source>out</source><target>melden</target></segment>
+      </unit>
+    </group>
+    <unit id="later">
+      <segment><source>Later</source></segment>
+    </unit>
+  </file>
+</xliff>`},
+	}
+	want := []Entry{
+		{Key: "nav.home", Source: "Home", Value: "Startseite", Note: "Top navigation"},
+		{Key: "account.logout", Source: "Log out", Value: "Abmelden"},
+	}
+	for _, tt := range tests {
+		got, err := Parse(XLIFF, strings.NewReader(tt.doc))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s:\n got %q\nwant %q", tt.name, got, want)
+		}
+	}
+
+	if _, err := Parse(XLIFF, strings.NewReader("<xliff><file>")); err == nil {
+		t.Error("truncated XLIFF: expected an error")
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	doc := `{"nav": {"home": "Startseite", "about": ""}, "title": "Titel", "skipped": null}`
+	got, err := Parse(JSON, strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{{Key: "nav.home", Value: "Startseite"}, {Key: "title", Value: "Titel"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, doc := range []string{`{"count": 3}`, `["a"]`, `{`} {
+		if _, err := Parse(JSON, strings.NewReader(doc)); err == nil {
+			t.Errorf("%s: expected an error", doc)
+		}
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		format, filename, want string
+	}{
+		{"", "de.po", PO},
+		{"", "messages.POT", PO},
+		{"", "de.xlf", XLIFF},
+		{"", "de.xliff", XLIFF},
+		{"", "de.json", JSON},
+		{"JSON", "de.po", JSON},
+	}
+	for _, tt := range tests {
+		if got, err := DetectFormat(tt.format, tt.filename); err != nil || got != tt.want {
+			t.Errorf("DetectFormat(%q, %q) = %q, %v; want %q", tt.format, tt.filename, got, err, tt.want)
+		}
+	}
+	if _, err := DetectFormat("", "de.txt"); err == nil {
+		t.Error("de.txt: expected an error")
+	}
+}
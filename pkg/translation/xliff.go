@@ -0,0 +1,133 @@
+package translation
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// xliffDocument is read from both XLIFF 1.2 and 2.0 files.
+type xliffDocument struct {
+	Files []struct {
+		// XLIFF 1.2
+		Units      []xliffUnit `xml:"body>trans-unit"`
+		GroupUnits []xliffUnit `xml:"body>group>trans-unit"`
+		// XLIFF 2.0
+		Units2      []xliff2Unit `xml:"unit"`
+		GroupUnits2 []xliff2Unit `xml:"group>unit"`
+	} `xml:"file"`
+}
+
+// xliffOutput is written as XLIFF 1.2.
+type xliffOutput struct {
+	XMLName xml.Name `xml:"xliff"`
+	Version string   `xml:"version,attr"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	File    struct {
+		SourceLanguage string      `xml:"source-language,attr"`
+		TargetLanguage string      `xml:"target-language,attr"`
+		Datatype       string      `xml:"datatype,attr"`
+		Original       string      `xml:"original,attr"`
+		Units          []xliffUnit `xml:"body>trans-unit"`
+	} `xml:"file"`
+}
+
+type xliffUnit struct {
+	ID      string       `xml:"id,attr"`
+	Resname string       `xml:"resname,attr,omitempty"`
+	Source  string       `xml:"source"`
+	Target  *xliffTarget `xml:"target"`
+	Note    string       `xml:"note,omitempty"`
+}
+
+type xliffTarget struct {
+	State string `xml:"state,attr,omitempty"`
+	Text  string `xml:",chardata"`
+}
+
+type xliff2Unit struct {
+	ID       string `xml:"id,attr"`
+	Name     string `xml:"name,attr"`
+	Segments []struct {
+		Source string `xml:"source"`
+		Target string `xml:"target"`
+	} `xml:"segment"`
+	Notes []string `xml:"notes>note"`
+}
+
+// parseXLIFF reads XLIFF 1.2 and 2.0 files. A unit's key is its resname
+// (1.2) or name (2.0) when set, and its id otherwise.
+func parseXLIFF(r io.Reader) ([]Entry, error) {
+	var doc xliffDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid XLIFF: %w", err)
+	}
+
+	var entries []Entry
+	for _, f := range doc.Files {
+		for _, u := range append(f.Units, f.GroupUnits...) {
+			e := Entry{Key: firstNonEmpty(u.Resname, u.ID), Source: u.Source, Note: strings.TrimSpace(u.Note)}
+			if u.Target != nil {
+				e.Value = u.Target.Text
+			}
+			entries = append(entries, e)
+		}
+		for _, u := range append(f.Units2, f.GroupUnits2...) {
+			e := Entry{Key: firstNonEmpty(u.Name, u.ID), Note: strings.Join(u.Notes, "\n")}
+			for _, s := range u.Segments {
+				e.Source += s.Source
+				e.Value += s.Target
+			}
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// writeXLIFF writes an XLIFF 1.2 file, marking untranslated units with the
+// needs-translation state.
+func writeXLIFF(w io.Writer, f File) error {
+	doc := xliffOutput{Version: "1.2", Xmlns: "urn:oasis:names:tc:xliff:document:1.2"}
+	doc.File.SourceLanguage = f.SourceLanguage
+	doc.File.TargetLanguage = f.TargetLanguage
+	doc.File.Datatype = "plaintext"
+	doc.File.Original = f.Namespace
+	for _, e := range f.Entries {
+		source := e.Source
+		if source == "" {
+			source = e.Key
+		}
+		state := "translated"
+		if e.Value == "" {
+			state = "needs-translation"
+		}
+		doc.File.Units = append(doc.File.Units, xliffUnit{
+			ID:      e.Key,
+			Resname: e.Key,
+			Source:  source,
+			Target:  &xliffTarget{State: state, Text: e.Value},
+			Note:    e.Note,
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
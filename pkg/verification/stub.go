@@ -0,0 +1,29 @@
+package verification
+
+import (
+	"context"
+	"fmt"
+)
+
+// StaticResolver answers TXT lookups from an in-memory map, for tests and
+// local development without DNS.
+type StaticResolver map[string][]string
+
+func (r StaticResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, ok := r[name]
+	if !ok {
+		return nil, fmt.Errorf("no such host %s", name)
+	}
+	return records, nil
+}
+
+// StaticFetcher serves well-known files from an in-memory map keyed by URL.
+type StaticFetcher map[string]string
+
+func (f StaticFetcher) Fetch(ctx context.Context, url string) ([]byte, error) {
+	body, ok := f[url]
+	if !ok {
+		return nil, fmt.Errorf("unexpected status 404")
+	}
+	return []byte(body), nil
+}
@@ -0,0 +1,171 @@
+package verification
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	MethodDNS  = "dns_txt"
+	MethodHTTP = "http_file"
+
+	recordPrefix = "_iraven-verification."
+	valuePrefix  = "iraven-verification="
+	wellKnown    = "/.well-known/iraven-verification.txt"
+)
+
+// TokenTTL is how long a new token can be used to verify a domain.
+const TokenTTL = 7 * 24 * time.Hour
+
+var (
+	ErrTokenNotFound = errors.New("verification token not found")
+	ErrTokenExpired  = errors.New("verification token expired; create a new token")
+)
+
+// Resolver looks up TXT records. *net.Resolver satisfies it.
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// Fetcher retrieves the body of a well-known verification file.
+type Fetcher interface {
+	Fetch(ctx context.Context, url string) ([]byte, error)
+}
+
+type Verifier struct {
+	Resolver Resolver
+	Fetcher  Fetcher
+	// Now returns the current time; nil means time.Now
+	Now func() time.Time
+}
+
+func NewVerifier() *Verifier {
+	return &Verifier{
+		Resolver: net.DefaultResolver,
+		Fetcher: &HTTPFetcher{Client: &http.Client{
+			Timeout:       10 * time.Second,
+			CheckRedirect: sameHostRedirect,
+		}},
+	}
+}
+
+func NewToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func ValidMethod(method string) bool {
+	return method == MethodDNS || method == MethodHTTP
+}
+
+// RecordName is the DNS name that must carry the TXT record for domain.
+func RecordName(domain string) string {
+	return recordPrefix + normalize(domain)
+}
+
+// RecordValue is the TXT record value expected for token.
+func RecordValue(token string) string {
+	return valuePrefix + token
+}
+
+// WellKnownURL is where the HTTP verification file must be served for domain.
+func WellKnownURL(domain string) string {
+	return "https://" + normalize(domain) + wellKnown
+}
+
+// Check verifies that domain publishes token using the given method. A
+// token past expiresAt is refused without looking; a zero expiresAt never
+// expires.
+func (v *Verifier) Check(ctx context.Context, method, domain, token string, expiresAt time.Time) error {
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+	if !expiresAt.IsZero() && !now().Before(expiresAt) {
+		return ErrTokenExpired
+	}
+
+	switch method {
+	case MethodDNS:
+		records, err := v.Resolver.LookupTXT(ctx, RecordName(domain))
+		if err != nil {
+			return fmt.Errorf("TXT lookup failed: %w", err)
+		}
+		for _, record := range records {
+			if strings.TrimSpace(record) == RecordValue(token) {
+				return nil
+			}
+		}
+		return ErrTokenNotFound
+	case MethodHTTP:
+		body, err := v.Fetcher.Fetch(ctx, WellKnownURL(domain))
+		if err != nil {
+			return fmt.Errorf("fetch failed: %w", err)
+		}
+		if strings.TrimSpace(string(body)) == token {
+			return nil
+		}
+		return ErrTokenNotFound
+	default:
+		return fmt.Errorf("unknown verification method %q", method)
+	}
+}
+
+// HTTPFetcher fetches verification files over HTTP. The client of
+// NewVerifier follows redirects only within the domain's host, so that a
+// redirect cannot vouch for the domain with another site's file.
+type HTTPFetcher struct {
+	Client *http.Client
+}
+
+func (f *HTTPFetcher) Fetch(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, 4096))
+}
+
+// sameHostRedirect stops redirects that leave the host of the first
+// request.
+func sameHostRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if req.URL.Host != via[0].URL.Host {
+		return fmt.Errorf("redirect to another host %s", req.URL.Host)
+	}
+	return nil
+}
+
+func normalize(domain string) string {
+	domain = strings.TrimSpace(strings.ToLower(domain))
+	domain = strings.TrimPrefix(domain, "https://")
+	domain = strings.TrimPrefix(domain, "http://")
+	if i := strings.IndexAny(domain, "/:"); i >= 0 {
+		domain = domain[:i]
+	}
+	return strings.TrimSuffix(domain, ".")
+}
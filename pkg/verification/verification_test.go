@@ -0,0 +1,123 @@
+package verification
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const token = "0123456789abcdef0123456789abcdef"
+
+func TestCheckDNS(t *testing.T) {
+	v := &Verifier{Resolver: StaticResolver{
+		"_iraven-verification.example.com": {"v=spf1 -all", " iraven-verification=" + token + " "},
+		"_iraven-verification.other.com":   {"iraven-verification=somethingelse"},
+	}}
+	ctx := context.Background()
+
+	if err := v.Check(ctx, MethodDNS, "Example.com.", token, time.Time{}); err != nil {
+		t.Errorf("published record: %v", err)
+	}
+	if err := v.Check(ctx, MethodDNS, "other.com", token, time.Time{}); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("other token: got %v, want ErrTokenNotFound", err)
+	}
+	err := v.Check(ctx, MethodDNS, "missing.com", token, time.Time{})
+	if err == nil || errors.Is(err, ErrTokenNotFound) || !strings.Contains(err.Error(), "TXT lookup failed") {
+		t.Errorf("no record: got %v", err)
+	}
+}
+
+func TestCheckHTTP(t *testing.T) {
+	v := &Verifier{Fetcher: StaticFetcher{
+		"https://example.com/.well-known/iraven-verification.txt": token + "\n",
+		"https://other.com/.well-known/iraven-verification.txt":   "not the token",
+	}}
+	ctx := context.Background()
+
+	if err := v.Check(ctx, MethodHTTP, "https://example.com/some/page", token, time.Time{}); err != nil {
+		t.Errorf("served file: %v", err)
+	}
+	if err := v.Check(ctx, MethodHTTP, "other.com", token, time.Time{}); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("mismatched file: got %v, want ErrTokenNotFound", err)
+	}
+	err := v.Check(ctx, MethodHTTP, "missing.com", token, time.Time{})
+	if err == nil || !strings.Contains(err.Error(), "fetch failed") {
+		t.Errorf("no file: got %v", err)
+	}
+}
+
+func TestCheckExpiry(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	v := &Verifier{
+		Resolver: StaticResolver{"_iraven-verification.example.com": {RecordValue(token)}},
+		Now:      func() time.Time { return now },
+	}
+	ctx := context.Background()
+
+	if err := v.Check(ctx, MethodDNS, "example.com", token, now.Add(time.Minute)); err != nil {
+		t.Errorf("before expiry: %v", err)
+	}
+	if err := v.Check(ctx, MethodDNS, "example.com", token, now); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("at expiry: got %v, want ErrTokenExpired", err)
+	}
+	if err := v.Check(ctx, MethodDNS, "example.com", token, now.Add(-TokenTTL)); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("after expiry: got %v, want ErrTokenExpired", err)
+	}
+}
+
+func TestCheckUnknownMethod(t *testing.T) {
+	v := &Verifier{}
+	if err := v.Check(context.Background(), "email", "example.com", token, time.Time{}); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestHTTPFetcherRedirects(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(token))
+	}))
+	defer other.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/file", http.StatusFound)
+	})
+	mux.HandleFunc("/file", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(token))
+	})
+	mux.HandleFunc("/elsewhere", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, other.URL+"/file", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	fetcher := NewVerifier().Fetcher
+	ctx := context.Background()
+
+	body, err := fetcher.Fetch(ctx, srv.URL+"/moved")
+	if err != nil || string(body) != token {
+		t.Errorf("same-host redirect: got %q, %v", body, err)
+	}
+	if _, err := fetcher.Fetch(ctx, srv.URL+"/elsewhere"); err == nil || !strings.Contains(err.Error(), "another host") {
+		t.Errorf("cross-host redirect: got %v", err)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"Example.COM":                 "example.com",
+		"https://example.com/path":    "example.com",
+		"http://example.com:8080":     "example.com",
+		" sub.example.com. ":          "sub.example.com",
+		"_iraven-verification.x.test": "_iraven-verification.x.test",
+	}
+	for in, want := range tests {
+		if got := normalize(in); got != want {
+			t.Errorf("normalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package workflow
+
+const (
+	StatusDraft     = "draft"
+	StatusInReview  = "in_review"
+	StatusPublished = "published"
+	StatusArchived  = "archived"
+)
+
+const (
+	RoleAdmin    = "admin"
+	RoleEditor   = "content_editor"
+	RoleReviewer = "content_reviewer"
+)
+
+var Statuses = []string{StatusDraft, StatusInReview, StatusPublished, StatusArchived}
+
+type Transition struct {
+	Action string
+	Label  string
+	From   []string
+	To     string
+	Roles  []string
+}
+
+// Transitions lists every allowed status change and the workflow roles
+// that may perform it. Being an admin grants none of them: an admin who
+// edits or reviews content holds the editor or reviewer role as well.
+var Transitions = []Transition{
+	{Action: "submit", Label: "Submit for Review", From: []string{StatusDraft}, To: StatusInReview,
+		Roles: []string{RoleEditor, RoleReviewer}},
+	{Action: "reject", Label: "Send Back to Draft", From: []string{StatusInReview}, To: StatusDraft,
+		Roles: []string{RoleReviewer}},
+	{Action: "publish", Label: "Publish", From: []string{StatusInReview}, To: StatusPublished,
+		Roles: []string{RoleReviewer}},
+	{Action: "unpublish", Label: "Unpublish", From: []string{StatusPublished}, To: StatusDraft,
+		Roles: []string{RoleReviewer}},
+	{Action: "archive", Label: "Archive", From: []string{StatusDraft, StatusPublished}, To: StatusArchived,
+		Roles: []string{RoleReviewer}},
+	{Action: "restore", Label: "Restore to Draft", From: []string{StatusArchived}, To: StatusDraft,
+		Roles: []string{RoleEditor, RoleReviewer}},
+}
+
+func ValidStatus(status string) bool {
+	for _, s := range Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func Find(action string) (Transition, bool) {
+	for _, t := range Transitions {
+		if t.Action == action {
+			return t, true
+		}
+	}
+	return Transition{}, false
+}
+
+// Available returns the transitions out of status that roles may perform.
+func Available(status string, roles []string) []Transition {
+	var out []Transition
+	for _, t := range Transitions {
+		if t.AppliesTo(status) && t.Permitted(roles) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func (t Transition) AppliesTo(status string) bool {
+	for _, s := range t.From {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func (t Transition) Permitted(roles []string) bool {
+	for _, role := range roles {
+		for _, allowed := range t.Roles {
+			if role == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasRole reports whether roles include one of the workflow roles.
+func HasRole(roles []string) bool {
+	for _, role := range roles {
+		if role == RoleEditor || role == RoleReviewer {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package workflow
+
+import "testing"
+
+func TestPermitted(t *testing.T) {
+	publish, ok := Find("publish")
+	if !ok {
+		t.Fatal("no publish transition")
+	}
+	submit, _ := Find("submit")
+
+	tests := []struct {
+		name  string
+		t     Transition
+		roles []string
+		want  bool
+	}{
+		{"reviewer publishes", publish, []string{RoleReviewer}, true},
+		{"editor cannot publish", publish, []string{RoleEditor}, false},
+		{"admin alone cannot publish", publish, []string{RoleAdmin}, false},
+		{"admin editor cannot publish", publish, []string{RoleAdmin, RoleEditor}, false},
+		{"admin reviewer publishes", publish, []string{RoleAdmin, RoleReviewer}, true},
+		{"editor submits", submit, []string{RoleEditor}, true},
+		{"no roles", submit, nil, false},
+	}
+	for _, tt := range tests {
+		if got := tt.t.Permitted(tt.roles); got != tt.want {
+			t.Errorf("%s: Permitted(%v) = %v, want %v", tt.name, tt.roles, got, tt.want)
+		}
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	actions := func(ts []Transition) []string {
+		var out []string
+		for _, t := range ts {
+			out = append(out, t.Action)
+		}
+		return out
+	}
+
+	if got := actions(Available(StatusInReview, []string{RoleAdmin, RoleEditor})); len(got) != 0 {
+		t.Errorf("editor in review: got %v, want nothing", got)
+	}
+	got := actions(Available(StatusInReview, []string{RoleReviewer}))
+	if len(got) != 2 || got[0] != "reject" || got[1] != "publish" {
+		t.Errorf("reviewer in review: got %v, want [reject publish]", got)
+	}
+	got = actions(Available(StatusDraft, []string{RoleEditor}))
+	if len(got) != 1 || got[0] != "submit" {
+		t.Errorf("editor on draft: got %v, want [submit]", got)
+	}
+}
+
+func TestHasRole(t *testing.T) {
+	if HasRole([]string{RoleAdmin}) {
+		t.Error("admin alone has no workflow role")
+	}
+	if !HasRole([]string{RoleAdmin, RoleReviewer}) {
+		t.Error("reviewer has a workflow role")
+	}
+}